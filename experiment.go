@@ -5,6 +5,8 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"strconv"
@@ -15,6 +17,7 @@ import (
 	"github.com/ooni/probe-engine/netx/bytecounter"
 	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/pcapng"
 	"github.com/ooni/probe-engine/probeservices"
 	"github.com/ooni/probe-engine/resources"
 )
@@ -114,6 +117,17 @@ func (e *Experiment) MeasureWithContext(
 	}
 	ctx = dialer.WithSessionByteCounter(ctx, e.session.byteCounter)
 	ctx = dialer.WithExperimentByteCounter(ctx, e.byteCounter)
+	if e.session.shaper != nil {
+		ctx = dialer.WithShaper(ctx, e.session.shaper)
+	}
+	if e.session.certPool != nil {
+		ctx = dialer.WithCertPool(ctx, e.session.certPool)
+	}
+	if e.session.keyLogWriter != nil {
+		ctx = dialer.WithKeyLogWriter(ctx, e.session.keyLogWriter)
+	}
+	ctx, closePcap := e.maybeWithPcapWriter(ctx)
+	defer closePcap()
 	measurement = e.newMeasurement(input)
 	start := time.Now()
 	err = e.measurer.Run(ctx, e.session, measurement, &sessionExperimentCallbacks{
@@ -132,6 +146,38 @@ func (e *Experiment) MeasureWithContext(
 	return
 }
 
+// maybeWithPcapWriter returns a context containing a pcapng writer that
+// writes into a new file inside the session's PcapDir, if configured, along
+// with a function to close such file. Because the pcapng file is just a
+// debugging aid, failing to create or write it is not fatal, and we
+// just log the first write error, if any, when closing the file.
+func (e *Experiment) maybeWithPcapWriter(
+	ctx context.Context) (context.Context, func()) {
+	if e.session.pcapDir == "" {
+		return ctx, func() {}
+	}
+	pattern := fmt.Sprintf("%s-%s-*.pcapng", e.testName,
+		time.Now().UTC().Format("20060102T150405Z"))
+	filep, err := ioutil.TempFile(e.session.pcapDir, pattern)
+	if err != nil {
+		e.session.logger.Warnf("cannot create pcapng file: %s", err.Error())
+		return ctx, func() {}
+	}
+	writer, err := pcapng.NewWriter(filep)
+	if err != nil {
+		e.session.logger.Warnf("cannot write pcapng file: %s", err.Error())
+		filep.Close()
+		return ctx, func() {}
+	}
+	e.session.logger.Infof("writing packets to %s", filep.Name())
+	return dialer.WithPcapWriter(ctx, writer), func() {
+		if err := writer.Err(); err != nil {
+			e.session.logger.Warnf("cannot write pcapng file: %s", err.Error())
+		}
+		filep.Close()
+	}
+}
+
 type sessionExperimentCallbacks struct {
 	exp   *Experiment
 	inner model.ExperimentCallbacks
@@ -16,6 +16,7 @@ import (
 
 	"github.com/montanaflynn/stats"
 	"github.com/ooni/probe-engine/internal/humanizex"
+	"github.com/ooni/probe-engine/internal/proxyx"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx"
 	"github.com/ooni/probe-engine/netx/archival"
@@ -63,6 +64,7 @@ type TestKeys struct {
 	Server        ServerInfo      `json:"server"`
 	Simple        Simple          `json:"simple"`
 	Failure       *string         `json:"failure"`
+	Proxy         string          `json:"proxy,omitempty"`
 	ReceiverData  []clientResults `json:"receiver_data"`
 	SOCKSProxy    string          `json:"socksproxy,omitempty"`
 	Tunnel        string          `json:"tunnel,omitempty"`
@@ -75,7 +77,7 @@ func registerExtensions(m *model.Measurement) {
 type runner struct {
 	callbacks  model.ExperimentCallbacks
 	httpClient *http.Client
-	saver      *trace.Saver
+	saver      *trace.RingSaver
 	sess       model.ExperimentSession
 	tk         *TestKeys
 }
@@ -274,13 +276,14 @@ func (m Measurer) Run(
 		return err
 	}
 	tk.BootstrapTime = sess.TunnelBootstrapTime().Seconds()
-	if url := sess.ProxyURL(); url != nil {
-		tk.SOCKSProxy = url.Host
-	}
-	saver := &trace.Saver{}
+	tk.Proxy, tk.SOCKSProxy = proxyx.Describe(sess.ProxyURL())
+	// We only need the latest connect event, hence we use a ring, which
+	// keeps the memory bounded regardless of how many times we connect.
+	saver := trace.NewRingSaver(1)
 	httpClient := &http.Client{
 		Transport: netx.NewHTTPTransport(netx.Config{
 			ContextByteCounting: true,
+			ContextPcap:         true,
 			DialSaver:           saver,
 			Logger:              sess.Logger(),
 			ProxyURL:            sess.ProxyURL(),
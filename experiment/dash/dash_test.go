@@ -27,7 +27,7 @@ func TestUnitRunnerLoopLocateFailure(t *testing.T) {
 				err: expected,
 			},
 		},
-		saver: new(trace.Saver),
+		saver: trace.NewRingSaver(1),
 		sess: &mockable.Session{
 			MockableLogger: log.Log,
 		},
@@ -57,7 +57,7 @@ func TestUnitRunnerLoopNegotiateFailure(t *testing.T) {
 				},
 			},
 		},
-		saver: new(trace.Saver),
+		saver: trace.NewRingSaver(1),
 		sess: &mockable.Session{
 			MockableLogger: log.Log,
 		},
@@ -94,7 +94,7 @@ func TestUnitRunnerLoopMeasureFailure(t *testing.T) {
 				},
 			},
 		},
-		saver: new(trace.Saver),
+		saver: trace.NewRingSaver(1),
 		sess: &mockable.Session{
 			MockableLogger: log.Log,
 		},
@@ -108,7 +108,7 @@ func TestUnitRunnerLoopMeasureFailure(t *testing.T) {
 
 func TestUnitRunnerLoopCollectFailure(t *testing.T) {
 	expected := errors.New("mocked error")
-	saver := new(trace.Saver)
+	saver := trace.NewRingSaver(1)
 	saver.Write(trace.Event{Name: errorx.ConnectOperation, Duration: 150 * time.Millisecond})
 	r := runner{
 		callbacks: model.NewPrinterCallbacks(log.Log),
@@ -152,7 +152,7 @@ func TestUnitRunnerLoopCollectFailure(t *testing.T) {
 }
 
 func TestUnitRunnerLoopSuccess(t *testing.T) {
-	saver := new(trace.Saver)
+	saver := trace.NewRingSaver(1)
 	saver.Write(trace.Event{Name: errorx.ConnectOperation, Duration: 150 * time.Millisecond})
 	r := runner{
 		callbacks: model.NewPrinterCallbacks(log.Log),
@@ -315,7 +315,7 @@ func TestUnitMeasureWithProxyURL(t *testing.T) {
 		&mockable.Session{
 			MockableHTTPClient: http.DefaultClient,
 			MockableLogger:     log.Log,
-			MockableProxyURL:   &url.URL{Host: "1.1.1.1:22"},
+			MockableProxyURL:   &url.URL{Scheme: "socks5", Host: "1.1.1.1:22"},
 		},
 		measurement,
 		model.NewPrinterCallbacks(log.Log),
@@ -326,4 +326,38 @@ func TestUnitMeasureWithProxyURL(t *testing.T) {
 	if measurement.TestKeys.(*TestKeys).SOCKSProxy != "1.1.1.1:22" {
 		t.Fatal("unexpected SOCKSProxy")
 	}
+	if measurement.TestKeys.(*TestKeys).Proxy != "socks5://1.1.1.1:22" {
+		t.Fatal("unexpected Proxy")
+	}
+}
+
+func TestUnitMeasureWithHTTPProxyURL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // cause failure
+	m := &Measurer{}
+	measurement := &model.Measurement{}
+	err := m.Run(
+		ctx,
+		&mockable.Session{
+			MockableHTTPClient: http.DefaultClient,
+			MockableLogger:     log.Log,
+			MockableProxyURL: &url.URL{
+				Scheme: "http",
+				User:   url.UserPassword("user", "pass"),
+				Host:   "1.1.1.1:8080",
+			},
+		},
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("unexpected error value")
+	}
+	tk := measurement.TestKeys.(*TestKeys)
+	if tk.SOCKSProxy != "" {
+		t.Fatal("unexpected SOCKSProxy")
+	}
+	if tk.Proxy != "http://1.1.1.1:8080" {
+		t.Fatal("unexpected Proxy")
+	}
 }
@@ -30,10 +30,22 @@
 //
 // For DNS over TLS, use `dot://<address_or_domain>[:<port>]`.
 //
+// For DNS over QUIC, use `doq://<address_or_domain>[:<port>]`.
+//
 // For DNS over HTTPS, use `https://<address_or_domain>[:<port>]/<path>`.
 //
 // When the `<port>` is missing, we use the correct default.
 //
+// With DNS over UDP, setting the DNSLateReplies option causes us to keep
+// reading replies for the configured number of milliseconds after the
+// first one. Each reply is saved as a distinct entry in the queries and
+// we set dns_replies_disagree when replies are not consistent, which is
+// a strong signal of on-path DNS injection.
+//
+// Setting the DNSSECValidation option causes us to set the DO bit in
+// queries and to validate the signatures we receive. The result of the
+// validation is the dnssec_status of each entry in the queries.
+//
 // Output data format
 //
 // A measurement data structure is generated for every input resolver
@@ -69,15 +81,18 @@ const (
 
 // Config contains the experiment's configuration.
 type Config struct {
-	Domain string `ooni:"domain to resolve using the specified resolver"`
+	DNSLateReplies   int64  `ooni:"milliseconds to wait for late UDP replies after the first one"`
+	DNSSECValidation bool   `ooni:"request and validate DNSSEC signatures"`
+	Domain           string `ooni:"domain to resolve using the specified resolver"`
 }
 
 // TestKeys contains the results of the dnscheck experiment.
 type TestKeys struct {
-	Domain           string                        `json:"domain"`
-	Bootstrap        *urlgetter.TestKeys           `json:"bootstrap"`
-	BootstrapFailure *string                       `json:"bootstrap_failure"`
-	Lookups          map[string]urlgetter.TestKeys `json:"lookups"`
+	DNSRepliesDisagree bool                          `json:"dns_replies_disagree"`
+	Domain             string                        `json:"domain"`
+	Bootstrap          *urlgetter.TestKeys           `json:"bootstrap"`
+	BootstrapFailure   *string                       `json:"bootstrap_failure"`
+	Lookups            map[string]urlgetter.TestKeys `json:"lookups"`
 }
 
 // Measurer performs the measurement.
@@ -136,7 +151,7 @@ func (m Measurer) Run(
 		return fmt.Errorf("%w: %s", ErrInvalidURL, err.Error())
 	}
 	switch URL.Scheme {
-	case "https", "dot", "udp", "tcp":
+	case "https", "dot", "doq", "udp", "tcp":
 		// all good
 	default:
 		return ErrUnsupportedURLScheme
@@ -171,6 +186,8 @@ func (m Measurer) Run(
 			Config: urlgetter.Config{
 				DNSHTTPHost:      URL.Host, // use original host (and optional port)
 				RejectDNSBogons:  true,     // bogons are errors in this context
+				DNSLateReplies:   m.Config.DNSLateReplies,
+				DNSSECValidation: m.Config.DNSSECValidation,
 				ResolverURL:      makeResolverURL(URL, addr),
 				DNSTLSServerName: URL.Hostname(), // just the domain/IP for SNI
 			},
@@ -181,6 +198,9 @@ func (m Measurer) Run(
 	// 7. perform all the required resolutions
 	for output := range Collect(ctx, multi, inputs, callbacks) {
 		tk.Lookups[output.Input.Config.ResolverURL] = output.TestKeys
+		// A disagreement between late replies from the same resolver is
+		// a strong signal that someone is injecting DNS replies.
+		tk.DNSRepliesDisagree = tk.DNSRepliesDisagree || output.TestKeys.DNSRepliesDisagree
 	}
 	return nil
 }
@@ -83,6 +83,26 @@ func TestWithCancelledContext(t *testing.T) {
 	}
 }
 
+func TestWithCancelledContextDoQ(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // immediately cancel the context
+	measurer := NewExperimentMeasurer(Config{})
+	measurement := &model.Measurement{Input: "doq://94.140.14.14"}
+	err := measurer.Run(
+		ctx,
+		newsession(),
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*TestKeys)
+	if _, found := tk.Lookups["doq://94.140.14.14"]; !found {
+		t.Fatal("expected a lookup using DNS over QUIC")
+	}
+}
+
 func TestMakeResolverURL(t *testing.T) {
 	// test address substitution
 	addr := "255.255.255.0"
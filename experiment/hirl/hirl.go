@@ -268,6 +268,7 @@ func RunMethod(ctx context.Context, config RunMethodConfig) {
 	}
 	dialer := config.NewDialer(netx.Config{
 		ContextByteCounting: true,
+		ContextPcap:         true,
 		Logger:              config.Logger,
 	})
 	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(config.Address, "80"))
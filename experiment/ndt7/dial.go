@@ -11,6 +11,7 @@ import (
 	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/resolver"
 	"github.com/ooni/probe-engine/netx/selfcensor"
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 type dialManager struct {
@@ -43,6 +44,9 @@ func (mgr dialManager) dialWithTestName(ctx context.Context, testName string) (*
 	dlr = dialer.TimeoutDialer{Dialer: dlr}
 	dlr = dialer.ErrorWrapperDialer{Dialer: dlr}
 	dlr = dialer.LoggingDialer{Dialer: dlr, Logger: mgr.logger}
+	if saver := trace.ContextSink(ctx); saver != nil {
+		dlr = dialer.SaverDialer{Dialer: dlr, Saver: saver}
+	}
 	dlr = dialer.DNSDialer{Dialer: dlr, Resolver: reso}
 	dlr = dialer.ProxyDialer{Dialer: dlr, ProxyURL: mgr.proxyURL}
 	dlr = dialer.ByteCounterDialer{Dialer: dlr}
@@ -11,6 +11,8 @@ import (
 
 	"github.com/apex/log"
 	"github.com/gorilla/websocket"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 func TestDialDownloadWithCancelledContext(t *testing.T) {
@@ -68,3 +70,29 @@ func TestDialIncludesUserAgent(t *testing.T) {
 	do("download")
 	do("upload")
 }
+
+func TestDialSavesConnectEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(500)
+		}))
+	defer server.Close()
+	url, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url.Scheme = "ws"
+	saver := trace.NewRingSaver(paramMaxSavedEvents)
+	ctx := trace.WithSink(context.Background(), saver)
+	mgr := newDialManager(url.String(), nil, log.Log, "miniooni/0.1.0-dev")
+	if _, err := mgr.dialDownload(ctx); !errors.Is(err, websocket.ErrBadHandshake) {
+		t.Fatal("not the error we expected")
+	}
+	events := saver.Read()
+	if len(events) != 1 || events[0].Name != errorx.ConnectOperation {
+		t.Fatal("not the events we expected")
+	}
+	if events[0].Address != url.Host || events[0].Err != nil {
+		t.Fatal("not the connect event we expected")
+	}
+}
@@ -12,9 +12,11 @@ import (
 
 	"github.com/ooni/probe-engine/internal/humanizex"
 	"github.com/ooni/probe-engine/internal/mlablocatev2"
+	"github.com/ooni/probe-engine/internal/proxyx"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx"
 	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 const (
@@ -64,7 +66,11 @@ type TestKeys struct {
 	// Protocol contains the version of the ndt protocol
 	Protocol int64 `json:"protocol"`
 
-	// SOCKSProxy is the proxy we're using (if any)
+	// Proxy is the URL of the proxy we're using (if any)
+	// without the credentials (if any)
+	Proxy string `json:"proxy,omitempty"`
+
+	// SOCKSProxy is the SOCKS5 proxy we're using (if any)
 	SOCKSProxy string `json:"socksproxy,omitempty"`
 
 	// Server contains information on the selected server
@@ -248,8 +254,14 @@ func (m *Measurer) Run(
 		return err
 	}
 	tk.BootstrapTime = sess.TunnelBootstrapTime().Seconds()
-	if url := sess.ProxyURL(); url != nil {
-		tk.SOCKSProxy = url.Host
+	tk.Proxy, tk.SOCKSProxy = proxyx.Describe(sess.ProxyURL())
+	if sink := trace.ContextSink(ctx); sink != nil {
+		// We save the connect events of the websocket dials into a ring,
+		// which keeps the memory bounded, and we pass them to the sink
+		// of the caller when we're done.
+		saver := trace.NewRingSaver(paramMaxSavedEvents)
+		defer func() { trace.WriteBatch(sink, saver.Read()) }()
+		ctx = trace.WithSink(ctx, saver)
 	}
 	locateResult, err := m.discover(ctx, sess)
 	if err != nil {
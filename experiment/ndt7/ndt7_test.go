@@ -122,7 +122,7 @@ func TestUnitRunWithProxyURL(t *testing.T) {
 	sess := &mockable.Session{
 		MockableHTTPClient: http.DefaultClient,
 		MockableLogger:     log.Log,
-		MockableProxyURL:   &url.URL{Host: "1.1.1.1:22"},
+		MockableProxyURL:   &url.URL{Scheme: "socks5", Host: "1.1.1.1:22"},
 		MockableUserAgent:  "miniooni/0.1.0-dev",
 	}
 	ctx, cancel := context.WithCancel(context.Background())
@@ -135,6 +135,9 @@ func TestUnitRunWithProxyURL(t *testing.T) {
 	if measurement.TestKeys.(*TestKeys).SOCKSProxy != "1.1.1.1:22" {
 		t.Fatal("not the SOCKSProxy we expected")
 	}
+	if measurement.TestKeys.(*TestKeys).Proxy != "socks5://1.1.1.1:22" {
+		t.Fatal("not the Proxy we expected")
+	}
 }
 
 func TestIntegration(t *testing.T) {
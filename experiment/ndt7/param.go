@@ -10,5 +10,6 @@ const (
 	paramMaxMessageSize       = 1 << 24
 	paramMaxRuntimeUpperBound = 15.0 // seconds
 	paramMaxRuntime           = 10 * time.Second
+	paramMaxSavedEvents       = 64
 	paramMeasureInterval      = 250 * time.Millisecond
 )
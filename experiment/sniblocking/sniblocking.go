@@ -30,6 +30,12 @@ type Config struct {
 
 	// TestHelperAddress is the address of the test helper.
 	TestHelperAddress string
+
+	// TLSSplitStrategy is the strategy used to split the Client
+	// Hello (see urlgetter.Config.TLSSplitStrategy). We record the
+	// strategy into the TLS handshakes, so we can learn whether it
+	// allows us to evade SNI blocking in the current network.
+	TLSSplitStrategy string
 }
 
 // Subresult contains the keys of a single measurement
@@ -130,8 +136,11 @@ func (m *Measurer) measureone(
 	}
 	// perform the measurement
 	g := urlgetter.Getter{
-		Begin:   beginning,
-		Config:  urlgetter.Config{TLSServerName: sni},
+		Begin: beginning,
+		Config: urlgetter.Config{
+			TLSServerName:    sni,
+			TLSSplitStrategy: m.config.TLSSplitStrategy,
+		},
 		Session: sess,
 		Target:  fmt.Sprintf("tlshandshake://%s", thaddr),
 	}
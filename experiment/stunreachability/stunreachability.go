@@ -97,6 +97,7 @@ func (tk *TestKeys) run(
 	begin := time.Now()
 	err := tk.do(ctx, config, netx.NewDialer(netx.Config{
 		ContextByteCounting: true,
+		ContextPcap:         true,
 		DialSaver:           saver,
 		Logger:              sess.Logger(),
 		ReadWriteSaver:      saver,
@@ -7,9 +7,12 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/dialer"
+	_ "github.com/ooni/probe-engine/netx/quicx" // for doq:// and HTTP/3
 	"github.com/ooni/probe-engine/netx/trace"
 )
 
@@ -19,7 +22,7 @@ type Configurer struct {
 	Config   Config
 	Logger   model.Logger
 	ProxyURL *url.URL
-	Saver    *trace.Saver
+	Saver    trace.Sink
 }
 
 // The Configuration is the configuration for running a measurement.
@@ -38,15 +41,18 @@ func (c Configurer) NewConfiguration() (Configuration, error) {
 	// set up defaults
 	configuration := Configuration{
 		HTTPConfig: netx.Config{
-			BogonIsError:        c.Config.RejectDNSBogons,
-			CacheResolutions:    true,
-			ContextByteCounting: true,
-			DialSaver:           c.Saver,
-			HTTPSaver:           c.Saver,
-			Logger:              c.Logger,
-			ReadWriteSaver:      c.Saver,
-			ResolveSaver:        c.Saver,
-			TLSSaver:            c.Saver,
+			BogonIsError:         c.Config.RejectDNSBogons,
+			CacheResolutions:     true,
+			ContextByteCounting:  true,
+			ContextPcap:          true,
+			DNSLateRepliesWindow: time.Duration(c.Config.DNSLateReplies) * time.Millisecond,
+			DNSSECValidation:     c.Config.DNSSECValidation,
+			DialSaver:            c.Saver,
+			HTTPSaver:            c.Saver,
+			Logger:               c.Logger,
+			ReadWriteSaver:       c.Saver,
+			ResolveSaver:         c.Saver,
+			TLSSaver:             c.Saver,
 		},
 	}
 	// fill DNS cache
@@ -104,7 +110,25 @@ func (c Configurer) NewConfiguration() (Configuration, error) {
 	default:
 		return configuration, errors.New("unsupported TLS version")
 	}
+	if c.Config.TLSFingerprint != "" {
+		if _, found := dialer.UTLSFingerprints[c.Config.TLSFingerprint]; !found {
+			return configuration, errors.New("unsupported TLS fingerprint")
+		}
+		configuration.HTTPConfig.TLSFingerprint = c.Config.TLSFingerprint
+	}
+	if c.Config.TLSSplitStrategy != "" {
+		if _, found := dialer.SplitStrategies[c.Config.TLSSplitStrategy]; !found {
+			return configuration, errors.New("unsupported TLS split strategy")
+		}
+		configuration.HTTPConfig.TLSSplitSize = int(c.Config.TLSSplitSize)
+		configuration.HTTPConfig.TLSSplitStrategy = c.Config.TLSSplitStrategy
+	}
 	configuration.HTTPConfig.NoTLSVerify = c.Config.NoTLSVerify
+	if c.Config.HTTP3Enabled && c.ProxyURL != nil {
+		// netx would silently use TCP, and we don't want to measure that
+		return configuration, errors.New("HTTP3 does not support proxies")
+	}
+	configuration.HTTPConfig.HTTP3Enabled = c.Config.HTTP3Enabled
 	// configure proxy
 	configuration.HTTPConfig.ProxyURL = c.ProxyURL
 	return configuration, nil
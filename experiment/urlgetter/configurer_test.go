@@ -32,6 +32,9 @@ func TestConfigurerNewConfigurationVanilla(t *testing.T) {
 	if configuration.HTTPConfig.ContextByteCounting != true {
 		t.Fatal("not the ContextByteCounting we expected")
 	}
+	if configuration.HTTPConfig.ContextPcap != true {
+		t.Fatal("not the ContextPcap we expected")
+	}
 	if configuration.HTTPConfig.DialSaver != saver {
 		t.Fatal("not the DialSaver we expected")
 	}
@@ -714,6 +717,110 @@ func TestConfigurerNewConfigurationTLSvInvalid(t *testing.T) {
 	}
 }
 
+func TestConfigurerNewConfigurationTLSFingerprint(t *testing.T) {
+	saver := new(trace.Saver)
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			TLSFingerprint: "firefox",
+		},
+		Logger: log.Log,
+		Saver:  saver,
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configuration.HTTPConfig.TLSFingerprint != "firefox" {
+		t.Fatal("not the TLSFingerprint we expected")
+	}
+}
+
+func TestConfigurerNewConfigurationHTTP3WithProxy(t *testing.T) {
+	saver := new(trace.Saver)
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			HTTP3Enabled: true,
+		},
+		Logger:   log.Log,
+		ProxyURL: &url.URL{Scheme: "socks5", Host: "127.0.0.1:9050"},
+		Saver:    saver,
+	}
+	_, err := configurer.NewConfiguration()
+	if err == nil || err.Error() != "HTTP3 does not support proxies" {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestConfigurerNewConfigurationTLSFingerprintInvalid(t *testing.T) {
+	saver := new(trace.Saver)
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			TLSFingerprint: "netscape",
+		},
+		Logger: log.Log,
+		Saver:  saver,
+	}
+	_, err := configurer.NewConfiguration()
+	if err.Error() != "unsupported TLS fingerprint" {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestConfigurerNewConfigurationTLSSplitStrategy(t *testing.T) {
+	saver := new(trace.Saver)
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			TLSSplitSize:     8,
+			TLSSplitStrategy: "tls_records",
+		},
+		Logger: log.Log,
+		Saver:  saver,
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configuration.HTTPConfig.TLSSplitStrategy != "tls_records" {
+		t.Fatal("not the TLSSplitStrategy we expected")
+	}
+	if configuration.HTTPConfig.TLSSplitSize != 8 {
+		t.Fatal("not the TLSSplitSize we expected")
+	}
+}
+
+func TestConfigurerNewConfigurationTLSSplitStrategyInvalid(t *testing.T) {
+	saver := new(trace.Saver)
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			TLSSplitStrategy: "antani",
+		},
+		Logger: log.Log,
+		Saver:  saver,
+	}
+	_, err := configurer.NewConfiguration()
+	if err.Error() != "unsupported TLS split strategy" {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestConfigurerNewConfigurationHTTP3Enabled(t *testing.T) {
+	saver := new(trace.Saver)
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			HTTP3Enabled: true,
+		},
+		Logger: log.Log,
+		Saver:  saver,
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configuration.HTTPConfig.HTTP3Enabled != true {
+		t.Fatal("not the HTTP3Enabled we expected")
+	}
+}
+
 func TestConfigurerNewConfigurationProxyURL(t *testing.T) {
 	URL, _ := url.Parse("socks5://127.0.0.1:9050")
 	saver := new(trace.Saver)
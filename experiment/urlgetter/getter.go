@@ -2,8 +2,11 @@ package urlgetter
 
 import (
 	"context"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/ooni/probe-engine/internal/proxyx"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx/archival"
 	"github.com/ooni/probe-engine/netx/errorx"
@@ -50,22 +53,24 @@ func (g Getter) Get(ctx context.Context) (TestKeys, error) {
 	tk.FailedOperation = archival.NewFailedOperation(err)
 	tk.Failure = archival.NewFailure(err)
 	events := saver.Read()
+	events = append(events, g.waitForLateReplies(ctx, saver, events)...)
+	if sink := trace.ContextSink(ctx); sink != nil {
+		// We write all the events at once, so that, if the sink
+		// allows it, concurrent getters' events do not interleave.
+		trace.WriteBatch(sink, events)
+	}
 	tk.Queries = append(
 		tk.Queries, archival.NewDNSQueriesList(
 			g.Begin, events, g.Session.ASNDatabasePath())...,
 	)
+	tk.DNSRepliesDisagree = DNSRepliesDisagree(tk.Queries)
 	tk.NetworkEvents = append(
 		tk.NetworkEvents, archival.NewNetworkEventsList(g.Begin, events)...,
 	)
 	tk.Requests = append(
 		tk.Requests, archival.NewRequestList(g.Begin, events)...,
 	)
-	if len(tk.Requests) > 0 {
-		// OONI's convention is that the last request appears first
-		tk.HTTPResponseStatus = tk.Requests[0].Response.Code
-		tk.HTTPResponseBody = tk.Requests[0].Response.Body.Value
-		tk.HTTPResponseLocations = tk.Requests[0].Response.Locations
-	}
+	tk.setHTTPResponseFields()
 	tk.TCPConnect = append(
 		tk.TCPConnect, archival.NewTCPConnectList(g.Begin, events)...,
 	)
@@ -75,6 +80,34 @@ func (g Getter) Get(ctx context.Context) (TestKeys, error) {
 	return tk, err
 }
 
+// waitForLateReplies waits for the late replies window, if any, to expire
+// for all the UDP DNS round trips in events. Then, it returns the events
+// saved in the meanwhile, i.e., the late replies read in the background.
+func (g Getter) waitForLateReplies(
+	ctx context.Context, saver *trace.Saver, events []trace.Event) []trace.Event {
+	if g.Config.DNSLateReplies <= 0 {
+		return nil
+	}
+	var last time.Time
+	for _, ev := range events {
+		if ev.Name == "dns_round_trip_done" && ev.Proto == "udp" &&
+			ev.Err == nil && ev.Time.After(last) {
+			last = ev.Time
+		}
+	}
+	if last.IsZero() {
+		return nil
+	}
+	window := time.Duration(g.Config.DNSLateReplies) * time.Millisecond
+	timer := time.NewTimer(time.Until(last.Add(window)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return saver.Read()
+}
+
 func (g Getter) get(ctx context.Context, saver *trace.Saver) (TestKeys, error) {
 	tk := TestKeys{
 		Agent:  "redirect",
@@ -91,9 +124,7 @@ func (g Getter) get(ctx context.Context, saver *trace.Saver) (TestKeys, error) {
 		return tk, err
 	}
 	tk.BootstrapTime = g.Session.TunnelBootstrapTime().Seconds()
-	if url := g.Session.ProxyURL(); url != nil {
-		tk.SOCKSProxy = url.Host
-	}
+	tk.Proxy, tk.SOCKSProxy = proxyx.Describe(g.Session.ProxyURL())
 	// create configuration
 	configurer := Configurer{
 		Config:   g.Config,
@@ -114,3 +145,45 @@ func (g Getter) get(ctx context.Context, saver *trace.Saver) (TestKeys, error) {
 	}
 	return tk, runner.Run(ctx)
 }
+
+// DNSRepliesDisagree returns true when queries contains more than a reply
+// for the same hostname and query type and such replies contain different
+// answers or failures. This happens, for example, when an on-path middlebox
+// injects a reply that races with the legitimate reply. To see more than a
+// reply per query, you need to set Config.DNSLateReplies. We only compare
+// the entries containing a raw reply, i.e., the first and the late replies,
+// because the other entries (e.g., the ones of LookupHost) are our own
+// summary of a lookup rather than replies.
+func DNSRepliesDisagree(queries []archival.DNSQueryEntry) bool {
+	seen := make(map[string]string)
+	for _, query := range queries {
+		if query.RawResponse == "" {
+			continue
+		}
+		key := query.Hostname + " " + query.QueryType
+		value := summarizeDNSReply(query)
+		if prev, found := seen[key]; found && prev != value {
+			return true
+		}
+		seen[key] = value
+	}
+	return false
+}
+
+// summarizeDNSReply returns a string summarizing the failure and the
+// answers matching the query type, in a way that is independent of the
+// order of the answers and of their TTL.
+func summarizeDNSReply(query archival.DNSQueryEntry) string {
+	var values []string
+	for _, answer := range query.Answers {
+		if answer.AnswerType != query.QueryType {
+			continue // e.g. skip the CNAME chain
+		}
+		values = append(values, answer.IPv4+answer.IPv6+answer.Hostname+answer.TXT)
+	}
+	sort.Strings(values)
+	if query.Failure != nil {
+		values = append(values, *query.Failure)
+	}
+	return strings.Join(values, " ")
+}
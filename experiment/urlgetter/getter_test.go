@@ -4,14 +4,19 @@ import (
 	"context"
 	"errors"
 	"io"
+	"net"
+	"net/http"
 	"net/url"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/experiment/urlgetter"
 	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/netx/archival"
 	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 func TestGetterWithCancelledContextVanilla(t *testing.T) {
@@ -81,6 +86,30 @@ func TestGetterWithCancelledContextVanilla(t *testing.T) {
 	}
 }
 
+func TestGetterWithCancelledContextAndContextSink(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	saver := new(trace.Saver)
+	ctx = trace.WithSink(ctx, saver)
+	g := urlgetter.Getter{
+		Session: &mockable.Session{},
+		Target:  "https://www.google.com",
+	}
+	tk, err := g.Get(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("not the error we expected")
+	}
+	ev := saver.Read()
+	if len(ev) != len(tk.NetworkEvents) {
+		t.Fatal("unexpected number of events")
+	}
+	for idx, e := range ev {
+		if e.Name != tk.NetworkEvents[idx].Operation {
+			t.Fatal("unexpected event", e.Name)
+		}
+	}
+}
+
 func TestGetterWithCancelledContextAndMethod(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
@@ -655,3 +684,207 @@ func TestGetterIntegrationTLSHandshake(t *testing.T) {
 		t.Fatal("not the HTTPResponseBody we expected")
 	}
 }
+
+func TestGetterHTTP3WithLocalServer(t *testing.T) {
+	address, stop := newHTTP3Server(t, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("antani"))
+		}))
+	defer stop()
+	g := urlgetter.Getter{
+		Config: urlgetter.Config{
+			HTTP3Enabled: true,
+			NoTLSVerify:  true,
+		},
+		Session: &mockable.Session{},
+		Target:  "https://" + address + "/",
+	}
+	tk, err := g.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Failure != nil {
+		t.Fatal("not the Failure we expected")
+	}
+	if len(tk.TLSHandshakes) != 1 {
+		t.Fatal("not the TLSHandshakes we expected")
+	}
+	if tk.TLSHandshakes[0].NegotiatedProtocol != "h3-29" {
+		t.Fatal("not the NegotiatedProtocol we expected")
+	}
+	if len(tk.Requests) != 1 {
+		t.Fatal("not the Requests we expected")
+	}
+	if tk.HTTPResponseStatus != 200 {
+		t.Fatal("not the HTTPResponseStatus we expected")
+	}
+}
+
+// newInjectingDNSServer starts a DNS server that replies to A queries with
+// an injected reply followed by the legitimate reply and to AAAA queries
+// with two empty replies. It returns the server address and a function
+// to stop the server.
+func newInjectingDNSServer(t *testing.T) (string, func()) {
+	pconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			buffer := make([]byte, 1<<17)
+			count, addr, err := pconn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			query := new(dns.Msg)
+			if err := query.Unpack(buffer[:count]); err != nil {
+				continue
+			}
+			for _, ip := range []string{"10.10.34.35", "93.184.216.34"} {
+				reply := new(dns.Msg)
+				reply.SetReply(query)
+				if query.Question[0].Qtype == dns.TypeA {
+					reply.Answer = append(reply.Answer, &dns.A{
+						Hdr: dns.RR_Header{
+							Name:   query.Question[0].Name,
+							Rrtype: dns.TypeA,
+							Class:  dns.ClassINET,
+						},
+						A: net.ParseIP(ip),
+					})
+				}
+				data, err := reply.Pack()
+				if err != nil {
+					continue
+				}
+				pconn.WriteTo(data, addr)
+			}
+		}
+	}()
+	return pconn.LocalAddr().String(), func() { pconn.Close() }
+}
+
+func TestGetterDNSLateRepliesWithLocalServer(t *testing.T) {
+	address, stop := newInjectingDNSServer(t)
+	defer stop()
+	const window = 300 * time.Millisecond
+	g := urlgetter.Getter{
+		Config: urlgetter.Config{
+			DNSLateReplies: int64(window / time.Millisecond),
+			ResolverURL:    "udp://" + address,
+		},
+		Session: &mockable.Session{},
+		Target:  "dnslookup://x.org",
+	}
+	tk, err := g.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tk.DNSRepliesDisagree {
+		t.Fatal("expected the replies to disagree")
+	}
+	var found bool
+	for _, query := range tk.Queries {
+		for _, answer := range query.Answers {
+			found = found || answer.IPv4 == "93.184.216.34"
+		}
+	}
+	if !found {
+		t.Fatal("we did not save the late reply")
+	}
+}
+
+func TestDNSRepliesDisagree(t *testing.T) {
+	failure := errorx.FailureDNSNXDOMAINError
+	noAnswer := errorx.FailureDNSNoAnswerError
+	servfail := errorx.FailureDNSServfailError
+	// lookup is an entry created by LookupHost, while reply is an entry
+	// created from a raw reply, i.e., the first or a late reply.
+	lookup := func(qtype string, failure *string, addrs ...string) archival.DNSQueryEntry {
+		var answers []archival.DNSAnswerEntry
+		for _, addr := range addrs {
+			answers = append(answers, archival.DNSAnswerEntry{AnswerType: "A", IPv4: addr})
+		}
+		return archival.DNSQueryEntry{
+			Answers:   answers,
+			Failure:   failure,
+			Hostname:  "x.org",
+			QueryType: qtype,
+		}
+	}
+	reply := func(qtype string, failure *string, addrs ...string) archival.DNSQueryEntry {
+		entry := lookup(qtype, failure, addrs...)
+		entry.RawResponse = "AAAA" // any non empty value is fine here
+		return entry
+	}
+	tests := []struct {
+		name    string
+		queries []archival.DNSQueryEntry
+		want    bool
+	}{{
+		name:    "with no queries",
+		queries: nil,
+		want:    false,
+	}, {
+		name: "with a single reply per query",
+		queries: []archival.DNSQueryEntry{
+			reply("A", nil, "10.0.0.1"), reply("AAAA", &noAnswer),
+		},
+		want: false,
+	}, {
+		name: "with identical replies in different order",
+		queries: []archival.DNSQueryEntry{
+			reply("A", nil, "10.0.0.1", "10.0.0.2"),
+			reply("A", nil, "10.0.0.2", "10.0.0.1"),
+		},
+		want: false,
+	}, {
+		name: "with different addresses",
+		queries: []archival.DNSQueryEntry{
+			reply("A", nil, "10.10.34.35"), reply("A", nil, "93.184.216.34"),
+		},
+		want: true,
+	}, {
+		name: "with a failure and a success",
+		queries: []archival.DNSQueryEntry{
+			reply("A", &failure), reply("A", nil, "93.184.216.34"),
+		},
+		want: true,
+	}, {
+		name: "with a NODATA reply and a late duplicate",
+		queries: []archival.DNSQueryEntry{
+			lookup("A", nil, "93.184.216.34"),
+			reply("AAAA", &noAnswer), reply("AAAA", &noAnswer),
+		},
+		want: false,
+	}, {
+		name: "with a NODATA reply and a success",
+		queries: []archival.DNSQueryEntry{
+			reply("A", &noAnswer), reply("A", nil, "93.184.216.34"),
+		},
+		want: true,
+	}, {
+		// LookupHost succeeds when the A query succeeds, hence its AAAA
+		// entry has no failure, while the replies to AAAA are SERVFAIL.
+		name: "with a lookup that succeeds and replies that fail",
+		queries: []archival.DNSQueryEntry{
+			lookup("A", nil, "93.184.216.34"), lookup("AAAA", nil),
+			reply("A", nil, "93.184.216.34"), reply("A", nil, "93.184.216.34"),
+			reply("AAAA", &servfail), reply("AAAA", &servfail),
+		},
+		want: false,
+	}, {
+		name: "with lookups that disagree and no replies",
+		queries: []archival.DNSQueryEntry{
+			lookup("A", nil, "10.10.34.35"), lookup("A", nil, "93.184.216.34"),
+		},
+		want: false,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := urlgetter.DNSRepliesDisagree(tt.queries); got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
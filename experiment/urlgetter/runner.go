@@ -2,6 +2,7 @@ package urlgetter
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -10,10 +11,12 @@ import (
 	"net/http/cookiejar"
 	"net/url"
 
+	"github.com/lucas-clemente/quic-go"
 	"github.com/ooni/probe-engine/internal/httpheader"
 	"github.com/ooni/probe-engine/internal/runtimex"
 	"github.com/ooni/probe-engine/netx"
 	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/quicx"
 )
 
 const httpRequestFailed = "http_request_failed"
@@ -45,6 +48,8 @@ func (r Runner) Run(ctx context.Context) error {
 		return r.dnsLookup(ctx, targetURL.Hostname())
 	case "tlshandshake":
 		return r.tlsHandshake(ctx, targetURL.Host)
+	case "quichandshake":
+		return r.quicHandshake(ctx, targetURL.Host)
 	case "tcpconnect":
 		return r.tcpConnect(ctx, targetURL.Host)
 	default:
@@ -119,6 +124,33 @@ func (r Runner) tlsHandshake(ctx context.Context, address string) error {
 	return err
 }
 
+// quicHandshakeNextProtos is the ALPN we use for quichandshake
+// when the configuration does not specify any protocol.
+var quicHandshakeNextProtos = []string{"h3-29"}
+
+func (r Runner) quicHandshake(ctx context.Context, address string) error {
+	tlsConfig := new(tls.Config)
+	if r.HTTPConfig.TLSConfig != nil {
+		tlsConfig.ServerName = r.HTTPConfig.TLSConfig.ServerName
+		tlsConfig.MinVersion = r.HTTPConfig.TLSConfig.MinVersion
+		tlsConfig.MaxVersion = r.HTTPConfig.TLSConfig.MaxVersion
+	}
+	// The TCP-oriented ALPN set by the configurer (i.e. h2 and
+	// http/1.1) does not make sense for QUIC, so we use h3.
+	tlsConfig.NextProtos = quicHandshakeNextProtos
+	tlsConfig.RootCAs = r.HTTPConfig.CertPool
+	if tlsConfig.RootCAs == nil {
+		tlsConfig.RootCAs = netx.CertPool
+	}
+	tlsConfig.InsecureSkipVerify = r.HTTPConfig.NoTLSVerify
+	dialer := quicx.NewQUICDialer(r.HTTPConfig)
+	sess, err := dialer.DialContext(ctx, "udp", address, tlsConfig, &quic.Config{})
+	if sess != nil {
+		sess.CloseWithError(0, "")
+	}
+	return err
+}
+
 func (r Runner) tcpConnect(ctx context.Context, address string) error {
 	dialer := netx.NewDialer(r.HTTPConfig)
 	conn, err := dialer.DialContext(ctx, "tcp", address)
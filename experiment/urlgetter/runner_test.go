@@ -4,16 +4,40 @@ import (
 	"context"
 	"errors"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/lucas-clemente/quic-go/http3"
 	"github.com/ooni/probe-engine/atomicx"
 	"github.com/ooni/probe-engine/experiment/urlgetter"
 	"github.com/ooni/probe-engine/internal/httpheader"
+	"github.com/ooni/probe-engine/netx"
 )
 
+// newHTTP3Server starts an in-process HTTP/3 server using handler and
+// returns its address and a function to stop the server. We borrow the
+// certificate from httptest, so clients must disable TLS verification.
+func newHTTP3Server(t *testing.T, handler http.Handler) (string, func()) {
+	tlsServer := httptest.NewTLSServer(handler)
+	pconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http3.Server{Server: &http.Server{
+		Handler:   handler,
+		TLSConfig: tlsServer.TLS,
+	}}
+	go server.Serve(pconn)
+	return pconn.LocalAddr().String(), func() {
+		server.Close()
+		pconn.Close()
+		tlsServer.Close()
+	}
+}
+
 func TestRunnerWithInvalidURLScheme(t *testing.T) {
 	r := urlgetter.Runner{Target: "antani://www.google.com"}
 	err := r.Run(context.Background())
@@ -52,6 +76,44 @@ func TestRunnerTLSHandshakeWithContextCanceled(t *testing.T) {
 	}
 }
 
+func TestRunnerQUICHandshakeWithContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := urlgetter.Runner{Target: "quichandshake://www.google.com:443"}
+	err := r.Run(ctx)
+	if err == nil || err.Error() != "interrupted" {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestRunnerQUICHandshakeSuccess(t *testing.T) {
+	address, stop := newHTTP3Server(t, http.NotFoundHandler())
+	defer stop()
+	r := urlgetter.Runner{
+		HTTPConfig: netx.Config{NoTLSVerify: true},
+		Target:     "quichandshake://" + address,
+	}
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunnerHTTP3Success(t *testing.T) {
+	address, stop := newHTTP3Server(t, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("antani"))
+		}))
+	defer stop()
+	r := urlgetter.Runner{
+		Config:     urlgetter.Config{FailOnHTTPError: true},
+		HTTPConfig: netx.Config{HTTP3Enabled: true, NoTLSVerify: true},
+		Target:     "https://" + address + "/",
+	}
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestRunnerTCPConnectWithContextCanceled(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
@@ -5,6 +5,7 @@ package urlgetter
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	"github.com/ooni/probe-engine/model"
@@ -20,15 +21,21 @@ const (
 type Config struct {
 	DNSCache          string `ooni:"Add 'DOMAIN IP...' to cache"`
 	DNSHTTPHost       string `ooni:"Force using specific HTTP Host header for DNS requests"`
+	DNSLateReplies    int64  `ooni:"Milliseconds to wait for late UDP DNS replies after the first one"`
+	DNSSECValidation  bool   `ooni:"Request and validate DNSSEC signatures of DNS replies"`
 	DNSTLSServerName  string `ooni:"Force TLS to using a specific SNI for encrypted DNS requests"`
 	FailOnHTTPError   bool   `ooni:"Fail HTTP request if status code is 400 or above"`
+	HTTP3Enabled      bool   `ooni:"Use HTTP/3 instead of TCP-based HTTP"`
 	HTTPHost          string `ooni:"Force using specific HTTP Host header"`
 	Method            string `ooni:"Force HTTP method different than GET"`
 	NoFollowRedirects bool   `ooni:"Disable following redirects"`
 	NoTLSVerify       bool   `ooni:"Disable TLS verification"`
 	RejectDNSBogons   bool   `ooni:"Fail DNS lookup if response contains bogons"`
 	ResolverURL       string `ooni:"URL describing the resolver to use"`
+	TLSFingerprint    string `ooni:"Mimic a browser's Client Hello (e.g. 'chrome', 'firefox', 'ios', 'randomized')"`
 	TLSServerName     string `ooni:"Force TLS to using a specific SNI in Client Hello"`
+	TLSSplitSize      int64  `ooni:"Size of the TCP segments or TLS records when splitting the Client Hello"`
+	TLSSplitStrategy  string `ooni:"Split the Client Hello to evade SNI filtering ('sni', 'tcp_segments', 'tls_records')"`
 	TLSVersion        string `ooni:"Force specific TLS version (e.g. 'TLSv1.3')"`
 	Tunnel            string `ooni:"Run experiment over a tunnel, e.g. psiphon"`
 	UserAgent         string `ooni:"Use the specified User-Agent"`
@@ -37,18 +44,20 @@ type Config struct {
 // TestKeys contains the experiment's result.
 type TestKeys struct {
 	// The following fields are part of the typical JSON emitted by OONI.
-	Agent           string                     `json:"agent"`
-	BootstrapTime   float64                    `json:"bootstrap_time,omitempty"`
-	DNSCache        []string                   `json:"dns_cache,omitempty"`
-	FailedOperation *string                    `json:"failed_operation"`
-	Failure         *string                    `json:"failure"`
-	NetworkEvents   []archival.NetworkEvent    `json:"network_events"`
-	Queries         []archival.DNSQueryEntry   `json:"queries"`
-	Requests        []archival.RequestEntry    `json:"requests"`
-	SOCKSProxy      string                     `json:"socksproxy,omitempty"`
-	TCPConnect      []archival.TCPConnectEntry `json:"tcp_connect"`
-	TLSHandshakes   []archival.TLSHandshake    `json:"tls_handshakes"`
-	Tunnel          string                     `json:"tunnel,omitempty"`
+	Agent              string                     `json:"agent"`
+	BootstrapTime      float64                    `json:"bootstrap_time,omitempty"`
+	DNSCache           []string                   `json:"dns_cache,omitempty"`
+	DNSRepliesDisagree bool                       `json:"dns_replies_disagree,omitempty"`
+	FailedOperation    *string                    `json:"failed_operation"`
+	Failure            *string                    `json:"failure"`
+	NetworkEvents      []archival.NetworkEvent    `json:"network_events"`
+	Proxy              string                     `json:"proxy,omitempty"`
+	Queries            []archival.DNSQueryEntry   `json:"queries"`
+	Requests           []archival.RequestEntry    `json:"requests"`
+	SOCKSProxy         string                     `json:"socksproxy,omitempty"`
+	TCPConnect         []archival.TCPConnectEntry `json:"tcp_connect"`
+	TLSHandshakes      []archival.TLSHandshake    `json:"tls_handshakes"`
+	Tunnel             string                     `json:"tunnel,omitempty"`
 
 	// The following fields are not serialised but are useful to simplify
 	// analysing the measurements in telegram, whatsapp, etc.
@@ -57,6 +66,29 @@ type TestKeys struct {
 	HTTPResponseLocations []string `json:"-"`
 }
 
+// UnmarshalJSON is like the default JSON unmarshaling except that it
+// also sets the fields that are not serialised, such that you can analyse
+// the test keys of measurements saved in the past.
+func (tk *TestKeys) UnmarshalJSON(d []byte) error {
+	type testKeys TestKeys // avoid infinite recursion
+	var out testKeys
+	if err := json.Unmarshal(d, &out); err != nil {
+		return err
+	}
+	*tk = TestKeys(out)
+	tk.setHTTPResponseFields()
+	return nil
+}
+
+func (tk *TestKeys) setHTTPResponseFields() {
+	if len(tk.Requests) > 0 {
+		// OONI's convention is that the last request appears first
+		tk.HTTPResponseStatus = tk.Requests[0].Response.Code
+		tk.HTTPResponseBody = tk.Requests[0].Response.Body.Value
+		tk.HTTPResponseLocations = tk.Requests[0].Response.Locations
+	}
+}
+
 // RegisterExtensions registers the extensions used by the urlgetter
 // experiment into the provided measurement.
 func RegisterExtensions(m *model.Measurement) {
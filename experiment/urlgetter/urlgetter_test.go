@@ -2,6 +2,7 @@ package urlgetter_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"testing"
 
@@ -68,3 +69,29 @@ func TestMeasurerDNSCache(t *testing.T) {
 		t.Fatal("invalid tk.DNSCache")
 	}
 }
+
+func TestTestKeysUnmarshalJSON(t *testing.T) {
+	var tk urlgetter.TestKeys
+	data := []byte(`{"requests": [{
+		"response": {
+			"body": {"format": "base64", "data": "/w=="},
+			"code": 302,
+			"headers_list": [["Location", "https://x.org/"]]
+		}
+	}]}`)
+	if err := json.Unmarshal(data, &tk); err != nil {
+		t.Fatal(err)
+	}
+	if tk.HTTPResponseStatus != 302 {
+		t.Fatal("not the HTTPResponseStatus we expected")
+	}
+	if tk.HTTPResponseBody != "\xff" {
+		t.Fatal("not the HTTPResponseBody we expected")
+	}
+	if len(tk.HTTPResponseLocations) != 1 || tk.HTTPResponseLocations[0] != "https://x.org/" {
+		t.Fatal("not the HTTPResponseLocations we expected")
+	}
+	if err := json.Unmarshal([]byte(`{"requests": {}}`), &tk); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
@@ -12,24 +12,41 @@ import (
 
 // DNSLookupConfig contains settings for the DNS lookup.
 type DNSLookupConfig struct {
-	Session model.ExperimentSession
-	URL     *url.URL
+	LateReplies int64 // milliseconds
+	ResolverURL string
+	Session     model.ExperimentSession
+	URL         *url.URL
 }
 
 // DNSLookupResult contains the result of the DNS lookup.
 type DNSLookupResult struct {
-	Addrs    map[string]int64
-	Failure  *string
-	TestKeys urlgetter.TestKeys
+	Addrs           map[string]int64
+	Failure         *string
+	RepliesDisagree bool
+	TestKeys        urlgetter.TestKeys
 }
 
 // DNSLookup performs the DNS lookup part of Web Connectivity.
 func DNSLookup(ctx context.Context, config DNSLookupConfig) (out DNSLookupResult) {
 	target := fmt.Sprintf("dnslookup://%s", config.URL.Hostname())
 	config.Session.Logger().Infof("%s...", target)
-	result, err := urlgetter.Getter{Session: config.Session, Target: target}.Get(ctx)
+	result, err := urlgetter.Getter{
+		Config: urlgetter.Config{
+			DNSLateReplies: config.LateReplies,
+			ResolverURL:    config.ResolverURL,
+		},
+		Session: config.Session,
+		Target:  target,
+	}.Get(ctx)
+	config.Session.Logger().Infof("%s... %+v", target, err)
+	return newDNSLookupResult(result)
+}
+
+// newDNSLookupResult creates a DNSLookupResult from the test keys
+// of the urlgetter measurement performing the lookup.
+func newDNSLookupResult(tk urlgetter.TestKeys) (out DNSLookupResult) {
 	out.Addrs = make(map[string]int64)
-	for _, query := range result.Queries {
+	for _, query := range tk.Queries {
 		for _, answer := range query.Answers {
 			if answer.IPv4 != "" {
 				out.Addrs[answer.IPv4] = answer.ASN
@@ -40,9 +57,9 @@ func DNSLookup(ctx context.Context, config DNSLookupConfig) (out DNSLookupResult
 			}
 		}
 	}
-	config.Session.Logger().Infof("%s... %+v", target, err)
-	out.Failure = result.Failure
-	out.TestKeys = result
+	out.Failure = tk.Failure
+	out.RepliesDisagree = tk.DNSRepliesDisagree
+	out.TestKeys = tk
 	return
 }
 
@@ -0,0 +1,170 @@
+package webconnectivity
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// The address families we measure separately.
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// SplitAddresses splits addrs into IPv4 and IPv6 addresses. We
+// ignore the strings that are not valid IP addresses.
+func SplitAddresses(addrs []string) (ipv4, ipv6 []string) {
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		switch {
+		case ip == nil:
+		case ip.To4() != nil:
+			ipv4 = append(ipv4, addr)
+		default:
+			ipv6 = append(ipv6, addr)
+		}
+	}
+	return
+}
+
+// ipv6RouteCheckAddress is the address we use to check whether we have
+// an IPv6 route. It is the address of Google's IPv6 public resolver.
+const ipv6RouteCheckAddress = "[2001:4860:4860::8888]:443"
+
+// HasIPv6Route returns whether the probe has a route to IPv6 hosts. We
+// check by connecting an UDP socket, which does not send any packet.
+func HasIPv6Route() bool {
+	conn, err := net.Dial("udp6", ipv6RouteCheckAddress)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// FamiliesConfig contains the config for MeasureFamilies
+type FamiliesConfig struct {
+	Addresses []string
+
+	// HasIPv6Route is the function checking whether we have an
+	// IPv6 route. We only call it when we have IPv6 addresses. If
+	// nil, we use the HasIPv6Route function of this package.
+	HasIPv6Route func() bool
+
+	Session   model.ExperimentSession
+	TargetURL *url.URL
+}
+
+func (config FamiliesConfig) hasIPv6Route() bool {
+	if config.HasIPv6Route != nil {
+		return config.HasIPv6Route()
+	}
+	return HasIPv6Route()
+}
+
+// FamilyResult contains the results of measuring an address family. When
+// Skipped is true, we did not measure because we have no IPv6 route.
+type FamilyResult struct {
+	Connects ConnectsResult
+	HTTP     HTTPGetResult
+	Name     string
+	Skipped  bool
+}
+
+// MeasureFamilies performs the TCP/TLS connects and the HTTP GET step
+// separately for each address family having addresses. When we do not
+// have an IPv6 route, we skip IPv6, rather than producing failures that
+// do not depend on the network we are measuring.
+func MeasureFamilies(ctx context.Context, config FamiliesConfig) (out []FamilyResult) {
+	ipv4, ipv6 := SplitAddresses(config.Addresses)
+	families := []struct {
+		addrs []string
+		name  string
+	}{{addrs: ipv4, name: FamilyIPv4}, {addrs: ipv6, name: FamilyIPv6}}
+	for _, family := range families {
+		if len(family.addrs) <= 0 {
+			continue
+		}
+		if family.name == FamilyIPv6 && !config.hasIPv6Route() {
+			config.Session.Logger().Infof("no IPv6 route: skipping %v", family.addrs)
+			out = append(out, FamilyResult{Name: family.name, Skipped: true})
+			continue
+		}
+		result := FamilyResult{Name: family.name}
+		result.Connects = Connects(ctx, ConnectsConfig{
+			Session:       config.Session,
+			TargetURL:     config.TargetURL,
+			URLGetterURLs: NewEndpoints(config.TargetURL, family.addrs).URLs(),
+		})
+		config.Session.Logger().Infof("TCP/TLS endpoints (%s): %d/%d reachable",
+			family.name, result.Connects.Successes, result.Connects.Total)
+		result.HTTP = HTTPGet(ctx, HTTPGetConfig{
+			Addresses: family.addrs,
+			Session:   config.Session,
+			TargetURL: config.TargetURL,
+		})
+		out = append(out, result)
+	}
+	return
+}
+
+// PrimaryFamily returns the family whose HTTP results we compare with
+// the control, i.e., the first family where the HTTP GET succeeded, if
+// any, and otherwise the first family we measured. When we did not
+// measure any family, this function returns an empty result.
+func PrimaryFamily(families []FamilyResult) (out FamilyResult) {
+	for _, family := range families {
+		if family.Skipped {
+			continue
+		}
+		if family.HTTP.Failure == nil {
+			return family
+		}
+		if out.Name == "" {
+			out = family
+		}
+	}
+	return
+}
+
+// FamilySummary summarizes the measurement of an address family.
+type FamilySummary struct {
+	// Reachable is nil when we skipped this family, true when the
+	// HTTP GET succeeded, and false otherwise.
+	Reachable *bool `json:"reachable"`
+
+	// Skipped is true when we did not measure this family because
+	// the probe does not have an IPv6 route.
+	Skipped bool `json:"skipped"`
+
+	// TCPConnectAttempts is the number of TCP/TLS connects.
+	TCPConnectAttempts int `json:"tcp_connect_attempts"`
+
+	// TCPConnectSuccesses is the number of successful TCP/TLS connects.
+	TCPConnectSuccesses int `json:"tcp_connect_successes"`
+}
+
+// SummarizeFamilies computes the summary of each address family. It
+// returns nil when we did not measure any family.
+func SummarizeFamilies(families []FamilyResult) map[string]FamilySummary {
+	if len(families) <= 0 {
+		return nil
+	}
+	out := make(map[string]FamilySummary)
+	for _, family := range families {
+		if family.Skipped {
+			out[family.Name] = FamilySummary{Skipped: true}
+			continue
+		}
+		reachable := family.HTTP.Failure == nil
+		out[family.Name] = FamilySummary{
+			Reachable:           &reachable,
+			TCPConnectAttempts:  family.Connects.Total,
+			TCPConnectSuccesses: family.Connects.Successes,
+		}
+	}
+	return out
+}
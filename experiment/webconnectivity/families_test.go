@@ -0,0 +1,182 @@
+package webconnectivity_test
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/probe-engine/experiment/webconnectivity"
+	"github.com/ooni/probe-engine/internal/mockable"
+)
+
+func TestSplitAddresses(t *testing.T) {
+	ipv4, ipv6 := webconnectivity.SplitAddresses([]string{
+		"104.16.249.249", "2606:4700::6810:f9f9", "antani", "::ffff:1.1.1.1",
+	})
+	if diff := cmp.Diff([]string{"104.16.249.249", "::ffff:1.1.1.1"}, ipv4); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff([]string{"2606:4700::6810:f9f9"}, ipv6); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestHasIPv6Route(t *testing.T) {
+	// We cannot know whether this host has IPv6, so we just make
+	// sure that the function does not misbehave
+	t.Log(webconnectivity.HasIPv6Route())
+}
+
+func TestMeasureFamiliesWithoutIPv6Route(t *testing.T) {
+	out := webconnectivity.MeasureFamilies(context.Background(), webconnectivity.FamiliesConfig{
+		Addresses: []string{"2606:4700::6810:f9f9", "2606:4700::6810:f8f9"},
+		HasIPv6Route: func() bool {
+			return false
+		},
+		Session:   &mockable.Session{MockableLogger: log.Log},
+		TargetURL: &url.URL{Scheme: "https", Host: "cloudflare-dns.com", Path: "/"},
+	})
+	expected := []webconnectivity.FamilyResult{{
+		Name:    webconnectivity.FamilyIPv6,
+		Skipped: true,
+	}}
+	if diff := cmp.Diff(expected, out); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestMeasureFamiliesWithoutAddresses(t *testing.T) {
+	out := webconnectivity.MeasureFamilies(context.Background(), webconnectivity.FamiliesConfig{
+		HasIPv6Route: func() bool {
+			t.Fatal("should not be called")
+			return true
+		},
+		Session:   &mockable.Session{MockableLogger: log.Log},
+		TargetURL: &url.URL{Scheme: "https", Host: "cloudflare-dns.com", Path: "/"},
+	})
+	if len(out) != 0 {
+		t.Fatal("expected no results")
+	}
+}
+
+func TestMeasureFamiliesWithBothFamilies(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	hasIPv6 := webconnectivity.HasIPv6Route()
+	out := webconnectivity.MeasureFamilies(context.Background(), webconnectivity.FamiliesConfig{
+		Addresses: []string{"104.16.249.249", "2606:4700::6810:f9f9"},
+		HasIPv6Route: func() bool {
+			return hasIPv6
+		},
+		Session:   newsession(t, false),
+		TargetURL: &url.URL{Scheme: "https", Host: "cloudflare-dns.com", Path: "/"},
+	})
+	if len(out) != 2 {
+		t.Fatal("unexpected number of families")
+	}
+	if out[0].Name != webconnectivity.FamilyIPv4 || out[0].Connects.Total != 1 {
+		t.Fatal("unexpected IPv4 result")
+	}
+	if out[0].HTTP.Failure != nil {
+		t.Fatal(*out[0].HTTP.Failure)
+	}
+	if out[1].Name != webconnectivity.FamilyIPv6 || out[1].Skipped == hasIPv6 {
+		t.Fatal("unexpected IPv6 result")
+	}
+}
+
+func TestPrimaryFamily(t *testing.T) {
+	failure := io.EOF.Error()
+	ipv4Failure := webconnectivity.FamilyResult{
+		Name: webconnectivity.FamilyIPv4,
+		HTTP: webconnectivity.HTTPGetResult{Failure: &failure},
+	}
+	ipv6Success := webconnectivity.FamilyResult{
+		Name: webconnectivity.FamilyIPv6,
+		HTTP: webconnectivity.HTTPGetResult{},
+	}
+	ipv6Failure := webconnectivity.FamilyResult{
+		Name: webconnectivity.FamilyIPv6,
+		HTTP: webconnectivity.HTTPGetResult{Failure: &failure},
+	}
+	ipv6Skipped := webconnectivity.FamilyResult{
+		Name:    webconnectivity.FamilyIPv6,
+		Skipped: true,
+	}
+	tests := []struct {
+		name     string
+		families []webconnectivity.FamilyResult
+		want     string
+	}{{
+		name: "with no families",
+		want: "",
+	}, {
+		name:     "with only skipped families",
+		families: []webconnectivity.FamilyResult{ipv6Skipped},
+		want:     "",
+	}, {
+		name:     "with the second family succeeding",
+		families: []webconnectivity.FamilyResult{ipv4Failure, ipv6Success},
+		want:     webconnectivity.FamilyIPv6,
+	}, {
+		name:     "with all families failing",
+		families: []webconnectivity.FamilyResult{ipv4Failure, ipv6Failure},
+		want:     webconnectivity.FamilyIPv4,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := webconnectivity.PrimaryFamily(tt.families); got.Name != tt.want {
+				t.Fatal("unexpected primary family", got.Name)
+			}
+		})
+	}
+}
+
+func TestSummarizeFamilies(t *testing.T) {
+	var (
+		failure    = io.EOF.Error()
+		falseValue = false
+		trueValue  = true
+	)
+	if out := webconnectivity.SummarizeFamilies(nil); out != nil {
+		t.Fatal("expected nil summary")
+	}
+	out := webconnectivity.SummarizeFamilies([]webconnectivity.FamilyResult{{
+		Connects: webconnectivity.ConnectsResult{Successes: 0, Total: 2},
+		HTTP:     webconnectivity.HTTPGetResult{Failure: &failure},
+		Name:     webconnectivity.FamilyIPv4,
+	}, {
+		Name:    webconnectivity.FamilyIPv6,
+		Skipped: true,
+	}})
+	expected := map[string]webconnectivity.FamilySummary{
+		webconnectivity.FamilyIPv4: {
+			Reachable:          &falseValue,
+			TCPConnectAttempts: 2,
+		},
+		webconnectivity.FamilyIPv6: {
+			Skipped: true,
+		},
+	}
+	if diff := cmp.Diff(expected, out); diff != "" {
+		t.Fatal(diff)
+	}
+	out = webconnectivity.SummarizeFamilies([]webconnectivity.FamilyResult{{
+		Connects: webconnectivity.ConnectsResult{Successes: 1, Total: 1},
+		Name:     webconnectivity.FamilyIPv6,
+	}})
+	expected = map[string]webconnectivity.FamilySummary{
+		webconnectivity.FamilyIPv6: {
+			Reachable:           &trueValue,
+			TCPConnectAttempts:  1,
+			TCPConnectSuccesses: 1,
+		},
+	}
+	if diff := cmp.Diff(expected, out); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -53,10 +53,15 @@ func HTTPBodyLengthChecks(
 		return
 	}
 	response := tk.Requests[0].Response
-	if response.BodyIsTruncated {
-		return
+	// BodyLength is the length of the whole body, which is set
+	// when we have read the whole body, even if truncated
+	measurement := response.BodyLength
+	if measurement <= 0 {
+		if response.BodyIsTruncated {
+			return
+		}
+		measurement = int64(len(response.Body.Value))
 	}
-	measurement := int64(len(response.Body.Value))
 	if measurement <= 0 {
 		return
 	}
@@ -55,6 +55,28 @@ func TestHTTPBodyLengthChecks(t *testing.T) {
 			},
 		},
 		lengthMatch: nil,
+	}, {
+		name: "response body is truncated but we know its length",
+		args: args{
+			tk: urlgetter.TestKeys{
+				Requests: []archival.RequestEntry{{
+					Response: archival.HTTPResponse{
+						Body: archival.MaybeBinaryValue{
+							Value: randx.Letters(16),
+						},
+						BodyIsTruncated: true,
+						BodyLength:      768,
+					},
+				}},
+			},
+			ctrl: webconnectivity.ControlResponse{
+				HTTPRequest: webconnectivity.ControlHTTPRequestResult{
+					BodyLength: 1024,
+				},
+			},
+		},
+		lengthMatch: &trueValue,
+		proportion:  0.75,
 	}, {
 		name: "response body length is zero",
 		args: args{
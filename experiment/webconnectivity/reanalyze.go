@@ -0,0 +1,35 @@
+package webconnectivity
+
+import (
+	"net/url"
+
+	"github.com/ooni/probe-engine/experiment/urlgetter"
+)
+
+// Reanalyze runs again the DNS analysis, the HTTP analysis and the
+// summary over the given test keys, which typically belong to a
+// measurement saved in the past. Because we do not save the ASNs of
+// the addresses returned by the control, you need to fill them, e.g.,
+// using tk.Control.DNS.FillASNs, to compare the ASNs of the addresses.
+// Otherwise, the DNS analysis only compares the addresses.
+//
+// Because we do not save the results of each address family, this
+// function keeps the families summary of the saved measurement.
+func Reanalyze(URL *url.URL, tk *TestKeys) {
+	if tk.ControlFailure == nil {
+		tk.DNSAnalysisResult = DNSAnalysis(URL, newDNSLookupResult(urlgetter.TestKeys{
+			DNSRepliesDisagree: tk.DNSRepliesDisagree,
+			Failure:            tk.DNSExperimentFailure,
+			Queries:            tk.Queries,
+		}), tk.Control)
+	}
+	tk.HTTPAnalysisResult = HTTPAnalysis(urlgetter.TestKeys{
+		Failure:  tk.HTTPExperimentFailure,
+		Requests: tk.Requests,
+	}, tk.Control)
+	families := tk.Summary.Families
+	tk.Summary = Summarize(tk)
+	if len(tk.Families) <= 0 {
+		tk.Summary.Families = families
+	}
+}
@@ -0,0 +1,137 @@
+package webconnectivity_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/probe-engine/experiment/webconnectivity"
+)
+
+func TestReanalyze(t *testing.T) {
+	filep, err := os.Open("../../testdata/web-connectivity-synthetic.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer filep.Close()
+	scanner := bufio.NewScanner(filep)
+	scanner.Buffer(nil, 1<<20)
+	var count int
+	for scanner.Scan() {
+		count++
+		var measurement struct {
+			Input    string          `json:"input"`
+			TestKeys json.RawMessage `json:"test_keys"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &measurement); err != nil {
+			t.Fatal(err)
+		}
+		var tk webconnectivity.TestKeys
+		if err := json.Unmarshal(measurement.TestKeys, &tk); err != nil {
+			t.Fatal(err)
+		}
+		URL, err := url.Parse(measurement.Input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Make sure we really compute the analysis again
+		tk.DNSAnalysisResult = webconnectivity.DNSAnalysisResult{}
+		tk.HTTPAnalysisResult = webconnectivity.HTTPAnalysisResult{}
+		tk.Accessible, tk.Blocking, tk.Status = nil, nil, 0
+		webconnectivity.Reanalyze(URL, &tk)
+		data, err := json.Marshal(tk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected, got interface{}
+		if err := json.Unmarshal(measurement.TestKeys, &expected); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(expected, got); diff != "" {
+			t.Fatalf("%s: %s", measurement.Input, diff)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatal("unexpected number of measurements")
+	}
+}
+
+func TestReanalyzeLegacy(t *testing.T) {
+	// These measurements use the Web Connectivity 0.1.0 format, whose
+	// analysis differs from ours in the details. Hence, we only check
+	// whether we reach the same conclusions.
+	filep, err := os.Open("../../testdata/web-connectivity-legacy.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer filep.Close()
+	scanner := bufio.NewScanner(filep)
+	scanner.Buffer(nil, 1<<20)
+	var count int
+	for scanner.Scan() {
+		count++
+		var measurement struct {
+			Input    string                   `json:"input"`
+			TestKeys webconnectivity.TestKeys `json:"test_keys"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &measurement); err != nil {
+			t.Fatal(err)
+		}
+		URL, err := url.Parse(measurement.Input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tk := measurement.TestKeys
+		expected, err := json.Marshal([]interface{}{tk.Accessible, tk.Blocking})
+		if err != nil {
+			t.Fatal(err)
+		}
+		webconnectivity.Reanalyze(URL, &tk)
+		got, err := json.Marshal([]interface{}{tk.Accessible, tk.Blocking})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(string(expected), string(got)); diff != "" {
+			t.Fatalf("%s: %s", measurement.Input, diff)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatal("unexpected number of measurements")
+	}
+}
+
+func TestTestKeysUnmarshalJSON(t *testing.T) {
+	var tk webconnectivity.TestKeys
+	data := []byte(`{
+		"tcp_connect": [
+			{"ip": "1.1.1.1", "port": 443, "status": {"failure": null, "success": true}},
+			{"ip": "::1", "port": 443, "status": {"failure": "connection_refused", "success": false}}
+		],
+		"accessible": false,
+		"blocking": "tcp_ip"
+	}`)
+	if err := json.Unmarshal(data, &tk); err != nil {
+		t.Fatal(err)
+	}
+	if tk.TCPConnectAttempts != 2 || tk.TCPConnectSuccesses != 1 {
+		t.Fatal("unexpected TCP connect counters")
+	}
+	if tk.BlockingReason == nil || *tk.BlockingReason != "tcp_ip" {
+		t.Fatal("unexpected blocking reason")
+	}
+	if err := json.Unmarshal([]byte(`{"tcp_connect": {}}`), &tk); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
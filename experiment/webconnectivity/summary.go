@@ -58,6 +58,10 @@ type Summary struct {
 	// Status contains zero or more status flags. This is currently
 	// an experimental interface subject to change at any time.
 	Status int64 `json:"x_status"`
+
+	// Families contains the summary of each address family. Like
+	// Status, this is currently an experimental interface.
+	Families map[string]FamilySummary `json:"x_families,omitempty"`
 }
 
 // DetermineBlocking returns the value of Summary.Blocking according to
@@ -92,13 +96,20 @@ func DetermineBlocking(s Summary) interface{} {
 func (s Summary) Log(logger model.Logger) {
 	logger.Infof("Blocking: %+v", internal.StringPointerToString(s.BlockingReason))
 	logger.Infof("Accessible: %+v", internal.BoolPointerToString(s.Accessible))
+	for _, name := range []string{FamilyIPv4, FamilyIPv6} {
+		if family, found := s.Families[name]; found {
+			logger.Infof("Reachable (%s): %+v", name,
+				internal.BoolPointerToString(family.Reachable))
+		}
+	}
 }
 
 // Summarize computes the summary from the TestKeys.
 func Summarize(tk *TestKeys) (out Summary) {
-	// Make sure we correctly set out.Blocking's value.
+	// Make sure we correctly set out.Blocking's and out.Families' values.
 	defer func() {
 		out.Blocking = DetermineBlocking(out)
+		out.Families = SummarizeFamilies(tk.Families)
 	}()
 	var (
 		accessible   = true
@@ -457,3 +457,18 @@ func TestSummarize(t *testing.T) {
 		})
 	}
 }
+
+func TestSummarizeSetsFamilies(t *testing.T) {
+	out := webconnectivity.Summarize(&webconnectivity.TestKeys{
+		Families: []webconnectivity.FamilyResult{{
+			Name:    webconnectivity.FamilyIPv6,
+			Skipped: true,
+		}},
+	})
+	expected := map[string]webconnectivity.FamilySummary{
+		webconnectivity.FamilyIPv6: {Skipped: true},
+	}
+	if diff := cmp.Diff(expected, out.Families); diff != "" {
+		t.Fatal(diff)
+	}
+}
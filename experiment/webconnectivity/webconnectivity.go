@@ -5,6 +5,7 @@ package webconnectivity
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net"
 	"net/url"
@@ -19,11 +20,14 @@ import (
 
 const (
 	testName    = "web_connectivity"
-	testVersion = "0.1.0"
+	testVersion = "0.2.0"
 )
 
 // Config contains the experiment config.
-type Config struct{}
+type Config struct {
+	DNSLateReplies int64  `ooni:"Milliseconds to wait for late UDP DNS replies after the first one"`
+	ResolverURL    string `ooni:"URL describing the resolver to use (default: system resolver)"`
+}
 
 // TestKeys contains webconnectivity test keys.
 type TestKeys struct {
@@ -35,6 +39,7 @@ type TestKeys struct {
 	// DNS experiment
 	Queries              []archival.DNSQueryEntry `json:"queries"`
 	DNSExperimentFailure *string                  `json:"dns_experiment_failure"`
+	DNSRepliesDisagree   bool                     `json:"dns_replies_disagree"`
 	DNSAnalysisResult
 
 	// Control experiment
@@ -52,10 +57,35 @@ type TestKeys struct {
 	HTTPExperimentFailure *string                 `json:"http_experiment_failure"`
 	HTTPAnalysisResult
 
+	// Per address family results
+	Families []FamilyResult `json:"-"`
+
 	// Top-level analysis
 	Summary
 }
 
+// UnmarshalJSON is like the default JSON unmarshaling except that it
+// also sets the fields that are not serialised but that we can compute
+// from the serialised ones. See also Reanalyze.
+func (tk *TestKeys) UnmarshalJSON(d []byte) error {
+	type testKeys TestKeys // avoid infinite recursion
+	var out testKeys
+	if err := json.Unmarshal(d, &out); err != nil {
+		return err
+	}
+	*tk = TestKeys(out)
+	for _, entry := range tk.TCPConnect {
+		if entry.Status.Success {
+			tk.TCPConnectSuccesses++
+		}
+		tk.TCPConnectAttempts++
+	}
+	if reason, ok := tk.Blocking.(string); ok {
+		tk.BlockingReason = &reason
+	}
+	return nil
+}
+
 // Measurer performs the measurement.
 type Measurer struct {
 	Config Config
@@ -129,9 +159,18 @@ func (m Measurer) Run(
 		"backend": testhelper,
 	}
 	// 2. perform the DNS lookup step
-	dnsResult := DNSLookup(ctx, DNSLookupConfig{Session: sess, URL: URL})
+	dnsResult := DNSLookup(ctx, DNSLookupConfig{
+		LateReplies: m.Config.DNSLateReplies,
+		ResolverURL: m.Config.ResolverURL,
+		Session:     sess,
+		URL:         URL,
+	})
 	tk.Queries = append(tk.Queries, dnsResult.TestKeys.Queries...)
 	tk.DNSExperimentFailure = dnsResult.Failure
+	tk.DNSRepliesDisagree = dnsResult.RepliesDisagree
+	if tk.DNSRepliesDisagree {
+		sess.Logger().Warn("DNS replies disagree: possible DNS injection")
+	}
 	epnts := NewEndpoints(URL, dnsResult.Addresses())
 	// 3. perform the control measurement
 	tk.Control, err = Control(ctx, sess, testhelper.Address, ControlRequest{
@@ -150,35 +189,37 @@ func (m Measurer) Run(
 	}
 	sess.Logger().Infof("DNS analysis result: %+v", internal.StringPointerToString(
 		tk.DNSAnalysisResult.DNSConsistency))
-	// 5. perform TCP/TLS connects
-	connectsResult := Connects(ctx, ConnectsConfig{
-		Session:       sess,
-		TargetURL:     URL,
-		URLGetterURLs: epnts.URLs(),
-	})
-	sess.Logger().Infof(
-		"TCP/TLS endpoints: %d/%d reachable", connectsResult.Successes, connectsResult.Total)
-	for _, tcpkeys := range connectsResult.AllKeys {
-		// rewrite TCPConnect to include blocking information - it is very
-		// sad that we're storing analysis result inside the measurement
-		tk.TCPConnect = append(tk.TCPConnect, ComputeTCPBlocking(
-			tcpkeys.TCPConnect, tk.Control.TCPConnect)...)
-	}
-	tk.TCPConnectAttempts = connectsResult.Total
-	tk.TCPConnectSuccesses = connectsResult.Successes
-	// 6. perform HTTP/HTTPS measurement
-	httpResult := HTTPGet(ctx, HTTPGetConfig{
+	// 5. perform TCP/TLS connects and HTTP/HTTPS measurement for each family
+	tk.Families = MeasureFamilies(ctx, FamiliesConfig{
 		Addresses: dnsResult.Addresses(),
 		Session:   sess,
 		TargetURL: URL,
 	})
-	tk.HTTPExperimentFailure = httpResult.Failure
-	tk.Requests = append(tk.Requests, httpResult.TestKeys.Requests...)
-	// 7. compare HTTP measurement to control
-	tk.HTTPAnalysisResult = HTTPAnalysis(httpResult.TestKeys, tk.Control)
+	primary := PrimaryFamily(tk.Families)
+	tk.Requests = append(tk.Requests, primary.HTTP.TestKeys.Requests...)
+	for _, family := range tk.Families {
+		for _, tcpkeys := range family.Connects.AllKeys {
+			// rewrite TCPConnect to include blocking information - it is very
+			// sad that we're storing analysis result inside the measurement
+			tk.TCPConnect = append(tk.TCPConnect, ComputeTCPBlocking(
+				tcpkeys.TCPConnect, tk.Control.TCPConnect)...)
+		}
+		tk.TCPConnectAttempts += family.Connects.Total
+		tk.TCPConnectSuccesses += family.Connects.Successes
+	}
+	// 6. compare the HTTP measurement of the primary family to control
+	tk.HTTPExperimentFailure = primary.HTTP.Failure
+	tk.HTTPAnalysisResult = HTTPAnalysis(primary.HTTP.TestKeys, tk.Control)
 	tk.HTTPAnalysisResult.Log(sess.Logger())
+	// Summarize considers only the requests of the primary family, hence
+	// we add the requests of the other families after it has run.
 	tk.Summary = Summarize(tk)
 	tk.Summary.Log(sess.Logger())
+	for _, family := range tk.Families {
+		if family.Name != primary.Name {
+			tk.Requests = append(tk.Requests, family.HTTP.TestKeys.Requests...)
+		}
+	}
 	return nil
 }
 
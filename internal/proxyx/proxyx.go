@@ -0,0 +1,19 @@
+// Package proxyx contains code to describe the proxy we are using
+// inside the test keys without leaking the proxy credentials.
+package proxyx
+
+import "net/url"
+
+// Describe returns the proxy URL without the credentials, if any, and,
+// when it is a socks5 proxy, the endpoint of the proxy. It returns empty
+// strings when proxyURL is nil, i.e., when we are not using a proxy.
+func Describe(proxyURL *url.URL) (proxy, socksProxy string) {
+	if proxyURL == nil {
+		return
+	}
+	proxy = (&url.URL{Scheme: proxyURL.Scheme, Host: proxyURL.Host}).String()
+	if proxyURL.Scheme == "socks5" {
+		socksProxy = proxyURL.Host
+	}
+	return
+}
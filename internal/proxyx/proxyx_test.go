@@ -0,0 +1,46 @@
+package proxyx_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/ooni/probe-engine/internal/proxyx"
+)
+
+func TestDescribeNil(t *testing.T) {
+	proxy, socksProxy := proxyx.Describe(nil)
+	if proxy != "" || socksProxy != "" {
+		t.Fatal("expected empty strings here")
+	}
+}
+
+func TestDescribeSOCKS5(t *testing.T) {
+	proxyURL := &url.URL{
+		Scheme: "socks5",
+		User:   url.UserPassword("user", "password"),
+		Host:   "127.0.0.1:9050",
+	}
+	proxy, socksProxy := proxyx.Describe(proxyURL)
+	if proxy != "socks5://127.0.0.1:9050" {
+		t.Fatal("not the proxy we expected", proxy)
+	}
+	if socksProxy != "127.0.0.1:9050" {
+		t.Fatal("not the socks proxy we expected", socksProxy)
+	}
+}
+
+func TestDescribeHTTP(t *testing.T) {
+	proxyURL := &url.URL{
+		Scheme: "http",
+		User:   url.User("user"),
+		Host:   "127.0.0.1:8080",
+		Path:   "/secret",
+	}
+	proxy, socksProxy := proxyx.Describe(proxyURL)
+	if proxy != "http://127.0.0.1:8080" {
+		t.Fatal("not the proxy we expected", proxy)
+	}
+	if socksProxy != "" {
+		t.Fatal("expected empty socks proxy here", socksProxy)
+	}
+}
@@ -1,78 +1,270 @@
 // Package sessionresolver contains the resolver used by the session. This
-// resolver uses Powerdns DoH by default and falls back on the system
-// provided resolver if Powerdns DoH is not working.
+// resolver uses a list of resolvers (DoH, DoT, UDP, system, etc.) and sorts
+// them according to a score that accounts for their latency and success rate
+// in previous lookups. We persist the scores when closing idle connections,
+// as well as the DNS cache of each resolver, into config.DNSCacheStore, if
+// set, so that the next session starts by using the resolver that worked
+// best in the previous one.
 package sessionresolver
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/ooni/probe-engine/atomicx"
 	"github.com/ooni/probe-engine/internal/runtimex"
 	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/resolver"
+)
+
+// DefaultURLs contains the resolvers we use by default. The system resolver
+// is the last entry such that, when nothing is known, we try it last. Because
+// the lookups before the last one share a budget of totalTimeout, a first run
+// in a network where DoH is blocked does not wait for all of them to time out.
+var DefaultURLs = []string{
+	"https://dns.quad9.net/dns-query",
+	"https://dns.google/dns-query",
+	"https://cloudflare-dns.com/dns-query",
+	"system:///",
+}
+
+const (
+	// storeKey is the key used to persist the scores.
+	storeKey = "sessionresolver.state"
+
+	// scoreWeight is the weight of the most recent sample
+	// when computing the moving average of the score.
+	scoreWeight = 0.3
+
+	// failureSample is the sample for a failed lookup. It is negative so
+	// that a resolver that failed ranks below one we have not tried yet,
+	// whose score is zero, and we do not wait for it to time out again.
+	failureSample = -1.0
+
+	// timeout is the timeout for each lookup, except the one using
+	// the last resolver in the list, for which we use ctx.
+	timeout = 4 * time.Second
+
+	// totalTimeout is the overall time we may spend using resolvers
+	// other than the last one, after which we skip to the last one.
+	totalTimeout = 6 * time.Second
 )
 
 // Resolver is the session resolver.
 type Resolver struct {
-	Primary         netx.DNSClient
-	PrimaryFailure  *atomicx.Int64
-	PrimaryQuery    *atomicx.Int64
-	Fallback        netx.DNSClient
-	FallbackFailure *atomicx.Int64
-	FallbackQuery   *atomicx.Int64
+	dirty        bool
+	entries      []*entry
+	logger       netx.Logger
+	mu           sync.Mutex
+	store        netx.KeyValueStore
+	timeout      time.Duration
+	totalTimeout time.Duration
+}
+
+// entry is a resolver in the list of resolvers.
+type entry struct {
+	client   netx.DNSClient
+	failures int64
+	queries  int64
+	score    float64
+	url      string
 }
 
-// New creates a new session resolver.
+// New creates a new session resolver using DefaultURLs.
 func New(config netx.Config) *Resolver {
-	primary, err := netx.NewDNSClient(config, "https://dns.quad9.net/dns-query")
-	runtimex.PanicOnError(err, "cannot create dns over https resolver")
-	fallback, err := netx.NewDNSClient(config, "system:///")
-	runtimex.PanicOnError(err, "cannot create system resolver")
-	return &Resolver{
-		Primary:         primary,
-		PrimaryFailure:  atomicx.NewInt64(),
-		PrimaryQuery:    atomicx.NewInt64(),
-		Fallback:        fallback,
-		FallbackFailure: atomicx.NewInt64(),
-		FallbackQuery:   atomicx.NewInt64(),
+	r, err := NewWithURLs(config, DefaultURLs)
+	runtimex.PanicOnError(err, "cannot create the default session resolver")
+	return r
+}
+
+// NewWithURLs creates a new session resolver using the resolvers
+// at the specified URLs, which are those accepted by netx.NewDNSClient.
+func NewWithURLs(config netx.Config, URLs []string) (*Resolver, error) {
+	if len(URLs) <= 0 {
+		return nil, fmt.Errorf("sessionresolver: no resolver URLs")
+	}
+	r := &Resolver{
+		logger:       config.Logger,
+		store:        config.DNSCacheStore,
+		timeout:      timeout,
+		totalTimeout: totalTimeout,
 	}
+	for _, URL := range URLs {
+		client, err := netx.NewDNSClient(config, URL)
+		if err != nil {
+			r.CloseIdleConnections()
+			return nil, fmt.Errorf("sessionresolver: %s: %w", URL, err)
+		}
+		// The key is base64 encoded because some stores use it as a file name.
+		client.Resolver = &resolver.CacheResolver{
+			Logger:   config.Logger,
+			Parallel: config.ParallelDNSQueries,
+			Resolver: client.Resolver,
+			Store:    config.DNSCacheStore,
+			StoreKey: fmt.Sprintf("sessionresolver.%s.cache",
+				base64.RawURLEncoding.EncodeToString([]byte(URL))),
+		}
+		r.entries = append(r.entries, &entry{client: client, url: URL})
+	}
+	r.load()
+	return r, nil
+}
+
+// load loads the scores from the store, if possible.
+func (r *Resolver) load() {
+	if r.store == nil {
+		return
+	}
+	data, err := r.store.Get(storeKey)
+	if err != nil {
+		return
+	}
+	var scores map[string]float64
+	if err := json.Unmarshal(data, &scores); err != nil {
+		r.debugf("sessionresolver: cannot parse scores: %s", err.Error())
+		return
+	}
+	for _, e := range r.entries {
+		e.score = scores[e.url]
+	}
+	r.sort()
 }
 
-// CloseIdleConnections closes the idle connections, if any
+// save saves the scores into the store, if they changed since we last
+// saved them. It must be called with the mutex held.
+func (r *Resolver) save() {
+	if r.store == nil || !r.dirty {
+		return
+	}
+	r.dirty = false
+	scores := make(map[string]float64)
+	for _, e := range r.entries {
+		scores[e.url] = e.score
+	}
+	data, err := json.Marshal(scores)
+	runtimex.PanicOnError(err, "json.Marshal should not fail here")
+	if err := r.store.Set(storeKey, data); err != nil {
+		r.debugf("sessionresolver: cannot save scores: %s", err.Error())
+	}
+}
+
+// sort sorts the entries by decreasing score. Because the sort is stable,
+// entries with equal score keep the order in which they were configured.
+func (r *Resolver) sort() {
+	sort.SliceStable(r.entries, func(i, j int) bool {
+		return r.entries[i].score > r.entries[j].score
+	})
+}
+
+// CloseIdleConnections closes the idle connections, if any, and saves
+// the scores, so that the next session can start from them.
 func (r *Resolver) CloseIdleConnections() {
-	r.Primary.CloseIdleConnections()
-	r.Fallback.CloseIdleConnections()
+	r.mu.Lock()
+	r.save()
+	r.mu.Unlock()
+	for _, e := range r.entries {
+		e.client.CloseIdleConnections()
+	}
 }
 
 // Stats returns stats about the session resolver.
 func (r *Resolver) Stats() string {
-	return fmt.Sprintf("sessionresolver: failure rate: primary: %d/%d; fallback: %d/%d",
-		r.PrimaryFailure.Load(), r.PrimaryQuery.Load(),
-		r.FallbackFailure.Load(), r.FallbackQuery.Load())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var stats []string
+	for _, e := range r.entries {
+		stats = append(stats, fmt.Sprintf("%s: score: %.2f; failure rate: %d/%d",
+			e.url, e.score, e.failures, e.queries))
+	}
+	return "sessionresolver: " + strings.Join(stats, "; ")
 }
 
-// LookupHost implements Resolver.LookupHost
+// LookupHost implements Resolver.LookupHost. We try each resolver in order
+// of decreasing score, until one of them gives us an answer. After each
+// attempt, we update the score of the resolver, and we sort the resolvers
+// again. When we run out of time, we skip directly to the last resolver.
 func (r *Resolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
-	// Algorithm similar to Firefox TRR2 mode. See:
-	// https://wiki.mozilla.org/Trusted_Recursive_Resolver#DNS-over-HTTPS_Prefs_in_Firefox
-	// We use a higher timeout than Firefox's timeout (1.5s) to be on the safe side
-	// and therefore see to use DoH more often.
-	r.PrimaryQuery.Add(1)
-	trr2, cancel := context.WithTimeout(ctx, 4*time.Second)
-	defer cancel()
-	addrs, err := r.Primary.LookupHost(trr2, hostname)
-	if err != nil {
-		r.PrimaryFailure.Add(1)
-		r.FallbackQuery.Add(1)
-		addrs, err = r.Fallback.LookupHost(ctx, hostname)
-		if err != nil {
-			r.FallbackFailure.Add(1)
+	r.mu.Lock()
+	entries := append([]*entry{}, r.entries...)
+	r.mu.Unlock()
+	var (
+		addrs    []string
+		err      error
+		deadline = time.Now().Add(r.totalTimeout)
+	)
+	for idx, e := range entries {
+		last := idx == len(entries)-1
+		if !last && !time.Now().Before(deadline) {
+			continue
+		}
+		addrs, err = r.lookupHost(ctx, e, hostname, last, deadline)
+		if err == nil || isDomainError(err, hostname) {
+			break
+		}
+	}
+	return addrs, err
+}
+
+func (r *Resolver) lookupHost(ctx context.Context, e *entry,
+	hostname string, last bool, deadline time.Time) ([]string, error) {
+	if !last {
+		if d := time.Now().Add(r.timeout); d.Before(deadline) {
+			deadline = d
 		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	start := time.Now()
+	addrs, err := e.client.LookupHost(ctx, hostname)
+	elapsed := time.Since(start)
+	// The sample is failureSample for failures and otherwise gets closer
+	// to one the faster the resolver was in giving us an answer.
+	failed := err != nil && !isDomainError(err, hostname)
+	sample := failureSample
+	if !failed {
+		sample = 1 / (1 + elapsed.Seconds())
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.queries++
+	if failed {
+		e.failures++
+		r.debugf("sessionresolver: %s failed: %s", e.url, err.Error())
 	}
+	e.score = scoreWeight*sample + (1-scoreWeight)*e.score
+	r.sort()
+	r.dirty = true
 	return addrs, err
 }
 
+// isDomainError returns whether err means that the resolver told us that
+// hostname does not exist or has no addresses. These are valid answers,
+// which other resolvers would most likely confirm, hence we do not try
+// other resolvers and we do not penalize the resolver. We are careful to
+// not confuse them with failures to resolve the name of a DoH server.
+func isDomainError(err error, hostname string) bool {
+	if errors.Is(err, errorx.ErrDNSNXDOMAIN) || errors.Is(err, errorx.ErrDNSNoAnswer) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound && dnsErr.Name == hostname
+}
+
+func (r *Resolver) debugf(format string, v ...interface{}) {
+	if r.logger != nil {
+		r.logger.Debugf(format, v...)
+	}
+}
+
 // Network implements Resolver.Network
 func (r *Resolver) Network() string {
 	return "sessionresolver"
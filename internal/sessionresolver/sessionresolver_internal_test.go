@@ -0,0 +1,56 @@
+package sessionresolver
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx"
+)
+
+func TestUnitTotalTimeoutSkipsToLastResolver(t *testing.T) {
+	// These DoH resolvers never reply, so each lookup times out.
+	var URLs []string
+	pool := x509.NewCertPool()
+	done := make(chan interface{})
+	for i := 0; i < 3; i++ {
+		server := httptest.NewTLSServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-done:
+				case <-r.Context().Done():
+				}
+			}))
+		defer server.Close()
+		pool.AddCert(server.Certificate())
+		URLs = append(URLs, server.URL+"/dns-query")
+	}
+	defer close(done)
+	URLs = append(URLs, "system:///")
+	reso, err := NewWithURLs(netx.Config{CertPool: pool}, URLs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reso.CloseIdleConnections()
+	reso.timeout = 400 * time.Millisecond
+	reso.totalTimeout = 600 * time.Millisecond
+	start := time.Now()
+	addrs, err := reso.LookupHost(context.Background(), "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) < 1 {
+		t.Fatal("expected some addresses here")
+	}
+	if elapsed := time.Since(start); elapsed >= 3*reso.timeout {
+		t.Fatalf("we did not skip to the last resolver: %s", elapsed)
+	}
+	// We did not try the third resolver at all
+	if !strings.Contains(reso.Stats(), URLs[2]+": score: 0.00; failure rate: 0/0") {
+		t.Fatalf("not the stats we expected: %s", reso.Stats())
+	}
+}
@@ -2,9 +2,14 @@ package sessionresolver_test
 
 import (
 	"context"
+	"encoding/base64"
+	"net"
 	"strings"
 	"testing"
 
+	"github.com/apex/log"
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/internal/kvstore"
 	"github.com/ooni/probe-engine/internal/sessionresolver"
 	"github.com/ooni/probe-engine/netx"
 )
@@ -25,7 +30,143 @@ func TestIntegrationFallbackWorks(t *testing.T) {
 	if addrs != nil {
 		t.Fatal("expected nil addrs here")
 	}
-	if reso.PrimaryFailure.Load() != 1 || reso.FallbackFailure.Load() != 1 {
-		t.Fatal("not the counters we expected to see here")
+	// NXDOMAIN is a valid answer, so we stop at the first resolver
+	// giving it to us and we do not count it as a failure
+	stats := reso.Stats()
+	if strings.Count(stats, "failure rate: 0/1") != 1 {
+		t.Fatalf("not the stats we expected: %s", stats)
+	}
+}
+
+func TestUnitNewWithURLsNoURLs(t *testing.T) {
+	reso, err := sessionresolver.NewWithURLs(netx.Config{}, nil)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if reso != nil {
+		t.Fatal("expected nil resolver here")
+	}
+}
+
+func TestUnitNewWithURLsInvalidURL(t *testing.T) {
+	reso, err := sessionresolver.NewWithURLs(netx.Config{}, []string{
+		"system:///", "antani:///",
+	})
+	if err == nil || !strings.HasSuffix(err.Error(), "unsupported resolver scheme") {
+		t.Fatal("not the error we expected")
+	}
+	if reso != nil {
+		t.Fatal("expected nil resolver here")
+	}
+}
+
+func TestUnitScoresArePersisted(t *testing.T) {
+	// The UDP resolver fails immediately because nothing is listening
+	// on port 1, so we expect the system resolver to win.
+	config := netx.Config{
+		DNSCacheStore: kvstore.NewMemoryKeyValueStore(),
+		Logger:        log.Log,
+	}
+	URLs := []string{"udp://127.0.0.1:1", "system:///"}
+	reso, err := sessionresolver.NewWithURLs(config, URLs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reso.CloseIdleConnections()
+	if !strings.HasPrefix(reso.Stats(), "sessionresolver: udp://127.0.0.1:1:") {
+		t.Fatal("expected the UDP resolver to be the first one")
+	}
+	addrs, err := reso.LookupHost(context.Background(), "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) < 1 {
+		t.Fatal("expected some addresses here")
+	}
+	if !strings.HasPrefix(reso.Stats(), "sessionresolver: system:///:") {
+		t.Fatal("expected the system resolver to be the first one")
+	}
+	// A failure scores below a resolver that we did not try yet
+	if !strings.Contains(reso.Stats(), "udp://127.0.0.1:1: score: -0.30;") {
+		t.Fatalf("not the stats we expected: %s", reso.Stats())
+	}
+	// We do not write the scores on every lookup but when closing
+	if _, err := config.DNSCacheStore.Get("sessionresolver.state"); err == nil {
+		t.Fatal("expected the scores to not be saved yet")
+	}
+	// Likewise, we write the DNS cache of each resolver when closing
+	cacheKey := "sessionresolver." + base64.RawURLEncoding.EncodeToString(
+		[]byte("system:///")) + ".cache"
+	if _, err := config.DNSCacheStore.Get(cacheKey); err == nil {
+		t.Fatal("expected the cache to not be saved yet")
+	}
+	reso.CloseIdleConnections()
+	if _, err := config.DNSCacheStore.Get(cacheKey); err != nil {
+		t.Fatal(err)
+	}
+	// A new resolver using the same store starts from the best resolver
+	warm, err := sessionresolver.NewWithURLs(config, URLs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer warm.CloseIdleConnections()
+	if !strings.HasPrefix(warm.Stats(), "sessionresolver: system:///:") {
+		t.Fatal("expected the system resolver to be the first one")
+	}
+}
+
+// newNXDOMAINServer starts a DNS server replying NXDOMAIN to every
+// query. It returns the server address and a function to stop it.
+func newNXDOMAINServer(t *testing.T) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			count, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			query := new(dns.Msg)
+			if err := query.Unpack(buffer[:count]); err != nil {
+				continue
+			}
+			reply := new(dns.Msg)
+			reply.SetRcode(query, dns.RcodeNameError)
+			data, err := reply.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(data, addr)
+		}
+	}()
+	return conn.LocalAddr().String(), func() { conn.Close() }
+}
+
+func TestUnitNXDOMAINIsAnAnswer(t *testing.T) {
+	address, stop := newNXDOMAINServer(t)
+	defer stop()
+	URL := "udp://" + address
+	reso, err := sessionresolver.NewWithURLs(netx.Config{}, []string{URL, "system:///"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reso.CloseIdleConnections()
+	addrs, err := reso.LookupHost(context.Background(), "nxdomain.example.com")
+	if err == nil || !strings.HasSuffix(err.Error(), "no such host") {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if addrs != nil {
+		t.Fatal("expected nil addrs here")
+	}
+	// We did not penalize the resolver and we did not try the system one
+	expected := "sessionresolver: " + URL + ": score: 0.3"
+	if !strings.HasPrefix(reso.Stats(), expected) {
+		t.Fatalf("not the stats we expected: %s", reso.Stats())
+	}
+	if !strings.HasSuffix(reso.Stats(), "system:///: score: 0.00; failure rate: 0/0") {
+		t.Fatalf("not the stats we expected: %s", reso.Stats())
 	}
 }
@@ -17,6 +17,7 @@ package libminiooni
 import (
 	"bufio"
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
@@ -35,13 +36,18 @@ import (
 	"github.com/ooni/probe-engine/internal/fsx"
 	"github.com/ooni/probe-engine/internal/humanizex"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/selfcensor"
+	"github.com/ooni/probe-engine/netx/trace"
 	"github.com/pborman/getopt/v2"
 )
 
 // Options contains the options you can set from the CLI.
 type Options struct {
 	Annotations      []string
+	CABundle         string
+	CABundleReplace  bool
 	ExtraOptions     []string
 	HomeDir          string
 	Inputs           []string
@@ -50,12 +56,16 @@ type Options struct {
 	NoGeoIP          bool
 	NoJSON           bool
 	NoCollector      bool
+	PcapDir          string
 	ProbeServicesURL string
 	Proxy            string
 	ReportFile       string
+	SSLKeyLogFile    string
 	SelfCensorSpec   string
+	ShapingSpec      string
 	TorArgs          []string
 	TorBinary        string
+	TraceOut         string
 	Tunnel           string
 	Verbose          bool
 }
@@ -74,6 +84,14 @@ func init() {
 	getopt.FlagLong(
 		&globalOptions.Annotations, "annotation", 'A', "Add annotaton", "KEY=VALUE",
 	)
+	getopt.FlagLong(
+		&globalOptions.CABundle, "ca-bundle", 0,
+		"Add the CAs in the PEM encoded FILE to the ones used by experiments", "FILE",
+	)
+	getopt.FlagLong(
+		&globalOptions.CABundleReplace, "ca-bundle-replace", 0,
+		"Use only the CAs passed with --ca-bundle rather than adding them",
+	)
 	getopt.FlagLong(
 		&globalOptions.ExtraOptions, "option", 'O',
 		"Pass an option to the experiment", "KEY=VALUE",
@@ -103,6 +121,10 @@ func init() {
 	getopt.FlagLong(
 		&globalOptions.NoCollector, "no-collector", 'n', "Don't use a collector",
 	)
+	getopt.FlagLong(
+		&globalOptions.PcapDir, "pcap-dir", 0,
+		"Write the packets of each measurement into a pcapng file in DIR", "DIR",
+	)
 	getopt.FlagLong(
 		&globalOptions.ProbeServicesURL, "probe-services", 0,
 		"Set the URL of the probe-services instance you want to use", "URL",
@@ -114,10 +136,20 @@ func init() {
 		&globalOptions.ReportFile, "reportfile", 'o',
 		"Set the report file path", "PATH",
 	)
+	getopt.FlagLong(
+		&globalOptions.SSLKeyLogFile, "ssl-key-log-file", 0,
+		"Append the TLS session keys of experiments to FILE using the NSS key log format (see also $SSLKEYLOGFILE)",
+		"FILE",
+	)
 	getopt.FlagLong(
 		&globalOptions.SelfCensorSpec, "self-censor-spec", 0,
 		"Enable and configure self censorship", "JSON",
 	)
+	getopt.FlagLong(
+		&globalOptions.ShapingSpec, "shaping", 0,
+		"Shape the traffic of experiments (e.g. `download=1mbit,upload=256kbit,latency=150ms,jitter=30ms`)",
+		"SPEC",
+	)
 	getopt.FlagLong(
 		&globalOptions.TorArgs, "tor-args", 0,
 		"Extra args for tor binary (may be specified multiple times)",
@@ -126,6 +158,11 @@ func init() {
 		&globalOptions.TorBinary, "tor-binary", 0,
 		"Specify path to a specific tor binary",
 	)
+	getopt.FlagLong(
+		&globalOptions.TraceOut, "trace-out", 0,
+		"Write the network events of measurements into FILE using the Chrome trace event format",
+		"FILE",
+	)
 	getopt.FlagLong(
 		&globalOptions.Tunnel, "tunnel", 0,
 		"Name of the tunnel to use (one of `tor`, `psiphon`)",
@@ -264,6 +301,13 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 	err := selfcensor.MaybeEnable(currentOptions.SelfCensorSpec)
 	fatalOnError(err, "cannot parse --self-censor-spec argument")
 
+	var shaper *dialer.Shaper
+	if currentOptions.ShapingSpec != "" {
+		shapingConfig, err := dialer.ParseShapingConfig(currentOptions.ShapingSpec)
+		fatalOnError(err, "cannot parse --shaping argument")
+		shaper = dialer.NewShaper(shapingConfig)
+	}
+
 	logger := &log.Logger{Level: log.InfoLevel, Handler: &logHandler{Writer: os.Stderr}}
 	if currentOptions.Verbose {
 		logger.Level = log.DebugLevel
@@ -286,20 +330,44 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 		proxyURL = mustParseURL(currentOptions.Proxy)
 	}
 
+	var certPool *x509.CertPool
+	if currentOptions.CABundle != "" {
+		certPool, err = netx.LoadCABundle(
+			currentOptions.CABundle, currentOptions.CABundleReplace)
+		fatalOnError(err, "cannot load --ca-bundle argument")
+	}
+
+	var keyLogWriter io.Writer
+	if currentOptions.SSLKeyLogFile != "" {
+		filep, err := netx.OpenKeyLogFile(currentOptions.SSLKeyLogFile)
+		fatalOnError(err, "cannot open --ssl-key-log-file argument")
+		defer filep.Close()
+		keyLogWriter = filep
+	}
+
+	if currentOptions.PcapDir != "" {
+		err = os.MkdirAll(currentOptions.PcapDir, 0700)
+		fatalOnError(err, "cannot create pcapng directory")
+	}
+
 	kvstore2dir := filepath.Join(miniooniDir, "kvstore2")
 	kvstore, err := engine.NewFileSystemKVStore(kvstore2dir)
 	fatalOnError(err, "cannot create kvstore2 directory")
 
 	config := engine.SessionConfig{
-		AssetsDir: assetsDir,
-		KVStore:   kvstore,
-		Logger:    logger,
+		AssetsDir:    assetsDir,
+		CertPool:     certPool,
+		KVStore:      kvstore,
+		KeyLogWriter: keyLogWriter,
+		Logger:       logger,
+		PcapDir:      currentOptions.PcapDir,
 		PrivacySettings: model.PrivacySettings{
 			// See https://github.com/ooni/explorer/issues/495#issuecomment-704101604
 			IncludeASN:     currentOptions.NoGeoIP == false,
 			IncludeCountry: true,
 		},
 		ProxyURL:        proxyURL,
+		Shaper:          shaper,
 		SoftwareName:    softwareName,
 		SoftwareVersion: softwareVersion,
 		TorArgs:         currentOptions.TorArgs,
@@ -402,6 +470,7 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 		log.Infof("Report ID: %s", experiment.ReportID())
 	}
 
+	var chromeTrace trace.ChromeTrace
 	inputCount := len(currentOptions.Inputs)
 	inputCounter := 0
 	for _, input := range currentOptions.Inputs {
@@ -409,8 +478,18 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 		if input != "" {
 			log.Infof("[%d/%d] running with input: %s", inputCounter, inputCount, input)
 		}
-		measurement, err := experiment.Measure(input)
+		ctx, saver := context.Background(), new(trace.Saver)
+		if currentOptions.TraceOut != "" {
+			ctx = trace.WithSink(ctx, saver)
+		}
+		measurement, err := experiment.MeasureWithContext(ctx, input)
 		warnOnError(err, "measurement failed")
+		if currentOptions.TraceOut != "" {
+			chromeTrace.AddProcess(
+				strings.TrimSpace(experimentName+" "+input), saver.Read())
+			err := writeChromeTrace(&chromeTrace, currentOptions.TraceOut)
+			warnOnError(err, "writing --trace-out file failed")
+		}
 		measurement.AddAnnotations(annotations)
 		measurement.Options = currentOptions.ExtraOptions
 		if !currentOptions.NoCollector {
@@ -427,3 +506,17 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 		}
 	}
 }
+
+// writeChromeTrace writes the trace into the file at path, which we rewrite
+// after each measurement, so that we don't lose it if we're interrupted.
+func writeChromeTrace(chromeTrace *trace.ChromeTrace, path string) error {
+	filep, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := chromeTrace.WriteJSON(filep); err != nil {
+		filep.Close()
+		return err
+	}
+	return filep.Close()
+}
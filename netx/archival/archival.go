@@ -4,6 +4,7 @@
 package archival
 
 import (
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
@@ -16,6 +17,7 @@ import (
 	"time"
 	"unicode/utf8"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/geolocate"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx/errorx"
@@ -270,9 +272,15 @@ type HTTPRequest struct {
 //
 // Headers are a map in Web Connectivity data format but
 // we have added support for a list since January 2020.
+//
+// The BodyLength and BodySHA256 fields refer to the whole body, even
+// when the Body is truncated, and are only set when we have read the
+// whole body. Otherwise, they are not serialised.
 type HTTPResponse struct {
 	Body            HTTPBody                    `json:"body"`
 	BodyIsTruncated bool                        `json:"body_is_truncated"`
+	BodyLength      int64                       `json:"body_length,omitempty"`
+	BodySHA256      string                      `json:"body_sha256,omitempty"`
 	Code            int64                       `json:"code"`
 	HeadersList     []HTTPHeader                `json:"headers_list"`
 	Headers         map[string]MaybeBinaryValue `json:"headers"`
@@ -282,6 +290,34 @@ type HTTPResponse struct {
 	Locations []string `json:"-"`
 }
 
+// UnmarshalJSON is like the default JSON unmarshaling except that it
+// also sets the Locations field, which is not serialised.
+func (hr *HTTPResponse) UnmarshalJSON(d []byte) error {
+	type httpResponse HTTPResponse // avoid infinite recursion
+	var out httpResponse
+	if err := json.Unmarshal(d, &out); err != nil {
+		return err
+	}
+	*hr = HTTPResponse(out)
+	hr.Locations = newHTTPHeader(hr.HeadersList, hr.Headers).Values("Location")
+	return nil
+}
+
+// HTTPTimings contains the duration in seconds of the phases of
+// an HTTP transaction. A phase is missing when it did not occur, e.g.,
+// we did not resolve and connect because we reused a connection. The
+// TTFB is the time between writing the request and receiving the first
+// byte of the response. The Body is the time between receiving the
+// first byte and reading the body snapshot, which is the whole body
+// unless the body has been truncated.
+type HTTPTimings struct {
+	Body         float64 `json:"body,omitempty"`
+	Connect      float64 `json:"connect,omitempty"`
+	DNS          float64 `json:"dns,omitempty"`
+	TLSHandshake float64 `json:"tls_handshake,omitempty"`
+	TTFB         float64 `json:"ttfb,omitempty"`
+}
+
 // RequestEntry is one of the entries that are part of
 // the "requests" key of a OONI report.
 type RequestEntry struct {
@@ -289,6 +325,7 @@ type RequestEntry struct {
 	Request       HTTPRequest  `json:"request"`
 	Response      HTTPResponse `json:"response"`
 	T             float64      `json:"t"`
+	Timings       *HTTPTimings `json:"timings,omitempty"`
 	TransactionID int64        `json:"transaction_id,omitempty"`
 }
 
@@ -331,12 +368,23 @@ func newRequestList(begin time.Time, events []trace.Event) []RequestEntry {
 	var (
 		out   []RequestEntry
 		entry RequestEntry
+		times map[string]time.Time
 	)
 	for _, ev := range events {
 		switch ev.Name {
 		case "http_transaction_start":
 			entry = RequestEntry{}
 			entry.T = ev.Time.Sub(begin).Seconds()
+			times = make(map[string]time.Time)
+		case "http_dns_start", "http_dns_done", "http_connect_start",
+			"http_connect_done", "http_tls_handshake_start",
+			"http_tls_handshake_done", "http_wrote_request",
+			"http_first_response_byte":
+			// When there are several attempts, e.g., we try to connect
+			// to several addresses, the latest attempt wins.
+			if times != nil {
+				times[ev.Name] = ev.Time
+			}
 		case "http_request_body_snapshot":
 			entry.Request.Body.Value = string(ev.Data)
 			entry.Request.BodyIsTruncated = ev.DataIsTruncated
@@ -355,33 +403,85 @@ func newRequestList(begin time.Time, events []trace.Event) []RequestEntry {
 		case "http_response_body_snapshot":
 			entry.Response.Body.Value = string(ev.Data)
 			entry.Response.BodyIsTruncated = ev.DataIsTruncated
+			if times != nil {
+				times[ev.Name] = ev.Time
+			}
+		case "http_response_body_hash":
+			// We usually finish reading the body after the transaction
+			// is done, in which case the hash refers to the latest entry.
+			target := &entry
+			if times == nil && len(out) > 0 {
+				target = &out[len(out)-1]
+			}
+			target.Response.BodyLength = int64(ev.NumBytes)
+			target.Response.BodySHA256 = ev.HTTPBodySHA256
 		case "http_transaction_done":
 			entry.Failure = NewFailure(ev.Err)
+			entry.Timings = newHTTPTimings(times)
 			out = append(out, entry)
+			times = nil
 		}
 	}
 	return out
 }
 
-// DNSAnswerEntry is the answer to a DNS query
+func newHTTPTimings(times map[string]time.Time) *HTTPTimings {
+	var (
+		found   bool
+		timings HTTPTimings
+	)
+	phase := func(start, done string) float64 {
+		t0, ok0 := times[start]
+		t1, ok1 := times[done]
+		if !ok0 || !ok1 || t1.Before(t0) {
+			return 0
+		}
+		found = true
+		return t1.Sub(t0).Seconds()
+	}
+	timings.DNS = phase("http_dns_start", "http_dns_done")
+	timings.Connect = phase("http_connect_start", "http_connect_done")
+	timings.TLSHandshake = phase("http_tls_handshake_start", "http_tls_handshake_done")
+	timings.TTFB = phase("http_wrote_request", "http_first_response_byte")
+	timings.Body = phase("http_first_response_byte", "http_response_body_snapshot")
+	if !found {
+		return nil
+	}
+	return &timings
+}
+
+// DNSAnswerEntry is the answer to a DNS query.
+//
+// The Hostname field contains the target of CNAME, NS, HTTPS and SVCB
+// records. The ALPN and IPv{4,6}Hint fields are only set for HTTPS and SVCB
+// records, while TXT contains the concatenated strings of a TXT record.
+// The Data field contains the presentation format of the data of DNSSEC
+// records (i.e., RRSIG, DNSKEY and DS).
 type DNSAnswerEntry struct {
-	ASN        int64   `json:"asn,omitempty"`
-	ASOrgName  string  `json:"as_org_name,omitempty"`
-	AnswerType string  `json:"answer_type"`
-	Hostname   string  `json:"hostname,omitempty"`
-	IPv4       string  `json:"ipv4,omitempty"`
-	IPv6       string  `json:"ipv6,omitempty"`
-	TTL        *uint32 `json:"ttl"`
+	ALPN       []string `json:"alpn,omitempty"`
+	ASN        int64    `json:"asn,omitempty"`
+	ASOrgName  string   `json:"as_org_name,omitempty"`
+	AnswerType string   `json:"answer_type"`
+	Data       string   `json:"data,omitempty"`
+	Hostname   string   `json:"hostname,omitempty"`
+	IPv4       string   `json:"ipv4,omitempty"`
+	IPv4Hint   []string `json:"ipv4_hint,omitempty"`
+	IPv6       string   `json:"ipv6,omitempty"`
+	IPv6Hint   []string `json:"ipv6_hint,omitempty"`
+	TTL        *uint32  `json:"ttl"`
+	TXT        string   `json:"txt,omitempty"`
 }
 
 // DNSQueryEntry is a DNS query with possibly an answer
 type DNSQueryEntry struct {
 	Answers          []DNSAnswerEntry `json:"answers"`
+	DNSSECStatus     string           `json:"dnssec_status,omitempty"`
 	DialID           int64            `json:"dial_id,omitempty"`
 	Engine           string           `json:"engine"`
 	Failure          *string          `json:"failure"`
 	Hostname         string           `json:"hostname"`
 	QueryType        string           `json:"query_type"`
+	RawResponse      string           `json:"raw_response,omitempty"`
 	ResolverHostname *string          `json:"resolver_hostname"`
 	ResolverPort     *string          `json:"resolver_port"`
 	ResolverAddress  string           `json:"resolver_address"`
@@ -393,14 +493,41 @@ type dnsQueryType string
 
 // NewDNSQueriesList returns a list of DNS queries.
 func NewDNSQueriesList(begin time.Time, events []trace.Event, dbpath string) []DNSQueryEntry {
-	// TODO(bassosimone): add support for CNAME lookups.
 	var out []DNSQueryEntry
+	// The DNSSEC validation of a query happens inside the resolve
+	// operation, hence the "dnssec_validation_done" event always comes
+	// before the related "resolve_done" event.
+	dnssec := make(map[string]string)
 	for _, ev := range events {
+		if ev.Name == "dnssec_validation_done" {
+			dnssec[ev.Hostname+" "+ev.DNSQueryType] = ev.DNSSECStatus
+			continue
+		}
+		if ev.Name == "dns_first_reply" || ev.Name == "dns_late_reply" {
+			// A late reply is a reply received after the first one for
+			// the same query, e.g., in case of DNS injection. When we
+			// wait for late replies, we also save the first reply, and
+			// we include the raw replies, so that we know which entries
+			// are replies and we can compare them.
+			entry := newDNSRecordsQueryEntry(begin, ev, dbpath)
+			entry.RawResponse = base64.StdEncoding.EncodeToString(ev.DNSReply)
+			out = append(out, entry)
+			continue
+		}
 		if ev.Name != "resolve_done" {
 			continue
 		}
+		if ev.DNSQueryType != "" {
+			// This is a typed lookup, so we know exactly which query
+			// we sent and we have the full answer set.
+			entry := newDNSRecordsQueryEntry(begin, ev, dbpath)
+			entry.DNSSECStatus = popDNSSECStatus(dnssec, ev.Hostname, ev.DNSQueryType)
+			out = append(out, entry)
+			continue
+		}
 		for _, qtype := range []dnsQueryType{"A", "AAAA"} {
 			entry := qtype.makequeryentry(begin, ev)
+			entry.DNSSECStatus = popDNSSECStatus(dnssec, ev.Hostname, string(qtype))
 			for _, addr := range ev.Addresses {
 				if qtype.ipoftype(addr) {
 					entry.Answers = append(
@@ -423,6 +550,15 @@ func NewDNSQueriesList(begin time.Time, events []trace.Event, dbpath string) []D
 	return out
 }
 
+// popDNSSECStatus returns and removes the DNSSEC status of the
+// query for hostname and qtype, if any.
+func popDNSSECStatus(dnssec map[string]string, hostname, qtype string) string {
+	key := hostname + " " + qtype
+	status := dnssec[key]
+	delete(dnssec, key)
+	return status
+}
+
 func (qtype dnsQueryType) ipoftype(addr string) bool {
 	switch qtype {
 	case "A":
@@ -458,6 +594,70 @@ func (qtype dnsQueryType) makequeryentry(begin time.Time, ev trace.Event) DNSQue
 	}
 }
 
+func newDNSRecordsQueryEntry(
+	begin time.Time, ev trace.Event, dbpath string) DNSQueryEntry {
+	entry := dnsQueryType(ev.DNSQueryType).makequeryentry(begin, ev)
+	for _, rr := range ev.DNSAnswers {
+		entry.Answers = append(entry.Answers, makeRecordAnswerEntry(rr, dbpath))
+	}
+	return entry
+}
+
+func makeRecordAnswerEntry(rr dns.RR, dbpath string) DNSAnswerEntry {
+	ttl := rr.Header().Ttl
+	answer := DNSAnswerEntry{
+		AnswerType: dns.TypeToString[rr.Header().Rrtype],
+		TTL:        &ttl,
+	}
+	switch v := rr.(type) {
+	case *dns.A:
+		answer = addASN(answer, v.A.String(), dbpath)
+		answer.IPv4 = v.A.String()
+	case *dns.AAAA:
+		answer = addASN(answer, v.AAAA.String(), dbpath)
+		answer.IPv6 = v.AAAA.String()
+	case *dns.CNAME:
+		answer.Hostname = v.Target
+	case *dns.NS:
+		answer.Hostname = v.Ns
+	case *dns.TXT:
+		answer.TXT = strings.Join(v.Txt, "")
+	case *dns.HTTPS:
+		answer = addSVCB(answer, &v.SVCB)
+	case *dns.SVCB:
+		answer = addSVCB(answer, v)
+	case *dns.RRSIG, *dns.DNSKEY, *dns.DS:
+		answer.Data = strings.TrimPrefix(rr.String(), rr.Header().String())
+	}
+	return answer
+}
+
+func addASN(answer DNSAnswerEntry, addr string, dbpath string) DNSAnswerEntry {
+	asn, org, _ := geolocate.LookupASN(dbpath, addr)
+	answer.ASN = int64(asn)
+	answer.ASOrgName = org
+	return answer
+}
+
+func addSVCB(answer DNSAnswerEntry, rr *dns.SVCB) DNSAnswerEntry {
+	answer.Hostname = rr.Target
+	for _, kv := range rr.Value {
+		switch v := kv.(type) {
+		case *dns.SVCBAlpn:
+			answer.ALPN = append(answer.ALPN, v.Alpn...)
+		case *dns.SVCBIPv4Hint:
+			for _, ip := range v.Hint {
+				answer.IPv4Hint = append(answer.IPv4Hint, ip.String())
+			}
+		case *dns.SVCBIPv6Hint:
+			for _, ip := range v.Hint {
+				answer.IPv6Hint = append(answer.IPv6Hint, ip.String())
+			}
+		}
+	}
+	return answer
+}
+
 // NetworkEvent is a network event.
 type NetworkEvent struct {
 	Address       string  `json:"address,omitempty"`
@@ -512,41 +712,123 @@ func NewNetworkEventsList(begin time.Time, events []trace.Event) []NetworkEvent
 	return out
 }
 
-// TLSHandshake contains TLS handshake data
+// TLSHandshake contains TLS handshake data. We also use it for QUIC
+// handshakes, which are TLS handshakes, in which case Network is "udp"
+// while it is empty for TLS handshakes over TCP.
 type TLSHandshake struct {
 	CipherSuite        string             `json:"cipher_suite"`
 	ConnID             int64              `json:"conn_id,omitempty"`
 	Failure            *string            `json:"failure"`
+	Fingerprint        string             `json:"fingerprint,omitempty"`
+	FingerprintALPN    []string           `json:"fingerprint_alpn,omitempty"`
 	NegotiatedProtocol string             `json:"negotiated_protocol"`
+	Network            string             `json:"network,omitempty"`
 	NoTLSVerify        bool               `json:"no_tls_verify"`
 	PeerCertificates   []MaybeBinaryValue `json:"peer_certificates"`
 	ServerName         string             `json:"server_name"`
+	SplitStrategy      string             `json:"split_strategy,omitempty"`
 	T                  float64            `json:"t"`
 	TLSVersion         string             `json:"tls_version"`
 	TransactionID      int64              `json:"transaction_id,omitempty"`
+	Verification       *TLSVerification   `json:"verification,omitempty"`
 }
 
+// TLSVerification describes the verification of the certificates
+// presented by the server, which we perform even when NoTLSVerify is
+// true. When verification succeeds, Chain contains the subjects of the
+// chain we built, from the leaf to the root. Otherwise, Failure and
+// Reason tell us why verification failed and, when we did not know the
+// authority, IssuerName is the issuer of the leaf. LeafSPKISHA256 is the
+// base64 encoded SHA256 of the SubjectPublicKeyInfo of the leaf.
+type TLSVerification struct {
+	Chain          []string `json:"chain"`
+	Failure        *string  `json:"failure"`
+	IssuerName     string   `json:"issuer_name,omitempty"`
+	LeafSPKISHA256 string   `json:"leaf_spki_sha256"`
+	Reason         string   `json:"reason,omitempty"`
+}
+
+// The possible values of TLSVerification.Reason.
+const (
+	TLSVerificationExpired          = "expired"
+	TLSVerificationHostnameMismatch = "hostname_mismatch"
+	TLSVerificationInvalid          = "invalid_certificate"
+	TLSVerificationNotYetValid      = "not_yet_valid"
+	TLSVerificationUnknownAuthority = "unknown_authority"
+)
+
 // NewTLSHandshakesList creates a new TLSHandshakesList
 func NewTLSHandshakesList(begin time.Time, events []trace.Event) []TLSHandshake {
 	var out []TLSHandshake
 	for _, ev := range events {
-		if ev.Name != "tls_handshake_done" {
+		// We also include QUIC handshakes, which are TLS handshakes
+		// whose negotiated protocol is usually "h3" or "doq".
+		var network string
+		switch ev.Name {
+		case "tls_handshake_done":
+		case "quic_handshake_done":
+			network = "udp"
+		default:
 			continue
 		}
 		out = append(out, TLSHandshake{
 			CipherSuite:        ev.TLSCipherSuite,
 			Failure:            NewFailure(ev.Err),
+			Fingerprint:        ev.TLSFingerprint,
+			FingerprintALPN:    ev.TLSFingerprintALPN,
 			NegotiatedProtocol: ev.TLSNegotiatedProto,
+			Network:            network,
 			NoTLSVerify:        ev.NoTLSVerify,
 			PeerCertificates:   makePeerCerts(ev.TLSPeerCerts),
 			ServerName:         ev.TLSServerName,
+			SplitStrategy:      ev.TLSSplitStrategy,
 			T:                  ev.Time.Sub(begin).Seconds(),
 			TLSVersion:         ev.TLSVersion,
+			Verification:       newTLSVerification(ev),
 		})
 	}
 	return out
 }
 
+func newTLSVerification(ev trace.Event) *TLSVerification {
+	if len(ev.TLSPeerCerts) <= 0 {
+		return nil // we don't know the certificates
+	}
+	leaf := ev.TLSPeerCerts[0]
+	sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	out := &TLSVerification{
+		Failure:        NewFailure(ev.TLSVerifyErr),
+		LeafSPKISHA256: base64.StdEncoding.EncodeToString(sum[:]),
+	}
+	for _, cert := range ev.TLSVerifiedChain {
+		out.Chain = append(out.Chain, cert.Subject.String())
+	}
+	var (
+		hostnameErr  x509.HostnameError
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(ev.TLSVerifyErr, &hostnameErr):
+		out.Reason = TLSVerificationHostnameMismatch
+	case errors.As(ev.TLSVerifyErr, &authorityErr):
+		out.Reason = TLSVerificationUnknownAuthority
+		if authorityErr.Cert != nil {
+			out.IssuerName = authorityErr.Cert.Issuer.String()
+		}
+	case errors.As(ev.TLSVerifyErr, &invalidErr):
+		out.Reason = TLSVerificationInvalid
+		if invalidErr.Reason == x509.Expired && invalidErr.Cert != nil {
+			// x509 uses Expired also for not yet valid certificates
+			out.Reason = TLSVerificationExpired
+			if ev.Time.Before(invalidErr.Cert.NotBefore) {
+				out.Reason = TLSVerificationNotYetValid
+			}
+		}
+	}
+	return out
+}
+
 func makePeerCerts(in []*x509.Certificate) (out []MaybeBinaryValue) {
 	for _, e := range in {
 		out = append(out, MaybeBinaryValue{Value: string(e.Raw)})
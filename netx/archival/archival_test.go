@@ -3,6 +3,7 @@ package archival_test
 import (
 	"context"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
 	"io"
 	"net/http"
@@ -13,6 +14,7 @@ import (
 	"github.com/apex/log"
 	"github.com/google/go-cmp/cmp"
 	"github.com/gorilla/websocket"
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx/archival"
 	"github.com/ooni/probe-engine/netx/errorx"
@@ -269,6 +271,131 @@ func TestNewRequestList(t *testing.T) {
 			},
 			T: 0.01,
 		}},
+	}, {
+		name: "run with timings",
+		args: args{
+			begin: begin,
+			events: []trace.Event{{
+				Name: "http_transaction_start",
+				Time: begin.Add(10 * time.Millisecond),
+			}, {
+				Name: "http_dns_start",
+				Time: begin.Add(11 * time.Millisecond),
+			}, {
+				Name: "http_dns_done",
+				Time: begin.Add(13 * time.Millisecond),
+			}, {
+				Name: "http_connect_start",
+				Time: begin.Add(13 * time.Millisecond),
+			}, {
+				Name: "http_connect_done",
+				Time: begin.Add(20 * time.Millisecond),
+			}, {
+				Name: "http_connect_start",
+				Time: begin.Add(20 * time.Millisecond),
+			}, {
+				Name: "http_connect_done",
+				Time: begin.Add(24 * time.Millisecond),
+			}, {
+				Name: "http_tls_handshake_start",
+				Time: begin.Add(24 * time.Millisecond),
+			}, {
+				Name: "http_tls_handshake_done",
+				Time: begin.Add(32 * time.Millisecond),
+			}, {
+				Name: "http_wrote_request",
+				Time: begin.Add(33 * time.Millisecond),
+			}, {
+				Name: "http_first_response_byte",
+				Time: begin.Add(49 * time.Millisecond),
+			}, {
+				Name: "http_response_body_snapshot",
+				Time: begin.Add(50 * time.Millisecond),
+			}, {
+				Name: "http_transaction_done",
+				Time: begin.Add(50 * time.Millisecond),
+			}, {
+				Name: "http_transaction_start",
+				Time: begin.Add(60 * time.Millisecond),
+			}, {
+				Name: "http_wrote_request",
+				Time: begin.Add(60 * time.Millisecond),
+			}, {
+				Name: "http_first_response_byte",
+				Time: begin.Add(64 * time.Millisecond),
+			}, {
+				Name: "http_transaction_done",
+				Time: begin.Add(64 * time.Millisecond),
+			}, {
+				Name: "http_transaction_start",
+				Time: begin.Add(70 * time.Millisecond),
+			}, {
+				Name: "http_transaction_done",
+				Time: begin.Add(70 * time.Millisecond),
+			}},
+		},
+		want: []archival.RequestEntry{{
+			T: 0.07,
+		}, {
+			T: 0.06,
+			Timings: &archival.HTTPTimings{
+				TTFB: 0.004,
+			},
+		}, {
+			T: 0.01,
+			Timings: &archival.HTTPTimings{
+				Body:         0.001,
+				Connect:      0.004,
+				DNS:          0.002,
+				TLSHandshake: 0.008,
+				TTFB:         0.016,
+			},
+		}},
+	}, {
+		name: "run with body hashes",
+		args: args{
+			begin: begin,
+			events: []trace.Event{{
+				Name: "http_transaction_start",
+				Time: begin.Add(10 * time.Millisecond),
+			}, {
+				HTTPBodySHA256: "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447",
+				Name:           "http_response_body_hash",
+				NumBytes:       8,
+			}, {
+				Name: "http_transaction_done",
+				Time: begin.Add(20 * time.Millisecond),
+			}, {
+				Name: "http_transaction_start",
+				Time: begin.Add(30 * time.Millisecond),
+			}, {
+				Data:            []byte("dead"),
+				DataIsTruncated: true,
+				Name:            "http_response_body_snapshot",
+			}, {
+				Name: "http_transaction_done",
+				Time: begin.Add(40 * time.Millisecond),
+			}, {
+				HTTPBodySHA256: "f24a9ae1025bd54ed3c84211709343300f88f7f8be2c44f1adf92ca51e991d21",
+				Name:           "http_response_body_hash",
+				NumBytes:       8,
+			}},
+		},
+		want: []archival.RequestEntry{{
+			Response: archival.HTTPResponse{
+				Body:            archival.MaybeBinaryValue{Value: "dead"},
+				BodyIsTruncated: true,
+				BodyLength:      8,
+				BodySHA256:      "f24a9ae1025bd54ed3c84211709343300f88f7f8be2c44f1adf92ca51e991d21",
+			},
+			T: 0.03,
+		}, {
+			Response: archival.HTTPResponse{
+				BodyLength: 8,
+				BodySHA256: "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447",
+			},
+			T: 0.01,
+		}},
 	}}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -427,6 +554,257 @@ func TestNewDNSQueriesList(t *testing.T) {
 	}
 }
 
+func TestNewDNSQueriesListWithRecords(t *testing.T) {
+	begin := time.Now()
+	newRR := func(s string) dns.RR {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr
+	}
+	var (
+		ttl0   uint32 = 0
+		ttl60  uint32 = 60
+		ttl300 uint32 = 300
+	)
+	events := []trace.Event{{
+		Address: "1.1.1.1:53",
+		DNSAnswers: []dns.RR{
+			newRR("www.x.org. 300 IN CNAME x.org."),
+			newRR("x.org. 60 IN A 10.0.0.1"),
+		},
+		DNSQueryType: "A",
+		Hostname:     "www.x.org",
+		Name:         "resolve_done",
+		Proto:        "udp",
+		Time:         begin.Add(100 * time.Millisecond),
+	}, {
+		Address: "1.1.1.1:53",
+		DNSAnswers: []dns.RR{
+			newRR(`x.org. 0 IN TXT "130.192." "91.211"`),
+		},
+		DNSQueryType: "TXT",
+		Hostname:     "x.org",
+		Name:         "resolve_done",
+		Proto:        "udp",
+		Time:         begin.Add(200 * time.Millisecond),
+	}, {
+		Address: "1.1.1.1:53",
+		DNSAnswers: []dns.RR{
+			newRR("x.org. 300 IN HTTPS 1 . alpn=h3,h2 ipv4hint=10.0.0.1"),
+		},
+		DNSQueryType: "HTTPS",
+		Hostname:     "x.org",
+		Name:         "resolve_done",
+		Proto:        "udp",
+		Time:         begin.Add(300 * time.Millisecond),
+	}, {
+		Address:      "1.1.1.1:53",
+		DNSQueryType: "NS",
+		Err:          errors.New("no such host"),
+		Hostname:     "x.org",
+		Name:         "resolve_done",
+		Proto:        "udp",
+		Time:         begin.Add(400 * time.Millisecond),
+	}}
+	failure := errorx.FailureDNSNXDOMAINError
+	want := []archival.DNSQueryEntry{{
+		Answers: []archival.DNSAnswerEntry{{
+			AnswerType: "CNAME",
+			Hostname:   "x.org.",
+			TTL:        &ttl300,
+		}, {
+			AnswerType: "A",
+			IPv4:       "10.0.0.1",
+			TTL:        &ttl60,
+		}},
+		Engine:          "udp",
+		Hostname:        "www.x.org",
+		QueryType:       "A",
+		ResolverAddress: "1.1.1.1:53",
+		T:               0.1,
+	}, {
+		Answers: []archival.DNSAnswerEntry{{
+			AnswerType: "TXT",
+			TTL:        &ttl0,
+			TXT:        "130.192.91.211",
+		}},
+		Engine:          "udp",
+		Hostname:        "x.org",
+		QueryType:       "TXT",
+		ResolverAddress: "1.1.1.1:53",
+		T:               0.2,
+	}, {
+		Answers: []archival.DNSAnswerEntry{{
+			ALPN:       []string{"h3", "h2"},
+			AnswerType: "HTTPS",
+			Hostname:   ".",
+			IPv4Hint:   []string{"10.0.0.1"},
+			TTL:        &ttl300,
+		}},
+		Engine:          "udp",
+		Hostname:        "x.org",
+		QueryType:       "HTTPS",
+		ResolverAddress: "1.1.1.1:53",
+		T:               0.3,
+	}, {
+		Engine:          "udp",
+		Failure:         &failure,
+		Hostname:        "x.org",
+		QueryType:       "NS",
+		ResolverAddress: "1.1.1.1:53",
+		T:               0.4,
+	}}
+	got := archival.NewDNSQueriesList(begin, events, "")
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestNewDNSQueriesListWithLateReplies(t *testing.T) {
+	begin := time.Now()
+	rr, err := dns.NewRR("x.org. 0 IN A 10.10.34.35")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []trace.Event{{
+		Address:   "1.1.1.1:53",
+		Addresses: []string{"93.184.216.34"},
+		Hostname:  "x.org",
+		Name:      "resolve_done",
+		Proto:     "udp",
+		Time:      begin.Add(100 * time.Millisecond),
+	}, {
+		Address:      "1.1.1.1:53",
+		DNSAnswers:   []dns.RR{rr},
+		DNSQueryType: "A",
+		DNSReply:     []byte("abc"),
+		Hostname:     "x.org",
+		Name:         "dns_first_reply",
+		Proto:        "udp",
+		Time:         begin.Add(50 * time.Millisecond),
+	}, {
+		Address:      "1.1.1.1:53",
+		DNSQueryType: "A",
+		DNSReply:     []byte("def"),
+		Err:          errorx.ErrDNSNoAnswer,
+		Hostname:     "x.org",
+		Name:         "dns_late_reply",
+		Proto:        "udp",
+		Time:         begin.Add(60 * time.Millisecond),
+	}}
+	noAnswer := errorx.FailureDNSNoAnswerError
+	var ttl0 uint32 = 0
+	want := []archival.DNSQueryEntry{{
+		Answers: []archival.DNSAnswerEntry{{
+			AnswerType: "A",
+			IPv4:       "93.184.216.34",
+		}},
+		Engine:          "udp",
+		Hostname:        "x.org",
+		QueryType:       "A",
+		ResolverAddress: "1.1.1.1:53",
+		T:               0.1,
+	}, {
+		Answers: []archival.DNSAnswerEntry{{
+			AnswerType: "A",
+			IPv4:       "10.10.34.35",
+			TTL:        &ttl0,
+		}},
+		Engine:          "udp",
+		Hostname:        "x.org",
+		QueryType:       "A",
+		RawResponse:     "YWJj",
+		ResolverAddress: "1.1.1.1:53",
+		T:               0.05,
+	}, {
+		Engine:          "udp",
+		Failure:         &noAnswer,
+		Hostname:        "x.org",
+		QueryType:       "A",
+		RawResponse:     "ZGVm",
+		ResolverAddress: "1.1.1.1:53",
+		T:               0.06,
+	}}
+	got := archival.NewDNSQueriesList(begin, events, "")
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestNewDNSQueriesListWithDNSSECStatus(t *testing.T) {
+	begin := time.Now()
+	newRR := func(s string) dns.RR {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr
+	}
+	events := []trace.Event{{
+		DNSQueryType: "A",
+		DNSSECStatus: "secure",
+		Hostname:     "x.org",
+		Name:         "dnssec_validation_done",
+	}, {
+		DNSQueryType: "AAAA",
+		DNSSECStatus: "unsigned",
+		Hostname:     "x.org",
+		Name:         "dnssec_validation_done",
+	}, {
+		Address:   "1.1.1.1:53",
+		Addresses: []string{"10.0.0.1", "::1"},
+		Hostname:  "x.org",
+		Name:      "resolve_done",
+		Proto:     "udp",
+		Time:      begin.Add(100 * time.Millisecond),
+	}, {
+		DNSQueryType: "DS",
+		DNSSECStatus: "bogus",
+		Hostname:     "x.org",
+		Name:         "dnssec_validation_done",
+	}, {
+		Address: "1.1.1.1:53",
+		DNSAnswers: []dns.RR{
+			newRR("x.org. 60 IN DS 12345 13 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"),
+		},
+		DNSQueryType: "DS",
+		Hostname:     "x.org",
+		Name:         "resolve_done",
+		Proto:        "udp",
+		Time:         begin.Add(200 * time.Millisecond),
+	}, {
+		Address:      "1.1.1.1:53",
+		DNSQueryType: "DS",
+		Err:          errors.New("no such host"),
+		Hostname:     "x.org",
+		Name:         "resolve_done",
+		Proto:        "udp",
+		Time:         begin.Add(300 * time.Millisecond),
+	}}
+	got := archival.NewDNSQueriesList(begin, events, "")
+	if len(got) != 4 {
+		t.Fatal("unexpected number of entries")
+	}
+	if got[0].QueryType != "A" || got[0].DNSSECStatus != "secure" {
+		t.Fatal("unexpected first entry")
+	}
+	if got[1].QueryType != "AAAA" || got[1].DNSSECStatus != "unsigned" {
+		t.Fatal("unexpected second entry")
+	}
+	if got[2].QueryType != "DS" || got[2].DNSSECStatus != "bogus" {
+		t.Fatal("unexpected third entry")
+	}
+	const data = "12345 13 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"
+	if len(got[2].Answers) != 1 || got[2].Answers[0].Data != data {
+		t.Fatal("unexpected DS answer", got[2].Answers)
+	}
+	if got[3].DNSSECStatus != "" {
+		t.Fatal("the status should only apply to the related query")
+	}
+}
+
 func TestNewNetworkEventsList(t *testing.T) {
 	begin := time.Now()
 	type args struct {
@@ -537,14 +915,19 @@ func TestNewTLSHandshakesList(t *testing.T) {
 				}, {
 					Raw: []byte("abad1dea"),
 				}},
-				TLSServerName: "x.org",
-				TLSVersion:    "TLSv1.3",
-				Time:          begin.Add(55 * time.Millisecond),
+				TLSFingerprint:     "chrome",
+				TLSFingerprintALPN: []string{"http/1.1"},
+				TLSServerName:      "x.org",
+				TLSSplitStrategy:   "sni",
+				TLSVersion:         "TLSv1.3",
+				Time:               begin.Add(55 * time.Millisecond),
 			}},
 		},
 		want: []archival.TLSHandshake{{
 			CipherSuite:        "SUITE",
 			Failure:            archival.NewFailure(io.EOF),
+			Fingerprint:        "chrome",
+			FingerprintALPN:    []string{"http/1.1"},
 			NegotiatedProtocol: "h2",
 			NoTLSVerify:        false,
 			PeerCertificates: []archival.MaybeBinaryValue{{
@@ -552,9 +935,41 @@ func TestNewTLSHandshakesList(t *testing.T) {
 			}, {
 				Value: "abad1dea",
 			}},
-			ServerName: "x.org",
-			T:          0.055,
-			TLSVersion: "TLSv1.3",
+			ServerName:    "x.org",
+			SplitStrategy: "sni",
+			T:             0.055,
+			TLSVersion:    "TLSv1.3",
+			Verification: &archival.TLSVerification{
+				// SHA256 of the empty SubjectPublicKeyInfo
+				LeafSPKISHA256: "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+			},
+		}},
+	}, {
+		name: "QUIC handshake",
+		args: args{
+			begin: begin,
+			events: []trace.Event{{
+				Name:          "quic_handshake_start",
+				TLSServerName: "dns.adguard.com",
+				Time:          begin.Add(10 * time.Millisecond),
+			}, {
+				Address:            "94.140.14.14:853",
+				Name:               "quic_handshake_done",
+				Proto:              "udp",
+				TLSCipherSuite:     "TLS_AES_128_GCM_SHA256",
+				TLSNegotiatedProto: "doq",
+				TLSServerName:      "dns.adguard.com",
+				TLSVersion:         "TLSv1.3",
+				Time:               begin.Add(55 * time.Millisecond),
+			}},
+		},
+		want: []archival.TLSHandshake{{
+			CipherSuite:        "TLS_AES_128_GCM_SHA256",
+			NegotiatedProtocol: "doq",
+			Network:            "udp",
+			ServerName:         "dns.adguard.com",
+			T:                  0.055,
+			TLSVersion:         "TLSv1.3",
 		}},
 	}}
 	for _, tt := range tests {
@@ -566,6 +981,118 @@ func TestNewTLSHandshakesList(t *testing.T) {
 	}
 }
 
+func TestNewTLSHandshakesListVerification(t *testing.T) {
+	begin := time.Now()
+	leaf := &x509.Certificate{
+		Issuer:                  pkix.Name{CommonName: "State CA"},
+		NotAfter:                begin.Add(time.Hour),
+		NotBefore:               begin.Add(-time.Hour),
+		RawSubjectPublicKeyInfo: []byte("spki"),
+		Subject:                 pkix.Name{CommonName: "x.org"},
+	}
+	root := &x509.Certificate{Subject: pkix.Name{CommonName: "Root CA"}}
+	const spki = "b+7MjBbFVR2f6z61934tp3O/aL2e+cUpJ86yyG5WiSs="
+	authorityErr := &errorx.ErrWrapper{
+		Failure:    errorx.FailureSSLUnknownAuthority,
+		WrappedErr: x509.UnknownAuthorityError{Cert: leaf},
+	}
+	tests := []struct {
+		name  string
+		event trace.Event
+		want  *archival.TLSVerification
+	}{{
+		name: "no certificates",
+		event: trace.Event{
+			Err:          io.EOF,
+			TLSVerifyErr: io.EOF,
+		},
+		want: nil,
+	}, {
+		name: "success",
+		event: trace.Event{
+			TLSPeerCerts:     []*x509.Certificate{leaf},
+			TLSVerifiedChain: []*x509.Certificate{leaf, root},
+		},
+		want: &archival.TLSVerification{
+			Chain:          []string{"CN=x.org", "CN=Root CA"},
+			LeafSPKISHA256: spki,
+		},
+	}, {
+		name: "hostname mismatch",
+		event: trace.Event{
+			TLSPeerCerts: []*x509.Certificate{leaf},
+			TLSVerifyErr: x509.HostnameError{Certificate: leaf, Host: "y.org"},
+		},
+		want: &archival.TLSVerification{
+			Failure:        archival.NewFailure(x509.HostnameError{Certificate: leaf, Host: "y.org"}),
+			LeafSPKISHA256: spki,
+			Reason:         archival.TLSVerificationHostnameMismatch,
+		},
+	}, {
+		name: "unknown authority",
+		event: trace.Event{
+			TLSPeerCerts: []*x509.Certificate{leaf},
+			TLSVerifyErr: authorityErr,
+		},
+		want: &archival.TLSVerification{
+			Failure:        archival.NewFailure(authorityErr),
+			IssuerName:     "CN=State CA",
+			LeafSPKISHA256: spki,
+			Reason:         archival.TLSVerificationUnknownAuthority,
+		},
+	}, {
+		name: "expired",
+		event: trace.Event{
+			TLSPeerCerts: []*x509.Certificate{leaf},
+			TLSVerifyErr: x509.CertificateInvalidError{Cert: leaf, Reason: x509.Expired},
+			Time:         begin.Add(2 * time.Hour),
+		},
+		want: &archival.TLSVerification{
+			Failure: archival.NewFailure(
+				x509.CertificateInvalidError{Cert: leaf, Reason: x509.Expired}),
+			LeafSPKISHA256: spki,
+			Reason:         archival.TLSVerificationExpired,
+		},
+	}, {
+		name: "not yet valid",
+		event: trace.Event{
+			TLSPeerCerts: []*x509.Certificate{leaf},
+			TLSVerifyErr: x509.CertificateInvalidError{Cert: leaf, Reason: x509.Expired},
+			Time:         begin.Add(-2 * time.Hour),
+		},
+		want: &archival.TLSVerification{
+			Failure: archival.NewFailure(
+				x509.CertificateInvalidError{Cert: leaf, Reason: x509.Expired}),
+			LeafSPKISHA256: spki,
+			Reason:         archival.TLSVerificationNotYetValid,
+		},
+	}, {
+		name: "invalid certificate",
+		event: trace.Event{
+			TLSPeerCerts: []*x509.Certificate{leaf},
+			TLSVerifyErr: x509.CertificateInvalidError{Cert: leaf, Reason: x509.NotAuthorizedToSign},
+		},
+		want: &archival.TLSVerification{
+			Failure: archival.NewFailure(
+				x509.CertificateInvalidError{Cert: leaf, Reason: x509.NotAuthorizedToSign}),
+			LeafSPKISHA256: spki,
+			Reason:         archival.TLSVerificationInvalid,
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.event.Name = "tls_handshake_done"
+			out := archival.NewTLSHandshakesList(begin, []trace.Event{tt.event})
+			if len(out) != 1 {
+				t.Fatal("unexpected number of entries")
+			}
+			if diff := cmp.Diff(tt.want, out[0].Verification); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
 func TestExtSpec_AddTo(t *testing.T) {
 	m := new(model.Measurement)
 	archival.ExtDNS.AddTo(m)
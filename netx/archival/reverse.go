@@ -0,0 +1,451 @@
+package archival
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+// This file contains the inverse of the functions creating the archival
+// data formats from a list of events. They allow us to rebuild the events
+// from measurements we saved in the past, such that we can run again our
+// analysis code over them. Passing the events they return to the function
+// that created the archival data, with the same begin time, gives back the
+// original data, with the following exceptions:
+//
+// 1. we cannot rebuild the ASN of the DNS answers, which depends on the
+// database that you pass to NewDNSQueriesList;
+//
+// 2. we cannot rebuild the Tor information of HTTP requests;
+//
+// 3. we cannot rebuild the chain of a TLS verification, of which we
+// only save the subjects of the certificates.
+
+// NewEventsFromTCPConnectList is the inverse of NewTCPConnectList.
+func NewEventsFromTCPConnectList(begin time.Time, in []TCPConnectEntry) []trace.Event {
+	var out []trace.Event
+	for _, entry := range in {
+		out = append(out, trace.Event{
+			Address: net.JoinHostPort(entry.IP, strconv.Itoa(entry.Port)),
+			ConnID:  entry.ConnID,
+			Err:     newErrorFromFailure(entry.Status.Failure, errorx.ConnectOperation),
+			Name:    errorx.ConnectOperation,
+			Proto:   "tcp",
+			Time:    newTimeFromT(begin, entry.T),
+		})
+	}
+	return out
+}
+
+// NewEventsFromRequestList is the inverse of NewRequestList. Because the
+// timings of a request only contain the duration of each phase, we pretend
+// that each phase started right after the previous one.
+func NewEventsFromRequestList(begin time.Time, in []RequestEntry) []trace.Event {
+	var out []trace.Event
+	// OONI wants the last request to appear first
+	for i := len(in) - 1; i >= 0; i-- {
+		out = append(out, newEventsFromRequestEntry(begin, in[i])...)
+	}
+	return out
+}
+
+func newEventsFromRequestEntry(begin time.Time, entry RequestEntry) []trace.Event {
+	t := newTimeFromT(begin, entry.T)
+	out := []trace.Event{{
+		Name: "http_transaction_start",
+		Time: t,
+	}, {
+		Data:            []byte(entry.Request.Body.Value),
+		DataIsTruncated: entry.Request.BodyIsTruncated,
+		Name:            "http_request_body_snapshot",
+		Time:            t,
+	}}
+	if entry.Request.Method != "" {
+		out = append(out, trace.Event{
+			HTTPHeaders: newHTTPHeader(entry.Request.HeadersList, entry.Request.Headers),
+			HTTPMethod:  entry.Request.Method,
+			HTTPURL:     entry.Request.URL,
+			Name:        "http_request_metadata",
+			Time:        t,
+		})
+	}
+	var timings HTTPTimings
+	if entry.Timings != nil {
+		timings = *entry.Timings
+	}
+	phase := func(start, done string, seconds float64) {
+		if seconds <= 0 {
+			return
+		}
+		out = append(out, trace.Event{Name: start, Time: t})
+		t = t.Add(newDurationFromSeconds(seconds))
+		out = append(out, trace.Event{Name: done, Time: t})
+	}
+	phase("http_dns_start", "http_dns_done", timings.DNS)
+	phase("http_connect_start", "http_connect_done", timings.Connect)
+	phase("http_tls_handshake_start", "http_tls_handshake_done", timings.TLSHandshake)
+	phase("http_wrote_request", "http_first_response_byte", timings.TTFB)
+	if timings.TTFB <= 0 && timings.Body > 0 {
+		out = append(out, trace.Event{Name: "http_first_response_byte", Time: t})
+	}
+	t = t.Add(newDurationFromSeconds(timings.Body))
+	if entry.Response.Code != 0 {
+		// We only have a response when we have a status code
+		out = append(out, trace.Event{
+			HTTPHeaders:    newHTTPHeader(entry.Response.HeadersList, entry.Response.Headers),
+			HTTPStatusCode: int(entry.Response.Code),
+			Name:           "http_response_metadata",
+			Time:           t,
+		}, trace.Event{
+			Data:            []byte(entry.Response.Body.Value),
+			DataIsTruncated: entry.Response.BodyIsTruncated,
+			Name:            "http_response_body_snapshot",
+			Time:            t,
+		})
+	}
+	if entry.Response.BodySHA256 != "" {
+		out = append(out, trace.Event{
+			HTTPBodySHA256: entry.Response.BodySHA256,
+			Name:           "http_response_body_hash",
+			NumBytes:       int(entry.Response.BodyLength),
+			Time:           t,
+		})
+	}
+	return append(out, trace.Event{
+		Err:  newErrorFromFailure(entry.Failure, errorx.HTTPRoundTripOperation),
+		Name: "http_transaction_done",
+		Time: t,
+	})
+}
+
+// newHTTPHeader creates an http.Header from the list representation of
+// the headers, if available, and otherwise from the map representation,
+// which is the only one available in old measurements.
+func newHTTPHeader(list []HTTPHeader, m map[string]MaybeBinaryValue) http.Header {
+	out := make(http.Header)
+	for _, h := range list {
+		out[h.Key] = append(out[h.Key], h.Value.Value)
+	}
+	if len(list) <= 0 {
+		for key, value := range m {
+			out[key] = append(out[key], value.Value)
+		}
+	}
+	return out
+}
+
+// NewEventsFromDNSQueriesList is the inverse of NewDNSQueriesList. When
+// an A query is followed by an AAAA query for the same hostname, with the
+// same engine, resolver, failure and time, and without TTLs, we assume
+// they are the result of a single lookup of the IP addresses of the host.
+// Otherwise, we assume the query is a lookup for the records of its type.
+func NewEventsFromDNSQueriesList(begin time.Time, in []DNSQueryEntry) []trace.Event {
+	var out []trace.Event
+	replied := make(map[string]bool)
+	for idx := 0; idx < len(in); idx++ {
+		entry := in[idx]
+		if entry.RawResponse != "" {
+			out = append(out, newDNSReplyEvent(begin, entry, replied))
+			continue
+		}
+		if entry.QueryType == "A" || entry.QueryType == "AAAA" {
+			qtypes, count := []DNSQueryEntry{entry}, 1
+			if idx+1 < len(in) && isSameHostLookup(entry, in[idx+1]) {
+				qtypes, count = append(qtypes, in[idx+1]), 2
+			}
+			if ev, ok := newHostLookupEvent(begin, qtypes); ok {
+				out = append(out, newDNSSECEvents(begin, qtypes)...)
+				out = append(out, ev)
+				idx += count - 1
+				continue
+			}
+		}
+		out = append(out, newDNSSECEvents(begin, []DNSQueryEntry{entry})...)
+		out = append(out, trace.Event{
+			Address:      entry.ResolverAddress,
+			DNSAnswers:   newDNSAnswers(entry),
+			DNSQueryType: entry.QueryType,
+			Err:          newErrorFromFailure(entry.Failure, errorx.ResolveOperation),
+			Hostname:     entry.Hostname,
+			Name:         "resolve_done",
+			Proto:        entry.Engine,
+			Time:         newTimeFromT(begin, entry.T),
+		})
+	}
+	return out
+}
+
+// newDNSReplyEvent returns the "dns_first_reply" or "dns_late_reply" event
+// of an entry containing a raw reply. The first entry of a query is the
+// first reply, since we save the first reply before the late replies.
+func newDNSReplyEvent(
+	begin time.Time, entry DNSQueryEntry, replied map[string]bool) trace.Event {
+	name := "dns_first_reply"
+	key := entry.Hostname + " " + entry.QueryType + " " + entry.ResolverAddress
+	if replied[key] {
+		name = "dns_late_reply"
+	}
+	replied[key] = true
+	reply, _ := base64.StdEncoding.DecodeString(entry.RawResponse)
+	return trace.Event{
+		Address:      entry.ResolverAddress,
+		DNSAnswers:   newDNSAnswers(entry),
+		DNSQueryType: entry.QueryType,
+		DNSReply:     reply,
+		Err:          newErrorFromFailure(entry.Failure, errorx.ResolveOperation),
+		Hostname:     entry.Hostname,
+		Name:         name,
+		Proto:        entry.Engine,
+		Time:         newTimeFromT(begin, entry.T),
+	}
+}
+
+func isSameHostLookup(a, b DNSQueryEntry) bool {
+	return a.QueryType == "A" && b.QueryType == "AAAA" &&
+		a.Hostname == b.Hostname && a.Engine == b.Engine &&
+		a.ResolverAddress == b.ResolverAddress && a.T == b.T &&
+		isSameFailure(a.Failure, b.Failure)
+}
+
+// newHostLookupEvent returns the event of a lookup of the IP addresses
+// of a host, if the queries are compatible with such a lookup. A lookup
+// that fails always yields both an A and an AAAA query, while a lookup
+// that succeeds does not yield queries without answers.
+func newHostLookupEvent(begin time.Time, queries []DNSQueryEntry) (trace.Event, bool) {
+	entry := queries[0]
+	if entry.Failure != nil && len(queries) != 2 {
+		return trace.Event{}, false
+	}
+	var addrs []string
+	for _, query := range queries {
+		if query.Failure == nil && len(query.Answers) <= 0 {
+			return trace.Event{}, false
+		}
+		for _, answer := range query.Answers {
+			if answer.TTL != nil || answer.AnswerType != query.QueryType {
+				return trace.Event{}, false
+			}
+			addr := answer.IPv4
+			if query.QueryType == "AAAA" {
+				addr = answer.IPv6
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	return trace.Event{
+		Address:   entry.ResolverAddress,
+		Addresses: addrs,
+		Err:       newErrorFromFailure(entry.Failure, errorx.ResolveOperation),
+		Hostname:  entry.Hostname,
+		Name:      "resolve_done",
+		Proto:     entry.Engine,
+		Time:      newTimeFromT(begin, entry.T),
+	}, true
+}
+
+func newDNSSECEvents(begin time.Time, queries []DNSQueryEntry) (out []trace.Event) {
+	for _, query := range queries {
+		if query.DNSSECStatus != "" {
+			out = append(out, trace.Event{
+				DNSQueryType: query.QueryType,
+				DNSSECStatus: query.DNSSECStatus,
+				Hostname:     query.Hostname,
+				Name:         "dnssec_validation_done",
+				Time:         newTimeFromT(begin, query.T),
+			})
+		}
+	}
+	return
+}
+
+func newDNSAnswers(entry DNSQueryEntry) (out []dns.RR) {
+	for _, answer := range entry.Answers {
+		if rr := newDNSRecord(entry.Hostname, answer); rr != nil {
+			out = append(out, rr)
+		}
+	}
+	return
+}
+
+func newDNSRecord(hostname string, answer DNSAnswerEntry) dns.RR {
+	hdr := dns.RR_Header{
+		Class:  dns.ClassINET,
+		Name:   dns.Fqdn(hostname),
+		Rrtype: dns.StringToType[answer.AnswerType],
+	}
+	if answer.TTL != nil {
+		hdr.Ttl = *answer.TTL
+	}
+	switch hdr.Rrtype {
+	case dns.TypeA:
+		return &dns.A{Hdr: hdr, A: net.ParseIP(answer.IPv4)}
+	case dns.TypeAAAA:
+		return &dns.AAAA{Hdr: hdr, AAAA: net.ParseIP(answer.IPv6)}
+	case dns.TypeCNAME:
+		return &dns.CNAME{Hdr: hdr, Target: answer.Hostname}
+	case dns.TypeNS:
+		return &dns.NS{Hdr: hdr, Ns: answer.Hostname}
+	case dns.TypeTXT:
+		return &dns.TXT{Hdr: hdr, Txt: []string{answer.TXT}}
+	case dns.TypeHTTPS:
+		return &dns.HTTPS{SVCB: newSVCB(hdr, answer)}
+	case dns.TypeSVCB:
+		svcb := newSVCB(hdr, answer)
+		return &svcb
+	case dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeDS:
+		rr, err := dns.NewRR(hdr.String() + answer.Data)
+		if err != nil {
+			return nil
+		}
+		return rr
+	case dns.TypeNone:
+		return nil // we don't know this type
+	}
+	return &dns.RFC3597{Hdr: hdr}
+}
+
+func newSVCB(hdr dns.RR_Header, answer DNSAnswerEntry) dns.SVCB {
+	svcb := dns.SVCB{Hdr: hdr, Target: answer.Hostname}
+	if len(answer.ALPN) > 0 {
+		svcb.Value = append(svcb.Value, &dns.SVCBAlpn{Alpn: answer.ALPN})
+	}
+	if len(answer.IPv4Hint) > 0 {
+		svcb.Value = append(svcb.Value, &dns.SVCBIPv4Hint{Hint: newIPs(answer.IPv4Hint)})
+	}
+	if len(answer.IPv6Hint) > 0 {
+		svcb.Value = append(svcb.Value, &dns.SVCBIPv6Hint{Hint: newIPs(answer.IPv6Hint)})
+	}
+	return svcb
+}
+
+func newIPs(addrs []string) (out []net.IP) {
+	for _, addr := range addrs {
+		out = append(out, net.ParseIP(addr))
+	}
+	return
+}
+
+// NewEventsFromNetworkEventsList is the inverse of NewNetworkEventsList.
+func NewEventsFromNetworkEventsList(begin time.Time, in []NetworkEvent) []trace.Event {
+	var out []trace.Event
+	for _, entry := range in {
+		out = append(out, trace.Event{
+			Address:  entry.Address,
+			ConnID:   entry.ConnID,
+			Err:      newErrorFromFailure(entry.Failure, entry.Operation),
+			Name:     entry.Operation,
+			NumBytes: int(entry.NumBytes),
+			Proto:    entry.Proto,
+			Time:     newTimeFromT(begin, entry.T),
+		})
+	}
+	return out
+}
+
+// NewEventsFromTLSHandshakesList is the inverse of NewTLSHandshakesList.
+func NewEventsFromTLSHandshakesList(begin time.Time, in []TLSHandshake) []trace.Event {
+	var out []trace.Event
+	for _, entry := range in {
+		ev := trace.Event{
+			ConnID:             entry.ConnID,
+			Err:                newErrorFromFailure(entry.Failure, errorx.TLSHandshakeOperation),
+			Name:               "tls_handshake_done",
+			NoTLSVerify:        entry.NoTLSVerify,
+			TLSCipherSuite:     entry.CipherSuite,
+			TLSFingerprint:     entry.Fingerprint,
+			TLSFingerprintALPN: entry.FingerprintALPN,
+			TLSNegotiatedProto: entry.NegotiatedProtocol,
+			TLSPeerCerts:       newPeerCerts(entry.PeerCertificates),
+			TLSServerName:      entry.ServerName,
+			TLSSplitStrategy:   entry.SplitStrategy,
+			TLSVersion:         entry.TLSVersion,
+			Time:               newTimeFromT(begin, entry.T),
+		}
+		if entry.Network == "udp" {
+			ev.Err = newErrorFromFailure(entry.Failure, errorx.QUICHandshakeOperation)
+			ev.Name = "quic_handshake_done"
+			ev.Proto = entry.Network
+		}
+		if entry.Verification != nil && len(ev.TLSPeerCerts) > 0 {
+			ev.TLSVerifyErr = newTLSVerifyErr(entry, ev.TLSPeerCerts[0])
+		}
+		out = append(out, ev)
+	}
+	return out
+}
+
+// newPeerCerts parses the peer certificates. When we cannot parse a
+// certificate, we still return a certificate containing its raw bytes.
+func newPeerCerts(in []MaybeBinaryValue) (out []*x509.Certificate) {
+	for _, e := range in {
+		cert, err := x509.ParseCertificate([]byte(e.Value))
+		if err != nil {
+			cert = &x509.Certificate{Raw: []byte(e.Value)}
+		}
+		out = append(out, cert)
+	}
+	return
+}
+
+// newTLSVerifyErr returns an error wrapping the x509 error that would
+// cause newTLSVerification to determine the same reason.
+func newTLSVerifyErr(entry TLSHandshake, leaf *x509.Certificate) error {
+	v := entry.Verification
+	if v.Failure == nil {
+		return nil
+	}
+	var wrapped error = fmt.Errorf("%s", *v.Failure)
+	switch v.Reason {
+	case TLSVerificationHostnameMismatch:
+		wrapped = x509.HostnameError{Certificate: leaf, Host: entry.ServerName}
+	case TLSVerificationUnknownAuthority:
+		wrapped = x509.UnknownAuthorityError{Cert: leaf}
+	case TLSVerificationExpired, TLSVerificationNotYetValid:
+		wrapped = x509.CertificateInvalidError{Cert: leaf, Reason: x509.Expired}
+	case TLSVerificationInvalid:
+		wrapped = x509.CertificateInvalidError{Reason: x509.NotAuthorizedToSign}
+	}
+	return &errorx.ErrWrapper{
+		Failure:    *v.Failure,
+		Operation:  errorx.TLSHandshakeOperation,
+		WrappedErr: wrapped,
+	}
+}
+
+// newErrorFromFailure is the inverse of NewFailure.
+func newErrorFromFailure(failure *string, operation string) error {
+	if failure == nil {
+		return nil
+	}
+	return &errorx.ErrWrapper{
+		Failure:    *failure,
+		Operation:  operation,
+		WrappedErr: fmt.Errorf("%s", *failure),
+	}
+}
+
+func isSameFailure(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// newTimeFromT is the inverse of computing T as the seconds elapsed
+// since the beginning of the measurement.
+func newTimeFromT(begin time.Time, t float64) time.Time {
+	return begin.Add(newDurationFromSeconds(t))
+}
+
+func newDurationFromSeconds(seconds float64) time.Duration {
+	return time.Duration(math.Round(seconds * float64(time.Second)))
+}
@@ -0,0 +1,306 @@
+package archival_test
+
+import (
+	"bufio"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/errorx"
+)
+
+type archivalTestKeys struct {
+	NetworkEvents []archival.NetworkEvent    `json:"network_events"`
+	Queries       []archival.DNSQueryEntry   `json:"queries"`
+	Requests      []archival.RequestEntry    `json:"requests"`
+	TCPConnect    []archival.TCPConnectEntry `json:"tcp_connect"`
+	TLSHandshakes []archival.TLSHandshake    `json:"tls_handshakes"`
+}
+
+func readTestKeys(t *testing.T, path string) (out []archivalTestKeys) {
+	filep, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer filep.Close()
+	scanner := bufio.NewScanner(filep)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var measurement struct {
+			TestKeys archivalTestKeys `json:"test_keys"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &measurement); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, measurement.TestKeys)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestNewEventsRoundTrip(t *testing.T) {
+	begin := time.Now()
+	for _, path := range []string{
+		"../../testdata/urlgetter-synthetic.jsonl",
+		"../../testdata/web-connectivity-synthetic.jsonl",
+	} {
+		for idx, tk := range readTestKeys(t, path) {
+			// We cannot rebuild the ASNs and TCP blocking is
+			// an analysis result added by Web Connectivity.
+			for i := range tk.Queries {
+				for j := range tk.Queries[i].Answers {
+					tk.Queries[i].Answers[j].ASN = 0
+					tk.Queries[i].Answers[j].ASOrgName = ""
+				}
+			}
+			for i := range tk.TCPConnect {
+				tk.TCPConnect[i].Status.Blocked = nil
+			}
+			var out archivalTestKeys
+			out.NetworkEvents = archival.NewNetworkEventsList(begin,
+				archival.NewEventsFromNetworkEventsList(begin, tk.NetworkEvents))
+			out.Queries = archival.NewDNSQueriesList(begin,
+				archival.NewEventsFromDNSQueriesList(begin, tk.Queries), "")
+			out.Requests = archival.NewRequestList(begin,
+				archival.NewEventsFromRequestList(begin, tk.Requests))
+			out.TCPConnect = archival.NewTCPConnectList(begin,
+				archival.NewEventsFromTCPConnectList(begin, tk.TCPConnect))
+			out.TLSHandshakes = archival.NewTLSHandshakesList(begin,
+				archival.NewEventsFromTLSHandshakesList(begin, tk.TLSHandshakes))
+			if diff := cmp.Diff(tk, out); diff != "" {
+				t.Fatalf("%s:%d: %s", path, idx+1, diff)
+			}
+		}
+	}
+}
+
+func TestNewEventsRoundTripLegacy(t *testing.T) {
+	// These measurements use the Web Connectivity 0.1.0 format, where the
+	// headers are only a map and there are no network events, timings, or
+	// TLS handshakes. We rebuild the headers list from the map, so we
+	// compare the rest of the test keys after removing it.
+	begin := time.Now()
+	for idx, tk := range readTestKeys(t, "../../testdata/web-connectivity-legacy.jsonl") {
+		for i := range tk.TCPConnect {
+			tk.TCPConnect[i].Status.Blocked = nil
+		}
+		var out archivalTestKeys
+		out.Queries = archival.NewDNSQueriesList(begin,
+			archival.NewEventsFromDNSQueriesList(begin, tk.Queries), "")
+		out.Requests = archival.NewRequestList(begin,
+			archival.NewEventsFromRequestList(begin, tk.Requests))
+		out.TCPConnect = archival.NewTCPConnectList(begin,
+			archival.NewEventsFromTCPConnectList(begin, tk.TCPConnect))
+		for i := range out.Requests {
+			if len(out.Requests[i].Request.HeadersList) <= 0 ||
+				len(out.Requests[i].Response.HeadersList) <= 0 {
+				t.Fatalf("%d: expected to rebuild the headers list", idx+1)
+			}
+			out.Requests[i].Request.HeadersList = nil
+			out.Requests[i].Response.HeadersList = nil
+		}
+		if diff := cmp.Diff(tk, out); diff != "" {
+			t.Fatalf("%d: %s", idx+1, diff)
+		}
+	}
+}
+
+func TestNewEventsFromRequestList(t *testing.T) {
+	begin := time.Now()
+	failure := errorx.FailureEOFError
+	events := archival.NewEventsFromRequestList(begin, []archival.RequestEntry{{
+		Failure: &failure,
+		Request: archival.HTTPRequest{
+			Headers: map[string]archival.MaybeBinaryValue{
+				"User-Agent": {Value: "miniooni/0.1.0-dev"},
+			},
+			Method: "GET",
+			URL:    "http://x.org/",
+		},
+		T: 1.5,
+		Timings: &archival.HTTPTimings{
+			Connect: 0.25,
+			DNS:     0.125,
+		},
+	}})
+	var names []string
+	for _, ev := range events {
+		names = append(names, ev.Name)
+	}
+	expected := []string{
+		"http_transaction_start", "http_request_body_snapshot",
+		"http_request_metadata", "http_dns_start", "http_dns_done",
+		"http_connect_start", "http_connect_done", "http_transaction_done",
+	}
+	if diff := cmp.Diff(expected, names); diff != "" {
+		t.Fatal(diff)
+	}
+	if events[0].Time.Sub(begin) != 1500*time.Millisecond {
+		t.Fatal("unexpected transaction start time")
+	}
+	if events[6].Time.Sub(begin) != 1875*time.Millisecond {
+		t.Fatal("unexpected connect done time")
+	}
+	// We use the map of headers for old measurements without the list
+	if events[2].HTTPHeaders.Get("User-Agent") != "miniooni/0.1.0-dev" {
+		t.Fatal("unexpected request headers")
+	}
+	var errWrapper *errorx.ErrWrapper
+	if !errors.As(events[7].Err, &errWrapper) {
+		t.Fatal("the error is not wrapped")
+	}
+	if errWrapper.Failure != failure || errWrapper.Operation != errorx.HTTPRoundTripOperation {
+		t.Fatal("unexpected wrapped error")
+	}
+}
+
+func TestNewEventsFromDNSQueriesList(t *testing.T) {
+	begin := time.Now()
+	var ttl uint32 = 300
+	events := archival.NewEventsFromDNSQueriesList(begin, []archival.DNSQueryEntry{{
+		Answers:   []archival.DNSAnswerEntry{{AnswerType: "A", IPv4: "1.1.1.1"}},
+		Hostname:  "x.org",
+		QueryType: "A",
+	}, {
+		Answers:   []archival.DNSAnswerEntry{{AnswerType: "AAAA", IPv6: "::1"}},
+		Hostname:  "x.org",
+		QueryType: "AAAA",
+	}, {
+		Answers: []archival.DNSAnswerEntry{{
+			AnswerType: "NS",
+			Hostname:   "ns1.x.org.",
+			TTL:        &ttl,
+		}, {
+			AnswerType: "DS",
+			Data:       "2371 13 2 1F987CC6583E92DF0890718C42",
+			TTL:        &ttl,
+		}, {
+			AnswerType: "NONEXISTENT",
+			TTL:        &ttl,
+		}},
+		Hostname:  "x.org",
+		QueryType: "NS",
+	}})
+	if len(events) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	if diff := cmp.Diff([]string{"1.1.1.1", "::1"}, events[0].Addresses); diff != "" {
+		t.Fatal(diff)
+	}
+	if events[0].DNSQueryType != "" {
+		t.Fatal("expected a lookup of the addresses of the host")
+	}
+	if events[1].DNSQueryType != "NS" || len(events[1].DNSAnswers) != 2 {
+		t.Fatal("unexpected records lookup")
+	}
+	if ns, ok := events[1].DNSAnswers[0].(*dns.NS); !ok || ns.Ns != "ns1.x.org." {
+		t.Fatal("unexpected NS record")
+	}
+	if ds, ok := events[1].DNSAnswers[1].(*dns.DS); !ok || ds.KeyTag != 2371 {
+		t.Fatal("unexpected DS record")
+	}
+}
+
+func TestNewEventsFromDNSQueriesListWithReplies(t *testing.T) {
+	begin := time.Now()
+	var ttl uint32 = 0
+	reply := func(ip, raw string) archival.DNSQueryEntry {
+		return archival.DNSQueryEntry{
+			Answers:         []archival.DNSAnswerEntry{{AnswerType: "A", IPv4: ip, TTL: &ttl}},
+			Engine:          "udp",
+			Hostname:        "x.org",
+			QueryType:       "A",
+			RawResponse:     raw,
+			ResolverAddress: "1.1.1.1:53",
+		}
+	}
+	entries := []archival.DNSQueryEntry{
+		reply("10.10.34.35", "YWJj"), reply("93.184.216.34", "ZGVm"),
+	}
+	events := archival.NewEventsFromDNSQueriesList(begin, entries)
+	var names []string
+	for _, ev := range events {
+		names = append(names, ev.Name)
+	}
+	if diff := cmp.Diff([]string{"dns_first_reply", "dns_late_reply"}, names); diff != "" {
+		t.Fatal(diff)
+	}
+	if string(events[0].DNSReply) != "abc" || string(events[1].DNSReply) != "def" {
+		t.Fatal("unexpected raw replies")
+	}
+	if diff := cmp.Diff(entries, archival.NewDNSQueriesList(begin, events, "")); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestNewEventsFromTLSHandshakesList(t *testing.T) {
+	begin := time.Now()
+	failure := errorx.FailureSSLInvalidHostname
+	events := archival.NewEventsFromTLSHandshakesList(begin, []archival.TLSHandshake{{
+		Failure:          &failure,
+		PeerCertificates: []archival.MaybeBinaryValue{{Value: "not a certificate"}},
+		ServerName:       "x.org",
+		Verification: &archival.TLSVerification{
+			Failure: &failure,
+			Reason:  archival.TLSVerificationHostnameMismatch,
+		},
+	}})
+	if len(events) != 1 || len(events[0].TLSPeerCerts) != 1 {
+		t.Fatal("unexpected events")
+	}
+	if string(events[0].TLSPeerCerts[0].Raw) != "not a certificate" {
+		t.Fatal("we did not keep the raw certificate")
+	}
+	var hostnameErr x509.HostnameError
+	if !errors.As(events[0].TLSVerifyErr, &hostnameErr) || hostnameErr.Host != "x.org" {
+		t.Fatal("unexpected verification error")
+	}
+	entries := archival.NewTLSHandshakesList(begin, events)
+	if entries[0].Verification.Reason != archival.TLSVerificationHostnameMismatch {
+		t.Fatal("unexpected verification reason")
+	}
+}
+
+func TestNewEventsFromTLSHandshakesListQUIC(t *testing.T) {
+	begin := time.Now()
+	failure := errorx.FailureGenericTimeoutError
+	events := archival.NewEventsFromTLSHandshakesList(begin, []archival.TLSHandshake{{
+		Failure:    &failure,
+		Network:    "udp",
+		ServerName: "dns.adguard.com",
+	}})
+	if len(events) != 1 || events[0].Name != "quic_handshake_done" {
+		t.Fatal("unexpected events")
+	}
+	var errWrapper *errorx.ErrWrapper
+	if !errors.As(events[0].Err, &errWrapper) ||
+		errWrapper.Operation != errorx.QUICHandshakeOperation {
+		t.Fatal("unexpected error")
+	}
+	entries := archival.NewTLSHandshakesList(begin, events)
+	if len(entries) != 1 || entries[0].Network != "udp" {
+		t.Fatal("unexpected entries")
+	}
+}
+
+func TestHTTPResponseUnmarshalJSON(t *testing.T) {
+	var resp archival.HTTPResponse
+	data := []byte(`{"code":302,"headers_list":[["Location","https://x.org/"]]}`)
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"https://x.org/"}, resp.Locations); diff != "" {
+		t.Fatal(diff)
+	}
+	if err := json.Unmarshal([]byte(`{"code":"302"}`), &resp); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
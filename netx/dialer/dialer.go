@@ -27,3 +27,10 @@ func safeLocalAddress(conn net.Conn) (s string) {
 func safeConnID(network string, conn net.Conn) int64 {
 	return connid.Compute(network, safeLocalAddress(conn))
 }
+
+func safeLocalNetwork(conn net.Conn) (s string) {
+	if conn != nil && conn.LocalAddr() != nil {
+		s = conn.LocalAddr().Network()
+	}
+	return
+}
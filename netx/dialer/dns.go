@@ -4,17 +4,23 @@ import (
 	"context"
 	"errors"
 	"net"
+	"net/http/httptrace"
 	"strings"
+	"time"
 
 	"github.com/ooni/probe-engine/legacy/netx/dialid"
 	"github.com/ooni/probe-engine/netx/errorx"
 )
 
 // DNSDialer is a dialer that uses the configured Resolver to resolver a
-// domain name to IP addresses, and the configured Dialer to connect.
+// domain name to IP addresses, and the configured Dialer to connect. By
+// default, we try each address one after the other. When HappyEyeballs
+// is true, we use the RFC8305 strategy implemented by dialHappyEyeballs.
 type DNSDialer struct {
 	Dialer
-	Resolver Resolver
+	HappyEyeballs      bool          // default: try one address at a time
+	HappyEyeballsDelay time.Duration // default: DefaultHappyEyeballsDelay
+	Resolver           Resolver
 }
 
 // DialContext implements Dialer.DialContext.
@@ -29,6 +35,9 @@ func (d DNSDialer) DialContext(ctx context.Context, network, address string) (ne
 	if err != nil {
 		return nil, err
 	}
+	if d.HappyEyeballs && len(addrs) > 1 {
+		return d.dialHappyEyeballs(ctx, network, onlyport, addrs)
+	}
 	var errorslist []error
 	for _, addr := range addrs {
 		target := net.JoinHostPort(addr, onlyport)
@@ -63,10 +72,23 @@ func reduceErrors(errorslist []error) error {
 	return errorslist[0]
 }
 
-// LookupHost implements Resolver.LookupHost
+// LookupHost implements Resolver.LookupHost. Because our resolvers do
+// not generally invoke the httptrace DNS hooks, we invoke them here.
 func (d DNSDialer) LookupHost(ctx context.Context, hostname string) ([]string, error) {
 	if net.ParseIP(hostname) != nil {
 		return []string{hostname}, nil
 	}
-	return d.Resolver.LookupHost(ctx, hostname)
+	tracep := httptrace.ContextClientTrace(ctx)
+	if tracep != nil && tracep.DNSStart != nil {
+		tracep.DNSStart(httptrace.DNSStartInfo{Host: hostname})
+	}
+	addrs, err := d.Resolver.LookupHost(ctx, hostname)
+	if tracep != nil && tracep.DNSDone != nil {
+		info := httptrace.DNSDoneInfo{Err: err}
+		for _, addr := range addrs {
+			info.Addrs = append(info.Addrs, net.IPAddr{IP: net.ParseIP(addr)})
+		}
+		tracep.DNSDone(info)
+	}
+	return addrs, err
 }
@@ -4,3 +4,8 @@ package dialer
 func ReduceErrors(errorslist []error) error {
 	return reduceErrors(errorslist)
 }
+
+// InterleaveAddrs exposes the internal function interleaveAddrs
+func InterleaveAddrs(addrs []string) []string {
+	return interleaveAddrs(addrs)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"net"
+	"net/http/httptrace"
 	"testing"
 	"time"
 
@@ -52,6 +53,37 @@ func TestUnitDNSDialerLookupHostFailure(t *testing.T) {
 	}
 }
 
+func TestUnitDNSDialerLookupHostCallsHTTPTrace(t *testing.T) {
+	var (
+		started string
+		done    []net.IPAddr
+	)
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			started = info.Host
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			done = info.Addrs
+		},
+	})
+	dialer := dialer.DNSDialer{Dialer: new(net.Dialer), Resolver: MockableResolver{
+		Addresses: []string{"1.1.1.1", "8.8.8.8"},
+	}}
+	addrs, err := dialer.LookupHost(ctx, "dns.google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 {
+		t.Fatal("not the result we expected")
+	}
+	if started != "dns.google" {
+		t.Fatal("DNSStart not called")
+	}
+	if len(done) != 2 || done[0].String() != "1.1.1.1" || done[1].String() != "8.8.8.8" {
+		t.Fatal("DNSDone not called with the expected addresses")
+	}
+}
+
 type MockableResolver struct {
 	Addresses []string
 	Err       error
@@ -0,0 +1,116 @@
+package dialer
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DefaultHappyEyeballsDelay is the default delay between two consecutive
+// connection attempts. This is the value recommended by RFC8305.
+const DefaultHappyEyeballsDelay = 250 * time.Millisecond
+
+type happyEyeballsResult struct {
+	conn net.Conn
+	err  error
+}
+
+// dialHappyEyeballs implements the RFC8305 connection strategy. We sort
+// addrs such that address families alternate, starting from IPv6, if we
+// have any IPv6 address. Then we start a new attempt
+// every HappyEyeballsDelay, or as soon as the previous one fails, until
+// one attempt succeeds. Before returning, we cancel the pending attempts
+// and wait for them to complete, so that the underlying dialers (e.g. a
+// SaverDialer) have a chance to record all of them.
+func (d DNSDialer) dialHappyEyeballs(
+	ctx context.Context, network, port string, addrs []string) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	addrs = interleaveAddrs(addrs)
+	results := make(chan happyEyeballsResult, len(addrs))
+	delay := d.HappyEyeballsDelay
+	if delay <= 0 {
+		delay = DefaultHappyEyeballsDelay
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var (
+		next, pending int
+		winner        net.Conn
+		errorslist    []error
+	)
+	startNext := func() {
+		target := net.JoinHostPort(addrs[next], port)
+		next, pending = next+1, pending+1
+		go func() {
+			conn, err := d.Dialer.DialContext(ctx, network, target)
+			results <- happyEyeballsResult{conn: conn, err: err}
+		}()
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(delay)
+	}
+	startNext()
+	for winner == nil && pending > 0 {
+		select {
+		case <-timer.C:
+			if next < len(addrs) {
+				startNext()
+			}
+		case res := <-results:
+			pending--
+			if res.err != nil {
+				errorslist = append(errorslist, res.err)
+				if next < len(addrs) {
+					startNext()
+				}
+				continue
+			}
+			winner = res.conn
+		}
+	}
+	cancel()
+	for ; pending > 0; pending-- {
+		if res := <-results; res.err == nil {
+			res.conn.Close() // we already have a winner
+		}
+	}
+	if winner != nil {
+		return winner, nil
+	}
+	return nil, reduceErrors(errorslist)
+}
+
+// interleaveAddrs returns addrs sorted such that IPv4 and IPv6
+// addresses alternate, starting with IPv6 if we have any IPv6
+// address, as recommended by RFC 8305. Within each family, we
+// keep the order in which the resolver returned the addresses.
+func interleaveAddrs(addrs []string) []string {
+	var primary, fallback []string
+	for _, addr := range addrs {
+		if isIPv6(addr) {
+			primary = append(primary, addr)
+			continue
+		}
+		fallback = append(fallback, addr)
+	}
+	out := make([]string, 0, len(addrs))
+	for len(primary) > 0 || len(fallback) > 0 {
+		if len(primary) > 0 {
+			out, primary = append(out, primary[0]), primary[1:]
+		}
+		if len(fallback) > 0 {
+			out, fallback = append(out, fallback[0]), fallback[1:]
+		}
+	}
+	return out
+}
+
+func isIPv6(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.To4() == nil
+}
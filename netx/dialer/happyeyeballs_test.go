@@ -0,0 +1,221 @@
+package dialer_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+// happyEyeballsConn is a conn that records whether it has been closed.
+type happyEyeballsConn struct {
+	net.Conn
+	closed bool
+	mu     sync.Mutex
+}
+
+func (c *happyEyeballsConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return nil
+}
+
+// LocalAddr returns a fake local address, because the embedded conn is
+// nil and the SaverDialer computes the connection ID from the local address.
+func (c *happyEyeballsConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 54321}
+}
+
+func (c *happyEyeballsConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// happyEyeballsDialer is a dialer whose behaviour depends on the address. An
+// address that is not in Conns nor in Errs hangs until the context is done.
+type happyEyeballsDialer struct {
+	Conns    map[string]net.Conn
+	Errs     map[string]error
+	attempts []string
+	mu       sync.Mutex
+}
+
+func (d *happyEyeballsDialer) DialContext(
+	ctx context.Context, network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.attempts = append(d.attempts, address)
+	d.mu.Unlock()
+	if conn, found := d.Conns[address]; found {
+		return conn, nil
+	}
+	if err, found := d.Errs[address]; found {
+		return nil, err
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (d *happyEyeballsDialer) Attempts() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.attempts
+}
+
+func TestUnitInterleaveAddrs(t *testing.T) {
+	orig := []string{"1.1.1.1", "1.0.0.1", "8.8.8.8", "2606:4700:4700::1111"}
+	expected := []string{"2606:4700:4700::1111", "1.1.1.1", "1.0.0.1", "8.8.8.8"}
+	if out := dialer.InterleaveAddrs(orig); !reflect.DeepEqual(out, expected) {
+		t.Fatalf("not the result we expected: %+v", out)
+	}
+	orig = []string{"2606:4700:4700::1111", "2606:4700:4700::1001", "1.1.1.1"}
+	expected = []string{"2606:4700:4700::1111", "1.1.1.1", "2606:4700:4700::1001"}
+	if out := dialer.InterleaveAddrs(orig); !reflect.DeepEqual(out, expected) {
+		t.Fatalf("not the result we expected: %+v", out)
+	}
+	orig = []string{"1.1.1.1", "1.0.0.1"}
+	expected = []string{"1.1.1.1", "1.0.0.1"}
+	if out := dialer.InterleaveAddrs(orig); !reflect.DeepEqual(out, expected) {
+		t.Fatalf("not the result we expected: %+v", out)
+	}
+}
+
+func TestUnitHappyEyeballsHangingFirstAttempt(t *testing.T) {
+	conn := &happyEyeballsConn{}
+	d := &happyEyeballsDialer{Conns: map[string]net.Conn{"1.1.1.1:443": conn}}
+	saver := &trace.Saver{}
+	dnsd := dialer.DNSDialer{
+		Dialer:             dialer.SaverDialer{Dialer: d, Saver: saver},
+		HappyEyeballs:      true,
+		HappyEyeballsDelay: 10 * time.Millisecond,
+		Resolver: MockableResolver{
+			Addresses: []string{"2606:4700:4700::1111", "1.1.1.1"},
+		},
+	}
+	out, err := dnsd.DialContext(context.Background(), "tcp", "one.one.one.one:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != conn {
+		t.Fatal("not the conn we expected")
+	}
+	if conn.isClosed() {
+		t.Fatal("the winner should not be closed")
+	}
+	// the losing attempt must have been recorded as well, and since the
+	// SaverDialer records events on completion, it comes after the winner
+	events := saver.Read()
+	if len(events) != 2 {
+		t.Fatal("expected two connect events")
+	}
+	if events[0].Address != "1.1.1.1:443" || events[0].Err != nil {
+		t.Fatal("unexpected first event")
+	}
+	if events[1].Address != "[2606:4700:4700::1111]:443" {
+		t.Fatal("unexpected second event address")
+	}
+	if !errors.Is(events[1].Err, context.Canceled) {
+		t.Fatal("expected the losing attempt to be cancelled")
+	}
+}
+
+func TestUnitHappyEyeballsFailureStartsNextAttempt(t *testing.T) {
+	conn := &happyEyeballsConn{}
+	d := &happyEyeballsDialer{
+		Conns: map[string]net.Conn{"1.1.1.1:443": conn},
+		Errs:  map[string]error{"[2606:4700:4700::1111]:443": errors.New("mocked error")},
+	}
+	dnsd := dialer.DNSDialer{
+		Dialer:             d,
+		HappyEyeballs:      true,
+		HappyEyeballsDelay: time.Hour,
+		Resolver: MockableResolver{
+			Addresses: []string{"2606:4700:4700::1111", "1.1.1.1"},
+		},
+	}
+	out, err := dnsd.DialContext(context.Background(), "tcp", "one.one.one.one:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != conn {
+		t.Fatal("not the conn we expected")
+	}
+}
+
+func TestUnitHappyEyeballsAllFail(t *testing.T) {
+	expected := errors.New("mocked error")
+	d := &happyEyeballsDialer{Errs: map[string]error{
+		"1.1.1.1:443":                expected,
+		"1.0.0.1:443":                expected,
+		"[2606:4700:4700::1111]:443": expected,
+	}}
+	dnsd := dialer.DNSDialer{
+		Dialer:        d,
+		HappyEyeballs: true,
+		Resolver: MockableResolver{
+			Addresses: []string{"1.1.1.1", "1.0.0.1", "2606:4700:4700::1111"},
+		},
+	}
+	out, err := dnsd.DialContext(context.Background(), "tcp", "one.one.one.one:443")
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if out != nil {
+		t.Fatal("expected nil conn here")
+	}
+	attempts := []string{"[2606:4700:4700::1111]:443", "1.1.1.1:443", "1.0.0.1:443"}
+	if !reflect.DeepEqual(d.Attempts(), attempts) {
+		t.Fatalf("not the attempts we expected: %+v", d.Attempts())
+	}
+}
+
+func TestUnitHappyEyeballsClosesLosingConns(t *testing.T) {
+	slow, fast := &happyEyeballsConn{}, &happyEyeballsConn{}
+	var d dialer.Dialer = &happyEyeballsDialer{Conns: map[string]net.Conn{
+		"1.1.1.1:443": slow,
+		"1.0.0.1:443": fast,
+	}}
+	d = slowDialer{Dialer: d, Address: "1.1.1.1:443", Delay: 50 * time.Millisecond}
+	dnsd := dialer.DNSDialer{
+		Dialer:             d,
+		HappyEyeballs:      true,
+		HappyEyeballsDelay: 10 * time.Millisecond,
+		Resolver: MockableResolver{
+			Addresses: []string{"1.1.1.1", "1.0.0.1"},
+		},
+	}
+	out, err := dnsd.DialContext(context.Background(), "tcp", "one.one.one.one:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != fast {
+		t.Fatal("not the conn we expected")
+	}
+	if !slow.isClosed() {
+		t.Fatal("expected the losing conn to be closed")
+	}
+}
+
+// slowDialer delays the connection to Address by Delay, ignoring
+// the context, to simulate a connection completing late.
+type slowDialer struct {
+	dialer.Dialer
+	Address string
+	Delay   time.Duration
+}
+
+func (d slowDialer) DialContext(
+	ctx context.Context, network, address string) (net.Conn, error) {
+	if address == d.Address {
+		time.Sleep(d.Delay)
+		return d.Dialer.DialContext(context.Background(), network, address)
+	}
+	return d.Dialer.DialContext(ctx, network, address)
+}
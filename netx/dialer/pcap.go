@@ -0,0 +1,87 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/pcapng"
+)
+
+// PcapDialer is a dialer that writes the data read and written by the
+// returned connections into a pcapng file. We use Writer, if not nil, and
+// otherwise the pcapng.Writer in the context, if any. To reconstruct
+// flows with the real addresses, this dialer should be below DNSDialer.
+type PcapDialer struct {
+	Dialer
+	Writer *pcapng.Writer
+}
+
+// DialContext implements Dialer.DialContext
+func (d PcapDialer) DialContext(
+	ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.Dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	writer := d.Writer
+	if writer == nil {
+		writer = ContextPcapWriter(ctx)
+	}
+	if writer == nil {
+		return conn, nil // no point in wrapping
+	}
+	flow := writer.NewFlow(conn.LocalAddr(), conn.RemoteAddr(), time.Now())
+	if flow == nil {
+		return conn, nil // cannot represent this flow
+	}
+	return &pcapConnWrapper{Conn: conn, flow: flow}, nil
+}
+
+type pcapWriterKey struct{}
+
+// ContextPcapWriter retrieves the pcapng writer from the context
+func ContextPcapWriter(ctx context.Context) *pcapng.Writer {
+	writer, _ := ctx.Value(pcapWriterKey{}).(*pcapng.Writer)
+	return writer
+}
+
+// WithPcapWriter assigns the pcapng writer to the context
+func WithPcapWriter(ctx context.Context, writer *pcapng.Writer) context.Context {
+	return context.WithValue(ctx, pcapWriterKey{}, writer)
+}
+
+type pcapConnWrapper struct {
+	net.Conn
+	closeRecv sync.Once
+	closeSend sync.Once
+	flow      *pcapng.Flow
+}
+
+func (c *pcapConnWrapper) Read(p []byte) (int, error) {
+	count, err := c.Conn.Read(p)
+	if count > 0 {
+		c.flow.Recv(p[:count], time.Now())
+	}
+	if errors.Is(err, io.EOF) {
+		c.closeRecv.Do(func() { c.flow.CloseRecv(time.Now()) })
+	}
+	return count, err
+}
+
+func (c *pcapConnWrapper) Write(p []byte) (int, error) {
+	count, err := c.Conn.Write(p)
+	if count > 0 {
+		c.flow.Send(p[:count], time.Now())
+	}
+	return count, err
+}
+
+func (c *pcapConnWrapper) Close() error {
+	err := c.Conn.Close()
+	c.closeSend.Do(func() { c.flow.CloseSend(time.Now()) })
+	return err
+}
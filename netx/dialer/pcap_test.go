@@ -0,0 +1,116 @@
+package dialer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/pcapng"
+)
+
+func TestUnitPcapDialerFailure(t *testing.T) {
+	expected := errors.New("mocked error")
+	writer, err := pcapng.NewWriter(new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := dialer.PcapDialer{
+		Dialer: dialer.FakeDialer{Err: expected},
+		Writer: writer,
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", "www.google.com:443")
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+}
+
+func TestUnitPcapDialerNoWriter(t *testing.T) {
+	fake := &dialer.FakeConn{}
+	d := dialer.PcapDialer{Dialer: dialer.FakeDialer{Conn: fake}}
+	conn, err := d.DialContext(context.Background(), "tcp", "www.google.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn != fake {
+		t.Fatal("expected the original conn here")
+	}
+}
+
+func TestUnitPcapDialerUnsupportedAddrs(t *testing.T) {
+	writer, err := pcapng.NewWriter(new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := dialer.WithPcapWriter(context.Background(), writer)
+	d := dialer.PcapDialer{Dialer: dialer.EOFConnDialer{}}
+	conn, err := d.DialContext(ctx, "tcp", "www.google.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.(dialer.EOFConn); !ok {
+		t.Fatal("expected the original conn here")
+	}
+}
+
+func TestIntegrationPcapDialerWithContextWriter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("antani"))
+		}))
+	defer server.Close()
+	URL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	writer, err := pcapng.NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerLen := buf.Len()
+	ctx := dialer.WithPcapWriter(context.Background(), writer)
+	d := dialer.PcapDialer{Dialer: new(net.Dialer)}
+	conn, err := d.DialContext(ctx, "tcp", URL.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := "GET / HTTP/1.1\r\nHost: x.org\r\nConnection: close\r\n\r\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	response, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	conn.Close() // we should only emit a single FIN
+	if writer.Err() != nil {
+		t.Fatal(writer.Err())
+	}
+	data := buf.Bytes()[headerLen:]
+	if !bytes.Contains(data, []byte(request)) {
+		t.Fatal("the request should be in the pcapng")
+	}
+	if !bytes.Contains(data, response[len(response)-6:]) {
+		t.Fatal("the response should be in the pcapng")
+	}
+	var count int
+	for len(data) > 0 {
+		data, count = data[binary.LittleEndian.Uint32(data[4:]):], count+1
+	}
+	// SYN, SYN-ACK, ACK, request, >= 1 response segments, FIN, FIN
+	if count < 7 {
+		t.Fatal("unexpected number of packets", count)
+	}
+}
@@ -1,17 +1,33 @@
 package dialer
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"net"
+	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
+	"github.com/ooni/probe-engine/netx/errorx"
 	"golang.org/x/net/proxy"
 )
 
 // ProxyDialer is a dialer that uses a proxy. If the ProxyURL is not configured, this
 // dialer is a passthrough for the next Dialer in chain. Otherwise, it will internally
-// create a SOCKS5 dialer that will connect to the proxy using the underlying Dialer.
+// create a proxy dialer that will connect to the proxy using the underlying Dialer.
+// We support `socks5://` proxies, as well as `http://` and `https://` proxies using
+// the CONNECT method. In all cases, the URL may contain `user:password` credentials.
+//
+// When we can connect to the proxy but the handshake with it fails, the returned
+// error wraps one of errorx.ErrProxyAuthenticationFailed, errorx.ErrProxyConnectFailed,
+// and errorx.ErrProxyHandshakeFailed, so that it maps to a distinct OONI failure.
+// When a SOCKS5 proxy replies with a known error code, the failure is more specific
+// than the one of errorx.ErrProxyConnectFailed, e.g., errorx.FailureSOCKSHostUnreachable.
 //
 // As a special case, you can force a proxy to be used only extemporarily. To this end,
 // you can use the WithProxyURL function, to store the proxy URL in the context. This
@@ -22,6 +38,9 @@ type ProxyDialer struct {
 	ProxyURL *url.URL
 }
 
+// ErrProxyUnsupportedScheme indicates that we don't support the proxy URL scheme.
+var ErrProxyUnsupportedScheme = errors.New("dialer: unsupported proxy scheme")
+
 type proxyKey struct{}
 
 // ContextProxyURL retrieves the proxy URL from the context. This is mainly used
@@ -45,13 +64,23 @@ func (d ProxyDialer) DialContext(ctx context.Context, network, address string) (
 	if url == nil {
 		return d.Dialer.DialContext(ctx, network, address)
 	}
-	if url.Scheme != "socks5" {
-		return nil, errors.New("Scheme is not socks5")
+	forward := &proxyForwardDialer{proxyDialerWrapper: proxyDialerWrapper{Dialer: d.Dialer}}
+	var child proxy.Dialer
+	switch url.Scheme {
+	case "socks5":
+		var auth *proxy.Auth
+		if url.User != nil {
+			password, _ := url.User.Password()
+			auth = &proxy.Auth{User: url.User.Username(), Password: password}
+		}
+		// the code at proxy/socks5.go never fails; see https://git.io/JfJ4g
+		child, _ = proxy.SOCKS5(network, url.Host, auth, forward)
+	case "http", "https":
+		child = httpConnectDialer{forward: forward, proxyURL: url}
+	default:
+		return nil, ErrProxyUnsupportedScheme
 	}
-	// the code at proxy/socks5.go never fails; see https://git.io/JfJ4g
-	child, _ := proxy.SOCKS5(
-		network, url.Host, nil, proxyDialerWrapper{Dialer: d.Dialer})
-	return d.dial(ctx, child, network, address)
+	return d.dial(ctx, proxyHandshakeDialer{child: child, forward: forward}, network, address)
 }
 
 func (d ProxyDialer) dial(
@@ -59,7 +88,7 @@ func (d ProxyDialer) dial(
 	connch := make(chan net.Conn)
 	errch := make(chan error, 1)
 	go func() {
-		conn, err := child.Dial(network, address)
+		conn, err := proxyDial(ctx, child, network, address)
 		if err != nil {
 			errch <- err
 			return
@@ -92,3 +121,219 @@ type proxyDialerWrapper struct {
 func (d proxyDialerWrapper) Dial(network, address string) (net.Conn, error) {
 	return d.DialContext(context.Background(), network, address)
 }
+
+// proxyForwardDialer is the dialer we use to connect to the proxy. It
+// remembers whether we connected, so that we know whether a subsequent
+// error occurred during the handshake with the proxy.
+type proxyForwardDialer struct {
+	proxyDialerWrapper
+	connected bool
+}
+
+func (d *proxyForwardDialer) DialContext(
+	ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.proxyDialerWrapper.DialContext(ctx, network, address)
+	d.connected = err == nil
+	return conn, err
+}
+
+func (d *proxyForwardDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// proxyDial uses the DialContext method of child, if available, and
+// otherwise falls back to using its Dial method.
+func proxyDial(
+	ctx context.Context, child proxy.Dialer, network, address string) (net.Conn, error) {
+	if child, ok := child.(proxy.ContextDialer); ok {
+		return child.DialContext(ctx, network, address)
+	}
+	return child.Dial(network, address)
+}
+
+// proxyHandshakeDialer wraps the errors occurring after we've
+// connected to the proxy using newProxyError.
+type proxyHandshakeDialer struct {
+	child   proxy.Dialer
+	forward *proxyForwardDialer
+}
+
+func (d proxyHandshakeDialer) DialContext(
+	ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := proxyDial(ctx, d.child, network, address)
+	if err != nil && d.forward.connected {
+		return nil, newProxyError(err)
+	}
+	return conn, err
+}
+
+func (d proxyHandshakeDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// proxyError is an error that occurred during the handshake with the
+// proxy. It unwraps to the underlying error and it is also one of the
+// errorx.ErrProxyXXX errors, which we call its kind.
+type proxyError struct {
+	err  error
+	kind error
+}
+
+func (e *proxyError) Error() string {
+	return fmt.Sprintf("%s: %s", e.kind.Error(), e.err.Error())
+}
+
+func (e *proxyError) Unwrap() error {
+	return e.err
+}
+
+func (e *proxyError) Is(target error) bool {
+	return target == e.kind
+}
+
+// newProxyError wraps err, an error occurred during the handshake with
+// the proxy, using the proper kind. Because the SOCKS5 implementation does
+// not export its errors, we need to look into the error string.
+func newProxyError(err error) error {
+	var perr *proxyError
+	if errors.As(err, &perr) {
+		return err // already wrapped
+	}
+	kind := errorx.ErrProxyHandshakeFailed
+	s := err.Error()
+	switch {
+	case strings.Contains(s, "authentication"), strings.Contains(s, "username/password"):
+		kind = errorx.ErrProxyAuthenticationFailed
+	case strings.Contains(s, "unknown error "): // reply code is not "succeeded"
+		kind = errorx.ErrProxyConnectFailed
+	}
+	return &proxyError{err: err, kind: kind}
+}
+
+// httpConnectDialer is a proxy.Dialer using the CONNECT method of
+// an HTTP proxy, which we reach using TLS for `https://` URLs.
+type httpConnectDialer struct {
+	forward  *proxyForwardDialer
+	proxyURL *url.URL
+}
+
+func (d httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d httpConnectDialer) DialContext(
+	ctx context.Context, network, address string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("dialer: cannot use HTTP proxy with %s", network)
+	}
+	proxyAddress := d.proxyURL.Host
+	if d.proxyURL.Port() == "" {
+		port := "80"
+		if d.proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddress = net.JoinHostPort(d.proxyURL.Hostname(), port)
+	}
+	conn, err := d.forward.DialContext(ctx, "tcp", proxyAddress)
+	if err != nil {
+		return nil, err
+	}
+	return d.handshakeContext(ctx, conn, address)
+}
+
+// handshakeContext is like handshake but honours ctx. We use the deadline
+// of ctx, if any, as the deadline of conn during the handshake, and we close
+// conn when ctx is done, so that we do not block on a stalled proxy.
+func (d httpConnectDialer) handshakeContext(
+	ctx context.Context, conn net.Conn, address string) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	stop, stopped := make(chan interface{}), make(chan interface{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	proxyConn, err := d.handshake(conn, address)
+	close(stop)
+	<-stopped
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, &proxyError{err: ctx.Err(), kind: errorx.ErrProxyHandshakeFailed}
+		}
+		return nil, err
+	}
+	if ctx.Err() != nil { // we may have closed conn
+		proxyConn.Close()
+		return nil, &proxyError{err: ctx.Err(), kind: errorx.ErrProxyHandshakeFailed}
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		proxyConn.Close()
+		return nil, err
+	}
+	return proxyConn, nil
+}
+
+func (d httpConnectDialer) handshake(conn net.Conn, address string) (net.Conn, error) {
+	if d.proxyURL.Scheme == "https" {
+		tlsconn := tls.Client(conn, &tls.Config{ServerName: d.proxyURL.Hostname()})
+		if err := tlsconn.Handshake(); err != nil {
+			conn.Close()
+			return nil, &proxyError{err: err, kind: errorx.ErrProxyHandshakeFailed}
+		}
+		conn = tlsconn
+	}
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if d.proxyURL.User != nil {
+		password, _ := d.proxyURL.User.Password()
+		credentials := d.proxyURL.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization",
+			"Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, &proxyError{err: err, kind: errorx.ErrProxyHandshakeFailed}
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, &proxyError{err: err, kind: errorx.ErrProxyHandshakeFailed}
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		conn.Close()
+		kind := errorx.ErrProxyConnectFailed
+		if resp.StatusCode == 407 {
+			kind = errorx.ErrProxyAuthenticationFailed
+		}
+		return nil, &proxyError{err: fmt.Errorf("proxy replied %s", resp.Status), kind: kind}
+	}
+	if reader.Buffered() > 0 {
+		// the server sent data right after the response
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose reads go through a bufio.Reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
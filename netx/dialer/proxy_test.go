@@ -2,12 +2,19 @@ package dialer_test
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/errorx"
 )
 
 func TestUnitProxyDialerDialContextNoProxyURL(t *testing.T) {
@@ -47,7 +54,7 @@ func TestUnitProxyDialerDialContextInvalidScheme(t *testing.T) {
 		ProxyURL: &url.URL{Scheme: "antani"},
 	}
 	conn, err := d.DialContext(context.Background(), "tcp", "www.google.com:443")
-	if err.Error() != "Scheme is not socks5" {
+	if !errors.Is(err, dialer.ErrProxyUnsupportedScheme) {
 		t.Fatal("not the error we expected")
 	}
 	if conn != nil {
@@ -150,3 +157,247 @@ func TestUnitProxyDialerWrapper(t *testing.T) {
 		t.Fatal("conn is not nil")
 	}
 }
+
+// newHTTPProxy creates an HTTP proxy supporting CONNECT which requires
+// the "user:pass" credentials, if requireAuth is true.
+func newHTTPProxy(t *testing.T, requireAuth bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "CONNECT" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			if requireAuth {
+				auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))
+				if r.Header.Get("Proxy-Authorization") != auth {
+					w.WriteHeader(http.StatusProxyAuthRequired)
+					return
+				}
+			}
+			target, err := net.Dial("tcp", r.Host)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer target.Close()
+			w.WriteHeader(http.StatusOK)
+			conn, bufrw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			if err := bufrw.Flush(); err != nil {
+				t.Error(err)
+				return
+			}
+			go io.Copy(target, conn)
+			io.Copy(conn, target)
+		}))
+}
+
+func httpProxyGet(ctx context.Context, proxyURL *url.URL, URL string) (*http.Response, error) {
+	d := dialer.ProxyDialer{Dialer: new(net.Dialer), ProxyURL: proxyURL}
+	client := &http.Client{Transport: &http.Transport{DialContext: d.DialContext}}
+	defer client.CloseIdleConnections()
+	return client.Get(URL)
+}
+
+func TestIntegrationProxyDialerHTTPConnect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("antani"))
+		}))
+	defer server.Close()
+	proxy := newHTTPProxy(t, true)
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyURL.User = url.UserPassword("user", "pass")
+	resp, err := httpProxyGet(context.Background(), proxyURL, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "antani" {
+		t.Fatal("not the body we expected")
+	}
+}
+
+func TestIntegrationProxyDialerHTTPConnectFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	serverURL := server.URL
+	server.Close() // so the proxy cannot connect to it
+	proxy := newHTTPProxy(t, true)
+	defer proxy.Close()
+	tlsProxy := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsProxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authProxyURL := *proxyURL
+	authProxyURL.User = url.UserPassword("user", "pass")
+	tlsProxyURL, err := url.Parse(tlsProxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		name     string
+		proxyURL *url.URL
+		failure  string
+	}{{
+		name:     "without credentials",
+		proxyURL: proxyURL,
+		failure:  errorx.FailureProxyAuthenticationFailed,
+	}, {
+		name:     "with unreachable destination",
+		proxyURL: &authProxyURL,
+		failure:  errorx.FailureProxyConnectFailed,
+	}, {
+		name:     "with untrusted TLS certificate",
+		proxyURL: tlsProxyURL,
+		failure:  errorx.FailureProxyHandshakeFailed,
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := httpProxyGet(context.Background(), c.proxyURL, serverURL)
+			err = errorx.SafeErrWrapperBuilder{Error: err}.MaybeBuild()
+			if err == nil || err.Error() != c.failure {
+				t.Fatal("not the error we expected", err)
+			}
+		})
+	}
+}
+
+func TestUnitProxyDialerHTTPConnectUDP(t *testing.T) {
+	d := dialer.ProxyDialer{
+		Dialer:   dialer.FakeDialer{Err: io.EOF},
+		ProxyURL: &url.URL{Scheme: "http", Host: "127.0.0.1:8080"},
+	}
+	conn, err := d.DialContext(context.Background(), "udp", "8.8.8.8:53")
+	if err == nil || err.Error() != "dialer: cannot use HTTP proxy with udp" {
+		t.Fatal("not the error we expected", err)
+	}
+	if conn != nil {
+		t.Fatal("conn is not nil")
+	}
+}
+
+func TestUnitProxyDialerHTTPConnectHonoursContext(t *testing.T) {
+	// This proxy accepts the connection and never replies to CONNECT.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	closed := make(chan interface{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		ioutil.ReadAll(conn)
+		close(closed)
+	}()
+	d := dialer.ProxyDialer{
+		Dialer:   new(net.Dialer),
+		ProxyURL: &url.URL{Scheme: "http", Host: listener.Addr().String()},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	conn, err := d.DialContext(ctx, "tcp", "8.8.8.8:443")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("not the error we expected", err)
+	}
+	if conn != nil {
+		t.Fatal("conn is not nil")
+	}
+	// We must not leave the connection to the proxy open
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("the connection to the proxy is still open")
+	}
+}
+
+// newSOCKS5Proxy creates a SOCKS5 proxy that reads the client greeting
+// and then sends back the given replies, reading after each of them.
+func newSOCKS5Proxy(t *testing.T, replies ...[]byte) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1024)
+		for _, reply := range replies {
+			if _, err := conn.Read(buf); err != nil {
+				return
+			}
+			if _, err := conn.Write(reply); err != nil {
+				return
+			}
+		}
+	}()
+	return listener
+}
+
+func TestIntegrationProxyDialerSOCKS5Failures(t *testing.T) {
+	var cases = []struct {
+		name    string
+		replies [][]byte
+		failure string
+	}{{
+		name:    "with wrong credentials",
+		replies: [][]byte{{5, 2}, {1, 1}},
+		failure: errorx.FailureProxyAuthenticationFailed,
+	}, {
+		name:    "with connection refused by the proxy",
+		replies: [][]byte{{5, 2}, {1, 0}, {5, 5, 0, 1, 0, 0, 0, 0, 0, 0}},
+		failure: errorx.FailureSOCKSConnectionRefused,
+	}, {
+		name:    "with unknown reply code",
+		replies: [][]byte{{5, 2}, {1, 0}, {5, 10, 0, 1, 0, 0, 0, 0, 0, 0}},
+		failure: errorx.FailureProxyConnectFailed,
+	}, {
+		name:    "with invalid protocol version",
+		replies: [][]byte{{4, 0}},
+		failure: errorx.FailureProxyHandshakeFailed,
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			listener := newSOCKS5Proxy(t, c.replies...)
+			defer listener.Close()
+			d := dialer.ProxyDialer{
+				Dialer: new(net.Dialer),
+				ProxyURL: &url.URL{
+					Scheme: "socks5",
+					User:   url.UserPassword("user", "pass"),
+					Host:   listener.Addr().String(),
+				},
+			}
+			conn, err := d.DialContext(context.Background(), "tcp", "8.8.8.8:443")
+			if conn != nil {
+				t.Fatal("conn is not nil")
+			}
+			err = errorx.SafeErrWrapperBuilder{Error: err}.MaybeBuild()
+			if err == nil || err.Error() != c.failure {
+				t.Fatal("not the error we expected", err)
+			}
+		})
+	}
+}
@@ -16,7 +16,7 @@ import (
 // SaverDialer saves events occurring during the dial
 type SaverDialer struct {
 	Dialer
-	Saver *trace.Saver
+	Saver trace.Sink
 }
 
 // DialContext implements Dialer.DialContext
@@ -26,6 +26,7 @@ func (d SaverDialer) DialContext(ctx context.Context, network, address string) (
 	stop := time.Now()
 	d.Saver.Write(trace.Event{
 		Address:  address,
+		ConnID:   safeConnID(network, conn),
 		Duration: stop.Sub(start),
 		Err:      err,
 		Name:     errorx.ConnectOperation,
@@ -35,36 +36,60 @@ func (d SaverDialer) DialContext(ctx context.Context, network, address string) (
 	return conn, err
 }
 
-// SaverTLSHandshaker saves events occurring during the handshake
+// SaverTLSHandshaker saves events occurring during the handshake. Set
+// Fingerprint when the underlying handshaker is an UTLSHandshaker, so
+// that we also record which ClientHello we were mimicking and the ALPN
+// we used in place of the mimicked one, if any. Likewise, set
+// SplitStrategy when the underlying handshaker is a SplitTLSHandshaker.
+// We also save the result of verifying the peer certificates, which we
+// compute ourselves when the handshake does not verify them.
 type SaverTLSHandshaker struct {
 	TLSHandshaker
-	Saver *trace.Saver
+	Fingerprint   string // default: not using UTLSHandshaker
+	Saver         trace.Sink
+	SplitStrategy string // default: not using SplitTLSHandshaker
 }
 
 // Handshake implements TLSHandshaker.Handshake
 func (h SaverTLSHandshaker) Handshake(
 	ctx context.Context, conn net.Conn, config *tls.Config,
 ) (net.Conn, tls.ConnectionState, error) {
+	connID := safeConnID(safeLocalNetwork(conn), conn)
+	var fingerprintALPN []string
+	if h.Fingerprint != "" {
+		fingerprintALPN = utlsALPN(config)
+	}
 	start := time.Now()
 	h.Saver.Write(trace.Event{
-		Name:          "tls_handshake_start",
-		NoTLSVerify:   config.InsecureSkipVerify,
-		TLSNextProtos: config.NextProtos,
-		TLSServerName: config.ServerName,
-		Time:          start,
+		ConnID:             connID,
+		Name:               "tls_handshake_start",
+		NoTLSVerify:        config.InsecureSkipVerify,
+		TLSFingerprint:     h.Fingerprint,
+		TLSFingerprintALPN: fingerprintALPN,
+		TLSNextProtos:      config.NextProtos,
+		TLSServerName:      config.ServerName,
+		TLSSplitStrategy:   h.SplitStrategy,
+		Time:               start,
 	})
 	tlsconn, state, err := h.TLSHandshaker.Handshake(ctx, conn, config)
 	stop := time.Now()
+	chain, verifyErr := verifyPeerCerts(config, state, err)
 	h.Saver.Write(trace.Event{
+		ConnID:             connID,
 		Duration:           stop.Sub(start),
 		Err:                err,
 		Name:               "tls_handshake_done",
 		NoTLSVerify:        config.InsecureSkipVerify,
 		TLSCipherSuite:     tlsx.CipherSuiteString(state.CipherSuite),
+		TLSFingerprint:     h.Fingerprint,
+		TLSFingerprintALPN: fingerprintALPN,
 		TLSNegotiatedProto: state.NegotiatedProtocol,
 		TLSNextProtos:      config.NextProtos,
 		TLSPeerCerts:       peerCerts(state, err),
 		TLSServerName:      config.ServerName,
+		TLSSplitStrategy:   h.SplitStrategy,
+		TLSVerifiedChain:   chain,
+		TLSVerifyErr:       verifyErr,
 		TLSVersion:         tlsx.VersionString(state.Version),
 		Time:               stop,
 	})
@@ -75,7 +100,7 @@ func (h SaverTLSHandshaker) Handshake(
 // collect all the read/write events that occur.
 type SaverConnDialer struct {
 	Dialer
-	Saver *trace.Saver
+	Saver trace.Sink
 }
 
 // DialContext implements Dialer.DialContext
@@ -84,12 +109,14 @@ func (d SaverConnDialer) DialContext(ctx context.Context, network, address strin
 	if err != nil {
 		return nil, err
 	}
-	return saverConn{saver: d.Saver, Conn: conn}, nil
+	return saverConn{
+		Conn: conn, connID: safeConnID(network, conn), saver: d.Saver}, nil
 }
 
 type saverConn struct {
 	net.Conn
-	saver *trace.Saver
+	connID int64
+	saver  trace.Sink
 }
 
 func (c saverConn) Read(p []byte) (int, error) {
@@ -97,6 +124,7 @@ func (c saverConn) Read(p []byte) (int, error) {
 	count, err := c.Conn.Read(p)
 	stop := time.Now()
 	c.saver.Write(trace.Event{
+		ConnID:   c.connID,
 		Data:     p[:count],
 		Duration: stop.Sub(start),
 		Err:      err,
@@ -112,6 +140,7 @@ func (c saverConn) Write(p []byte) (int, error) {
 	count, err := c.Conn.Write(p)
 	stop := time.Now()
 	c.saver.Write(trace.Event{
+		ConnID:   c.connID,
 		Data:     p[:count],
 		Duration: stop.Sub(start),
 		Err:      err,
@@ -144,6 +173,43 @@ func peerCerts(state tls.ConnectionState, err error) []*x509.Certificate {
 	return state.PeerCertificates
 }
 
+// verifyPeerCerts returns the chain we built when verifying the
+// certificates presented by the peer or the reason why verification
+// failed. When the handshake skipped verification, we verify now using
+// config, so that we know whether the certificates are valid anyway.
+func verifyPeerCerts(
+	config *tls.Config, state tls.ConnectionState, err error,
+) ([]*x509.Certificate, error) {
+	if err != nil {
+		return nil, err
+	}
+	if !config.InsecureSkipVerify {
+		if len(state.VerifiedChains) <= 0 {
+			return nil, nil
+		}
+		return state.VerifiedChains[0], nil
+	}
+	if len(state.PeerCertificates) <= 0 {
+		return nil, nil
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       config.ServerName,
+		Intermediates: x509.NewCertPool(),
+		Roots:         config.RootCAs,
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	chains, err := state.PeerCertificates[0].Verify(opts)
+	if err != nil {
+		return nil, errorx.SafeErrWrapperBuilder{
+			Error:     err,
+			Operation: errorx.TLSHandshakeOperation,
+		}.MaybeBuild()
+	}
+	return chains[0], nil
+}
+
 var _ Dialer = SaverDialer{}
 var _ TLSHandshaker = SaverTLSHandshaker{}
 var _ net.Conn = saverConn{}
@@ -3,8 +3,12 @@ package dialer_test
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 	"time"
@@ -54,6 +58,28 @@ func TestUnitSaverDialerFailure(t *testing.T) {
 	}
 }
 
+func TestUnitSaverDialerConnID(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	saver := &trace.Saver{}
+	dlr := dialer.SaverDialer{Dialer: new(net.Dialer), Saver: saver}
+	conn, err := dlr.DialContext(context.Background(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ev := saver.Read()
+	if len(ev) != 1 {
+		t.Fatal("unexpected number of events")
+	}
+	if ev[0].ConnID != int64(conn.LocalAddr().(*net.TCPAddr).Port) {
+		t.Fatal("unexpected ConnID")
+	}
+}
+
 func TestUnitSaverConnDialerFailure(t *testing.T) {
 	expected := errors.New("mocked error")
 	saver := &trace.Saver{}
@@ -72,6 +98,70 @@ func TestUnitSaverConnDialerFailure(t *testing.T) {
 	}
 }
 
+func TestUnitSaverTLSHandshakerFingerprint(t *testing.T) {
+	saver := &trace.Saver{}
+	tlsdlr := dialer.TLSDialer{
+		Config: &tls.Config{NextProtos: []string{"http/1.1"}},
+		Dialer: dialer.EOFConnDialer{},
+		TLSHandshaker: dialer.SaverTLSHandshaker{
+			Fingerprint:   "ios",
+			Saver:         saver,
+			TLSHandshaker: dialer.UTLSHandshaker{Fingerprint: "ios"},
+		},
+	}
+	conn, err := tlsdlr.DialTLSContext(context.Background(), "tcp", "www.google.com:443")
+	if !errors.Is(err, io.EOF) {
+		t.Fatal("not the error we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+	ev := saver.Read()
+	if len(ev) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	for _, e := range ev {
+		if e.TLSFingerprint != "ios" {
+			t.Fatal("unexpected TLSFingerprint")
+		}
+		if len(e.TLSFingerprintALPN) != 1 || e.TLSFingerprintALPN[0] != "http/1.1" {
+			t.Fatal("unexpected TLSFingerprintALPN")
+		}
+	}
+}
+
+func TestUnitSaverTLSHandshakerSplitStrategy(t *testing.T) {
+	saver := &trace.Saver{}
+	tlsdlr := dialer.TLSDialer{
+		Config: &tls.Config{NextProtos: []string{"http/1.1"}},
+		Dialer: dialer.EOFConnDialer{},
+		TLSHandshaker: dialer.SaverTLSHandshaker{
+			Saver:         saver,
+			SplitStrategy: "sni",
+			TLSHandshaker: dialer.SplitTLSHandshaker{
+				Strategy:      "sni",
+				TLSHandshaker: dialer.SystemTLSHandshaker{},
+			},
+		},
+	}
+	conn, err := tlsdlr.DialTLSContext(context.Background(), "tcp", "www.google.com:443")
+	if !errors.Is(err, io.EOF) {
+		t.Fatal("not the error we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+	ev := saver.Read()
+	if len(ev) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	for _, e := range ev {
+		if e.TLSSplitStrategy != "sni" {
+			t.Fatal("unexpected TLSSplitStrategy")
+		}
+	}
+}
+
 func TestIntegrationSaverTLSHandshakerSuccessWithReadWrite(t *testing.T) {
 	// This is the most common use case for collecting reads, writes
 	if testing.Short() {
@@ -114,6 +204,11 @@ func TestIntegrationSaverTLSHandshakerSuccessWithReadWrite(t *testing.T) {
 	if ev[0].Time.After(time.Now()) {
 		t.Fatal("unexpected Time")
 	}
+	for _, e := range ev {
+		if e.ConnID <= 0 || e.ConnID != ev[0].ConnID {
+			t.Fatal("unexpected ConnID")
+		}
+	}
 	last := len(ev) - 1
 	for idx := 1; idx < last; idx++ {
 		if ev[idx].Data == nil {
@@ -369,3 +464,68 @@ func TestIntegrationSaverTLSHandshakerNoTLSVerify(t *testing.T) {
 		}
 	}
 }
+
+func TestUnitSaverTLSHandshakerVerification(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	address := server.Listener.Addr().String()
+	tests := []struct {
+		name        string
+		config      *tls.Config
+		pool        *x509.CertPool
+		wantChain   int
+		wantFailure string
+	}{{
+		name:      "with verification",
+		config:    &tls.Config{RootCAs: x509.NewCertPool()},
+		pool:      pool,
+		wantChain: 1,
+	}, {
+		name:      "without verification and with the proper CA",
+		config:    &tls.Config{InsecureSkipVerify: true},
+		pool:      pool,
+		wantChain: 1,
+	}, {
+		name:        "without verification and with the wrong CA",
+		config:      &tls.Config{InsecureSkipVerify: true, RootCAs: x509.NewCertPool()},
+		wantFailure: errorx.FailureSSLUnknownAuthority,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saver := &trace.Saver{}
+			tlsdlr := dialer.TLSDialer{
+				Config: tt.config,
+				Dialer: new(net.Dialer),
+				TLSHandshaker: dialer.SaverTLSHandshaker{
+					TLSHandshaker: dialer.SystemTLSHandshaker{},
+					Saver:         saver,
+				},
+			}
+			ctx := context.Background()
+			if tt.pool != nil {
+				ctx = dialer.WithCertPool(ctx, tt.pool)
+			}
+			conn, err := tlsdlr.DialTLSContext(ctx, "tcp", address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+			ev := saver.Read()
+			if len(ev) != 2 {
+				t.Fatal("unexpected number of events")
+			}
+			if len(ev[1].TLSVerifiedChain) != tt.wantChain {
+				t.Fatal("unexpected TLSVerifiedChain")
+			}
+			var failure string
+			if ev[1].TLSVerifyErr != nil {
+				failure = ev[1].TLSVerifyErr.Error()
+			}
+			if failure != tt.wantFailure {
+				t.Fatal("unexpected TLSVerifyErr", ev[1].TLSVerifyErr)
+			}
+		})
+	}
+}
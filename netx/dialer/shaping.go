@@ -0,0 +1,285 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShapingConfig contains the configuration of a Shaper.
+type ShapingConfig struct {
+	// DownloadRate is the download rate in bytes per second.
+	// When zero or negative, we do not limit the download rate.
+	DownloadRate int64
+
+	// Jitter is the maximum random delay we add to Latency.
+	Jitter time.Duration
+
+	// Latency is the extra round trip time. We add it when we
+	// connect and when we read after having written, i.e. when we
+	// are waiting for the response to a request. We do not add it
+	// to reads following reads and to writes, so that we do not
+	// reduce the throughput of downloads and uploads.
+	Latency time.Duration
+
+	// UploadRate is like DownloadRate but for uploads.
+	UploadRate int64
+}
+
+// Shaper shapes the traffic of all the connections created by the
+// dialers using it. The download and upload rates are limited using
+// token buckets shared among all such connections, which thus behave
+// as if they were using the same network link.
+type Shaper struct {
+	config   ShapingConfig
+	download *tokenBucket
+	upload   *tokenBucket
+}
+
+// NewShaper creates a new Shaper with the specified config.
+func NewShaper(config ShapingConfig) *Shaper {
+	return &Shaper{
+		config:   config,
+		download: newTokenBucket(config.DownloadRate),
+		upload:   newTokenBucket(config.UploadRate),
+	}
+}
+
+// Config returns the configuration of the Shaper.
+func (s *Shaper) Config() ShapingConfig {
+	return s.config
+}
+
+// sleepLatency sleeps for the configured latency, or until the
+// context is done, in which case it returns the context error.
+func (s *Shaper) sleepLatency(ctx context.Context) error {
+	delay := s.config.Latency
+	if s.config.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(s.config.Jitter)))
+	}
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ErrInvalidShapingSpec indicates that we cannot parse a shaping spec.
+var ErrInvalidShapingSpec = errors.New("dialer: invalid shaping spec")
+
+// ParseShapingConfig parses a shaping spec such as
+// `download=1mbit,upload=256kbit,latency=150ms,jitter=30ms`. Rates
+// are expressed in bit per second using the `bit`, `kbit`, `mbit`, and
+// `gbit` units. Durations use the syntax of time.ParseDuration.
+func ParseShapingConfig(spec string) (ShapingConfig, error) {
+	var config ShapingConfig
+	for _, entry := range strings.Split(spec, ",") {
+		v := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(v) != 2 {
+			return config, fmt.Errorf("%w: %s", ErrInvalidShapingSpec, entry)
+		}
+		var err error
+		switch v[0] {
+		case "download":
+			config.DownloadRate, err = parseRate(v[1])
+		case "jitter":
+			config.Jitter, err = time.ParseDuration(v[1])
+		case "latency":
+			config.Latency, err = time.ParseDuration(v[1])
+		case "upload":
+			config.UploadRate, err = parseRate(v[1])
+		default:
+			err = errors.New("unknown key")
+		}
+		if err != nil {
+			return config, fmt.Errorf("%w: %s: %s", ErrInvalidShapingSpec, entry, err)
+		}
+	}
+	return config, nil
+}
+
+// parseRate parses a rate in bit per second and returns bytes per second.
+func parseRate(s string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{{"gbit", 1e9}, {"mbit", 1e6}, {"kbit", 1e3}, {"bit", 1}}
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			value, err := strconv.ParseInt(strings.TrimSuffix(s, unit.suffix), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			if value <= 0 {
+				return 0, errors.New("rate must be positive")
+			}
+			return value * unit.multiplier / 8, nil
+		}
+	}
+	return 0, errors.New("missing rate unit")
+}
+
+// tokenBucket is a token bucket where tokens are bytes. A nil
+// tokenBucket does not limit the rate.
+type tokenBucket struct {
+	burst  float64
+	last   time.Time
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+}
+
+// minBurst is the minimum size of the bucket, which is large
+// enough to contain a full sized TCP segment.
+const minBurst = 1500
+
+func newTokenBucket(rate int64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := float64(rate) / 10 // tenth of a second worth of bytes
+	if burst < minBurst {
+		burst = minBurst
+	}
+	return &tokenBucket{burst: burst, last: time.Now(), rate: float64(rate), tokens: burst}
+}
+
+// maxChunk returns the maximum number of bytes we should transfer at once.
+func (b *tokenBucket) maxChunk() int {
+	if b == nil {
+		return 0
+	}
+	return int(b.burst)
+}
+
+// take removes count tokens from the bucket and sleeps until the
+// bucket is not in debt anymore, thus enforcing the rate.
+func (b *tokenBucket) take(count int) {
+	if b == nil || count <= 0 {
+		return
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(count)
+	debt := b.tokens
+	b.mu.Unlock()
+	if debt < 0 {
+		time.Sleep(time.Duration(-debt / b.rate * float64(time.Second)))
+	}
+}
+
+// ShapingDialer shapes the traffic of the connections it creates. We use
+// Shaper, if not nil, and otherwise the Shaper in the context, if any. When
+// neither is set, we use a default Shaper, which does not shape traffic
+// unless you compile with `-tags shaping`.
+type ShapingDialer struct {
+	Dialer
+	Shaper *Shaper
+}
+
+// DialContext implements Dialer.DialContext
+func (d ShapingDialer) DialContext(
+	ctx context.Context, network, address string) (net.Conn, error) {
+	shaper := d.Shaper
+	if shaper == nil {
+		shaper = ContextShaper(ctx)
+	}
+	if shaper == nil {
+		shaper = defaultShaper
+	}
+	if shaper == nil {
+		return d.Dialer.DialContext(ctx, network, address)
+	}
+	if err := shaper.sleepLatency(ctx); err != nil {
+		return nil, err
+	}
+	conn, err := d.Dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	packet := strings.HasPrefix(network, "udp")
+	return &shapingConn{Conn: conn, packet: packet, shaper: shaper}, nil
+}
+
+type shaperKey struct{}
+
+// ContextShaper retrieves the Shaper from the context
+func ContextShaper(ctx context.Context) *Shaper {
+	shaper, _ := ctx.Value(shaperKey{}).(*Shaper)
+	return shaper
+}
+
+// WithShaper assigns the Shaper to the context
+func WithShaper(ctx context.Context, shaper *Shaper) context.Context {
+	return context.WithValue(ctx, shaperKey{}, shaper)
+}
+
+// shapingConn is a shaped connection. We split the reads and writes of
+// stream conns in chunks not larger than the token buckets, to smooth the
+// traffic. We cannot do that with packet conns, where each read and write
+// is a datagram, so we take the tokens for the whole datagram instead.
+type shapingConn struct {
+	net.Conn
+	mu      sync.Mutex
+	packet  bool
+	shaper  *Shaper
+	written bool
+}
+
+func (c *shapingConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	written := c.written
+	c.written = false
+	c.mu.Unlock()
+	if written {
+		c.shaper.sleepLatency(context.Background())
+	}
+	if max := c.shaper.download.maxChunk(); !c.packet && max > 0 && len(p) > max {
+		p = p[:max]
+	}
+	count, err := c.Conn.Read(p)
+	c.shaper.download.take(count)
+	return count, err
+}
+
+func (c *shapingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.written = true
+	c.mu.Unlock()
+	if c.packet {
+		c.shaper.upload.take(len(p))
+		return c.Conn.Write(p)
+	}
+	var total int
+	for len(p) > 0 {
+		chunk := p
+		if max := c.shaper.upload.maxChunk(); max > 0 && len(chunk) > max {
+			chunk = chunk[:max]
+		}
+		c.shaper.upload.take(len(chunk))
+		count, err := c.Conn.Write(chunk)
+		total += count
+		if err != nil {
+			return total, err
+		}
+		p = p[count:]
+	}
+	return total, nil
+}
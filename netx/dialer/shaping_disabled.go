@@ -2,20 +2,7 @@
 
 package dialer
 
-import (
-	"context"
-	"net"
-)
-
-// ShapingDialer ensures we don't use too much bandwidth
-// when using integration tests at GitHub. To select
-// the implementation with shaping use `-tags shaping`.
-type ShapingDialer struct {
-	Dialer
-}
-
-// DialContext implements Dialer.DialContext
-func (d ShapingDialer) DialContext(
-	ctx context.Context, network, address string) (net.Conn, error) {
-	return d.Dialer.DialContext(ctx, network, address)
-}
+// defaultShaper is the Shaper used by ShapingDialer when no other
+// Shaper has been configured. To select the implementation with
+// shaping enabled by default use `-tags shaping`.
+var defaultShaper *Shaper
@@ -1,9 +1,12 @@
 package dialer_test
 
 import (
+	"context"
+	"errors"
 	"net"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/ooni/probe-engine/netx"
 	"github.com/ooni/probe-engine/netx/dialer"
@@ -25,3 +28,199 @@ func TestIntegration(t *testing.T) {
 	}
 	resp.Body.Close()
 }
+
+func TestUnitParseShapingConfig(t *testing.T) {
+	config, err := dialer.ParseShapingConfig(
+		"download=8mbit, upload=256kbit,latency=150ms,jitter=30ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := dialer.ShapingConfig{
+		DownloadRate: 1000000,
+		Jitter:       30 * time.Millisecond,
+		Latency:      150 * time.Millisecond,
+		UploadRate:   32000,
+	}
+	if config != expected {
+		t.Fatalf("not the config we expected: %+v", config)
+	}
+}
+
+func TestUnitParseShapingConfigInvalid(t *testing.T) {
+	specs := []string{
+		"", "download", "antani=1mbit", "download=1mb", "upload=xmbit",
+		"upload=0bit", "latency=10", "jitter=x",
+	}
+	for _, spec := range specs {
+		_, err := dialer.ParseShapingConfig(spec)
+		if !errors.Is(err, dialer.ErrInvalidShapingSpec) {
+			t.Fatal("not the error we expected for", spec)
+		}
+	}
+}
+
+func TestUnitShapingDialerFailure(t *testing.T) {
+	expected := errors.New("mocked error")
+	d := dialer.ShapingDialer{
+		Dialer: dialer.FakeDialer{Err: expected},
+		Shaper: dialer.NewShaper(dialer.ShapingConfig{}),
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", "www.google.com:443")
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+}
+
+func TestUnitShapingDialerUploadRate(t *testing.T) {
+	d := dialer.ShapingDialer{
+		Dialer: dialer.FakeDialer{Conn: &dialer.FakeConn{}},
+		Shaper: dialer.NewShaper(dialer.ShapingConfig{UploadRate: 100000}),
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", "www.google.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	// The bucket initially contains 10000 bytes, hence we need
+	// to wait for 20000 more bytes, i.e., for 200 ms.
+	count, err := conn.Write(make([]byte, 30000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 30000 {
+		t.Fatal("unexpected number of bytes written")
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatal("upload rate not enforced", elapsed)
+	}
+}
+
+func TestUnitShapingDialerDownloadRate(t *testing.T) {
+	d := dialer.ShapingDialer{
+		Dialer: dialer.FakeDialer{Conn: &dialer.FakeConn{
+			ReadData: make([]byte, 30000),
+		}},
+	}
+	ctx := dialer.WithShaper(context.Background(), dialer.NewShaper(
+		dialer.ShapingConfig{DownloadRate: 100000}))
+	conn, err := d.DialContext(ctx, "tcp", "www.google.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	buf := make([]byte, 65536)
+	var total int
+	for total < 30000 {
+		count, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count > 10000 {
+			t.Fatal("read more than the bucket size")
+		}
+		total += count
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatal("download rate not enforced", elapsed)
+	}
+}
+
+func TestUnitShapingDialerLatency(t *testing.T) {
+	d := dialer.ShapingDialer{
+		Dialer: dialer.FakeDialer{Conn: &dialer.FakeConn{
+			ReadData: []byte("abcdef"),
+		}},
+		Shaper: dialer.NewShaper(dialer.ShapingConfig{
+			Jitter:  10 * time.Millisecond,
+			Latency: 50 * time.Millisecond,
+		}),
+	}
+	start := time.Now()
+	conn, err := d.DialContext(context.Background(), "tcp", "www.google.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatal("latency not added when connecting", elapsed)
+	}
+	if _, err := conn.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	if _, err := conn.Read(make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatal("latency not added when reading after writing", elapsed)
+	}
+	start = time.Now()
+	if _, err := conn.Read(make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Fatal("latency added when reading after reading", elapsed)
+	}
+}
+
+// writesCountingConn counts the writes.
+type writesCountingConn struct {
+	dialer.FakeConn
+	writes int
+}
+
+func (c *writesCountingConn) Write(b []byte) (int, error) {
+	c.writes++
+	return c.FakeConn.Write(b)
+}
+
+func TestUnitShapingDialerDatagrams(t *testing.T) {
+	conn := &writesCountingConn{FakeConn: dialer.FakeConn{
+		ReadData: make([]byte, 30000),
+	}}
+	d := dialer.ShapingDialer{
+		Dialer: dialer.FakeDialer{Conn: conn},
+		Shaper: dialer.NewShaper(dialer.ShapingConfig{
+			DownloadRate: 100000,
+			UploadRate:   100000,
+		}),
+	}
+	shaped, err := d.DialContext(context.Background(), "udp", "8.8.8.8:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// We must not split a datagram in several writes
+	count, err := shaped.Write(make([]byte, 30000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 30000 || conn.writes != 1 {
+		t.Fatal("datagram split in several writes")
+	}
+	// We must not truncate a datagram when reading
+	count, err = shaped.Read(make([]byte, 65536))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 30000 {
+		t.Fatal("datagram truncated when reading")
+	}
+}
+
+func TestUnitShapingDialerLatencyHonoursContext(t *testing.T) {
+	d := dialer.ShapingDialer{
+		Dialer: dialer.FakeDialer{Conn: &dialer.FakeConn{}},
+		Shaper: dialer.NewShaper(dialer.ShapingConfig{Latency: time.Hour}),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	conn, err := d.DialContext(ctx, "tcp", "www.google.com:443")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("not the error we expected", err)
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+}
@@ -0,0 +1,195 @@
+package dialer
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// DefaultSplitSize is the default size of the TCP segments, or of the
+// TLS records, into which SplitConn splits the ClientHello.
+const DefaultSplitSize = 32
+
+// The names of the strategies supported by SplitConn.
+const (
+	// SplitStrategySNI splits the ClientHello into two TCP segments
+	// such that the split point is in the middle of the SNI.
+	SplitStrategySNI = "sni"
+
+	// SplitStrategyTCPSegments splits the ClientHello into TCP
+	// segments containing at most Size bytes each.
+	SplitStrategyTCPSegments = "tcp_segments"
+
+	// SplitStrategyTLSRecords fragments the ClientHello into TLS
+	// records containing at most Size bytes of payload each, which
+	// we send to the server using a single write.
+	SplitStrategyTLSRecords = "tls_records"
+)
+
+// SplitStrategies maps the name of each strategy supported by
+// SplitConn to the function splitting the ClientHello.
+var SplitStrategies = map[string]func(data []byte, size int) [][]byte{
+	SplitStrategySNI:         splitAtSNI,
+	SplitStrategyTCPSegments: splitIntoSegments,
+	SplitStrategyTLSRecords:  splitIntoRecords,
+}
+
+// ErrUnknownSplitStrategy indicates that the strategy
+// is not one of the keys of SplitStrategies.
+var ErrUnknownSplitStrategy = errors.New("dialer: unknown split strategy")
+
+// SplitConn is a net.Conn that splits the first write, which is
+// the ClientHello when we're doing a TLS handshake, according to
+// the specified Strategy. Middleboxes that do not reassemble the
+// ClientHello may fail to see the SNI and hence not block us.
+type SplitConn struct {
+	net.Conn
+	Size     int // default: DefaultSplitSize
+	Strategy string
+	written  bool
+}
+
+// Write implements net.Conn.Write
+func (c *SplitConn) Write(b []byte) (int, error) {
+	if c.written {
+		return c.Conn.Write(b)
+	}
+	c.written = true
+	split, found := SplitStrategies[c.Strategy]
+	if !found {
+		return 0, ErrUnknownSplitStrategy
+	}
+	size := c.Size
+	if size <= 0 {
+		size = DefaultSplitSize
+	}
+	for _, chunk := range split(b, size) {
+		if _, err := c.Conn.Write(chunk); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// SplitTLSHandshaker is a TLSHandshaker that wraps the connection
+// using a SplitConn before performing the TLS handshake.
+type SplitTLSHandshaker struct {
+	TLSHandshaker
+	Size     int // default: DefaultSplitSize
+	Strategy string
+}
+
+// Handshake implements Handshaker.Handshake
+func (h SplitTLSHandshaker) Handshake(
+	ctx context.Context, conn net.Conn, config *tls.Config,
+) (net.Conn, tls.ConnectionState, error) {
+	if _, found := SplitStrategies[h.Strategy]; !found {
+		return nil, tls.ConnectionState{}, ErrUnknownSplitStrategy
+	}
+	conn = &SplitConn{Conn: conn, Size: h.Size, Strategy: h.Strategy}
+	return h.TLSHandshaker.Handshake(ctx, conn, config)
+}
+
+const (
+	recordHeaderLen          = 5
+	recordTypeHandshake      = 22
+	handshakeTypeClientHello = 1
+	extensionServerName      = 0
+)
+
+// splitAtSNI splits data in the middle of the SNI. If data is not
+// a ClientHello containing the SNI, we split data in half.
+func splitAtSNI(data []byte, size int) [][]byte {
+	mid := len(data) / 2
+	if start, end, found := findSNI(data); found {
+		mid = (start + end) / 2
+	}
+	if mid <= 0 {
+		return [][]byte{data}
+	}
+	return [][]byte{data[:mid], data[mid:]}
+}
+
+// splitIntoSegments splits data into chunks of at most size bytes.
+func splitIntoSegments(data []byte, size int) (out [][]byte) {
+	for len(data) > size {
+		out, data = append(out, data[:size]), data[size:]
+	}
+	return append(out, data)
+}
+
+// splitIntoRecords fragments the first TLS record in data into records
+// whose payload is at most size bytes and returns them as a single chunk
+// followed by the rest of data. If data does not start with a complete
+// TLS record, we return it unmodified.
+func splitIntoRecords(data []byte, size int) [][]byte {
+	if len(data) < recordHeaderLen {
+		return [][]byte{data}
+	}
+	end := recordHeaderLen + int(binary.BigEndian.Uint16(data[3:]))
+	if end > len(data) {
+		return [][]byte{data}
+	}
+	var out []byte
+	for _, fragment := range splitIntoSegments(data[recordHeaderLen:end], size) {
+		header := make([]byte, recordHeaderLen)
+		copy(header, data[:3]) // content type and version
+		binary.BigEndian.PutUint16(header[3:], uint16(len(fragment)))
+		out = append(out, header...)
+		out = append(out, fragment...)
+	}
+	return [][]byte{append(out, data[end:]...)}
+}
+
+// ClientHelloSNI returns the server name inside data, if data starts with
+// a TLS record containing a ClientHello with the server_name extension.
+func ClientHelloSNI(data []byte) (string, bool) {
+	start, end, found := findSNI(data)
+	if !found {
+		return "", false
+	}
+	return string(data[start:end]), true
+}
+
+// findSNI returns the offsets of the first byte of the server name and
+// of the first byte after it, if data starts with a TLS record containing
+// a ClientHello with the server_name extension.
+func findSNI(data []byte) (start, end int, found bool) {
+	// record header, handshake header, client version, and random
+	off := recordHeaderLen + 4 + 2 + 32
+	if len(data) < off+1 || data[0] != recordTypeHandshake ||
+		data[recordHeaderLen] != handshakeTypeClientHello {
+		return
+	}
+	off += 1 + int(data[off]) // session ID
+	if len(data) < off+2 {
+		return
+	}
+	off += 2 + int(binary.BigEndian.Uint16(data[off:])) // cipher suites
+	if len(data) < off+1 {
+		return
+	}
+	off += 1 + int(data[off]) // compression methods
+	off += 2                  // extensions length
+	for len(data) >= off+4 {
+		extType := binary.BigEndian.Uint16(data[off:])
+		extLen := int(binary.BigEndian.Uint16(data[off+2:]))
+		off += 4
+		if extType == extensionServerName {
+			// server name list length, name type, and name length
+			if extLen < 5 || len(data) < off+5 {
+				return
+			}
+			start = off + 5
+			end = start + int(binary.BigEndian.Uint16(data[off+3:]))
+			if end > len(data) {
+				return 0, 0, false
+			}
+			return start, end, true
+		}
+		off += extLen
+	}
+	return
+}
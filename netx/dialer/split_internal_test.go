@@ -0,0 +1,6 @@
+package dialer
+
+// FindSNI exposes the internal function findSNI
+func FindSNI(data []byte) (start, end int, found bool) {
+	return findSNI(data)
+}
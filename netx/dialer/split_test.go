@@ -0,0 +1,215 @@
+package dialer_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/dialer"
+)
+
+// writesRecorderConn records the writes and returns EOF when reading.
+type writesRecorderConn struct {
+	dialer.EOFConn
+	writes [][]byte
+}
+
+func (c *writesRecorderConn) Write(b []byte) (int, error) {
+	c.writes = append(c.writes, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func recordClientHello(t *testing.T, strategy string, size int) [][]byte {
+	conn := &writesRecorderConn{}
+	h := dialer.SplitTLSHandshaker{
+		Size:          size,
+		Strategy:      strategy,
+		TLSHandshaker: dialer.SystemTLSHandshaker{},
+	}
+	_, _, err := h.Handshake(context.Background(), conn, &tls.Config{
+		ServerName: "www.example.com",
+	})
+	if err != io.EOF {
+		t.Fatal("not the error we expected")
+	}
+	return conn.writes
+}
+
+func TestUnitSplitTLSHandshakerUnknownStrategy(t *testing.T) {
+	h := dialer.SplitTLSHandshaker{
+		Strategy:      "antani",
+		TLSHandshaker: dialer.SystemTLSHandshaker{},
+	}
+	conn, _, err := h.Handshake(context.Background(), dialer.EOFConn{}, &tls.Config{
+		ServerName: "x.org",
+	})
+	if !errors.Is(err, dialer.ErrUnknownSplitStrategy) {
+		t.Fatal("not the error that we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+}
+
+func TestUnitSplitConnUnknownStrategy(t *testing.T) {
+	conn := &dialer.SplitConn{Conn: dialer.EOFConn{}, Strategy: "antani"}
+	if _, err := conn.Write([]byte("abc")); !errors.Is(err, dialer.ErrUnknownSplitStrategy) {
+		t.Fatal("not the error that we expected")
+	}
+}
+
+func TestUnitSplitConnWriteError(t *testing.T) {
+	expected := errors.New("mocked error")
+	conn := &dialer.SplitConn{
+		Conn:     &dialer.FakeConn{WriteError: expected},
+		Strategy: dialer.SplitStrategyTCPSegments,
+	}
+	count, err := conn.Write([]byte("abc"))
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error that we expected")
+	}
+	if count != 0 {
+		t.Fatal("expected zero bytes here")
+	}
+}
+
+func TestUnitSplitConnOnlySplitsFirstWrite(t *testing.T) {
+	inner := &writesRecorderConn{}
+	conn := &dialer.SplitConn{
+		Conn:     inner,
+		Size:     1,
+		Strategy: dialer.SplitStrategyTCPSegments,
+	}
+	for i := 0; i < 2; i++ {
+		count, err := conn.Write([]byte("abc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 3 {
+			t.Fatal("unexpected number of bytes written")
+		}
+	}
+	if len(inner.writes) != 4 {
+		t.Fatal("unexpected number of writes")
+	}
+}
+
+func TestUnitSplitStrategySNI(t *testing.T) {
+	writes := recordClientHello(t, dialer.SplitStrategySNI, 0)
+	if len(writes) != 2 {
+		t.Fatal("unexpected number of writes")
+	}
+	sni := []byte("www.example.com")
+	if bytes.Contains(writes[0], sni) || bytes.Contains(writes[1], sni) {
+		t.Fatal("the SNI should have been split")
+	}
+	if !bytes.Contains(append(writes[0], writes[1]...), sni) {
+		t.Fatal("the SNI should be in the ClientHello")
+	}
+}
+
+func TestUnitSplitStrategySNIWithoutClientHello(t *testing.T) {
+	inner := &writesRecorderConn{}
+	conn := &dialer.SplitConn{Conn: inner, Strategy: dialer.SplitStrategySNI}
+	if _, err := conn.Write([]byte("abcd")); err != nil {
+		t.Fatal(err)
+	}
+	if len(inner.writes) != 2 || string(inner.writes[0]) != "ab" {
+		t.Fatal("we should have split the data in half")
+	}
+}
+
+func TestUnitSplitStrategyTCPSegments(t *testing.T) {
+	writes := recordClientHello(t, dialer.SplitStrategyTCPSegments, 16)
+	if len(writes) < 2 {
+		t.Fatal("unexpected number of writes")
+	}
+	for _, w := range writes {
+		if len(w) > 16 {
+			t.Fatal("write is too large")
+		}
+	}
+}
+
+func TestUnitSplitStrategyTLSRecords(t *testing.T) {
+	writes := recordClientHello(t, dialer.SplitStrategyTLSRecords, 0)
+	if len(writes) != 1 {
+		t.Fatal("unexpected number of writes")
+	}
+	data, payload, records := writes[0], []byte{}, 0
+	for len(data) > 0 {
+		if len(data) < 5 || data[0] != 22 {
+			t.Fatal("invalid TLS record")
+		}
+		length := int(binary.BigEndian.Uint16(data[3:]))
+		if length > dialer.DefaultSplitSize || len(data) < 5+length {
+			t.Fatal("invalid TLS record length")
+		}
+		payload = append(payload, data[5:5+length]...)
+		data, records = data[5+length:], records+1
+	}
+	if records < 2 {
+		t.Fatal("expected more than one record")
+	}
+	if payload[0] != 1 || !bytes.Contains(payload, []byte("www.example.com")) {
+		t.Fatal("the payload should be the ClientHello")
+	}
+}
+
+func TestUnitFindSNI(t *testing.T) {
+	writes := recordClientHello(t, dialer.SplitStrategyTCPSegments, 1<<16)
+	start, end, found := dialer.FindSNI(writes[0])
+	if !found {
+		t.Fatal("expected to find the SNI")
+	}
+	if string(writes[0][start:end]) != "www.example.com" {
+		t.Fatal("not the SNI we expected")
+	}
+	if _, _, found := dialer.FindSNI([]byte("antani")); found {
+		t.Fatal("expected to not find the SNI")
+	}
+	if _, _, found := dialer.FindSNI(writes[0][:end-1]); found {
+		t.Fatal("expected to not find the SNI in a truncated ClientHello")
+	}
+}
+
+func TestIntegrationSplitTLSHandshakerSuccess(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	URL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range dialer.SplitStrategies {
+		t.Run(name, func(t *testing.T) {
+			tcpconn, err := net.Dial("tcp", URL.Host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := dialer.SplitTLSHandshaker{
+				Strategy:      name,
+				TLSHandshaker: dialer.SystemTLSHandshaker{},
+			}
+			conn, state, err := h.Handshake(context.Background(), tcpconn, &tls.Config{
+				InsecureSkipVerify: true,
+				ServerName:         "www.example.com",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if !state.HandshakeComplete {
+				t.Fatal("expected the handshake to be complete")
+			}
+		})
+	}
+}
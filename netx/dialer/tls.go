@@ -3,7 +3,10 @@ package dialer
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"io"
 	"net"
+	"net/http/httptrace"
 	"time"
 
 	"github.com/ooni/probe-engine/legacy/netx/connid"
@@ -102,7 +105,11 @@ func (h EmitterTLSHandshaker) Handshake(
 	return tlsconn, state, err
 }
 
-// TLSDialer is the TLS dialer
+// TLSDialer is the TLS dialer. The certificate pool in the context, if
+// any, takes precedence over the RootCAs of Config. The use case for this
+// functionality is using custom CAs for all the measurements of a session.
+// Likewise, the key log writer in the context, if any, takes precedence
+// over the KeyLogWriter of Config, so that we can decrypt captures.
 type TLSDialer struct {
 	Config        *tls.Config
 	Dialer        Dialer
@@ -132,10 +139,51 @@ func (d TLSDialer) DialTLSContext(ctx context.Context, network, address string)
 	if config.ServerName == "" {
 		config.ServerName = host
 	}
-	tlsconn, _, err := d.TLSHandshaker.Handshake(ctx, conn, config)
+	if pool := ContextCertPool(ctx); pool != nil {
+		config.RootCAs = pool
+	}
+	if w := ContextKeyLogWriter(ctx); w != nil {
+		config.KeyLogWriter = w
+	}
+	// Because net/http does not perform the handshake when DialTLS is
+	// set, we need to invoke the httptrace hooks on its behalf.
+	tracep := httptrace.ContextClientTrace(ctx)
+	if tracep != nil && tracep.TLSHandshakeStart != nil {
+		tracep.TLSHandshakeStart()
+	}
+	tlsconn, state, err := d.TLSHandshaker.Handshake(ctx, conn, config)
+	if tracep != nil && tracep.TLSHandshakeDone != nil {
+		tracep.TLSHandshakeDone(state, err)
+	}
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
 	return tlsconn, nil
 }
+
+type certPoolKey struct{}
+
+// ContextCertPool retrieves the certificate pool from the context
+func ContextCertPool(ctx context.Context) *x509.CertPool {
+	pool, _ := ctx.Value(certPoolKey{}).(*x509.CertPool)
+	return pool
+}
+
+// WithCertPool assigns the certificate pool to the context
+func WithCertPool(ctx context.Context, pool *x509.CertPool) context.Context {
+	return context.WithValue(ctx, certPoolKey{}, pool)
+}
+
+type keyLogWriterKey struct{}
+
+// ContextKeyLogWriter retrieves the TLS key log writer from the context
+func ContextKeyLogWriter(ctx context.Context) io.Writer {
+	w, _ := ctx.Value(keyLogWriterKey{}).(io.Writer)
+	return w
+}
+
+// WithKeyLogWriter assigns the TLS key log writer to the context
+func WithKeyLogWriter(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, keyLogWriterKey{}, w)
+}
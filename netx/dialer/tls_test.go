@@ -1,11 +1,13 @@
 package dialer_test
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
 	"io"
 	"net"
+	"net/http/httptrace"
 	"testing"
 	"time"
 
@@ -228,14 +230,69 @@ func TestUnitTLSDialerFailureHandshakingOverrideSNI(t *testing.T) {
 	}
 }
 
+func TestUnitTLSDialerCallsHTTPTrace(t *testing.T) {
+	var (
+		started bool
+		doneErr error
+	)
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		TLSHandshakeStart: func() {
+			started = true
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			doneErr = err
+		},
+	})
+	dialer := dialer.TLSDialer{
+		Dialer:        dialer.EOFConnDialer{},
+		TLSHandshaker: dialer.SystemTLSHandshaker{},
+	}
+	conn, err := dialer.DialTLSContext(ctx, "tcp", "www.google.com:443")
+	if !errors.Is(err, io.EOF) {
+		t.Fatal("expected an error here")
+	}
+	if conn != nil {
+		t.Fatal("connection is not nil")
+	}
+	if !started {
+		t.Fatal("TLSHandshakeStart not called")
+	}
+	if !errors.Is(doneErr, io.EOF) {
+		t.Fatal("TLSHandshakeDone not called with the expected error")
+	}
+}
+
+func TestUnitTLSDialerUsesContextKeyLogWriter(t *testing.T) {
+	w := new(bytes.Buffer)
+	ctx := dialer.WithKeyLogWriter(context.Background(), w)
+	rec := &RecorderTLSHandshaker{TLSHandshaker: dialer.SystemTLSHandshaker{}}
+	dialer := dialer.TLSDialer{
+		Config:        &tls.Config{KeyLogWriter: new(bytes.Buffer)},
+		Dialer:        dialer.EOFConnDialer{},
+		TLSHandshaker: rec,
+	}
+	conn, err := dialer.DialTLSContext(ctx, "tcp", "www.google.com:443")
+	if !errors.Is(err, io.EOF) {
+		t.Fatal("expected an error here")
+	}
+	if conn != nil {
+		t.Fatal("connection is not nil")
+	}
+	if rec.KeyLogWriter != w {
+		t.Fatal("unexpected KeyLogWriter")
+	}
+}
+
 type RecorderTLSHandshaker struct {
 	dialer.TLSHandshaker
-	SNI string
+	KeyLogWriter io.Writer
+	SNI          string
 }
 
 func (h *RecorderTLSHandshaker) Handshake(
 	ctx context.Context, conn net.Conn, config *tls.Config,
 ) (net.Conn, tls.ConnectionState, error) {
+	h.KeyLogWriter = config.KeyLogWriter
 	h.SNI = config.ServerName
 	return h.TLSHandshaker.Handshake(ctx, conn, config)
 }
@@ -0,0 +1,93 @@
+package dialer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// UTLSFingerprints maps the name of each fingerprint supported by
+// UTLSHandshaker to the corresponding utls ClientHelloID.
+var UTLSFingerprints = map[string]*utls.ClientHelloID{
+	"chrome":     &utls.HelloChrome_Auto,
+	"firefox":    &utls.HelloFirefox_Auto,
+	"golang":     &utls.HelloGolang,
+	"ios":        &utls.HelloIOS_Auto,
+	"randomized": &utls.HelloRandomized,
+}
+
+// ErrUnknownTLSFingerprint indicates that the fingerprint
+// is not one of the keys of UTLSFingerprints.
+var ErrUnknownTLSFingerprint = errors.New("dialer: unknown TLS fingerprint")
+
+// UTLSHandshaker is a TLSHandshaker using refraction-networking/utls
+// to mimic the ClientHello of the specified Fingerprint. We override
+// the ALPN of the mimicked ClientHello with config.NextProtos, when
+// set, because the caller may not be able to speak all the protocols
+// that the mimicked client would advertise (e.g. "h2"). When wrapping
+// it, SaverTLSHandshaker records whether we overrode the ALPN.
+type UTLSHandshaker struct {
+	Fingerprint string
+}
+
+// Handshake implements Handshaker.Handshake
+func (h UTLSHandshaker) Handshake(
+	ctx context.Context, conn net.Conn, config *tls.Config,
+) (net.Conn, tls.ConnectionState, error) {
+	clientHelloID, found := UTLSFingerprints[h.Fingerprint]
+	if !found {
+		return nil, tls.ConnectionState{}, ErrUnknownTLSFingerprint
+	}
+	tlsconn := utls.UClient(conn, &utls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		KeyLogWriter:       config.KeyLogWriter,
+		MaxVersion:         config.MaxVersion,
+		MinVersion:         config.MinVersion,
+		NextProtos:         config.NextProtos,
+		RootCAs:            config.RootCAs,
+		ServerName:         config.ServerName,
+	}, *clientHelloID)
+	if err := tlsconn.BuildHandshakeState(); err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	if protos := utlsALPN(config); protos != nil {
+		for _, ext := range tlsconn.Extensions {
+			if alpn, ok := ext.(*utls.ALPNExtension); ok {
+				alpn.AlpnProtocols = protos
+			}
+		}
+	}
+	if err := tlsconn.Handshake(); err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	return tlsconn, newConnectionStateFromUTLS(tlsconn.ConnectionState()), nil
+}
+
+// utlsALPN returns the ALPN that UTLSHandshaker uses in place of the
+// one of the mimicked ClientHello, or nil if it keeps the latter.
+func utlsALPN(config *tls.Config) []string {
+	if len(config.NextProtos) > 0 {
+		return config.NextProtos
+	}
+	return nil
+}
+
+func newConnectionStateFromUTLS(state utls.ConnectionState) tls.ConnectionState {
+	return tls.ConnectionState{
+		CipherSuite:                 state.CipherSuite,
+		DidResume:                   state.DidResume,
+		HandshakeComplete:           state.HandshakeComplete,
+		NegotiatedProtocol:          state.NegotiatedProtocol,
+		NegotiatedProtocolIsMutual:  state.NegotiatedProtocolIsMutual,
+		OCSPResponse:                state.OCSPResponse,
+		PeerCertificates:            state.PeerCertificates,
+		ServerName:                  state.ServerName,
+		SignedCertificateTimestamps: state.SignedCertificateTimestamps,
+		TLSUnique:                   state.TLSUnique,
+		VerifiedChains:              state.VerifiedChains,
+		Version:                     state.Version,
+	}
+}
@@ -0,0 +1,78 @@
+package dialer_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/dialer"
+)
+
+func TestUnitUTLSHandshakerUnknownFingerprint(t *testing.T) {
+	h := dialer.UTLSHandshaker{Fingerprint: "antani"}
+	conn, _, err := h.Handshake(context.Background(), dialer.EOFConn{}, &tls.Config{
+		ServerName: "x.org",
+	})
+	if !errors.Is(err, dialer.ErrUnknownTLSFingerprint) {
+		t.Fatal("not the error that we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected nil con here")
+	}
+}
+
+func TestUnitUTLSHandshakerEOFError(t *testing.T) {
+	h := dialer.UTLSHandshaker{Fingerprint: "chrome"}
+	conn, _, err := h.Handshake(context.Background(), dialer.EOFConn{}, &tls.Config{
+		ServerName: "x.org",
+	})
+	if err != io.EOF {
+		t.Fatal("not the error that we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected nil con here")
+	}
+}
+
+func TestUnitUTLSHandshakerSuccess(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	URL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range dialer.UTLSFingerprints {
+		t.Run(name, func(t *testing.T) {
+			tcpconn, err := net.Dial("tcp", URL.Host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := dialer.UTLSHandshaker{Fingerprint: name}
+			conn, state, err := h.Handshake(context.Background(), tcpconn, &tls.Config{
+				InsecureSkipVerify: true,
+				NextProtos:         []string{"http/1.1"},
+				ServerName:         "example.com",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if !state.HandshakeComplete {
+				t.Fatal("expected the handshake to be complete")
+			}
+			if len(state.PeerCertificates) < 1 {
+				t.Fatal("expected some peer certificates")
+			}
+			if state.NegotiatedProtocol == "h2" {
+				t.Fatal("we should not have negotiated h2")
+			}
+		})
+	}
+}
@@ -0,0 +1,537 @@
+package errorx
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Classifier maps an error to a failure string. It returns an empty
+// string when it does not know how to classify the error.
+type Classifier func(err error) string
+
+// registeredClassifier is a classifier along with the ID that
+// we use to unregister it, since we cannot compare functions.
+type registeredClassifier struct {
+	classifier Classifier
+	id         int64
+}
+
+var (
+	classifiers       []registeredClassifier
+	classifiersMu     sync.RWMutex
+	classifiersNextID int64
+)
+
+// RegisterClassifier registers a classifier that experiments can use to
+// map errors that we do not know about to their own failure strings. We
+// run the registered classifiers after checking for the errors defined by
+// this package, for context.Canceled and for the x509 errors, but before
+// the builtin TLS alert and HTTP/2 classifiers and all the checks based on
+// the error string. We start from the most recently registered classifier
+// and we use the first failure string that is not empty. Errors that are
+// already wrapped are never classified again.
+//
+// The registered classifiers apply to all the experiments running in the
+// process, therefore you should call this function from the init function
+// of the package that needs the classifier. Use the returned function to
+// unregister the classifier, e.g., at the end of a test. It is safe to call
+// this function, and the returned one, from many goroutines.
+func RegisterClassifier(c Classifier) (unregister func()) {
+	classifiersMu.Lock()
+	defer classifiersMu.Unlock()
+	classifiersNextID++
+	id := classifiersNextID
+	classifiers = append(classifiers, registeredClassifier{classifier: c, id: id})
+	return func() {
+		unregisterClassifier(id)
+	}
+}
+
+// unregisterClassifier removes the classifier with the given ID. We
+// build a new slice, because classify may be reading the current one.
+func unregisterClassifier(id int64) {
+	classifiersMu.Lock()
+	defer classifiersMu.Unlock()
+	var out []registeredClassifier
+	for _, rc := range classifiers {
+		if rc.id != id {
+			out = append(out, rc)
+		}
+	}
+	classifiers = out
+}
+
+// classify runs the registered and the builtin classifiers. The SOCKS5
+// classifier is not among them, because we only run it for the errors
+// wrapping ErrProxyConnectFailed.
+func classify(err error) string {
+	classifiersMu.RLock()
+	registered := classifiers
+	classifiersMu.RUnlock()
+	for i := len(registered) - 1; i >= 0; i-- {
+		if s := registered[i].classifier(err); s != "" {
+			return s
+		}
+	}
+	for _, c := range []Classifier{classifyTLSAlert, classifyHTTP2Error} {
+		if s := c(err); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+const (
+	// FailureSSLAlertAccessDenied means the server sent an
+	// access_denied TLS alert.
+	FailureSSLAlertAccessDenied = "ssl_alert_access_denied"
+
+	// FailureSSLAlertBadCertificate means the server sent a
+	// bad_certificate TLS alert.
+	FailureSSLAlertBadCertificate = "ssl_alert_bad_certificate"
+
+	// FailureSSLAlertBadCertificateHashValue means the server sent a
+	// bad_certificate_hash_value TLS alert.
+	FailureSSLAlertBadCertificateHashValue = "ssl_alert_bad_certificate_hash_value"
+
+	// FailureSSLAlertBadCertificateStatusResponse means the server sent a
+	// bad_certificate_status_response TLS alert.
+	FailureSSLAlertBadCertificateStatusResponse = "ssl_alert_bad_certificate_status_response"
+
+	// FailureSSLAlertBadRecordMAC means the server sent a
+	// bad_record_mac TLS alert.
+	FailureSSLAlertBadRecordMAC = "ssl_alert_bad_record_mac"
+
+	// FailureSSLAlertCertificateExpired means the server sent a
+	// certificate_expired TLS alert.
+	FailureSSLAlertCertificateExpired = "ssl_alert_certificate_expired"
+
+	// FailureSSLAlertCertificateRequired means the server sent a
+	// certificate_required TLS alert.
+	FailureSSLAlertCertificateRequired = "ssl_alert_certificate_required"
+
+	// FailureSSLAlertCertificateRevoked means the server sent a
+	// certificate_revoked TLS alert.
+	FailureSSLAlertCertificateRevoked = "ssl_alert_certificate_revoked"
+
+	// FailureSSLAlertCertificateUnknown means the server sent a
+	// certificate_unknown TLS alert.
+	FailureSSLAlertCertificateUnknown = "ssl_alert_certificate_unknown"
+
+	// FailureSSLAlertCertificateUnobtainable means the server sent a
+	// certificate_unobtainable TLS alert.
+	FailureSSLAlertCertificateUnobtainable = "ssl_alert_certificate_unobtainable"
+
+	// FailureSSLAlertCloseNotify means the server sent a
+	// close_notify TLS alert.
+	FailureSSLAlertCloseNotify = "ssl_alert_close_notify"
+
+	// FailureSSLAlertDecodeError means the server sent a
+	// decode_error TLS alert.
+	FailureSSLAlertDecodeError = "ssl_alert_decode_error"
+
+	// FailureSSLAlertDecompressionFailure means the server sent a
+	// decompression_failure TLS alert.
+	FailureSSLAlertDecompressionFailure = "ssl_alert_decompression_failure"
+
+	// FailureSSLAlertDecryptError means the server sent a
+	// decrypt_error TLS alert.
+	FailureSSLAlertDecryptError = "ssl_alert_decrypt_error"
+
+	// FailureSSLAlertDecryptionFailed means the server sent a
+	// decryption_failed TLS alert.
+	FailureSSLAlertDecryptionFailed = "ssl_alert_decryption_failed"
+
+	// FailureSSLAlertECHRequired means the server sent an
+	// ech_required TLS alert.
+	FailureSSLAlertECHRequired = "ssl_alert_ech_required"
+
+	// FailureSSLAlertExportRestriction means the server sent an
+	// export_restriction TLS alert.
+	FailureSSLAlertExportRestriction = "ssl_alert_export_restriction"
+
+	// FailureSSLAlertHandshakeFailure means the server sent a
+	// handshake_failure TLS alert.
+	FailureSSLAlertHandshakeFailure = "ssl_alert_handshake_failure"
+
+	// FailureSSLAlertIllegalParameter means the server sent an
+	// illegal_parameter TLS alert.
+	FailureSSLAlertIllegalParameter = "ssl_alert_illegal_parameter"
+
+	// FailureSSLAlertInappropriateFallback means the server sent an
+	// inappropriate_fallback TLS alert.
+	FailureSSLAlertInappropriateFallback = "ssl_alert_inappropriate_fallback"
+
+	// FailureSSLAlertInsufficientSecurity means the server sent an
+	// insufficient_security TLS alert.
+	FailureSSLAlertInsufficientSecurity = "ssl_alert_insufficient_security"
+
+	// FailureSSLAlertInternalError means the server sent an
+	// internal_error TLS alert.
+	FailureSSLAlertInternalError = "ssl_alert_internal_error"
+
+	// FailureSSLAlertMissingExtension means the server sent a
+	// missing_extension TLS alert.
+	FailureSSLAlertMissingExtension = "ssl_alert_missing_extension"
+
+	// FailureSSLAlertNoApplicationProtocol means the server sent a
+	// no_application_protocol TLS alert.
+	FailureSSLAlertNoApplicationProtocol = "ssl_alert_no_application_protocol"
+
+	// FailureSSLAlertNoRenegotiation means the server sent a
+	// no_renegotiation TLS alert.
+	FailureSSLAlertNoRenegotiation = "ssl_alert_no_renegotiation"
+
+	// FailureSSLAlertProtocolVersion means the server sent a
+	// protocol_version TLS alert.
+	FailureSSLAlertProtocolVersion = "ssl_alert_protocol_version"
+
+	// FailureSSLAlertRecordOverflow means the server sent a
+	// record_overflow TLS alert.
+	FailureSSLAlertRecordOverflow = "ssl_alert_record_overflow"
+
+	// FailureSSLAlertUnexpectedMessage means the server sent an
+	// unexpected_message TLS alert.
+	FailureSSLAlertUnexpectedMessage = "ssl_alert_unexpected_message"
+
+	// FailureSSLAlertUnknown means the server sent a TLS
+	// alert that we do not know.
+	FailureSSLAlertUnknown = "ssl_alert_unknown"
+
+	// FailureSSLAlertUnknownCA means the server sent an
+	// unknown_ca TLS alert.
+	FailureSSLAlertUnknownCA = "ssl_alert_unknown_ca"
+
+	// FailureSSLAlertUnknownPSKIdentity means the server sent an
+	// unknown_psk_identity TLS alert.
+	FailureSSLAlertUnknownPSKIdentity = "ssl_alert_unknown_psk_identity"
+
+	// FailureSSLAlertUnrecognizedName means the server sent an
+	// unrecognized_name TLS alert, i.e., it did not like the SNI.
+	FailureSSLAlertUnrecognizedName = "ssl_alert_unrecognized_name"
+
+	// FailureSSLAlertUnsupportedCertificate means the server sent an
+	// unsupported_certificate TLS alert.
+	FailureSSLAlertUnsupportedCertificate = "ssl_alert_unsupported_certificate"
+
+	// FailureSSLAlertUnsupportedExtension means the server sent an
+	// unsupported_extension TLS alert.
+	FailureSSLAlertUnsupportedExtension = "ssl_alert_unsupported_extension"
+
+	// FailureSSLAlertUserCanceled means the server sent an
+	// user_canceled TLS alert.
+	FailureSSLAlertUserCanceled = "ssl_alert_user_canceled"
+)
+
+// tlsAlertFailures maps the description of the TLS alerts used by the
+// crypto/tls package (and by uTLS) to failure strings. The failure string
+// is "ssl_alert_" followed by the name of the alert in RFC 8446.
+var tlsAlertFailures = map[string]string{
+	"access denied":                   FailureSSLAlertAccessDenied,
+	"bad certificate":                 FailureSSLAlertBadCertificate,
+	"bad certificate hash value":      FailureSSLAlertBadCertificateHashValue,
+	"bad certificate status response": FailureSSLAlertBadCertificateStatusResponse,
+	"bad record MAC":                  FailureSSLAlertBadRecordMAC,
+	"certificate required":            FailureSSLAlertCertificateRequired,
+	"certificate unobtainable":        FailureSSLAlertCertificateUnobtainable,
+	"close notify":                    FailureSSLAlertCloseNotify,
+	"decompression failure":           FailureSSLAlertDecompressionFailure,
+	"decryption failed":               FailureSSLAlertDecryptionFailed,
+	"encrypted client hello required": FailureSSLAlertECHRequired,
+	"error decoding message":          FailureSSLAlertDecodeError,
+	"error decrypting message":        FailureSSLAlertDecryptError,
+	"expired certificate":             FailureSSLAlertCertificateExpired,
+	"export restriction":              FailureSSLAlertExportRestriction,
+	"handshake failure":               FailureSSLAlertHandshakeFailure,
+	"illegal parameter":               FailureSSLAlertIllegalParameter,
+	"inappropriate fallback":          FailureSSLAlertInappropriateFallback,
+	"insufficient security level":     FailureSSLAlertInsufficientSecurity,
+	"internal error":                  FailureSSLAlertInternalError,
+	"missing extension":               FailureSSLAlertMissingExtension,
+	"no application protocol":         FailureSSLAlertNoApplicationProtocol,
+	"no renegotiation":                FailureSSLAlertNoRenegotiation,
+	"protocol version not supported":  FailureSSLAlertProtocolVersion,
+	"record overflow":                 FailureSSLAlertRecordOverflow,
+	"revoked certificate":             FailureSSLAlertCertificateRevoked,
+	"unexpected message":              FailureSSLAlertUnexpectedMessage,
+	"unknown PSK identity":            FailureSSLAlertUnknownPSKIdentity,
+	"unknown certificate":             FailureSSLAlertCertificateUnknown,
+	"unknown certificate authority":   FailureSSLAlertUnknownCA,
+	"unrecognized name":               FailureSSLAlertUnrecognizedName,
+	"unsupported certificate":         FailureSSLAlertUnsupportedCertificate,
+	"unsupported extension":           FailureSSLAlertUnsupportedExtension,
+	"user canceled":                   FailureSSLAlertUserCanceled,
+}
+
+// classifyTLSAlert classifies the TLS alerts sent by the server. Because
+// the crypto/tls package does not export the type of alerts, we need to
+// look into the error string, e.g., "remote error: tls: handshake failure".
+func classifyTLSAlert(err error) string {
+	const prefix = "remote error: tls: "
+	s := err.Error()
+	idx := strings.LastIndex(s, prefix)
+	if idx < 0 {
+		return ""
+	}
+	description := s[idx+len(prefix):]
+	if failure, found := tlsAlertFailures[description]; found {
+		return failure
+	}
+	return FailureSSLAlertUnknown
+}
+
+const (
+	// FailureHTTP2GoAwayCancel means the server sent
+	// a GOAWAY frame with the CANCEL error code.
+	FailureHTTP2GoAwayCancel = "http2_goaway_cancel"
+
+	// FailureHTTP2GoAwayCompressionError means the server sent
+	// a GOAWAY frame with the COMPRESSION_ERROR error code.
+	FailureHTTP2GoAwayCompressionError = "http2_goaway_compression_error"
+
+	// FailureHTTP2GoAwayConnectError means the server sent
+	// a GOAWAY frame with the CONNECT_ERROR error code.
+	FailureHTTP2GoAwayConnectError = "http2_goaway_connect_error"
+
+	// FailureHTTP2GoAwayEnhanceYourCalm means the server sent
+	// a GOAWAY frame with the ENHANCE_YOUR_CALM error code.
+	FailureHTTP2GoAwayEnhanceYourCalm = "http2_goaway_enhance_your_calm"
+
+	// FailureHTTP2GoAwayFlowControlError means the server sent
+	// a GOAWAY frame with the FLOW_CONTROL_ERROR error code.
+	FailureHTTP2GoAwayFlowControlError = "http2_goaway_flow_control_error"
+
+	// FailureHTTP2GoAwayFrameSizeError means the server sent
+	// a GOAWAY frame with the FRAME_SIZE_ERROR error code.
+	FailureHTTP2GoAwayFrameSizeError = "http2_goaway_frame_size_error"
+
+	// FailureHTTP2GoAwayHTTP11Required means the server sent
+	// a GOAWAY frame with the HTTP_1_1_REQUIRED error code.
+	FailureHTTP2GoAwayHTTP11Required = "http2_goaway_http_1_1_required"
+
+	// FailureHTTP2GoAwayInadequateSecurity means the server sent
+	// a GOAWAY frame with the INADEQUATE_SECURITY error code.
+	FailureHTTP2GoAwayInadequateSecurity = "http2_goaway_inadequate_security"
+
+	// FailureHTTP2GoAwayInternalError means the server sent
+	// a GOAWAY frame with the INTERNAL_ERROR error code.
+	FailureHTTP2GoAwayInternalError = "http2_goaway_internal_error"
+
+	// FailureHTTP2GoAwayNoError means the server sent
+	// a GOAWAY frame with the NO_ERROR error code.
+	FailureHTTP2GoAwayNoError = "http2_goaway_no_error"
+
+	// FailureHTTP2GoAwayProtocolError means the server sent
+	// a GOAWAY frame with the PROTOCOL_ERROR error code.
+	FailureHTTP2GoAwayProtocolError = "http2_goaway_protocol_error"
+
+	// FailureHTTP2GoAwayRefusedStream means the server sent
+	// a GOAWAY frame with the REFUSED_STREAM error code.
+	FailureHTTP2GoAwayRefusedStream = "http2_goaway_refused_stream"
+
+	// FailureHTTP2GoAwaySettingsTimeout means the server sent
+	// a GOAWAY frame with the SETTINGS_TIMEOUT error code.
+	FailureHTTP2GoAwaySettingsTimeout = "http2_goaway_settings_timeout"
+
+	// FailureHTTP2GoAwayStreamClosed means the server sent
+	// a GOAWAY frame with the STREAM_CLOSED error code.
+	FailureHTTP2GoAwayStreamClosed = "http2_goaway_stream_closed"
+
+	// FailureHTTP2GoAwayUnknown means the server sent
+	// a GOAWAY frame with an error code that we do not know.
+	FailureHTTP2GoAwayUnknown = "http2_goaway_unknown"
+
+	// FailureHTTP2RSTStreamCancel means the server sent
+	// a RST_STREAM frame with the CANCEL error code.
+	FailureHTTP2RSTStreamCancel = "http2_rst_stream_cancel"
+
+	// FailureHTTP2RSTStreamCompressionError means the server sent
+	// a RST_STREAM frame with the COMPRESSION_ERROR error code.
+	FailureHTTP2RSTStreamCompressionError = "http2_rst_stream_compression_error"
+
+	// FailureHTTP2RSTStreamConnectError means the server sent
+	// a RST_STREAM frame with the CONNECT_ERROR error code.
+	FailureHTTP2RSTStreamConnectError = "http2_rst_stream_connect_error"
+
+	// FailureHTTP2RSTStreamEnhanceYourCalm means the server sent
+	// a RST_STREAM frame with the ENHANCE_YOUR_CALM error code.
+	FailureHTTP2RSTStreamEnhanceYourCalm = "http2_rst_stream_enhance_your_calm"
+
+	// FailureHTTP2RSTStreamFlowControlError means the server sent
+	// a RST_STREAM frame with the FLOW_CONTROL_ERROR error code.
+	FailureHTTP2RSTStreamFlowControlError = "http2_rst_stream_flow_control_error"
+
+	// FailureHTTP2RSTStreamFrameSizeError means the server sent
+	// a RST_STREAM frame with the FRAME_SIZE_ERROR error code.
+	FailureHTTP2RSTStreamFrameSizeError = "http2_rst_stream_frame_size_error"
+
+	// FailureHTTP2RSTStreamHTTP11Required means the server sent
+	// a RST_STREAM frame with the HTTP_1_1_REQUIRED error code.
+	FailureHTTP2RSTStreamHTTP11Required = "http2_rst_stream_http_1_1_required"
+
+	// FailureHTTP2RSTStreamInadequateSecurity means the server sent
+	// a RST_STREAM frame with the INADEQUATE_SECURITY error code.
+	FailureHTTP2RSTStreamInadequateSecurity = "http2_rst_stream_inadequate_security"
+
+	// FailureHTTP2RSTStreamInternalError means the server sent
+	// a RST_STREAM frame with the INTERNAL_ERROR error code.
+	FailureHTTP2RSTStreamInternalError = "http2_rst_stream_internal_error"
+
+	// FailureHTTP2RSTStreamNoError means the server sent
+	// a RST_STREAM frame with the NO_ERROR error code.
+	FailureHTTP2RSTStreamNoError = "http2_rst_stream_no_error"
+
+	// FailureHTTP2RSTStreamProtocolError means the server sent
+	// a RST_STREAM frame with the PROTOCOL_ERROR error code.
+	FailureHTTP2RSTStreamProtocolError = "http2_rst_stream_protocol_error"
+
+	// FailureHTTP2RSTStreamRefusedStream means the server sent
+	// a RST_STREAM frame with the REFUSED_STREAM error code.
+	FailureHTTP2RSTStreamRefusedStream = "http2_rst_stream_refused_stream"
+
+	// FailureHTTP2RSTStreamSettingsTimeout means the server sent
+	// a RST_STREAM frame with the SETTINGS_TIMEOUT error code.
+	FailureHTTP2RSTStreamSettingsTimeout = "http2_rst_stream_settings_timeout"
+
+	// FailureHTTP2RSTStreamStreamClosed means the server sent
+	// a RST_STREAM frame with the STREAM_CLOSED error code.
+	FailureHTTP2RSTStreamStreamClosed = "http2_rst_stream_stream_closed"
+
+	// FailureHTTP2RSTStreamUnknown means the server sent
+	// a RST_STREAM frame with an error code that we do not know.
+	FailureHTTP2RSTStreamUnknown = "http2_rst_stream_unknown"
+)
+
+// http2GoAwayFailures maps the names of the HTTP/2 error codes
+// to the failures for GOAWAY frames.
+var http2GoAwayFailures = map[string]string{
+	"CANCEL":              FailureHTTP2GoAwayCancel,
+	"COMPRESSION_ERROR":   FailureHTTP2GoAwayCompressionError,
+	"CONNECT_ERROR":       FailureHTTP2GoAwayConnectError,
+	"ENHANCE_YOUR_CALM":   FailureHTTP2GoAwayEnhanceYourCalm,
+	"FLOW_CONTROL_ERROR":  FailureHTTP2GoAwayFlowControlError,
+	"FRAME_SIZE_ERROR":    FailureHTTP2GoAwayFrameSizeError,
+	"HTTP_1_1_REQUIRED":   FailureHTTP2GoAwayHTTP11Required,
+	"INADEQUATE_SECURITY": FailureHTTP2GoAwayInadequateSecurity,
+	"INTERNAL_ERROR":      FailureHTTP2GoAwayInternalError,
+	"NO_ERROR":            FailureHTTP2GoAwayNoError,
+	"PROTOCOL_ERROR":      FailureHTTP2GoAwayProtocolError,
+	"REFUSED_STREAM":      FailureHTTP2GoAwayRefusedStream,
+	"SETTINGS_TIMEOUT":    FailureHTTP2GoAwaySettingsTimeout,
+	"STREAM_CLOSED":       FailureHTTP2GoAwayStreamClosed,
+}
+
+// http2RSTStreamFailures maps the names of the HTTP/2 error codes
+// to the failures for RST_STREAM frames.
+var http2RSTStreamFailures = map[string]string{
+	"CANCEL":              FailureHTTP2RSTStreamCancel,
+	"COMPRESSION_ERROR":   FailureHTTP2RSTStreamCompressionError,
+	"CONNECT_ERROR":       FailureHTTP2RSTStreamConnectError,
+	"ENHANCE_YOUR_CALM":   FailureHTTP2RSTStreamEnhanceYourCalm,
+	"FLOW_CONTROL_ERROR":  FailureHTTP2RSTStreamFlowControlError,
+	"FRAME_SIZE_ERROR":    FailureHTTP2RSTStreamFrameSizeError,
+	"HTTP_1_1_REQUIRED":   FailureHTTP2RSTStreamHTTP11Required,
+	"INADEQUATE_SECURITY": FailureHTTP2RSTStreamInadequateSecurity,
+	"INTERNAL_ERROR":      FailureHTTP2RSTStreamInternalError,
+	"NO_ERROR":            FailureHTTP2RSTStreamNoError,
+	"PROTOCOL_ERROR":      FailureHTTP2RSTStreamProtocolError,
+	"REFUSED_STREAM":      FailureHTTP2RSTStreamRefusedStream,
+	"SETTINGS_TIMEOUT":    FailureHTTP2RSTStreamSettingsTimeout,
+	"STREAM_CLOSED":       FailureHTTP2RSTStreamStreamClosed,
+}
+
+var (
+	http2GoAwayRegexp = regexp.MustCompile(
+		`server sent GOAWAY and closed the connection; LastStreamID=[0-9]+, ErrCode=([^,]+), `)
+	http2RSTStreamRegexp = regexp.MustCompile(
+		`stream error: stream ID [0-9]+; ([^;]+); received from peer$`)
+)
+
+// classifyHTTP2Error classifies the GOAWAY and RST_STREAM frames sent by
+// the server, which we map to "http2_goaway_" and "http2_rst_stream_"
+// respectively, followed by the lowercase name of the error code, or by
+// "unknown" if we do not know the error code. Because net/http bundles
+// its own copy of golang.org/x/net/http2, we need to look into the error
+// string, which is the same for both implementations.
+func classifyHTTP2Error(err error) string {
+	s := err.Error()
+	if v := http2GoAwayRegexp.FindStringSubmatch(s); len(v) == 2 {
+		if failure, found := http2GoAwayFailures[v[1]]; found {
+			return failure
+		}
+		return FailureHTTP2GoAwayUnknown
+	}
+	if v := http2RSTStreamRegexp.FindStringSubmatch(s); len(v) == 2 {
+		if failure, found := http2RSTStreamFailures[v[1]]; found {
+			return failure
+		}
+		return FailureHTTP2RSTStreamUnknown
+	}
+	return ""
+}
+
+const (
+	// FailureSOCKSAddressTypeNotSupported means the SOCKS5 proxy
+	// replied with "address type not supported".
+	FailureSOCKSAddressTypeNotSupported = "socks_address_type_not_supported"
+
+	// FailureSOCKSCommandNotSupported means the SOCKS5 proxy
+	// replied with "command not supported".
+	FailureSOCKSCommandNotSupported = "socks_command_not_supported"
+
+	// FailureSOCKSConnectionNotAllowed means the SOCKS5 proxy
+	// replied with "connection not allowed by ruleset".
+	FailureSOCKSConnectionNotAllowed = "socks_connection_not_allowed"
+
+	// FailureSOCKSConnectionRefused means the SOCKS5 proxy
+	// replied with "connection refused".
+	FailureSOCKSConnectionRefused = "socks_connection_refused"
+
+	// FailureSOCKSGeneralFailure means the SOCKS5 proxy replied
+	// with "general SOCKS server failure".
+	FailureSOCKSGeneralFailure = "socks_general_failure"
+
+	// FailureSOCKSHostUnreachable means the SOCKS5 proxy
+	// replied with "host unreachable".
+	FailureSOCKSHostUnreachable = "socks_host_unreachable"
+
+	// FailureSOCKSNetworkUnreachable means the SOCKS5 proxy
+	// replied with "network unreachable".
+	FailureSOCKSNetworkUnreachable = "socks_network_unreachable"
+
+	// FailureSOCKSTTLExpired means the SOCKS5 proxy
+	// replied with "TTL expired".
+	FailureSOCKSTTLExpired = "socks_ttl_expired"
+)
+
+// socksReplyFailures maps the SOCKS5 reply codes, as described
+// by golang.org/x/net/proxy, to failure strings.
+var socksReplyFailures = map[string]string{
+	"address type not supported":        FailureSOCKSAddressTypeNotSupported,
+	"command not supported":             FailureSOCKSCommandNotSupported,
+	"connection not allowed by ruleset": FailureSOCKSConnectionNotAllowed,
+	"connection refused":                FailureSOCKSConnectionRefused,
+	"general SOCKS server failure":      FailureSOCKSGeneralFailure,
+	"host unreachable":                  FailureSOCKSHostUnreachable,
+	"network unreachable":               FailureSOCKSNetworkUnreachable,
+	"TTL expired":                       FailureSOCKSTTLExpired,
+}
+
+// classifySOCKSReply classifies the reply codes of SOCKS5 proxies. Because
+// golang.org/x/net/proxy does not export its errors, we need to look into the
+// error string, e.g., "socks connect tcp 1.1.1.1:1080->x.org:443: unknown
+// error host unreachable". We do not classify unknown reply codes, which
+// are therefore proxy_connect_failed failures.
+func classifySOCKSReply(err error) string {
+	const prefix = "unknown error "
+	s := err.Error()
+	if !strings.Contains(s, "socks connect ") {
+		return ""
+	}
+	idx := strings.LastIndex(s, prefix)
+	if idx < 0 {
+		return ""
+	}
+	return socksReplyFailures[s[idx+len(prefix):]]
+}
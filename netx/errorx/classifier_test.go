@@ -0,0 +1,177 @@
+package errorx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+func TestClassifyTLSAlert(t *testing.T) {
+	for description, failure := range map[string]string{
+		"access denied":                  FailureSSLAlertAccessDenied,
+		"handshake failure":              FailureSSLAlertHandshakeFailure,
+		"internal error":                 FailureSSLAlertInternalError,
+		"protocol version not supported": FailureSSLAlertProtocolVersion,
+		"unrecognized name":              FailureSSLAlertUnrecognizedName,
+		"unknown certificate authority":  FailureSSLAlertUnknownCA,
+		"alert(200)":                     FailureSSLAlertUnknown,
+	} {
+		err := &net.OpError{
+			Op:  "remote error",
+			Err: errors.New("tls: " + description),
+		}
+		if s := toFailureString(err); s != failure {
+			t.Fatal("unexpected failure for", description, s)
+		}
+	}
+	if s := classifyTLSAlert(errors.New("tls: handshake failure")); s != "" {
+		t.Fatal("we classified an alert we did not receive", s)
+	}
+}
+
+func TestClassifyTLSAlertWithHandshake(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		tlsConn := tls.Server(server, &tls.Config{MaxVersion: tls.VersionTLS12})
+		tlsConn.Handshake()
+	}()
+	tlsConn := tls.Client(client, &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+	})
+	err := tlsConn.Handshake()
+	if s := toFailureString(err); s != FailureSSLAlertProtocolVersion {
+		t.Fatal("unexpected failure", s, err)
+	}
+}
+
+func TestClassifyHTTP2Error(t *testing.T) {
+	var cases = []struct {
+		err     error
+		failure string
+	}{{
+		err: http2.GoAwayError{
+			ErrCode: http2.ErrCodeEnhanceYourCalm, LastStreamID: 3, DebugData: "calm, down",
+		},
+		failure: FailureHTTP2GoAwayEnhanceYourCalm,
+	}, {
+		err:     http2.GoAwayError{ErrCode: http2.ErrCode(0xff)},
+		failure: FailureHTTP2GoAwayUnknown,
+	}, {
+		err: http2.StreamError{
+			Code: http2.ErrCodeRefusedStream, Cause: errors.New("received from peer"), StreamID: 1,
+		},
+		failure: FailureHTTP2RSTStreamRefusedStream,
+	}, {
+		err: fmt.Errorf("Get \"https://x.org/\": %w", http2.StreamError{
+			Code: http2.ErrCodeProtocol, Cause: errors.New("received from peer"), StreamID: 5,
+		}),
+		failure: FailureHTTP2RSTStreamProtocolError,
+	}, {
+		err:     http2.StreamError{Code: http2.ErrCodeProtocol, StreamID: 1},
+		failure: "",
+	}}
+	for _, c := range cases {
+		if s := classifyHTTP2Error(c.err); s != c.failure {
+			t.Fatal("unexpected failure for", c.err, s)
+		}
+	}
+}
+
+func TestClassifyHTTP2ErrorAllCodes(t *testing.T) {
+	for code := http2.ErrCodeNo; code <= http2.ErrCodeHTTP11Required; code++ {
+		suffix := strings.ToLower(code.String())
+		if s := classifyHTTP2Error(http2.GoAwayError{ErrCode: code}); s != "http2_goaway_"+suffix {
+			t.Fatal("unexpected failure for", code, s)
+		}
+		err := http2.StreamError{Code: code, Cause: errors.New("received from peer")}
+		if s := classifyHTTP2Error(err); s != "http2_rst_stream_"+suffix {
+			t.Fatal("unexpected failure for", code, s)
+		}
+	}
+}
+
+func TestClassifySOCKSReply(t *testing.T) {
+	newErr := func(reply string) error {
+		return &net.OpError{
+			Op:  "socks connect",
+			Net: "tcp",
+			Err: errors.New("unknown error " + reply),
+		}
+	}
+	for reply, failure := range map[string]string{
+		"general SOCKS server failure":      FailureSOCKSGeneralFailure,
+		"connection not allowed by ruleset": FailureSOCKSConnectionNotAllowed,
+		"network unreachable":               FailureSOCKSNetworkUnreachable,
+		"host unreachable":                  FailureSOCKSHostUnreachable,
+		"connection refused":                FailureSOCKSConnectionRefused,
+		"TTL expired":                       FailureSOCKSTTLExpired,
+		"command not supported":             FailureSOCKSCommandNotSupported,
+		"address type not supported":        FailureSOCKSAddressTypeNotSupported,
+	} {
+		wrapped := fmt.Errorf("%w: %s", ErrProxyConnectFailed, newErr(reply))
+		if s := toFailureString(wrapped); s != failure {
+			t.Fatal("unexpected failure for", reply, s)
+		}
+	}
+	wrapped := fmt.Errorf("%w: %s", ErrProxyConnectFailed, newErr("unknown code: 10"))
+	if s := toFailureString(wrapped); s != FailureProxyConnectFailed {
+		t.Fatal("unexpected failure for unknown reply code", s)
+	}
+}
+
+var errMockedForClassifier = errors.New("mocked error for classifier")
+
+func TestRegisterClassifier(t *testing.T) {
+	unregister := RegisterClassifier(func(err error) string {
+		if errors.Is(err, errMockedForClassifier) {
+			return "mocked_failure"
+		}
+		return ""
+	})
+	defer unregister()
+	if s := toFailureString(fmt.Errorf("x: %w", errMockedForClassifier)); s != "mocked_failure" {
+		t.Fatal("unexpected failure", s)
+	}
+	// The registered classifiers do not override the DNS errors
+	// and context.Canceled, and they let other errors through
+	if s := toFailureString(context.Canceled); s != FailureInterrupted {
+		t.Fatal("unexpected failure", s)
+	}
+	if s := toFailureString(errors.New("remote error: tls: access denied")); s != FailureSSLAlertAccessDenied {
+		t.Fatal("unexpected failure", s)
+	}
+}
+
+func TestUnregisterClassifier(t *testing.T) {
+	newClassifier := func(failure string) Classifier {
+		return func(err error) string {
+			if errors.Is(err, errMockedForClassifier) {
+				return failure
+			}
+			return ""
+		}
+	}
+	unregisterFirst := RegisterClassifier(newClassifier("first_failure"))
+	defer unregisterFirst()
+	unregisterSecond := RegisterClassifier(newClassifier("second_failure"))
+	if s := toFailureString(errMockedForClassifier); s != "second_failure" {
+		t.Fatal("unexpected failure", s)
+	}
+	unregisterSecond()
+	if s := toFailureString(errMockedForClassifier); s != "first_failure" {
+		t.Fatal("unexpected failure", s)
+	}
+	unregisterFirst()
+	if s := toFailureString(errMockedForClassifier); s == "first_failure" {
+		t.Fatal("unexpected failure", s)
+	}
+}
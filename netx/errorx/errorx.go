@@ -22,6 +22,22 @@ const (
 	// FailureDNSNXDOMAINError means we got NXDOMAIN in DNS reply.
 	FailureDNSNXDOMAINError = "dns_nxdomain_error"
 
+	// FailureDNSServfailError means we got SERVFAIL in DNS reply.
+	FailureDNSServfailError = "dns_servfail_error"
+
+	// FailureDNSRefusedError means we got REFUSED in DNS reply.
+	FailureDNSRefusedError = "dns_refused_error"
+
+	// FailureDNSNotImplementedError means we got NOTIMP in DNS reply.
+	FailureDNSNotImplementedError = "dns_not_implemented_error"
+
+	// FailureDNSFormatError means we got FORMERR in DNS reply.
+	FailureDNSFormatError = "dns_format_error"
+
+	// FailureDNSNoAnswerError means the DNS reply was successful but it did
+	// not contain any answer for the requested query type.
+	FailureDNSNoAnswerError = "dns_no_answer_error"
+
 	// FailureEOFError means we got unexpected EOF on connection.
 	FailureEOFError = "eof_error"
 
@@ -43,6 +59,18 @@ const (
 
 	// FailureJSONParseError indicates that we couldn't parse a JSON
 	FailureJSONParseError = "json_parse_error"
+
+	// FailureProxyAuthenticationFailed means that the proxy
+	// rejected our credentials or required credentials.
+	FailureProxyAuthenticationFailed = "proxy_authentication_failed"
+
+	// FailureProxyConnectFailed means that the proxy could
+	// not connect to the destination on our behalf.
+	FailureProxyConnectFailed = "proxy_connect_failed"
+
+	// FailureProxyHandshakeFailed means that the handshake with
+	// the proxy failed for any other reason.
+	FailureProxyHandshakeFailed = "proxy_handshake_failed"
 )
 
 const (
@@ -55,6 +83,9 @@ const (
 	// TLSHandshakeOperation is the TLS handshake
 	TLSHandshakeOperation = "tls_handshake"
 
+	// QUICHandshakeOperation is the QUIC handshake
+	QUICHandshakeOperation = "quic_handshake"
+
 	// HTTPRoundTripOperation is the HTTP round trip
 	HTTPRoundTripOperation = "http_round_trip"
 
@@ -80,6 +111,42 @@ const (
 // to tell this library to return an error when a bogon is found.
 var ErrDNSBogon = errors.New("dns: detected bogon address")
 
+var (
+	// ErrDNSNXDOMAIN indicates that the DNS reply rcode is NXDOMAIN. We
+	// keep the historical message, which maps to FailureDNSNXDOMAINError.
+	ErrDNSNXDOMAIN = errors.New("ooniresolver: no such host")
+
+	// ErrDNSServfail indicates that the DNS reply rcode is SERVFAIL.
+	ErrDNSServfail = errors.New("dns: server failure")
+
+	// ErrDNSRefused indicates that the DNS reply rcode is REFUSED.
+	ErrDNSRefused = errors.New("dns: query refused")
+
+	// ErrDNSNotImplemented indicates that the DNS reply rcode is NOTIMP.
+	ErrDNSNotImplemented = errors.New("dns: not implemented")
+
+	// ErrDNSFormatError indicates that the DNS reply rcode is FORMERR.
+	ErrDNSFormatError = errors.New("dns: format error")
+
+	// ErrDNSNoAnswer indicates that the DNS reply rcode is NOERROR
+	// but there are no answers for the requested query type.
+	ErrDNSNoAnswer = errors.New("dns: no response returned")
+)
+
+var (
+	// ErrProxyAuthenticationFailed indicates that the proxy
+	// rejected our credentials or required credentials.
+	ErrProxyAuthenticationFailed = errors.New("proxy: authentication failed")
+
+	// ErrProxyConnectFailed indicates that the proxy could not
+	// connect to the destination on our behalf.
+	ErrProxyConnectFailed = errors.New("proxy: connect failed")
+
+	// ErrProxyHandshakeFailed indicates that the handshake with
+	// the proxy failed for any other reason.
+	ErrProxyHandshakeFailed = errors.New("proxy: handshake failed")
+)
+
 // ErrWrapper is our error wrapper for Go errors. The key objective of
 // this structure is to properly set Failure, which is also returned by
 // the Error() method, so be one of the OONI defined strings.
@@ -104,6 +171,7 @@ type ErrWrapper struct {
 	// - ResolveOperation: resolving a domain name failed
 	// - ConnectOperation: connecting to an IP failed
 	// - TLSHandshakeOperation: TLS handshaking failed
+	// - QUICHandshakeOperation: QUIC handshaking failed
 	// - HTTPRoundTripOperation: other errors during round trip
 	//
 	// Because a network connection doesn't necessarily know
@@ -186,9 +254,36 @@ func toFailureString(err error) string {
 	if errors.Is(err, ErrDNSBogon) {
 		return FailureDNSBogonError // not in MK
 	}
+	if errors.Is(err, ErrDNSServfail) {
+		return FailureDNSServfailError // not in MK
+	}
+	if errors.Is(err, ErrDNSRefused) {
+		return FailureDNSRefusedError // not in MK
+	}
+	if errors.Is(err, ErrDNSNotImplemented) {
+		return FailureDNSNotImplementedError // not in MK
+	}
+	if errors.Is(err, ErrDNSFormatError) {
+		return FailureDNSFormatError // not in MK
+	}
+	if errors.Is(err, ErrDNSNoAnswer) {
+		return FailureDNSNoAnswerError // not in MK
+	}
 	if errors.Is(err, context.Canceled) {
 		return FailureInterrupted
 	}
+	if errors.Is(err, ErrProxyAuthenticationFailed) {
+		return FailureProxyAuthenticationFailed // not in MK
+	}
+	if errors.Is(err, ErrProxyConnectFailed) {
+		if s := classifySOCKSReply(err); s != "" {
+			return s // not in MK
+		}
+		return FailureProxyConnectFailed // not in MK
+	}
+	if errors.Is(err, ErrProxyHandshakeFailed) {
+		return FailureProxyHandshakeFailed // not in MK
+	}
 
 	var x509HostnameError x509.HostnameError
 	if errors.As(err, &x509HostnameError) {
@@ -206,6 +301,9 @@ func toFailureString(err error) string {
 		// Test case: https://expired.badssl.com/
 		return FailureSSLInvalidCertificate
 	}
+	if s := classify(err); s != "" {
+		return s // not in MK
+	}
 
 	s := err.Error()
 	if strings.HasSuffix(s, "operation was canceled") {
@@ -232,6 +330,12 @@ func toFailureString(err error) string {
 	if strings.HasSuffix(s, "TLS handshake timeout") {
 		return FailureGenericTimeoutError
 	}
+	if strings.HasSuffix(s, "Handshake did not complete in time") {
+		return FailureGenericTimeoutError // QUIC, not in MK
+	}
+	if strings.HasSuffix(s, "no recent network activity") {
+		return FailureGenericTimeoutError // QUIC, not in MK
+	}
 	if strings.HasSuffix(s, "no such host") {
 		// This is dns_lookup_error in MK but such error is used as a
 		// generic "hey, the lookup failed" error. Instead, this error
@@ -260,6 +364,9 @@ func toOperationString(err error, operation string) string {
 		if errwrapper.Operation == TLSHandshakeOperation {
 			return errwrapper.Operation
 		}
+		if errwrapper.Operation == QUICHandshakeOperation {
+			return errwrapper.Operation
+		}
 		// FALLTHROUGH
 	}
 	return operation
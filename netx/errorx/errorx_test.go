@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/x509"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"syscall"
@@ -53,6 +54,31 @@ func TestToFailureString(t *testing.T) {
 			t.Fatal("unexpected result")
 		}
 	})
+	t.Run("for DNS rcode errors", func(t *testing.T) {
+		for err, failure := range map[error]string{
+			ErrDNSServfail:       FailureDNSServfailError,
+			ErrDNSRefused:        FailureDNSRefusedError,
+			ErrDNSNotImplemented: FailureDNSNotImplementedError,
+			ErrDNSFormatError:    FailureDNSFormatError,
+			ErrDNSNoAnswer:       FailureDNSNoAnswerError,
+		} {
+			if toFailureString(err) != failure {
+				t.Fatal("unexpected result for", err)
+			}
+		}
+	})
+	t.Run("for proxy errors", func(t *testing.T) {
+		for err, failure := range map[error]string{
+			ErrProxyAuthenticationFailed: FailureProxyAuthenticationFailed,
+			ErrProxyConnectFailed:        FailureProxyConnectFailed,
+			ErrProxyHandshakeFailed:      FailureProxyHandshakeFailed,
+		} {
+			wrapped := fmt.Errorf("%w: %s", err, io.EOF)
+			if toFailureString(wrapped) != failure {
+				t.Fatal("unexpected result for", err)
+			}
+		}
+	})
 	t.Run("for context.Canceled", func(t *testing.T) {
 		if toFailureString(context.Canceled) != FailureInterrupted {
 			t.Fatal("unexpected result")
@@ -129,6 +155,18 @@ func TestToFailureString(t *testing.T) {
 			t.Fatal("unexpected results")
 		}
 	})
+	t.Run("for QUIC handshake timeout error", func(t *testing.T) {
+		err := errors.New("Handshake did not complete in time")
+		if toFailureString(err) != FailureGenericTimeoutError {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for QUIC idle timeout error", func(t *testing.T) {
+		err := errors.New("timeout: no recent network activity")
+		if toFailureString(err) != FailureGenericTimeoutError {
+			t.Fatal("unexpected results")
+		}
+	})
 	t.Run("for no such host", func(t *testing.T) {
 		if toFailureString(&net.DNSError{
 			Err: "no such host",
@@ -187,6 +225,14 @@ func TestUnitToOperationString(t *testing.T) {
 			t.Fatal("unexpected result")
 		}
 	})
+	t.Run("for quic_handshake", func(t *testing.T) {
+		// You're doing DNS over QUIC and the QUIC handshake fails. You
+		// want to know about a QUIC handshake error.
+		err := &ErrWrapper{Operation: QUICHandshakeOperation}
+		if toOperationString(err, ResolveOperation) != QUICHandshakeOperation {
+			t.Fatal("unexpected result")
+		}
+	})
 	t.Run("for minor operation", func(t *testing.T) {
 		// You just noticed that TLS handshake failed and you
 		// have a child error telling you that read failed. Here
@@ -2,20 +2,29 @@ package httptransport
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"hash"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptrace"
 	"time"
 
+	"github.com/ooni/probe-engine/legacy/netx/connid"
 	"github.com/ooni/probe-engine/netx/trace"
 )
 
 // SaverPerformanceHTTPTransport is a RoundTripper that saves
-// performance events occurring during the round trip
+// performance events occurring during the round trip. We use these
+// events to compute the timings of each request and to know which
+// connection each request used. Because we use custom dialers,
+// http.Transport does not emit the DNS and TLS events, which
+// are instead emitted by the dialers in netx/dialer.
 type SaverPerformanceHTTPTransport struct {
 	RoundTripper
-	Saver *trace.Saver
+	Saver trace.Sink
 }
 
 // RoundTrip implements RoundTripper.RoundTrip
@@ -23,6 +32,34 @@ func (txp SaverPerformanceHTTPTransport) RoundTrip(req *http.Request) (*http.Res
 	tracep := httptrace.ContextClientTrace(req.Context())
 	if tracep == nil {
 		tracep = &httptrace.ClientTrace{
+			DNSStart: func(httptrace.DNSStartInfo) {
+				txp.Saver.Write(trace.Event{Name: "http_dns_start", Time: time.Now()})
+			},
+			DNSDone: func(httptrace.DNSDoneInfo) {
+				txp.Saver.Write(trace.Event{Name: "http_dns_done", Time: time.Now()})
+			},
+			ConnectStart: func(network, addr string) {
+				txp.Saver.Write(trace.Event{Name: "http_connect_start", Time: time.Now()})
+			},
+			ConnectDone: func(network, addr string, err error) {
+				txp.Saver.Write(trace.Event{Name: "http_connect_done", Time: time.Now()})
+			},
+			TLSHandshakeStart: func() {
+				txp.Saver.Write(trace.Event{
+					Name: "http_tls_handshake_start", Time: time.Now()})
+			},
+			TLSHandshakeDone: func(tls.ConnectionState, error) {
+				txp.Saver.Write(trace.Event{
+					Name: "http_tls_handshake_done", Time: time.Now()})
+			},
+			GotConn: func(info httptrace.GotConnInfo) {
+				txp.Saver.Write(trace.Event{
+					ConnID: connid.Compute(
+						info.Conn.LocalAddr().Network(), info.Conn.LocalAddr().String()),
+					Name: "http_got_conn",
+					Time: time.Now(),
+				})
+			},
 			WroteHeaders: func() {
 				txp.Saver.Write(trace.Event{Name: "http_wrote_headers", Time: time.Now()})
 			},
@@ -43,7 +80,7 @@ func (txp SaverPerformanceHTTPTransport) RoundTrip(req *http.Request) (*http.Res
 // events related to HTTP request and response metadata
 type SaverMetadataHTTPTransport struct {
 	RoundTripper
-	Saver *trace.Saver
+	Saver trace.Sink
 }
 
 // RoundTrip implements RoundTripper.RoundTrip
@@ -72,7 +109,7 @@ func (txp SaverMetadataHTTPTransport) RoundTrip(req *http.Request) (*http.Respon
 // events related to the HTTP transaction
 type SaverTransactionHTTPTransport struct {
 	RoundTripper
-	Saver *trace.Saver
+	Saver trace.Sink
 }
 
 // RoundTrip implements RoundTripper.RoundTrip
@@ -91,10 +128,13 @@ func (txp SaverTransactionHTTPTransport) RoundTrip(req *http.Request) (*http.Res
 }
 
 // SaverBodyHTTPTransport is a RoundTripper that saves
-// body events occurring during the round trip
+// body events occurring during the round trip. Besides saving
+// a snapshot of the bodies, we also compute the length and the
+// SHA-256 of the whole response body while it is being read, and
+// we save them when the reader reaches EOF.
 type SaverBodyHTTPTransport struct {
 	RoundTripper
-	Saver        *trace.Saver
+	Saver        trace.Sink
 	SnapshotSize int
 }
 
@@ -122,12 +162,14 @@ func (txp SaverBodyHTTPTransport) RoundTrip(req *http.Request) (*http.Response,
 	if err != nil {
 		return nil, err
 	}
-	data, err := saverSnapRead(resp.Body, snapsize)
+	body := &saverBodyHasher{
+		ReadCloser: resp.Body, hash: sha256.New(), saver: txp.Saver}
+	data, err := saverSnapRead(body, snapsize)
 	if err != nil {
-		resp.Body.Close()
+		body.Close()
 		return nil, err
 	}
-	resp.Body = saverCompose(data, resp.Body)
+	resp.Body = saverCompose(data, body)
 	txp.Saver.Write(trace.Event{
 		DataIsTruncated: len(data) >= snapsize,
 		Data:            data,
@@ -150,6 +192,34 @@ type saverReadCloser struct {
 	io.Reader
 }
 
+// saverBodyHasher computes the length and the SHA-256 of a response
+// body while it is being read. When we reach EOF, we save them using an
+// http_response_body_hash event. If the body is closed before EOF, we do
+// not save anything, because we have not seen the whole body.
+type saverBodyHasher struct {
+	io.ReadCloser
+	count int
+	done  bool
+	hash  hash.Hash
+	saver trace.Sink
+}
+
+func (r *saverBodyHasher) Read(p []byte) (int, error) {
+	count, err := r.ReadCloser.Read(p)
+	r.count += count
+	r.hash.Write(p[:count])
+	if err == io.EOF && !r.done {
+		r.done = true
+		r.saver.Write(trace.Event{
+			HTTPBodySHA256: hex.EncodeToString(r.hash.Sum(nil)),
+			Name:           "http_response_body_hash",
+			NumBytes:       r.count,
+			Time:           time.Now(),
+		})
+	}
+	return count, err
+}
+
 var _ RoundTripper = SaverPerformanceHTTPTransport{}
 var _ RoundTripper = SaverMetadataHTTPTransport{}
 var _ RoundTripper = SaverBodyHTTPTransport{}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -43,19 +44,55 @@ func TestIntegrationSaverPerformanceNoMultipleEvents(t *testing.T) {
 	// explicit, while the context is implicit and hence leads to
 	// more subtle bugs. For example, this happens when you measure
 	// every event and combine HTTP with DoH.
-	if len(ev) != 3 {
-		t.Fatal("expected three events")
+	counts := make(map[string]int)
+	for _, e := range ev {
+		counts[e.Name]++
 	}
+	// We do not check the connect events because http.Transport
+	// may attempt to connect to several addresses.
 	expected := []string{
+		"http_got_conn",            // measured with context
+		"http_dns_start",           // measured with context
+		"http_dns_done",            // measured with context
+		"http_tls_handshake_start", // measured with context
+		"http_tls_handshake_done",  // measured with context
 		"http_wrote_headers",       // measured with context
 		"http_wrote_request",       // measured with context
 		"http_first_response_byte", // measured with context
 	}
-	for i := 0; i < len(expected); i++ {
-		if ev[i].Name != expected[i] {
-			t.Fatal("unexpected event name")
+	for _, name := range expected {
+		if counts[name] != 1 {
+			t.Fatal("unexpected number of events for", name)
+		}
+	}
+}
+
+func TestUnitSaverPerformanceGotConn(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	saver := &trace.Saver{}
+	txp := httptransport.SaverPerformanceHTTPTransport{
+		RoundTripper: &http.Transport{},
+		Saver:        saver,
+	}
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := txp.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	var found bool
+	for _, ev := range saver.Read() {
+		if ev.Name == "http_got_conn" {
+			found = ev.ConnID > 0
 		}
 	}
+	if !found {
+		t.Fatal("no http_got_conn event with a valid ConnID")
+	}
 }
 
 func TestIntegrationSaverMetadataSuccess(t *testing.T) {
@@ -282,7 +319,7 @@ func TestUnitSaverBodySuccess(t *testing.T) {
 		t.Fatal("unexpected body")
 	}
 	ev := saver.Read()
-	if len(ev) != 2 {
+	if len(ev) != 3 {
 		t.Fatal("unexpected number of events")
 	}
 	if string(ev[0].Data) != "dead" {
@@ -309,6 +346,87 @@ func TestUnitSaverBodySuccess(t *testing.T) {
 	if ev[1].Time.Before(ev[0].Time) {
 		t.Fatal("invalid Time")
 	}
+	// echo -n abad1dea | sha256sum
+	const sha256 = "f24a9ae1025bd54ed3c84211709343300f88f7f8be2c44f1adf92ca51e991d21"
+	if ev[2].HTTPBodySHA256 != sha256 {
+		t.Fatal("invalid HTTPBodySHA256", ev[2].HTTPBodySHA256)
+	}
+	if ev[2].Name != "http_response_body_hash" {
+		t.Fatal("invalid Name")
+	}
+	if ev[2].NumBytes != 8 {
+		t.Fatal("invalid NumBytes")
+	}
+	if ev[2].Time.Before(ev[1].Time) {
+		t.Fatal("invalid Time")
+	}
+}
+
+func TestUnitSaverBodyResponseClosedBeforeEOF(t *testing.T) {
+	saver := new(trace.Saver)
+	txp := httptransport.SaverBodyHTTPTransport{
+		RoundTripper: httptransport.FakeTransport{
+			Func: func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: 200,
+					Body:       ioutil.NopCloser(strings.NewReader("abad1dea")),
+				}, nil
+			},
+		},
+		SnapshotSize: 4,
+		Saver:        saver,
+	}
+	req, err := http.NewRequest("GET", "http://x.org/y", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := txp.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	ev := saver.Read()
+	if len(ev) != 1 {
+		t.Fatal("unexpected number of events")
+	}
+	if ev[0].Name != "http_response_body_snapshot" {
+		t.Fatal("invalid Name")
+	}
+}
+
+func TestUnitSaverBodyResponseShorterThanSnapshot(t *testing.T) {
+	saver := new(trace.Saver)
+	txp := httptransport.SaverBodyHTTPTransport{
+		RoundTripper: httptransport.FakeTransport{
+			Func: func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: 200,
+					Body:       ioutil.NopCloser(strings.NewReader("abad1dea")),
+				}, nil
+			},
+		},
+		SnapshotSize: 16,
+		Saver:        saver,
+	}
+	req, err := http.NewRequest("GET", "http://x.org/y", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := txp.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	ev := saver.Read()
+	if len(ev) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	if ev[0].Name != "http_response_body_hash" || ev[0].NumBytes != 8 {
+		t.Fatal("invalid hash event")
+	}
+	if ev[1].Name != "http_response_body_snapshot" || ev[1].DataIsTruncated {
+		t.Fatal("invalid snapshot event")
+	}
 }
 
 func TestUnitSaverBodyRequestReadError(t *testing.T) {
@@ -4,11 +4,16 @@ import (
 	"context"
 	"errors"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/archival"
 	"github.com/ooni/probe-engine/netx/bytecounter"
 	"github.com/ooni/probe-engine/netx/errorx"
 	"github.com/ooni/probe-engine/netx/trace"
@@ -50,19 +55,19 @@ func TestIntegrationSuccess(t *testing.T) {
 	if counter.Received.Load() <= 0 {
 		t.Fatal("no bytes received?!")
 	}
-	if ev := config.DialSaver.Read(); len(ev) <= 0 {
+	if ev := config.DialSaver.(*trace.Saver).Read(); len(ev) <= 0 {
 		t.Fatal("no dial events?!")
 	}
-	if ev := config.HTTPSaver.Read(); len(ev) <= 0 {
+	if ev := config.HTTPSaver.(*trace.Saver).Read(); len(ev) <= 0 {
 		t.Fatal("no HTTP events?!")
 	}
-	if ev := config.ReadWriteSaver.Read(); len(ev) <= 0 {
+	if ev := config.ReadWriteSaver.(*trace.Saver).Read(); len(ev) <= 0 {
 		t.Fatal("no R/W events?!")
 	}
-	if ev := config.ResolveSaver.Read(); len(ev) <= 0 {
+	if ev := config.ResolveSaver.(*trace.Saver).Read(); len(ev) <= 0 {
 		t.Fatal("no resolver events?!")
 	}
-	if ev := config.TLSSaver.Read(); len(ev) <= 0 {
+	if ev := config.TLSSaver.(*trace.Saver).Read(); len(ev) <= 0 {
 		t.Fatal("no TLS events?!")
 	}
 }
@@ -91,3 +96,44 @@ func TestIntegrationBogonResolutionNotBroken(t *testing.T) {
 		t.Fatal("address was not returned")
 	}
 }
+
+func TestIntegrationHTTPTimings(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+	defer server.Close()
+	URL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saver := &trace.Saver{}
+	txp := netx.NewHTTPTransport(netx.Config{
+		DNSCache: map[string][]string{
+			"www.example.com": {"127.0.0.1"},
+		},
+		HTTPSaver:   saver,
+		NoTLSVerify: true,
+	})
+	client := &http.Client{Transport: txp}
+	begin := time.Now()
+	resp, err := client.Get("https://" + net.JoinHostPort("www.example.com", URL.Port()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	requests := archival.NewRequestList(begin, saver.Read())
+	if len(requests) != 1 {
+		t.Fatal("unexpected number of requests")
+	}
+	timings := requests[0].Timings
+	if timings == nil {
+		t.Fatal("expected timings here")
+	}
+	if timings.DNS <= 0 || timings.Connect <= 0 || timings.TLSHandshake <= 0 || timings.TTFB <= 0 {
+		t.Fatalf("unexpected timings: %+v", timings)
+	}
+}
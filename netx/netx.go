@@ -26,15 +26,23 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"sync"
+	"time"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/internal/runtimex"
 	"github.com/ooni/probe-engine/netx/bytecounter"
 	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/gocertifi"
 	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/pcapng"
 	"github.com/ooni/probe-engine/netx/resolver"
 	"github.com/ooni/probe-engine/netx/selfcensor"
 	"github.com/ooni/probe-engine/netx/trace"
@@ -69,30 +77,58 @@ type Resolver interface {
 	Address() string
 }
 
+// KeyValueStore is the key-value store used to persist the DNS cache.
+type KeyValueStore interface {
+	Get(key string) (value []byte, err error)
+	Set(key string, value []byte) error
+}
+
 // Config contains configuration for creating a new transport. When any
 // field of Config is nil/empty, we will use a suitable default.
 //
 // We use different savers for different kind of events such that the
 // user of this library can choose what to save.
+//
+// When HTTP3Enabled is true, we use QUIC rather than TCP, hence settings
+// that only apply to TCP connections (e.g., DialSaver, ReadWriteSaver,
+// ContextByteCounting, ContextPcap, PcapWriter) have no effect. Because we
+// cannot route QUIC through a proxy, we ignore HTTP3Enabled and use TCP
+// based HTTP when ProxyURL is also set. We also use TCP based HTTP when
+// there is no QUIC support, i.e., the program does not import netx/quicx.
 type Config struct {
-	BaseResolver        Resolver             // default: system resolver
-	BogonIsError        bool                 // default: bogon is not error
-	ByteCounter         *bytecounter.Counter // default: no explicit byte counting
-	CacheResolutions    bool                 // default: no caching
-	ContextByteCounting bool                 // default: no implicit byte counting
-	DNSCache            map[string][]string  // default: cache is empty
-	DialSaver           *trace.Saver         // default: not saving dials
-	Dialer              Dialer               // default: dialer.DNSDialer
-	FullResolver        Resolver             // default: base resolver + goodies
-	HTTPSaver           *trace.Saver         // default: not saving HTTP
-	Logger              Logger               // default: no logging
-	NoTLSVerify         bool                 // default: perform TLS verify
-	ProxyURL            *url.URL             // default: no proxy
-	ReadWriteSaver      *trace.Saver         // default: not saving read/write
-	ResolveSaver        *trace.Saver         // default: not saving resolves
-	TLSConfig           *tls.Config          // default: attempt using h2
-	TLSDialer           TLSDialer            // default: dialer.TLSDialer
-	TLSSaver            *trace.Saver         // defaukt: not saving TLS
+	BaseResolver         Resolver             // default: system resolver
+	BogonIsError         bool                 // default: bogon is not error
+	ByteCounter          *bytecounter.Counter // default: no explicit byte counting
+	CacheResolutions     bool                 // default: no caching
+	CertPool             *x509.CertPool       // default: CertPool
+	ContextByteCounting  bool                 // default: no implicit byte counting
+	ContextPcap          bool                 // default: no implicit pcapng writing
+	DNSCache             map[string][]string  // default: cache is empty
+	DNSCacheStore        KeyValueStore        // default: sessionresolver starts cold
+	DNSCheckingDisabled  bool                 // default: do not set the CD bit
+	DNSLateRepliesWindow time.Duration        // default: only read first UDP reply
+	DNSSECValidation     bool                 // default: no DNSSEC validation
+	DialSaver            trace.Sink           // default: not saving dials
+	Dialer               Dialer               // default: dialer.DNSDialer
+	FullResolver         Resolver             // default: base resolver + goodies
+	HTTP3Enabled         bool                 // default: TCP-based HTTP
+	HTTPSaver            trace.Sink           // default: not saving HTTP
+	HappyEyeballs        bool                 // default: try one address at a time
+	KeyLogWriter         io.Writer            // default: see KeyLogFileEnv
+	Logger               Logger               // default: no logging
+	NoTLSVerify          bool                 // default: perform TLS verify
+	ParallelDNSQueries   bool                 // default: serial A and AAAA queries
+	PcapWriter           *pcapng.Writer       // default: no explicit pcapng writing
+	ProxyURL             *url.URL             // default: no proxy
+	ReadWriteSaver       trace.Sink           // default: not saving read/write
+	ResolveSaver         trace.Sink           // default: not saving resolves
+	Shaper               *dialer.Shaper       // default: see dialer.ShapingDialer
+	TLSConfig            *tls.Config          // default: attempt using h2
+	TLSDialer            TLSDialer            // default: dialer.TLSDialer
+	TLSFingerprint       string               // default: use crypto/tls
+	TLSSaver             trace.Sink           // defaukt: not saving TLS
+	TLSSplitSize         int                  // default: dialer.DefaultSplitSize
+	TLSSplitStrategy     string               // default: do not split the ClientHello
 }
 
 type tlsHandshaker interface {
@@ -109,14 +145,84 @@ func init() {
 	runtimex.PanicOnError(err, "gocertifi.CACerts() failed")
 }
 
+// ErrEmptyCABundle indicates that a CA bundle contains no certificates.
+var ErrEmptyCABundle = errors.New("netx: no certificates in CA bundle")
+
+// LoadCABundle returns a new certificate pool containing the PEM encoded
+// certificates in the file at path. When replace is false, the pool also
+// contains the certificates of the default pool, so that we add extra
+// roots. Otherwise, we replace the default roots.
+func LoadCABundle(path string, replace bool) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !replace {
+		if pool, err = gocertifi.CACerts(); err != nil {
+			return nil, err
+		}
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%w: %s", ErrEmptyCABundle, path)
+	}
+	return pool, nil
+}
+
+// rootCAs returns the certificate pool we should use for config.
+func rootCAs(config Config) *x509.CertPool {
+	if config.CertPool != nil {
+		return config.CertPool
+	}
+	return CertPool
+}
+
+// KeyLogFileEnv is the environment variable containing the path of the
+// file where we write the TLS session keys using the NSS key log format,
+// which Wireshark understands, when Config.KeyLogWriter is nil.
+const KeyLogFileEnv = "SSLKEYLOGFILE"
+
+// OpenKeyLogFile opens the file at path for appending NSS key log lines.
+func OpenKeyLogFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+}
+
+var (
+	defaultKeyLogOnce   sync.Once
+	defaultKeyLogWriter io.Writer
+)
+
+// keyLogWriter returns the key log writer we should use for config. We
+// open the file named by KeyLogFileEnv at most once and keep it open.
+func keyLogWriter(config Config) io.Writer {
+	if config.KeyLogWriter != nil {
+		return config.KeyLogWriter
+	}
+	defaultKeyLogOnce.Do(func() {
+		if path := os.Getenv(KeyLogFileEnv); path != "" {
+			if filep, err := OpenKeyLogFile(path); err == nil {
+				defaultKeyLogWriter = filep
+			}
+		}
+	})
+	return defaultKeyLogWriter
+}
+
 // NewResolver creates a new resolver from the specified config
 func NewResolver(config Config) Resolver {
 	if config.BaseResolver == nil {
 		config.BaseResolver = resolver.SystemResolver{}
 	}
 	var r Resolver = config.BaseResolver
+	if config.DNSSECValidation {
+		r = resolver.DNSSECResolver{Resolver: r, Saver: config.ResolveSaver}
+	}
 	if config.CacheResolutions {
-		r = &resolver.CacheResolver{Resolver: r}
+		r = &resolver.CacheResolver{
+			Logger:   config.Logger,
+			Parallel: config.ParallelDNSQueries,
+			Resolver: r,
+		}
 	}
 	if config.DNSCache != nil {
 		cache := &resolver.CacheResolver{Resolver: r, ReadOnly: true}
@@ -156,12 +262,19 @@ func NewDialer(config Config) Dialer {
 	if config.ReadWriteSaver != nil {
 		d = dialer.SaverConnDialer{Dialer: d, Saver: config.ReadWriteSaver}
 	}
-	d = dialer.DNSDialer{Resolver: config.FullResolver, Dialer: d}
+	if config.ContextPcap || config.PcapWriter != nil {
+		d = dialer.PcapDialer{Dialer: d, Writer: config.PcapWriter}
+	}
+	d = dialer.DNSDialer{
+		Dialer:        d,
+		HappyEyeballs: config.HappyEyeballs,
+		Resolver:      config.FullResolver,
+	}
 	d = dialer.ProxyDialer{ProxyURL: config.ProxyURL, Dialer: d}
 	if config.ContextByteCounting {
 		d = dialer.ByteCounterDialer{Dialer: d}
 	}
-	d = dialer.ShapingDialer{Dialer: d}
+	d = dialer.ShapingDialer{Dialer: d, Shaper: config.Shaper}
 	return d
 }
 
@@ -171,19 +284,46 @@ func NewTLSDialer(config Config) TLSDialer {
 		config.Dialer = NewDialer(config)
 	}
 	var h tlsHandshaker = dialer.SystemTLSHandshaker{}
+	if config.TLSFingerprint != "" {
+		h = dialer.UTLSHandshaker{Fingerprint: config.TLSFingerprint}
+	}
+	if config.TLSSplitStrategy != "" {
+		h = dialer.SplitTLSHandshaker{
+			Size:          config.TLSSplitSize,
+			Strategy:      config.TLSSplitStrategy,
+			TLSHandshaker: h,
+		}
+	}
 	h = dialer.TimeoutTLSHandshaker{TLSHandshaker: h}
 	h = dialer.ErrorWrapperTLSHandshaker{TLSHandshaker: h}
 	if config.Logger != nil {
 		h = dialer.LoggingTLSHandshaker{Logger: config.Logger, TLSHandshaker: h}
 	}
 	if config.TLSSaver != nil {
-		h = dialer.SaverTLSHandshaker{TLSHandshaker: h, Saver: config.TLSSaver}
+		h = dialer.SaverTLSHandshaker{
+			Fingerprint:   config.TLSFingerprint,
+			Saver:         config.TLSSaver,
+			SplitStrategy: config.TLSSplitStrategy,
+			TLSHandshaker: h,
+		}
 	}
 	if config.TLSConfig == nil {
 		config.TLSConfig = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
 	}
-	config.TLSConfig.RootCAs = CertPool // always use our own CA
+	if config.TLSFingerprint != "" {
+		// net/http only speaks HTTP/2 over a *tls.Conn, hence we must
+		// not negotiate "h2" when we're using dialer.UTLSHandshaker. We
+		// always set an explicit ALPN, because an empty one would cause
+		// dialer.UTLSHandshaker to keep the mimicked ALPN, which usually
+		// includes "h2". The saver records that we overrode the ALPN.
+		config.TLSConfig.NextProtos = withoutH2(config.TLSConfig.NextProtos)
+		if len(config.TLSConfig.NextProtos) <= 0 {
+			config.TLSConfig.NextProtos = []string{"http/1.1"}
+		}
+	}
+	config.TLSConfig.RootCAs = rootCAs(config) // always use our own CA
 	config.TLSConfig.InsecureSkipVerify = config.NoTLSVerify
+	config.TLSConfig.KeyLogWriter = keyLogWriter(config)
 	return dialer.TLSDialer{
 		Config:        config.TLSConfig,
 		Dialer:        config.Dialer,
@@ -191,6 +331,54 @@ func NewTLSDialer(config Config) TLSDialer {
 	}
 }
 
+func withoutH2(protos []string) (out []string) {
+	for _, proto := range protos {
+		if proto != "h2" {
+			out = append(out, proto)
+		}
+	}
+	return
+}
+
+// QUICSupport contains the features of netx that require QUIC. The
+// netx/quicx package implements them and registers them when imported,
+// such that programs that do not use QUIC do not link quic-go.
+type QUICSupport interface {
+	// NewDNSOverQUIC creates a DNS over QUIC transport for endpoint
+	// using config.TLSConfig and a QUIC dialer created using config.
+	NewDNSOverQUIC(config Config, endpoint string) resolver.RoundTripper
+
+	// NewHTTP3Transport creates an HTTP/3 transport using tlsConfig
+	// and a QUIC dialer created using config.
+	NewHTTP3Transport(config Config, tlsConfig *tls.Config) HTTPRoundTripper
+}
+
+var quicSupport QUICSupport
+
+// RegisterQUICSupport registers the QUIC support. You should only call
+// this function from an init function, as netx/quicx does.
+func RegisterQUICSupport(s QUICSupport) {
+	quicSupport = s
+}
+
+// ErrQUICNotSupported indicates that we need QUIC but no QUIC support
+// is registered, i.e., the program does not import netx/quicx.
+var ErrQUICNotSupported = errors.New("netx: QUIC not supported; import netx/quicx")
+
+// newQUICTLSConfig returns the TLS config for QUIC, which is a copy of
+// config.TLSConfig using our own CA and honouring config.NoTLSVerify
+// as well as the key log writer.
+func newQUICTLSConfig(config Config) *tls.Config {
+	tlsConfig := new(tls.Config)
+	if config.TLSConfig != nil {
+		tlsConfig = config.TLSConfig.Clone()
+	}
+	tlsConfig.RootCAs = rootCAs(config) // always use our own CA
+	tlsConfig.InsecureSkipVerify = config.NoTLSVerify
+	tlsConfig.KeyLogWriter = keyLogWriter(config)
+	return tlsConfig
+}
+
 // NewHTTPTransport creates a new HTTPRoundTripper. You can further extend the returned
 // HTTPRoundTripper before wrapping it into an http.Client.
 func NewHTTPTransport(config Config) HTTPRoundTripper {
@@ -201,7 +389,11 @@ func NewHTTPTransport(config Config) HTTPRoundTripper {
 		config.TLSDialer = NewTLSDialer(config)
 	}
 	var txp HTTPRoundTripper
-	txp = httptransport.NewSystemTransport(config.Dialer, config.TLSDialer)
+	if config.HTTP3Enabled && config.ProxyURL == nil && quicSupport != nil {
+		txp = quicSupport.NewHTTP3Transport(config, newQUICTLSConfig(config))
+	} else {
+		txp = httptransport.NewSystemTransport(config.Dialer, config.TLSDialer)
+	}
 	if config.ByteCounter != nil {
 		txp = httptransport.ByteCountingTransport{
 			Counter: config.ByteCounter, RoundTripper: txp}
@@ -230,11 +422,28 @@ type DNSClient struct {
 	httpClient *http.Client
 }
 
-// CloseIdleConnections closes idle connections, if any.
+// CloseIdleConnections closes idle connections, if any. If the Resolver
+// also has a CloseIdleConnections method (e.g., a resolver.CacheResolver
+// persisting its entries), we call it as well.
 func (c DNSClient) CloseIdleConnections() {
 	if c.httpClient != nil {
 		c.httpClient.CloseIdleConnections()
 	}
+	if closer, ok := c.Resolver.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// LookupRecords performs a typed lookup using the underlying resolver. It
+// returns resolver.ErrLookupRecordsNotSupported when the underlying resolver
+// cannot perform typed lookups (e.g., the system resolver).
+func (c DNSClient) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error) {
+	rr, ok := c.Resolver.(resolver.RecordsResolver)
+	if !ok {
+		return nil, resolver.ErrLookupRecordsNotSupported
+	}
+	return rr.LookupRecords(ctx, hostname, qtype)
 }
 
 // NewDNSClient creates a new DNS client. The config argument is used to
@@ -247,14 +456,29 @@ func (c DNSClient) CloseIdleConnections() {
 // - if the URL is `` or `system:///`, then we create a system client,
 // i.e. a client using the system resolver.
 //
+// - if the URL starts with `doq://`, then we create a DNS over QUIC
+// client. If config.TLSSaver is not nil, we also save QUIC handshake events.
+// This requires the QUIC support, which you get by importing netx/quicx.
+//
 // - if the URL starts with `udp://`, then we create a client using
-// a resolver that uses the specified UDP endpoint.
+// a resolver that uses the specified UDP endpoint. If config.DNSLateRepliesWindow
+// is positive, we also read late replies in the background and save them as
+// "dns_late_reply" events into config.ResolveSaver, where we also save the
+// first reply to each query as a "dns_first_reply" event.
 //
 // We return error if the URL does not parse or the URL scheme does not
 // fall into one of the cases described above.
 //
 // If config.ResolveSaver is not nil and we're creating an underlying
 // resolver where this is possible, we will also save events.
+//
+// If config.ParallelDNSQueries is true and we're not using the system
+// resolver, we will send the A and AAAA queries in parallel.
+//
+// If config.DNSSECValidation is true and we're not using the system
+// resolver, we will set the DO bit in queries. Likewise, we will set
+// the CD bit if config.DNSCheckingDisabled is true. Note that you also
+// need to use DNSSECValidation when calling NewResolver to validate.
 func NewDNSClient(config Config, URL string) (DNSClient, error) {
 	return NewDNSClientWithOverrides(config, URL, "", "")
 }
@@ -293,7 +517,7 @@ func NewDNSClientWithOverrides(config Config, URL, hostOverride, SNIOverride str
 				Saver:        config.ResolveSaver,
 			}
 		}
-		c.Resolver = resolver.NewSerialResolver(txp)
+		c.Resolver = newDNSResolver(config, txp)
 		return c, nil
 	case "udp":
 		dialer := NewDialer(config)
@@ -301,14 +525,15 @@ func NewDNSClientWithOverrides(config Config, URL, hostOverride, SNIOverride str
 		if err != nil {
 			return c, err
 		}
-		var txp resolver.RoundTripper = resolver.NewDNSOverUDP(dialer, endpoint)
+		var txp resolver.RoundTripper = resolver.NewDNSOverUDPWithLateRepliesWindow(
+			dialer, endpoint, config.DNSLateRepliesWindow)
 		if config.ResolveSaver != nil {
 			txp = resolver.SaverDNSTransport{
 				RoundTripper: txp,
 				Saver:        config.ResolveSaver,
 			}
 		}
-		c.Resolver = resolver.NewSerialResolver(txp)
+		c.Resolver = newDNSResolver(config, txp)
 		return c, nil
 	case "dot":
 		config.TLSConfig.NextProtos = []string{"dot"}
@@ -325,7 +550,27 @@ func NewDNSClientWithOverrides(config Config, URL, hostOverride, SNIOverride str
 				Saver:        config.ResolveSaver,
 			}
 		}
-		c.Resolver = resolver.NewSerialResolver(txp)
+		c.Resolver = newDNSResolver(config, txp)
+		return c, nil
+	case "doq":
+		if quicSupport == nil {
+			return c, ErrQUICNotSupported
+		}
+		config.TLSConfig.RootCAs = rootCAs(config) // always use our own CA
+		config.TLSConfig.InsecureSkipVerify = config.NoTLSVerify
+		config.TLSConfig.KeyLogWriter = keyLogWriter(config)
+		endpoint, err := makeValidEndpoint(resolverURL)
+		if err != nil {
+			return c, err
+		}
+		txp := quicSupport.NewDNSOverQUIC(config, endpoint)
+		if config.ResolveSaver != nil {
+			txp = resolver.SaverDNSTransport{
+				RoundTripper: txp,
+				Saver:        config.ResolveSaver,
+			}
+		}
+		c.Resolver = newDNSResolver(config, txp)
 		return c, nil
 	case "tcp":
 		dialer := NewDialer(config)
@@ -341,13 +586,30 @@ func NewDNSClientWithOverrides(config Config, URL, hostOverride, SNIOverride str
 				Saver:        config.ResolveSaver,
 			}
 		}
-		c.Resolver = resolver.NewSerialResolver(txp)
+		c.Resolver = newDNSResolver(config, txp)
 		return c, nil
 	default:
 		return c, errors.New("unsupported resolver scheme")
 	}
 }
 
+// newDNSResolver creates the resolver for NewDNSClientWithOverrides
+// using txp as the underlying DNS transport.
+func newDNSResolver(config Config, txp resolver.RoundTripper) Resolver {
+	encoder := resolver.MiekgEncoder{
+		CheckingDisabled: config.DNSCheckingDisabled,
+		DNSSECOK:         config.DNSSECValidation,
+	}
+	if config.ParallelDNSQueries {
+		r := resolver.NewParallelResolver(txp)
+		r.Encoder = encoder
+		return r
+	}
+	r := resolver.NewSerialResolver(txp)
+	r.Encoder = encoder
+	return r
+}
+
 // makeValidEndpoint makes a valid endpoint for DoT and Do53 given the
 // input URL representing such endpoint. Specifically, we are
 // concerned with the case where the port is missing. In such a
@@ -386,7 +648,7 @@ func makeValidEndpoint(URL *url.URL) (string, error) {
 	// For this reason we check again whether we can split it using
 	// net.SplitHostPort. If we cannot, we were in case four.
 	host := URL.Host
-	if URL.Scheme == "dot" {
+	if URL.Scheme == "dot" || URL.Scheme == "doq" {
 		host += ":853"
 	} else {
 		host += ":53"
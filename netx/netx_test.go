@@ -1,17 +1,28 @@
 package netx_test
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/apex/log"
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/netx"
 	"github.com/ooni/probe-engine/netx/bytecounter"
 	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/pcapng"
 	"github.com/ooni/probe-engine/netx/resolver"
 	"github.com/ooni/probe-engine/netx/selfcensor"
 	"github.com/ooni/probe-engine/netx/trace"
@@ -175,6 +186,40 @@ func TestNewResolverWithReadWriteCache(t *testing.T) {
 	}
 }
 
+func TestNewResolverWithParallelCache(t *testing.T) {
+	r := netx.NewResolver(netx.Config{
+		CacheResolutions:   true,
+		Logger:             log.Log,
+		ParallelDNSQueries: true,
+	})
+	ir, ok := r.(resolver.IDNAResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	ar, ok := ir.Resolver.(resolver.AddressResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	lr, ok := ar.Resolver.(resolver.LoggingResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	ewr, ok := lr.Resolver.(resolver.ErrorWrapperResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	cr, ok := ewr.Resolver.(*resolver.CacheResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	if cr.Parallel != true {
+		t.Fatal("expected parallel lookups here")
+	}
+	if cr.Logger != log.Log {
+		t.Fatal("not the logger we expected")
+	}
+}
+
 func TestNewResolverWithPrefilledReadonlyCache(t *testing.T) {
 	r := netx.NewResolver(netx.Config{
 		DNSCache: map[string][]string{
@@ -209,6 +254,25 @@ func TestNewResolverWithPrefilledReadonlyCache(t *testing.T) {
 	}
 }
 
+func TestNewDialerWithHappyEyeballs(t *testing.T) {
+	d := netx.NewDialer(netx.Config{HappyEyeballs: true})
+	sd, ok := d.(dialer.ShapingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	pd, ok := sd.Dialer.(dialer.ProxyDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	dnsd, ok := pd.Dialer.(dialer.DNSDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if dnsd.HappyEyeballs != true {
+		t.Fatal("expected happy eyeballs to be enabled")
+	}
+}
+
 func TestNewDialerVanilla(t *testing.T) {
 	d := netx.NewDialer(netx.Config{})
 	sd, ok := d.(dialer.ShapingDialer)
@@ -438,6 +502,77 @@ func TestNewDialerWithReadWriteSaver(t *testing.T) {
 	}
 }
 
+func TestNewDialerWithPcapWriter(t *testing.T) {
+	writer, err := pcapng.NewWriter(new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := netx.NewDialer(netx.Config{
+		PcapWriter: writer,
+	})
+	sd, ok := d.(dialer.ShapingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	pd, ok := sd.Dialer.(dialer.ProxyDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	dnsd, ok := pd.Dialer.(dialer.DNSDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	pcd, ok := dnsd.Dialer.(dialer.PcapDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if pcd.Writer != writer {
+		t.Fatal("not the writer we expected")
+	}
+	if _, ok := pcd.Dialer.(dialer.ErrorWrapperDialer); !ok {
+		t.Fatal("not the dialer we expected")
+	}
+}
+
+func TestNewDialerWithContextPcap(t *testing.T) {
+	d := netx.NewDialer(netx.Config{
+		ContextPcap: true,
+	})
+	sd, ok := d.(dialer.ShapingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	pd, ok := sd.Dialer.(dialer.ProxyDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	dnsd, ok := pd.Dialer.(dialer.DNSDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	pcd, ok := dnsd.Dialer.(dialer.PcapDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if pcd.Writer != nil {
+		t.Fatal("not the writer we expected")
+	}
+}
+
+func TestNewDialerWithShaper(t *testing.T) {
+	shaper := dialer.NewShaper(dialer.ShapingConfig{DownloadRate: 1000})
+	d := netx.NewDialer(netx.Config{
+		Shaper: shaper,
+	})
+	sd, ok := d.(dialer.ShapingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if sd.Shaper != shaper {
+		t.Fatal("not the shaper we expected")
+	}
+}
+
 func TestNewDialerWithContextByteCounting(t *testing.T) {
 	d := netx.NewDialer(netx.Config{
 		ContextByteCounting: true,
@@ -666,6 +801,245 @@ func TestNewTLSDialerWithSaver(t *testing.T) {
 	}
 }
 
+func TestNewTLSDialerWithFingerprint(t *testing.T) {
+	saver := new(trace.Saver)
+	td := netx.NewTLSDialer(netx.Config{
+		TLSFingerprint: "chrome",
+		TLSSaver:       saver,
+	})
+	rtd, ok := td.(dialer.TLSDialer)
+	if !ok {
+		t.Fatal("not the TLSDialer we expected")
+	}
+	if len(rtd.Config.NextProtos) != 1 || rtd.Config.NextProtos[0] != "http/1.1" {
+		t.Fatal("invalid Config.NextProtos")
+	}
+	sth, ok := rtd.TLSHandshaker.(dialer.SaverTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	if sth.Fingerprint != "chrome" {
+		t.Fatal("not the Fingerprint we expected")
+	}
+	ewth, ok := sth.TLSHandshaker.(dialer.ErrorWrapperTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	tth, ok := ewth.TLSHandshaker.(dialer.TimeoutTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	uth, ok := tth.TLSHandshaker.(dialer.UTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	if uth.Fingerprint != "chrome" {
+		t.Fatal("not the Fingerprint we expected")
+	}
+}
+
+func TestNewTLSDialerWithFingerprintAndOnlyH2(t *testing.T) {
+	td := netx.NewTLSDialer(netx.Config{
+		TLSConfig:      &tls.Config{NextProtos: []string{"h2"}},
+		TLSFingerprint: "chrome",
+	})
+	rtd, ok := td.(dialer.TLSDialer)
+	if !ok {
+		t.Fatal("not the TLSDialer we expected")
+	}
+	// An empty ALPN would cause UTLSHandshaker to use the one of the
+	// mimicked ClientHello, which offers h2, hence we want http/1.1.
+	if len(rtd.Config.NextProtos) != 1 || rtd.Config.NextProtos[0] != "http/1.1" {
+		t.Fatal("invalid Config.NextProtos")
+	}
+}
+
+func TestNewTLSDialerWithSplitStrategy(t *testing.T) {
+	saver := new(trace.Saver)
+	td := netx.NewTLSDialer(netx.Config{
+		TLSSaver:         saver,
+		TLSSplitSize:     16,
+		TLSSplitStrategy: "tcp_segments",
+	})
+	rtd, ok := td.(dialer.TLSDialer)
+	if !ok {
+		t.Fatal("not the TLSDialer we expected")
+	}
+	sth, ok := rtd.TLSHandshaker.(dialer.SaverTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	if sth.SplitStrategy != "tcp_segments" {
+		t.Fatal("not the SplitStrategy we expected")
+	}
+	ewth, ok := sth.TLSHandshaker.(dialer.ErrorWrapperTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	tth, ok := ewth.TLSHandshaker.(dialer.TimeoutTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	sph, ok := tth.TLSHandshaker.(dialer.SplitTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	if sph.Size != 16 || sph.Strategy != "tcp_segments" {
+		t.Fatal("not the SplitTLSHandshaker config we expected")
+	}
+	if _, ok := sph.TLSHandshaker.(dialer.SystemTLSHandshaker); !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+}
+
+func TestNewTLSDialerWithCertPool(t *testing.T) {
+	pool := x509.NewCertPool()
+	td := netx.NewTLSDialer(netx.Config{CertPool: pool})
+	rtd, ok := td.(dialer.TLSDialer)
+	if !ok {
+		t.Fatal("not the TLSDialer we expected")
+	}
+	if rtd.Config.RootCAs != pool {
+		t.Fatal("invalid Config.RootCAs")
+	}
+}
+
+func TestNewTLSDialerWithKeyLogWriter(t *testing.T) {
+	w := new(bytes.Buffer)
+	td := netx.NewTLSDialer(netx.Config{KeyLogWriter: w})
+	rtd, ok := td.(dialer.TLSDialer)
+	if !ok {
+		t.Fatal("not the TLSDialer we expected")
+	}
+	if rtd.Config.KeyLogWriter != w {
+		t.Fatal("invalid Config.KeyLogWriter")
+	}
+}
+
+func TestNewHTTPTransportWithKeyLogWriter(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	w := new(bytes.Buffer)
+	txp := netx.NewHTTPTransport(netx.Config{KeyLogWriter: w, NoTLSVerify: true})
+	client := &http.Client{Transport: txp}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !strings.HasPrefix(w.String(), "CLIENT_") {
+		t.Fatal("unexpected key log", w.String())
+	}
+}
+
+func TestNewHTTPTransportWithRingSaver(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	saver := trace.NewRingSaver(2)
+	txp := netx.NewHTTPTransport(netx.Config{HTTPSaver: saver})
+	client := &http.Client{Transport: txp}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if ev := saver.Read(); len(ev) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	if saver.Dropped() <= 0 {
+		t.Fatal("expected to have dropped some events")
+	}
+}
+
+func TestOpenKeyLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keylog.txt")
+	for _, line := range []string{"a\n", "b\n"} {
+		filep, err := netx.OpenKeyLogFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := filep.WriteString(line); err != nil {
+			t.Fatal(err)
+		}
+		filep.Close()
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "a\nb\n" {
+		t.Fatal("we did not append to the file")
+	}
+	if _, err := netx.OpenKeyLogFile(filepath.Join(dir, "x", "y")); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestLoadCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	server.Close()
+	dir, err := ioutil.TempDir("", "netx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "bundle.pem")
+	data := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	})
+	if err := ioutil.WriteFile(bundle, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("with extra CAs", func(t *testing.T) {
+		pool, err := netx.LoadCABundle(bundle, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pool.Subjects()) != len(netx.CertPool.Subjects())+1 {
+			t.Fatal("unexpected number of CAs")
+		}
+	})
+	t.Run("with replacement CAs", func(t *testing.T) {
+		pool, err := netx.LoadCABundle(bundle, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pool.Subjects()) != 1 {
+			t.Fatal("unexpected number of CAs")
+		}
+	})
+	t.Run("with empty bundle", func(t *testing.T) {
+		pool, err := netx.LoadCABundle(empty, false)
+		if !errors.Is(err, netx.ErrEmptyCABundle) {
+			t.Fatal("not the error we expected")
+		}
+		if pool != nil {
+			t.Fatal("expected nil pool here")
+		}
+	})
+	t.Run("with nonexistent bundle", func(t *testing.T) {
+		pool, err := netx.LoadCABundle(filepath.Join(dir, "nonexistent.pem"), false)
+		if !os.IsNotExist(err) {
+			t.Fatal("not the error we expected")
+		}
+		if pool != nil {
+			t.Fatal("expected nil pool here")
+		}
+	})
+}
+
 func TestNewTLSDialerWithNoTLSVerifyAndConfig(t *testing.T) {
 	td := netx.NewTLSDialer(netx.Config{
 		TLSConfig:   new(tls.Config),
@@ -767,6 +1141,51 @@ func TestNewVanilla(t *testing.T) {
 	}
 }
 
+func TestNewWithHTTP3WithoutQUICSupport(t *testing.T) {
+	// This package does not import netx/quicx, hence there is no
+	// registered QUIC support and we fall back to TCP based HTTP.
+	txp := netx.NewHTTPTransport(netx.Config{HTTP3Enabled: true})
+	uatxp, ok := txp.(httptransport.UserAgentTransport)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	if _, ok := uatxp.RoundTripper.(*http.Transport); !ok {
+		t.Fatal("expected to fall back to TCP based HTTP")
+	}
+}
+
+func TestNewWithHTTP3AndProxyURL(t *testing.T) {
+	txp := netx.NewHTTPTransport(netx.Config{
+		HTTP3Enabled: true,
+		ProxyURL:     &url.URL{Scheme: "socks5", Host: "127.0.0.1:9050"},
+	})
+	uatxp, ok := txp.(httptransport.UserAgentTransport)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	if _, ok := uatxp.RoundTripper.(*http.Transport); !ok {
+		t.Fatal("expected to fall back to TCP based HTTP")
+	}
+}
+
+func TestNewWithNilSaver(t *testing.T) {
+	// A nil *trace.Saver is not a nil trace.Sink, hence we use it.
+	var saver *trace.Saver
+	expected := errors.New("mocked error")
+	txp := netx.NewHTTPTransport(netx.Config{
+		Dialer:    netx.FakeDialer{Err: expected},
+		HTTPSaver: saver,
+	})
+	client := &http.Client{Transport: txp}
+	resp, err := client.Get("http://www.google.com")
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if resp != nil {
+		t.Fatal("not the response we expected")
+	}
+}
+
 func TestNewWithDialer(t *testing.T) {
 	expected := errors.New("mocked error")
 	dialer := netx.FakeDialer{Err: expected}
@@ -920,6 +1339,35 @@ func TestNewDNSClientSystemResolver(t *testing.T) {
 	dnsclient.CloseIdleConnections()
 }
 
+func TestNewDNSClientSystemResolverLookupRecords(t *testing.T) {
+	dnsclient, err := netx.NewDNSClient(
+		netx.Config{}, "system:///")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := dnsclient.LookupRecords(
+		context.Background(), "dns.google", dns.TypeTXT)
+	if !errors.Is(err, resolver.ErrLookupRecordsNotSupported) {
+		t.Fatal("not the error we expected")
+	}
+	if records != nil {
+		t.Fatal("expected nil records here")
+	}
+	dnsclient.CloseIdleConnections()
+}
+
+func TestNewDNSClientUDPLookupRecords(t *testing.T) {
+	dnsclient, err := netx.NewDNSClient(
+		netx.Config{}, "udp://8.8.8.8:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dnsclient.Resolver.(resolver.RecordsResolver); !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	dnsclient.CloseIdleConnections()
+}
+
 func TestNewDNSClientEmpty(t *testing.T) {
 	dnsclient, err := netx.NewDNSClient(
 		netx.Config{}, "")
@@ -1184,3 +1632,89 @@ func TestNewDNSClientBadUDPEndpoint(t *testing.T) {
 		t.Fatal("expected error with bad endpoint")
 	}
 }
+
+func TestNewDNSClientUDPParallel(t *testing.T) {
+	dnsclient, err := netx.NewDNSClient(
+		netx.Config{ParallelDNSQueries: true}, "udp://8.8.8.8:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := dnsclient.Resolver.(resolver.ParallelResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	if _, ok := r.Transport().(resolver.DNSOverUDP); !ok {
+		t.Fatal("not the transport we expected")
+	}
+	dnsclient.CloseIdleConnections()
+}
+
+func TestNewDNSClientDoQWithoutQUICSupport(t *testing.T) {
+	// This package does not import netx/quicx, hence there is no
+	// registered QUIC support and we cannot create a DoQ client.
+	dnsclient, err := netx.NewDNSClient(
+		netx.Config{}, "doq://dns.adguard.com:853")
+	if !errors.Is(err, netx.ErrQUICNotSupported) {
+		t.Fatal("not the error we expected", err)
+	}
+	if dnsclient.Resolver != nil {
+		t.Fatal("expected nil resolver here")
+	}
+}
+
+func TestNewResolverWithDNSSECValidation(t *testing.T) {
+	saver := new(trace.Saver)
+	r := netx.NewResolver(netx.Config{
+		BaseResolver:     resolver.NewSerialResolver(resolver.NewDNSOverUDP(nil, "")),
+		DNSSECValidation: true,
+		ResolveSaver:     saver,
+	})
+	ir, ok := r.(resolver.IDNAResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	ar, ok := ir.Resolver.(resolver.AddressResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	sr, ok := ar.Resolver.(resolver.SaverResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	ewr, ok := sr.Resolver.(resolver.ErrorWrapperResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	dr, ok := ewr.Resolver.(resolver.DNSSECResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	if dr.Saver != saver {
+		t.Fatal("not the saver we expected")
+	}
+	if _, ok := dr.Resolver.(resolver.SerialResolver); !ok {
+		t.Fatal("not the resolver we expected")
+	}
+}
+
+func TestNewDNSClientWithDNSSECOptions(t *testing.T) {
+	dnsclient, err := netx.NewDNSClient(netx.Config{
+		DNSCheckingDisabled: true,
+		DNSSECValidation:    true,
+	}, "udp://8.8.8.8:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := dnsclient.Resolver.(resolver.SerialResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	encoder, ok := r.Encoder.(resolver.MiekgEncoder)
+	if !ok {
+		t.Fatal("not the encoder we expected")
+	}
+	if !encoder.DNSSECOK || !encoder.CheckingDisabled {
+		t.Fatal("not the encoder options we expected")
+	}
+	dnsclient.CloseIdleConnections()
+}
@@ -0,0 +1,358 @@
+// Package pcapng writes pcapng files containing packets that we synthesize
+// from the data read and written by connections. Because we do not capture
+// at the network level, we don't need any elevated privileges. The price to
+// pay is that the packets are a reconstruction: the segmentation reflects
+// the reads and writes performed by the application, we do not see pure
+// ACKs and retransmissions, and checksums are always correct.
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	blockTypeSectionHeader        = 0x0A0D0D0A
+	blockTypeInterfaceDescription = 0x00000001
+	blockTypeEnhancedPacket       = 0x00000006
+	byteOrderMagic                = 0x1A2B3C4D
+	linkTypeRaw                   = 101 // packets begin with the IP header
+	protocolTCP                   = 6
+	protocolUDP                   = 17
+	tcpFlagFIN                    = 0x01
+	tcpFlagSYN                    = 0x02
+	tcpFlagPSH                    = 0x08
+	tcpFlagACK                    = 0x10
+	maxSegmentSize                = 1460
+)
+
+// Writer writes a pcapng file. It is safe to use a Writer, and the
+// flows created from it, from multiple goroutines. After the first
+// write error, we stop writing and Err returns such error.
+type Writer struct {
+	err  error
+	ipID uint16
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+// NewWriter creates a new Writer and writes the pcapng header into w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	pw := &Writer{w: w}
+	pw.writeBlock(blockTypeSectionHeader, func(b []byte) []byte {
+		b = appendUint32(b, byteOrderMagic)
+		b = appendUint16(b, 1)                     // major version
+		b = appendUint16(b, 0)                     // minor version
+		return appendUint64(b, 0xFFFFFFFFFFFFFFFF) // unknown section length
+	})
+	pw.writeBlock(blockTypeInterfaceDescription, func(b []byte) []byte {
+		b = appendUint16(b, linkTypeRaw)
+		b = appendUint16(b, 0)    // reserved
+		return appendUint32(b, 0) // no snap length
+	})
+	if pw.err != nil {
+		return nil, pw.err
+	}
+	return pw, nil
+}
+
+// Err returns the first error that occurred when writing.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Flow is a TCP or UDP flow between a local and a remote endpoint.
+type Flow struct {
+	ack    uint32
+	local  endpoint
+	proto  uint8
+	remote endpoint
+	seq    uint32
+	w      *Writer
+}
+
+type endpoint struct {
+	ip   net.IP
+	port uint16
+}
+
+// NewFlow creates a new flow between local and remote, which must both be
+// either *net.TCPAddr or *net.UDPAddr. For TCP, we also write the three way
+// handshake, pretending that it completed at time t. This function returns
+// nil if we cannot represent the flow using the given addresses.
+func (w *Writer) NewFlow(local, remote net.Addr, t time.Time) *Flow {
+	f := &Flow{w: w}
+	switch l := local.(type) {
+	case *net.TCPAddr:
+		r, ok := remote.(*net.TCPAddr)
+		if !ok {
+			return nil
+		}
+		f.proto = protocolTCP
+		f.local, f.remote = newEndpoint(l.IP, l.Port), newEndpoint(r.IP, r.Port)
+	case *net.UDPAddr:
+		r, ok := remote.(*net.UDPAddr)
+		if !ok {
+			return nil
+		}
+		f.proto = protocolUDP
+		f.local, f.remote = newEndpoint(l.IP, l.Port), newEndpoint(r.IP, r.Port)
+	default:
+		return nil
+	}
+	if f.local.ip == nil || f.remote.ip == nil ||
+		(f.local.ip.To4() == nil) != (f.remote.ip.To4() == nil) {
+		return nil
+	}
+	if f.proto == protocolTCP {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		f.seq, f.ack = rand.Uint32(), rand.Uint32()
+		f.writeTCP(true, tcpFlagSYN, nil, t)
+		f.seq++
+		f.writeTCP(false, tcpFlagSYN|tcpFlagACK, nil, t)
+		f.ack++
+		f.writeTCP(true, tcpFlagACK, nil, t)
+	}
+	return f
+}
+
+func newEndpoint(ip net.IP, port int) endpoint {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return endpoint{ip: ip, port: uint16(port)}
+}
+
+// Send records that we sent data at time t.
+func (f *Flow) Send(data []byte, t time.Time) {
+	f.w.mu.Lock()
+	defer f.w.mu.Unlock()
+	f.writeData(true, data, t)
+}
+
+// Recv records that we received data at time t.
+func (f *Flow) Recv(data []byte, t time.Time) {
+	f.w.mu.Lock()
+	defer f.w.mu.Unlock()
+	f.writeData(false, data, t)
+}
+
+// CloseSend records that we closed the flow at time t. For TCP, we
+// write a FIN segment. For UDP, this function does nothing.
+func (f *Flow) CloseSend(t time.Time) {
+	f.closeWithFIN(true, t)
+}
+
+// CloseRecv records that the peer closed the flow at time t. For TCP,
+// we write a FIN segment. For UDP, this function does nothing.
+func (f *Flow) CloseRecv(t time.Time) {
+	f.closeWithFIN(false, t)
+}
+
+func (f *Flow) closeWithFIN(outgoing bool, t time.Time) {
+	if f.proto != protocolTCP {
+		return
+	}
+	f.w.mu.Lock()
+	defer f.w.mu.Unlock()
+	f.writeTCP(outgoing, tcpFlagFIN|tcpFlagACK, nil, t)
+	if outgoing {
+		f.seq++
+	} else {
+		f.ack++
+	}
+}
+
+// writeData writes data. It must be called with the writer mutex held.
+func (f *Flow) writeData(outgoing bool, data []byte, t time.Time) {
+	if f.proto == protocolUDP {
+		f.writePacket(outgoing, f.udpHeader(outgoing, data), data, t)
+		return
+	}
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > maxSegmentSize {
+			chunk = chunk[:maxSegmentSize]
+		}
+		f.writeTCP(outgoing, tcpFlagPSH|tcpFlagACK, chunk, t)
+		if outgoing {
+			f.seq += uint32(len(chunk))
+		} else {
+			f.ack += uint32(len(chunk))
+		}
+		data = data[len(chunk):]
+	}
+}
+
+// writeTCP writes a TCP segment. It must be called with the writer mutex held.
+func (f *Flow) writeTCP(outgoing bool, flags uint8, data []byte, t time.Time) {
+	src, dst, seq, ack := f.local, f.remote, f.seq, f.ack
+	if !outgoing {
+		src, dst, seq, ack = f.remote, f.local, f.ack, f.seq
+	}
+	var header []byte
+	header = appendUint16BE(header, src.port)
+	header = appendUint16BE(header, dst.port)
+	header = appendUint32BE(header, seq)
+	if flags&tcpFlagACK != 0 {
+		header = appendUint32BE(header, ack)
+	} else {
+		header = appendUint32BE(header, 0)
+	}
+	header = append(header, 5<<4, flags) // data offset and flags
+	header = appendUint16BE(header, 65535)
+	header = appendUint16BE(header, 0) // checksum
+	header = appendUint16BE(header, 0) // urgent pointer
+	sum := f.transportChecksum(src, dst, header, data)
+	binary.BigEndian.PutUint16(header[16:], sum)
+	f.writePacket(outgoing, header, data, t)
+}
+
+func (f *Flow) udpHeader(outgoing bool, data []byte) []byte {
+	src, dst := f.local, f.remote
+	if !outgoing {
+		src, dst = f.remote, f.local
+	}
+	var header []byte
+	header = appendUint16BE(header, src.port)
+	header = appendUint16BE(header, dst.port)
+	header = appendUint16BE(header, uint16(8+len(data)))
+	header = appendUint16BE(header, 0) // checksum
+	sum := f.transportChecksum(src, dst, header, data)
+	if sum == 0 {
+		sum = 0xFFFF // zero means no checksum for UDP
+	}
+	binary.BigEndian.PutUint16(header[6:], sum)
+	return header
+}
+
+func (f *Flow) transportChecksum(src, dst endpoint, header, data []byte) uint16 {
+	var pseudo []byte
+	pseudo = append(pseudo, src.ip...)
+	pseudo = append(pseudo, dst.ip...)
+	length := len(header) + len(data)
+	if src.ip.To4() != nil {
+		pseudo = append(pseudo, 0, f.proto)
+		pseudo = appendUint16BE(pseudo, uint16(length))
+	} else {
+		pseudo = appendUint32BE(pseudo, uint32(length))
+		pseudo = append(pseudo, 0, 0, 0, f.proto)
+	}
+	return checksum(pseudo, header, data)
+}
+
+// writePacket writes an IP packet. It must be called with the writer mutex held.
+func (f *Flow) writePacket(outgoing bool, header, data []byte, t time.Time) {
+	src, dst := f.local, f.remote
+	if !outgoing {
+		src, dst = f.remote, f.local
+	}
+	length := len(header) + len(data)
+	var packet []byte
+	if src.ip.To4() != nil {
+		f.w.ipID++
+		packet = append(packet, 0x45, 0) // version, IHL and TOS
+		packet = appendUint16BE(packet, uint16(20+length))
+		packet = appendUint16BE(packet, f.w.ipID)
+		packet = appendUint16BE(packet, 0x4000) // don't fragment
+		packet = append(packet, 64, f.proto)    // TTL and protocol
+		packet = appendUint16BE(packet, 0)      // checksum
+		packet = append(packet, src.ip...)
+		packet = append(packet, dst.ip...)
+		binary.BigEndian.PutUint16(packet[10:], checksum(packet))
+	} else {
+		packet = appendUint32BE(packet, 6<<28) // version
+		packet = appendUint16BE(packet, uint16(length))
+		packet = append(packet, f.proto, 64) // next header and hop limit
+		packet = append(packet, src.ip...)
+		packet = append(packet, dst.ip...)
+	}
+	packet = append(packet, header...)
+	packet = append(packet, data...)
+	f.w.writePacket(packet, t)
+}
+
+// writePacket writes an enhanced packet block. It must be called
+// with the writer mutex held.
+func (w *Writer) writePacket(packet []byte, t time.Time) {
+	w.writeBlock(blockTypeEnhancedPacket, func(b []byte) []byte {
+		usec := uint64(t.UnixNano() / 1000) // default resolution
+		b = appendUint32(b, 0)              // interface ID
+		b = appendUint32(b, uint32(usec>>32))
+		b = appendUint32(b, uint32(usec))
+		b = appendUint32(b, uint32(len(packet))) // captured length
+		b = appendUint32(b, uint32(len(packet))) // original length
+		b = append(b, packet...)
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+		return b
+	})
+}
+
+func (w *Writer) writeBlock(blockType uint32, body func([]byte) []byte) {
+	if w.err != nil {
+		return
+	}
+	b := body(nil)
+	length := uint32(12 + len(b))
+	var block []byte
+	block = appendUint32(block, blockType)
+	block = appendUint32(block, length)
+	block = append(block, b...)
+	block = appendUint32(block, length)
+	_, w.err = w.w.Write(block)
+}
+
+// checksum computes the internet checksum of the concatenation of data.
+func checksum(data ...[]byte) uint16 {
+	var (
+		sum  uint32
+		odd  bool
+		last byte
+	)
+	for _, d := range data {
+		for _, c := range d {
+			if odd {
+				sum += uint32(last)<<8 | uint32(c)
+			} else {
+				last = c
+			}
+			odd = !odd
+		}
+	}
+	if odd {
+		sum += uint32(last) << 8
+	}
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+	return ^uint16(sum)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v)), uint32(v>>32))
+}
+
+func appendUint16BE(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32BE(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
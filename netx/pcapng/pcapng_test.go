@@ -0,0 +1,219 @@
+package pcapng_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/pcapng"
+)
+
+type block struct {
+	blockType uint32
+	body      []byte
+}
+
+func readBlocks(t *testing.T, data []byte) (out []block) {
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatal("truncated block")
+		}
+		blockType := binary.LittleEndian.Uint32(data)
+		length := int(binary.LittleEndian.Uint32(data[4:]))
+		if length%4 != 0 || length > len(data) {
+			t.Fatal("invalid block length")
+		}
+		if int(binary.LittleEndian.Uint32(data[length-4:])) != length {
+			t.Fatal("mismatch between block lengths")
+		}
+		out = append(out, block{blockType: blockType, body: data[8 : length-4]})
+		data = data[length:]
+	}
+	return
+}
+
+// packets returns the IP packets inside the enhanced packet blocks.
+func packets(t *testing.T, blocks []block) (out [][]byte) {
+	for _, b := range blocks[2:] {
+		if b.blockType != 6 {
+			t.Fatal("expected an enhanced packet block")
+		}
+		length := int(binary.LittleEndian.Uint32(b.body[12:]))
+		out = append(out, b.body[20:20+length])
+	}
+	return
+}
+
+// sum computes the internet checksum, which is zero when
+// data contains a valid checksum.
+func sum(data []byte) uint16 {
+	var s uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		s += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 != 0 {
+		s += uint32(data[len(data)-1]) << 8
+	}
+	for s > 0xFFFF {
+		s = (s >> 16) + (s & 0xFFFF)
+	}
+	return ^uint16(s)
+}
+
+func TestUnitWriterHeader(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if _, err := pcapng.NewWriter(buf); err != nil {
+		t.Fatal(err)
+	}
+	blocks := readBlocks(t, buf.Bytes())
+	if len(blocks) != 2 {
+		t.Fatal("unexpected number of blocks")
+	}
+	if blocks[0].blockType != 0x0A0D0D0A {
+		t.Fatal("expected a section header block")
+	}
+	if binary.LittleEndian.Uint32(blocks[0].body) != 0x1A2B3C4D {
+		t.Fatal("invalid byte order magic")
+	}
+	if blocks[1].blockType != 1 || binary.LittleEndian.Uint16(blocks[1].body) != 101 {
+		t.Fatal("expected an interface description block with raw link type")
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("mocked error")
+}
+
+func TestUnitWriterError(t *testing.T) {
+	writer, err := pcapng.NewWriter(failingWriter{})
+	if err == nil || err.Error() != "mocked error" {
+		t.Fatal("not the error we expected")
+	}
+	if writer != nil {
+		t.Fatal("expected nil writer here")
+	}
+}
+
+func TestUnitWriterTCPFlow(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writer, err := pcapng.NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow := writer.NewFlow(
+		&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 54321},
+		&net.TCPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 443},
+		time.Now(),
+	)
+	if flow == nil {
+		t.Fatal("expected non-nil flow here")
+	}
+	flow.Send(make([]byte, 2000), time.Now())
+	flow.Recv([]byte("abc"), time.Now())
+	flow.CloseRecv(time.Now())
+	flow.CloseSend(time.Now())
+	if writer.Err() != nil {
+		t.Fatal(writer.Err())
+	}
+	pkts := packets(t, readBlocks(t, buf.Bytes()))
+	// SYN, SYN-ACK, ACK, two segments, one segment, FIN, FIN
+	if len(pkts) != 8 {
+		t.Fatal("unexpected number of packets", len(pkts))
+	}
+	expectFlags := []uint8{0x02, 0x12, 0x10, 0x18, 0x18, 0x18, 0x11, 0x11}
+	for idx, pkt := range pkts {
+		if pkt[0] != 0x45 || pkt[9] != 6 {
+			t.Fatal("expected IPv4 and TCP")
+		}
+		if int(binary.BigEndian.Uint16(pkt[2:])) != len(pkt) {
+			t.Fatal("invalid IP total length")
+		}
+		if sum(pkt[:20]) != 0 {
+			t.Fatal("invalid IP checksum")
+		}
+		pseudo := append(append([]byte{}, pkt[12:20]...), 0, 6, 0, 0)
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(len(pkt)-20))
+		if sum(append(pseudo, pkt[20:]...)) != 0 {
+			t.Fatal("invalid TCP checksum")
+		}
+		if pkt[33] != expectFlags[idx] {
+			t.Fatal("unexpected TCP flags at", idx)
+		}
+	}
+	// The first data segment starts right after the SYN and the
+	// data received by the client is acknowledged by its FIN.
+	isn := binary.BigEndian.Uint32(pkts[0][24:])
+	if binary.BigEndian.Uint32(pkts[3][24:]) != isn+1 {
+		t.Fatal("unexpected sequence number")
+	}
+	if binary.BigEndian.Uint32(pkts[4][24:]) != isn+1+1460 {
+		t.Fatal("unexpected sequence number")
+	}
+	peerISN := binary.BigEndian.Uint32(pkts[1][24:])
+	if binary.BigEndian.Uint32(pkts[7][28:]) != peerISN+1+3+1 {
+		t.Fatal("unexpected acknowledgement number")
+	}
+}
+
+func TestUnitWriterUDPFlowIPv6(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writer, err := pcapng.NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow := writer.NewFlow(
+		&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 54321},
+		&net.UDPAddr{IP: net.ParseIP("2001:4860:4860::8888"), Port: 53},
+		time.Now(),
+	)
+	if flow == nil {
+		t.Fatal("expected non-nil flow here")
+	}
+	flow.Send([]byte("query"), time.Now())
+	flow.Recv([]byte("response"), time.Now())
+	flow.CloseSend(time.Now())
+	pkts := packets(t, readBlocks(t, buf.Bytes()))
+	if len(pkts) != 2 {
+		t.Fatal("unexpected number of packets")
+	}
+	for _, pkt := range pkts {
+		if pkt[0]>>4 != 6 || pkt[6] != 17 {
+			t.Fatal("expected IPv6 and UDP")
+		}
+		if int(binary.BigEndian.Uint16(pkt[4:])) != len(pkt)-40 {
+			t.Fatal("invalid IPv6 payload length")
+		}
+		pseudo := append([]byte{}, pkt[8:40]...)
+		pseudo = append(pseudo, 0, 0, pkt[4], pkt[5], 0, 0, 0, 17)
+		if sum(append(pseudo, pkt[40:]...)) != 0 {
+			t.Fatal("invalid UDP checksum")
+		}
+	}
+	if string(pkts[1][48:]) != "response" {
+		t.Fatal("unexpected payload")
+	}
+}
+
+func TestUnitWriterNewFlowUnsupported(t *testing.T) {
+	writer, err := pcapng.NewWriter(new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp4 := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 54321}
+	tcp6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+	udp4 := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53}
+	pipe := &net.UnixAddr{Name: "pipe", Net: "unix"}
+	var cases = [][2]net.Addr{
+		{tcp4, tcp6}, {tcp4, udp4}, {udp4, tcp4}, {pipe, pipe}, {&net.TCPAddr{}, tcp4},
+	}
+	for _, c := range cases {
+		if writer.NewFlow(c[0], c[1], time.Now()) != nil {
+			t.Fatal("expected nil flow here")
+		}
+	}
+}
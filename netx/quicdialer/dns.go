@@ -0,0 +1,68 @@
+package quicdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/ooni/probe-engine/legacy/netx/dialid"
+	"github.com/ooni/probe-engine/netx/dialer"
+)
+
+// DNSDialer is a dialer that uses the configured Resolver to resolve a
+// domain name to IP addresses, and the configured Dialer to connect.
+type DNSDialer struct {
+	Dialer   ContextDialer
+	Resolver Resolver
+}
+
+// DialContext implements ContextDialer.DialContext. When the TLS config
+// has no ServerName, we use the domain in address as the SNI. As for the
+// dialer.TLSDialer, the certificate pool and the key log writer in the
+// context, if any, take precedence over the ones in the TLS config.
+func (d DNSDialer) DialContext(ctx context.Context, network, address string,
+	tlsConfig *tls.Config, config *quic.Config) (quic.EarlySession, error) {
+	onlyhost, onlyport, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ctx = dialid.WithDialID(ctx) // important to create before lookupHost
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = onlyhost
+	}
+	if pool := dialer.ContextCertPool(ctx); pool != nil {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.RootCAs = pool
+	}
+	if w := dialer.ContextKeyLogWriter(ctx); w != nil {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.KeyLogWriter = w
+	}
+	var addrs []string
+	addrs, err = d.LookupHost(ctx, onlyhost)
+	if err != nil {
+		return nil, err
+	}
+	var errorslist []error
+	for _, addr := range addrs {
+		target := net.JoinHostPort(addr, onlyport)
+		sess, err := d.Dialer.DialContext(ctx, network, target, tlsConfig, config)
+		if err == nil {
+			return sess, nil
+		}
+		errorslist = append(errorslist, err)
+	}
+	// As for dialer.DNSDialer, the first error is likely to be an IPv4
+	// error, hence most likely the most relevant error.
+	return nil, errorslist[0]
+}
+
+// LookupHost implements Resolver.LookupHost
+func (d DNSDialer) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	if net.ParseIP(hostname) != nil {
+		return []string{hostname}, nil
+	}
+	return d.Resolver.LookupHost(ctx, hostname)
+}
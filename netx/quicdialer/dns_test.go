@@ -0,0 +1,80 @@
+package quicdialer_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/quicdialer"
+)
+
+func TestUnitDNSDialerInvalidAddress(t *testing.T) {
+	d := quicdialer.DNSDialer{Dialer: &quicdialer.FakeDialer{}, Resolver: quicdialer.FakeResolver{}}
+	sess, err := d.DialContext(context.Background(), "udp", "x.org", &tls.Config{}, nil)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+}
+
+func TestUnitDNSDialerLookupFailure(t *testing.T) {
+	mocked := errors.New("mocked error")
+	d := quicdialer.DNSDialer{Dialer: &quicdialer.FakeDialer{}, Resolver: quicdialer.FakeResolver{Err: mocked}}
+	sess, err := d.DialContext(context.Background(), "udp", "x.org:853", &tls.Config{}, nil)
+	if !errors.Is(err, mocked) {
+		t.Fatal("not the error we expected")
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+}
+
+func TestUnitDNSDialerAllDialsFail(t *testing.T) {
+	mocked := errors.New("mocked error")
+	d := quicdialer.DNSDialer{
+		Dialer:   &quicdialer.FakeDialer{Err: mocked},
+		Resolver: quicdialer.FakeResolver{Addrs: []string{"10.0.0.1", "10.0.0.2"}},
+	}
+	sess, err := d.DialContext(context.Background(), "udp", "x.org:853", &tls.Config{}, nil)
+	if !errors.Is(err, mocked) {
+		t.Fatal("not the error we expected")
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+}
+
+func TestUnitDNSDialerSetsServerName(t *testing.T) {
+	fd := &quicdialer.FakeDialer{Session: quicdialer.FakeSession{}}
+	d := quicdialer.DNSDialer{Dialer: fd, Resolver: quicdialer.FakeResolver{Addrs: []string{"10.0.0.1"}}}
+	config := &tls.Config{}
+	sess, err := d.DialContext(context.Background(), "udp", "x.org:853", config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess == nil {
+		t.Fatal("expected non-nil session here")
+	}
+	if fd.TLSConfig.ServerName != "x.org" {
+		t.Fatal("we did not set the ServerName")
+	}
+	if config.ServerName != "" {
+		t.Fatal("we modified the original config")
+	}
+}
+
+func TestUnitDNSDialerKeepsServerName(t *testing.T) {
+	fd := &quicdialer.FakeDialer{Session: quicdialer.FakeSession{}}
+	d := quicdialer.DNSDialer{Dialer: fd, Resolver: quicdialer.FakeResolver{}}
+	config := &tls.Config{ServerName: "dns.google"}
+	_, err := d.DialContext(context.Background(), "udp", "8.8.8.8:853", config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fd.TLSConfig.ServerName != "dns.google" {
+		t.Fatal("we changed the ServerName")
+	}
+}
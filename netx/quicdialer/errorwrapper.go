@@ -0,0 +1,28 @@
+package quicdialer
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/ooni/probe-engine/legacy/netx/dialid"
+	"github.com/ooni/probe-engine/netx/errorx"
+)
+
+// ErrorWrapperDialer is a dialer that performs QUIC err wrapping
+type ErrorWrapperDialer struct {
+	Dialer ContextDialer
+}
+
+// DialContext implements ContextDialer.DialContext
+func (d ErrorWrapperDialer) DialContext(ctx context.Context, network, address string,
+	tlsConfig *tls.Config, config *quic.Config) (quic.EarlySession, error) {
+	dialID := dialid.ContextDialID(ctx)
+	sess, err := d.Dialer.DialContext(ctx, network, address, tlsConfig, config)
+	err = errorx.SafeErrWrapperBuilder{
+		DialID:    dialID,
+		Error:     err,
+		Operation: errorx.QUICHandshakeOperation,
+	}.MaybeBuild()
+	return sess, err
+}
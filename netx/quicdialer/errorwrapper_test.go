@@ -0,0 +1,47 @@
+package quicdialer_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ooni/probe-engine/legacy/netx/dialid"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/quicdialer"
+)
+
+func TestUnitErrorWrapperFailure(t *testing.T) {
+	ctx := dialid.WithDialID(context.Background())
+	d := quicdialer.ErrorWrapperDialer{Dialer: &quicdialer.FakeDialer{Err: io.EOF}}
+	sess, err := d.DialContext(ctx, "udp", "10.0.0.1:853", &tls.Config{}, nil)
+	if sess != nil {
+		t.Fatal("expected a nil session here")
+	}
+	var errWrapper *errorx.ErrWrapper
+	if !errors.As(err, &errWrapper) {
+		t.Fatal("cannot cast to ErrWrapper")
+	}
+	if errWrapper.DialID == 0 {
+		t.Fatal("unexpected DialID")
+	}
+	if errWrapper.Operation != errorx.QUICHandshakeOperation {
+		t.Fatal("unexpected Operation")
+	}
+	if errWrapper.Failure != errorx.FailureEOFError {
+		t.Fatal("unexpected failure")
+	}
+}
+
+func TestUnitErrorWrapperSuccess(t *testing.T) {
+	d := quicdialer.ErrorWrapperDialer{Dialer: &quicdialer.FakeDialer{Session: quicdialer.FakeSession{}}}
+	sess, err := d.DialContext(
+		context.Background(), "udp", "10.0.0.1:853", &tls.Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess == nil {
+		t.Fatal("expected non-nil session here")
+	}
+}
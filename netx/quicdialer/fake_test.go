@@ -0,0 +1,40 @@
+package quicdialer
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+type FakeDialer struct {
+	Session quic.EarlySession
+	Err     error
+
+	// TLSConfig is the last TLS config we have seen.
+	TLSConfig *tls.Config
+}
+
+func (d *FakeDialer) DialContext(ctx context.Context, network, address string,
+	tlsConfig *tls.Config, config *quic.Config) (quic.EarlySession, error) {
+	d.TLSConfig = tlsConfig
+	return d.Session, d.Err
+}
+
+type FakeSession struct {
+	quic.EarlySession
+	State tls.ConnectionState
+}
+
+func (s FakeSession) ConnectionState() quic.ConnectionState {
+	return quic.ConnectionState{ConnectionState: s.State}
+}
+
+type FakeResolver struct {
+	Addrs []string
+	Err   error
+}
+
+func (r FakeResolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	return r.Addrs, r.Err
+}
@@ -0,0 +1,20 @@
+// Package quicdialer contains dialers creating QUIC sessions.
+package quicdialer
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// ContextDialer is a dialer for QUIC using Context.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string,
+		tlsConfig *tls.Config, config *quic.Config) (quic.EarlySession, error)
+}
+
+// Resolver is the interface we expect from a resolver
+type Resolver interface {
+	LookupHost(ctx context.Context, hostname string) (addrs []string, err error)
+}
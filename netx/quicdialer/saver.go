@@ -0,0 +1,59 @@
+package quicdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/ooni/probe-engine/internal/tlsx"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+// HandshakeSaver saves events occurring during the QUIC handshake
+type HandshakeSaver struct {
+	Dialer ContextDialer
+	Saver  trace.Sink
+}
+
+// DialContext implements ContextDialer.DialContext
+func (d HandshakeSaver) DialContext(ctx context.Context, network, address string,
+	tlsConfig *tls.Config, config *quic.Config) (quic.EarlySession, error) {
+	start := time.Now()
+	d.Saver.Write(trace.Event{
+		Address:       address,
+		Name:          "quic_handshake_start",
+		NoTLSVerify:   tlsConfig.InsecureSkipVerify,
+		Proto:         network,
+		TLSNextProtos: tlsConfig.NextProtos,
+		TLSServerName: tlsConfig.ServerName,
+		Time:          start,
+	})
+	sess, err := d.Dialer.DialContext(ctx, network, address, tlsConfig, config)
+	stop := time.Now()
+	var state tls.ConnectionState
+	if err == nil {
+		state = ConnectionState(sess)
+	}
+	d.Saver.Write(trace.Event{
+		Address:            address,
+		Duration:           stop.Sub(start),
+		Err:                err,
+		Name:               "quic_handshake_done",
+		NoTLSVerify:        tlsConfig.InsecureSkipVerify,
+		Proto:              network,
+		TLSCipherSuite:     tlsx.CipherSuiteString(state.CipherSuite),
+		TLSNegotiatedProto: state.NegotiatedProtocol,
+		TLSNextProtos:      tlsConfig.NextProtos,
+		TLSPeerCerts:       state.PeerCertificates,
+		TLSServerName:      tlsConfig.ServerName,
+		TLSVersion:         tlsx.VersionString(state.Version),
+		Time:               stop,
+	})
+	return sess, err
+}
+
+// ConnectionState returns the TLS connection state of sess.
+func ConnectionState(sess quic.EarlySession) tls.ConnectionState {
+	return sess.ConnectionState().ConnectionState
+}
@@ -0,0 +1,73 @@
+package quicdialer_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/quicdialer"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+func TestUnitHandshakeSaverFailure(t *testing.T) {
+	mocked := errors.New("mocked error")
+	saver := &trace.Saver{}
+	d := quicdialer.HandshakeSaver{Dialer: &quicdialer.FakeDialer{Err: mocked}, Saver: saver}
+	config := &tls.Config{NextProtos: []string{"doq"}, ServerName: "x.org"}
+	sess, err := d.DialContext(context.Background(), "udp", "10.0.0.1:853", config, nil)
+	if !errors.Is(err, mocked) {
+		t.Fatal("not the error we expected")
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+	ev := saver.Read()
+	if len(ev) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	if ev[0].Name != "quic_handshake_start" || ev[0].TLSServerName != "x.org" {
+		t.Fatal("unexpected first event")
+	}
+	if ev[1].Name != "quic_handshake_done" || !errors.Is(ev[1].Err, mocked) {
+		t.Fatal("unexpected second event")
+	}
+	if ev[1].Address != "10.0.0.1:853" || ev[1].Proto != "udp" {
+		t.Fatal("unexpected endpoint in second event")
+	}
+	if ev[1].Duration <= 0 {
+		t.Fatal("unexpected Duration")
+	}
+}
+
+func TestUnitHandshakeSaverSuccess(t *testing.T) {
+	saver := &trace.Saver{}
+	d := quicdialer.HandshakeSaver{Dialer: &quicdialer.FakeDialer{Session: quicdialer.FakeSession{
+		State: tls.ConnectionState{
+			CipherSuite:        tls.TLS_AES_128_GCM_SHA256,
+			NegotiatedProtocol: "doq",
+			Version:            tls.VersionTLS13,
+		},
+	}}, Saver: saver}
+	config := &tls.Config{NextProtos: []string{"doq"}, ServerName: "x.org"}
+	sess, err := d.DialContext(context.Background(), "udp", "10.0.0.1:853", config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess == nil {
+		t.Fatal("expected non-nil session here")
+	}
+	ev := saver.Read()
+	if len(ev) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	if ev[1].TLSCipherSuite != "TLS_AES_128_GCM_SHA256" {
+		t.Fatal("unexpected TLSCipherSuite")
+	}
+	if ev[1].TLSNegotiatedProto != "doq" {
+		t.Fatal("unexpected TLSNegotiatedProto")
+	}
+	if ev[1].TLSVersion != "TLSv1.3" {
+		t.Fatal("unexpected TLSVersion")
+	}
+}
@@ -0,0 +1,63 @@
+package quicdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// ErrNotAnIPAddress indicates that SystemDialer was passed
+// an address containing a domain name rather than an IP.
+var ErrNotAnIPAddress = errors.New("quicdialer: not an IP address")
+
+// SystemDialer is the basic QUIC dialer. It only accepts addresses
+// containing IP addresses; use DNSDialer to also resolve domains.
+type SystemDialer struct{}
+
+// DialContext implements ContextDialer.DialContext
+func (d SystemDialer) DialContext(ctx context.Context, network, address string,
+	tlsConfig *tls.Config, config *quic.Config) (quic.EarlySession, error) {
+	onlyhost, onlyport, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(onlyhost)
+	if ip == nil {
+		return nil, ErrNotAnIPAddress
+	}
+	port, err := strconv.Atoi(onlyport)
+	if err != nil {
+		return nil, err
+	}
+	pconn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	raddr := &net.UDPAddr{IP: ip, Port: port}
+	sess, err := quic.DialEarlyContext(ctx, pconn, raddr, address, tlsConfig, config)
+	if err != nil {
+		pconn.Close()
+		return nil, err
+	}
+	return sessionWithPacketConn{EarlySession: sess, pconn: pconn}, nil
+}
+
+// sessionWithPacketConn closes the underlying UDP socket when the
+// session is closed, since quic-go does not own the socket we have
+// created and would otherwise leak it.
+type sessionWithPacketConn struct {
+	quic.EarlySession
+	pconn net.PacketConn
+}
+
+// CloseWithError implements quic.Session.CloseWithError
+func (sess sessionWithPacketConn) CloseWithError(
+	code quic.ErrorCode, reason string) error {
+	err := sess.EarlySession.CloseWithError(code, reason)
+	sess.pconn.Close()
+	return err
+}
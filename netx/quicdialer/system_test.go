@@ -0,0 +1,56 @@
+package quicdialer_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/quicdialer"
+)
+
+func TestUnitSystemDialerInvalidAddress(t *testing.T) {
+	sess, err := quicdialer.SystemDialer{}.DialContext(
+		context.Background(), "udp", "1.1.1.1", &tls.Config{}, nil)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+}
+
+func TestUnitSystemDialerNotAnIPAddress(t *testing.T) {
+	sess, err := quicdialer.SystemDialer{}.DialContext(
+		context.Background(), "udp", "dns.google:853", &tls.Config{}, nil)
+	if !errors.Is(err, quicdialer.ErrNotAnIPAddress) {
+		t.Fatal("not the error we expected")
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+}
+
+func TestUnitSystemDialerInvalidPort(t *testing.T) {
+	sess, err := quicdialer.SystemDialer{}.DialContext(
+		context.Background(), "udp", "1.1.1.1:xx", &tls.Config{}, nil)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+}
+
+func TestUnitSystemDialerCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	sess, err := quicdialer.SystemDialer{}.DialContext(
+		ctx, "udp", "127.0.0.1:853", &tls.Config{ServerName: "x.org"}, nil)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+}
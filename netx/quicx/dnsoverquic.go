@@ -0,0 +1,103 @@
+package quicx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"math"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/ooni/probe-engine/netx/resolver"
+)
+
+// QUICDialContextFunc is a generic function for dialing a QUIC session.
+type QUICDialContextFunc func(ctx context.Context, network, address string,
+	tlsConfig *tls.Config, config *quic.Config) (quic.EarlySession, error)
+
+// DNSOverQUIC is a DNS over QUIC RoundTripper (RFC9250).
+//
+// Like resolver.DNSOverTCP, this implementation creates a new session
+// for each incoming query, thus increasing the response delay.
+type DNSOverQUIC struct {
+	dial      QUICDialContextFunc
+	address   string
+	tlsConfig *tls.Config
+}
+
+// NewDNSOverQUIC creates a new DNSOverQUIC transport. The tlsConfig
+// argument may be nil, in which case we use an empty config. In any
+// case, we always force the ALPN to be "doq" as mandated by RFC9250.
+func NewDNSOverQUIC(dial QUICDialContextFunc, address string,
+	tlsConfig *tls.Config) DNSOverQUIC {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{"doq"}
+	return DNSOverQUIC{dial: dial, address: address, tlsConfig: tlsConfig}
+}
+
+// RoundTrip implements resolver.RoundTripper.RoundTrip.
+func (t DNSOverQUIC) RoundTrip(ctx context.Context, query []byte) ([]byte, error) {
+	if len(query) > math.MaxUint16 {
+		return nil, errors.New("query too long")
+	}
+	if len(query) < 2 {
+		return nil, errors.New("query too short")
+	}
+	sess, err := t.dial(ctx, "udp", t.address, t.tlsConfig, &quic.Config{})
+	if err != nil {
+		return nil, err
+	}
+	defer sess.CloseWithError(0, "")
+	stream, err := sess.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = stream.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return nil, err
+	}
+	// Write request. RFC9250 mandates a zero message ID, uses the
+	// same framing of DNS over TCP, and requires us to close the
+	// sending side of the stream to signal the end of the query.
+	buf := []byte{byte(len(query) >> 8)}
+	buf = append(buf, byte(len(query)))
+	buf = append(buf, 0, 0)
+	buf = append(buf, query[2:]...)
+	if _, err = stream.Write(buf); err != nil {
+		return nil, err
+	}
+	if err = stream.Close(); err != nil {
+		return nil, err
+	}
+	// Read response
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(stream, header); err != nil {
+		return nil, err
+	}
+	length := int(header[0])<<8 | int(header[1])
+	reply := make([]byte, length)
+	if _, err = io.ReadFull(stream, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// RequiresPadding returns true for DoQ according to RFC9250.
+func (t DNSOverQUIC) RequiresPadding() bool {
+	return true
+}
+
+// Network returns the transport network, i.e., "doq".
+func (t DNSOverQUIC) Network() string {
+	return "doq"
+}
+
+// Address returns the upstream server address.
+func (t DNSOverQUIC) Address() string {
+	return t.address
+}
+
+var _ resolver.RoundTripper = DNSOverQUIC{}
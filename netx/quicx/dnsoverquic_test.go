@@ -0,0 +1,198 @@
+package quicx_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/quicdialer"
+	"github.com/ooni/probe-engine/netx/quicx"
+	"github.com/ooni/probe-engine/netx/resolver"
+)
+
+// newDoQServer starts an in-process DNS over QUIC server that replies
+// to each query using reply and returns the listener and the pool to
+// use to verify its certificate.
+func newDoQServer(t *testing.T, reply []byte) (quic.Listener, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dns.example.com"},
+		DNSNames:              []string{"dns.example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	listener, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{"doq"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			sess, err := listener.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go serveDoQ(sess, reply)
+		}
+	}()
+	return listener, pool
+}
+
+// genReplySuccess generates a reply for an A query for x.org.
+func genReplySuccess(t *testing.T) []byte {
+	query := new(dns.Msg)
+	query.SetQuestion("x.org.", dns.TypeA)
+	reply := new(dns.Msg)
+	reply.SetReply(query)
+	reply.Answer = append(reply.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: "x.org.", Rrtype: dns.TypeA, Class: dns.ClassINET},
+		A:   net.IPv4(8, 8, 8, 8),
+	})
+	data, err := reply.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func serveDoQ(sess quic.Session, reply []byte) {
+	defer sess.CloseWithError(0, "")
+	stream, err := sess.AcceptStream(context.Background())
+	if err != nil {
+		return
+	}
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(stream, header); err != nil {
+		return
+	}
+	query := make([]byte, int(header[0])<<8|int(header[1]))
+	if _, err := io.ReadFull(stream, query); err != nil {
+		return
+	}
+	if query[0] != 0 || query[1] != 0 {
+		stream.CancelWrite(0) // RFC9250 requires a zero message ID
+		return
+	}
+	stream.Write(append([]byte{byte(len(reply) >> 8), byte(len(reply))}, reply...))
+	stream.Close()
+}
+
+func TestUnitDNSOverQUICTransportOK(t *testing.T) {
+	const address = "9.9.9.9:853"
+	txp := quicx.NewDNSOverQUIC(quicdialer.SystemDialer{}.DialContext, address, nil)
+	if txp.RequiresPadding() != true {
+		t.Fatal("invalid RequiresPadding")
+	}
+	if txp.Network() != "doq" {
+		t.Fatal("invalid Network")
+	}
+	if txp.Address() != address {
+		t.Fatal("invalid Address")
+	}
+}
+
+func TestUnitDNSOverQUICDialFailure(t *testing.T) {
+	mocked := errors.New("mocked error")
+	dial := func(ctx context.Context, network, address string,
+		tlsConfig *tls.Config, config *quic.Config) (quic.EarlySession, error) {
+		if len(tlsConfig.NextProtos) != 1 || tlsConfig.NextProtos[0] != "doq" {
+			t.Fatal("not the ALPN we expected")
+		}
+		if network != "udp" {
+			t.Fatal("not the network we expected")
+		}
+		return nil, mocked
+	}
+	txp := quicx.NewDNSOverQUIC(dial, "9.9.9.9:853", &tls.Config{})
+	data, err := txp.RoundTrip(context.Background(), make([]byte, 17))
+	if !errors.Is(err, mocked) {
+		t.Fatal("not the error we expected")
+	}
+	if data != nil {
+		t.Fatal("expected no response here")
+	}
+}
+
+func TestUnitDNSOverQUICQueryTooLarge(t *testing.T) {
+	txp := quicx.NewDNSOverQUIC(
+		quicdialer.SystemDialer{}.DialContext, "9.9.9.9:853", nil)
+	reply, err := txp.RoundTrip(context.Background(), make([]byte, 1<<18))
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if reply != nil {
+		t.Fatal("expected nil reply here")
+	}
+}
+
+func TestUnitDNSOverQUICSuccess(t *testing.T) {
+	expected := genReplySuccess(t)
+	listener, pool := newDoQServer(t, expected)
+	defer listener.Close()
+	txp := quicx.NewDNSOverQUIC(
+		quicdialer.SystemDialer{}.DialContext, listener.Addr().String(),
+		&tls.Config{RootCAs: pool, ServerName: "dns.example.com"},
+	)
+	query, err := resolver.MiekgEncoder{}.Encode("x.org", dns.TypeA, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := txp.RoundTrip(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply, expected) {
+		t.Fatal("not the reply we expected")
+	}
+}
+
+func TestUnitDNSOverQUICWithSerialResolver(t *testing.T) {
+	listener, pool := newDoQServer(
+		t, genReplySuccess(t))
+	defer listener.Close()
+	txp := quicx.NewDNSOverQUIC(
+		quicdialer.SystemDialer{}.DialContext, listener.Addr().String(),
+		&tls.Config{RootCAs: pool, ServerName: "dns.example.com"},
+	)
+	r := resolver.NewSerialResolver(txp)
+	records, err := r.LookupRecords(context.Background(), "x.org", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatal("not the result we expected")
+	}
+}
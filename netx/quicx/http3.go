@@ -0,0 +1,90 @@
+package quicx
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
+	"github.com/ooni/probe-engine/netx/quicdialer"
+)
+
+// HTTP3Transport is an HTTP/3 transport. It uses Dialer to establish
+// QUIC sessions using TLSClientConfig.
+//
+// Because http3 does not pass us a context when dialing, we use a distinct
+// http3.RoundTripper for each host, whose dial function uses the context
+// of the request that created it. This way, we honour the cancellation as
+// well as the certificate pool and key log writer in the context. When such
+// a dial fails, we forget the http3.RoundTripper, so that the next request
+// for the same host dials again, rather than failing with the same error.
+type HTTP3Transport struct {
+	Dialer          quicdialer.ContextDialer
+	TLSClientConfig *tls.Config
+	hosts           map[string]*http3.RoundTripper
+	mu              sync.Mutex
+}
+
+// NewHTTP3Transport creates a new HTTP3Transport.
+func NewHTTP3Transport(
+	dialer quicdialer.ContextDialer, tlsConfig *tls.Config) *HTTP3Transport {
+	return &HTTP3Transport{Dialer: dialer, TLSClientConfig: tlsConfig}
+}
+
+// RoundTrip implements http.RoundTripper.RoundTrip.
+func (t *HTTP3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.roundTripper(req).RoundTrip(req)
+}
+
+func (t *HTTP3Transport) roundTripper(req *http.Request) *http3.RoundTripper {
+	var host string
+	if req.URL != nil {
+		host = req.URL.Host
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hosts == nil {
+		t.hosts = make(map[string]*http3.RoundTripper)
+	}
+	if txp, found := t.hosts[host]; found {
+		return txp
+	}
+	ctx := req.Context()
+	txp := &http3.RoundTripper{
+		// Same rationale as in httptransport.NewSystemTransport
+		DisableCompression: true,
+		TLSClientConfig:    t.TLSClientConfig,
+	}
+	txp.Dial = func(network, address string, tlsConfig *tls.Config,
+		config *quic.Config) (quic.EarlySession, error) {
+		sess, err := t.Dialer.DialContext(ctx, network, address, tlsConfig, config)
+		if err != nil {
+			t.forget(host, txp)
+		}
+		return sess, err
+	}
+	t.hosts[host] = txp
+	return txp
+}
+
+func (t *HTTP3Transport) forget(host string, txp *http3.RoundTripper) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hosts[host] == txp {
+		delete(t.hosts, host)
+	}
+}
+
+// CloseIdleConnections closes all the connections opened by this transport.
+func (t *HTTP3Transport) CloseIdleConnections() {
+	t.mu.Lock()
+	hosts := t.hosts
+	t.hosts = nil
+	t.mu.Unlock()
+	for _, txp := range hosts {
+		txp.Close()
+	}
+}
+
+var _ http.RoundTripper = &HTTP3Transport{}
@@ -0,0 +1,136 @@
+package quicx_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
+	"github.com/ooni/probe-engine/netx/quicdialer"
+	"github.com/ooni/probe-engine/netx/quicx"
+)
+
+// newHTTP3Server starts an in-process HTTP/3 server using handler and
+// returns its address, the TLS config to connect to it, and a function
+// to stop the server. We borrow the certificate from httptest.
+func newHTTP3Server(t *testing.T, handler http.Handler) (string, *tls.Config, func()) {
+	tlsServer := httptest.NewTLSServer(handler)
+	pconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http3.Server{Server: &http.Server{
+		Handler:   handler,
+		TLSConfig: tlsServer.TLS,
+	}}
+	go server.Serve(pconn)
+	tlsConfig := &tls.Config{
+		RootCAs: tlsServer.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+	}
+	return pconn.LocalAddr().String(), tlsConfig, func() {
+		server.Close()
+		pconn.Close()
+		tlsServer.Close()
+	}
+}
+
+func TestUnitHTTP3TransportSuccess(t *testing.T) {
+	address, tlsConfig, stop := newHTTP3Server(t, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("antani"))
+		}))
+	defer stop()
+	txp := quicx.NewHTTP3Transport(quicdialer.DNSDialer{
+		Dialer:   quicdialer.SystemDialer{},
+		Resolver: new(net.Resolver),
+	}, tlsConfig)
+	defer txp.CloseIdleConnections()
+	req, err := http.NewRequest("GET", "https://"+address+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := txp.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 3 {
+		t.Fatal("not the protocol we expected")
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "antani" {
+		t.Fatal("not the body we expected")
+	}
+}
+
+func TestUnitHTTP3TransportFailure(t *testing.T) {
+	txp := quicx.NewHTTP3Transport(quicdialer.DNSDialer{
+		Dialer:   quicdialer.SystemDialer{},
+		Resolver: new(net.Resolver),
+	}, &tls.Config{})
+	defer txp.CloseIdleConnections()
+	req, err := http.NewRequest("GET", "https://antani.ooni.nu/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := txp.RoundTrip(req)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if resp != nil {
+		t.Fatal("expected nil response here")
+	}
+}
+
+type http3ContextKey struct{}
+
+type http3FakeDialer struct {
+	contexts []context.Context
+	err      error
+}
+
+func (d *http3FakeDialer) DialContext(ctx context.Context, network, address string,
+	tlsConfig *tls.Config, config *quic.Config) (quic.EarlySession, error) {
+	d.contexts = append(d.contexts, ctx)
+	return nil, d.err
+}
+
+func TestUnitHTTP3TransportDialUsesRequestContext(t *testing.T) {
+	expected := errors.New("mocked error")
+	dialer := &http3FakeDialer{err: expected}
+	txp := quicx.NewHTTP3Transport(dialer, &tls.Config{})
+	defer txp.CloseIdleConnections()
+	for _, value := range []string{"antani", "mascetti"} {
+		ctx := context.WithValue(context.Background(), http3ContextKey{}, value)
+		req, err := http.NewRequestWithContext(ctx, "GET", "https://antani.ooni.nu/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := txp.RoundTrip(req)
+		if !errors.Is(err, expected) {
+			t.Fatal("not the error we expected", err)
+		}
+		if resp != nil {
+			t.Fatal("expected nil response here")
+		}
+	}
+	// Because the first dial failed, we expect to dial again.
+	if len(dialer.contexts) != 2 {
+		t.Fatal("not the number of dials we expected")
+	}
+	if dialer.contexts[0].Value(http3ContextKey{}) != "antani" {
+		t.Fatal("first dial did not use the request context")
+	}
+	if dialer.contexts[1].Value(http3ContextKey{}) != "mascetti" {
+		t.Fatal("second dial did not use the request context")
+	}
+}
@@ -0,0 +1,52 @@
+// Package quicx contains the features of netx that require QUIC, i.e.,
+// DNS over QUIC and HTTP/3. We keep them separate from netx, such that
+// programs that do not use QUIC do not link quic-go. Importing this package
+// registers such features into netx, hence `doq://` resolver URLs and
+// netx.Config.HTTP3Enabled only work for programs importing it.
+package quicx
+
+import (
+	"crypto/tls"
+
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/quicdialer"
+	"github.com/ooni/probe-engine/netx/resolver"
+)
+
+func init() {
+	netx.RegisterQUICSupport(quicSupport{})
+}
+
+// NewQUICDialer creates a new QUIC dialer from the specified config. When
+// config.TLSSaver is not nil, we also save QUIC handshake events. We do not
+// save dials and I/O events, count bytes or capture packets for QUIC.
+func NewQUICDialer(config netx.Config) quicdialer.ContextDialer {
+	if config.FullResolver == nil {
+		config.FullResolver = netx.NewResolver(config)
+	}
+	var d quicdialer.ContextDialer = quicdialer.SystemDialer{}
+	d = quicdialer.ErrorWrapperDialer{Dialer: d}
+	if config.TLSSaver != nil {
+		d = quicdialer.HandshakeSaver{Dialer: d, Saver: config.TLSSaver}
+	}
+	d = quicdialer.DNSDialer{Resolver: config.FullResolver, Dialer: d}
+	return d
+}
+
+// quicSupport implements netx.QUICSupport.
+type quicSupport struct{}
+
+// NewDNSOverQUIC implements netx.QUICSupport.NewDNSOverQUIC.
+func (quicSupport) NewDNSOverQUIC(
+	config netx.Config, endpoint string) resolver.RoundTripper {
+	return NewDNSOverQUIC(
+		NewQUICDialer(config).DialContext, endpoint, config.TLSConfig)
+}
+
+// NewHTTP3Transport implements netx.QUICSupport.NewHTTP3Transport.
+func (quicSupport) NewHTTP3Transport(
+	config netx.Config, tlsConfig *tls.Config) netx.HTTPRoundTripper {
+	return NewHTTP3Transport(NewQUICDialer(config), tlsConfig)
+}
+
+var _ netx.QUICSupport = quicSupport{}
@@ -0,0 +1,125 @@
+package quicx_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/quicdialer"
+	"github.com/ooni/probe-engine/netx/quicx"
+	"github.com/ooni/probe-engine/netx/resolver"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+func TestNewDNSClientDoQ(t *testing.T) {
+	dnsclient, err := netx.NewDNSClient(
+		netx.Config{}, "doq://dns.adguard.com:853")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := dnsclient.Resolver.(resolver.SerialResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	txp, ok := r.Transport().(quicx.DNSOverQUIC)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	if txp.Network() != "doq" {
+		t.Fatal("not the Network we expected")
+	}
+	dnsclient.CloseIdleConnections()
+}
+
+func TestNewDNSClientDoQDNSSaver(t *testing.T) {
+	saver := new(trace.Saver)
+	dnsclient, err := netx.NewDNSClient(
+		netx.Config{ResolveSaver: saver}, "doq://dns.adguard.com:853")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := dnsclient.Resolver.(resolver.SerialResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	txp, ok := r.Transport().(resolver.SaverDNSTransport)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	if _, ok := txp.RoundTripper.(quicx.DNSOverQUIC); !ok {
+		t.Fatal("not the transport we expected")
+	}
+	dnsclient.CloseIdleConnections()
+}
+
+func TestNewDNSCLientDoQWithoutPort(t *testing.T) {
+	c, err := netx.NewDNSClientWithOverrides(
+		netx.Config{}, "doq://94.140.14.14", "", "dns.adguard.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Resolver.Address() != "94.140.14.14:853" {
+		t.Fatal("expected default port to be added")
+	}
+}
+
+func TestNewDNSClientBadDoQEndpoint(t *testing.T) {
+	_, err := netx.NewDNSClient(
+		netx.Config{}, "doq://bad:endpoint:853")
+	if err == nil || !strings.Contains(err.Error(), "too many colons in address") {
+		t.Fatal("expected error with bad endpoint")
+	}
+}
+
+func TestNewWithHTTP3(t *testing.T) {
+	txp := netx.NewHTTPTransport(netx.Config{HTTP3Enabled: true})
+	uatxp, ok := txp.(httptransport.UserAgentTransport)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	h3txp, ok := uatxp.RoundTripper.(*quicx.HTTP3Transport)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	if h3txp.TLSClientConfig.RootCAs != netx.CertPool {
+		t.Fatal("not the RootCAs we expected")
+	}
+}
+
+func TestNewQUICDialerVanilla(t *testing.T) {
+	d := quicx.NewQUICDialer(netx.Config{})
+	dnsd, ok := d.(quicdialer.DNSDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := dnsd.Resolver.(resolver.IDNAResolver); !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	ewd, ok := dnsd.Dialer.(quicdialer.ErrorWrapperDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := ewd.Dialer.(quicdialer.SystemDialer); !ok {
+		t.Fatal("not the dialer we expected")
+	}
+}
+
+func TestNewQUICDialerWithTLSSaver(t *testing.T) {
+	saver := new(trace.Saver)
+	d := quicx.NewQUICDialer(netx.Config{TLSSaver: saver})
+	dnsd, ok := d.(quicdialer.DNSDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	sd, ok := dnsd.Dialer.(quicdialer.HandshakeSaver)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if sd.Saver != saver {
+		t.Fatal("not the saver we expected")
+	}
+	if _, ok := sd.Dialer.(quicdialer.ErrorWrapperDialer); !ok {
+		t.Fatal("not the dialer we expected")
+	}
+}
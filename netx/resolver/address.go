@@ -3,6 +3,8 @@ package resolver
 import (
 	"context"
 	"net"
+
+	"github.com/miekg/dns"
 )
 
 // AddressResolver is a resolver that knows how to correctly
@@ -19,4 +21,10 @@ func (r AddressResolver) LookupHost(ctx context.Context, hostname string) ([]str
 	return r.Resolver.LookupHost(ctx, hostname)
 }
 
-var _ Resolver = AddressResolver{}
+// LookupRecords implements RecordsResolver.LookupRecords
+func (r AddressResolver) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error) {
+	return lookupRecords(ctx, r.Resolver, hostname, qtype)
+}
+
+var _ RecordsResolver = AddressResolver{}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/internal/runtimex"
 	"github.com/ooni/probe-engine/netx/errorx"
 )
@@ -68,4 +69,25 @@ func (r BogonResolver) LookupHost(ctx context.Context, hostname string) ([]strin
 	return addrs, err
 }
 
-var _ Resolver = BogonResolver{}
+// LookupRecords implements RecordsResolver.LookupRecords
+func (r BogonResolver) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error) {
+	answers, err := lookupRecords(ctx, r.Resolver, hostname, qtype)
+	for _, answer := range answers {
+		var ip net.IP
+		switch v := answer.(type) {
+		case *dns.A:
+			ip = v.A
+		case *dns.AAAA:
+			ip = v.AAAA
+		default:
+			continue
+		}
+		if IsBogon(ip.String()) == true {
+			return answers, errorx.ErrDNSBogon
+		}
+	}
+	return answers, err
+}
+
+var _ RecordsResolver = BogonResolver{}
@@ -3,8 +3,10 @@ package resolver_test
 import (
 	"context"
 	"errors"
+	"net"
 	"testing"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/netx/errorx"
 	"github.com/ooni/probe-engine/netx/resolver"
 )
@@ -50,3 +52,21 @@ func TestUnitBogonAwareResolverWithoutBogon(t *testing.T) {
 		t.Fatal("not the error we expected")
 	}
 }
+
+func TestUnitBogonAwareResolverLookupRecordsWithBogon(t *testing.T) {
+	r := resolver.BogonResolver{
+		Resolver: resolver.FakeResolver{
+			Records: []dns.RR{&dns.A{
+				Hdr: dns.RR_Header{Name: "x.org.", Rrtype: dns.TypeA},
+				A:   net.IPv4(10, 0, 0, 1),
+			}},
+		},
+	}
+	records, err := r.LookupRecords(context.Background(), "x.org", dns.TypeA)
+	if !errors.Is(err, errorx.ErrDNSBogon) {
+		t.Fatal("not the error we expected")
+	}
+	if len(records) != 1 {
+		t.Fatal("expected to see records here")
+	}
+}
@@ -2,46 +2,341 @@ package resolver
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DefaultCacheMaxEntries is the default maximum number of
+	// entries that a CacheResolver keeps in memory.
+	DefaultCacheMaxEntries = 1024
+
+	// DefaultCacheTTL is the TTL we use for the entries of a CacheResolver
+	// when the underlying resolver cannot tell us the TTL of the answers.
+	DefaultCacheTTL = 5 * time.Minute
+
+	// CacheStoreKey is the default key used to persist the cache.
+	CacheStoreKey = "dnscache.state"
 )
 
-// CacheResolver is a resolver that caches successful replies.
+// KeyValueStore is the key-value store used by CacheResolver to persist
+// its entries. It is compatible with model.KeyValueStore.
+type KeyValueStore interface {
+	Get(key string) (value []byte, err error)
+	Set(key string, value []byte) error
+}
+
+// CacheResolver is a resolver that caches successful replies. When the
+// underlying resolver supports typed lookups, we query for A and AAAA, in
+// parallel if Parallel is true, and honour the smallest TTL of the answers.
+// Otherwise, we use DefaultTTL. A ReadOnly cache does not store replies, so
+// it just uses LookupHost. The entries added using Set never expire. When
+// the cache is full, we evict the expired entries first and then the ones
+// expiring sooner. If Store is not nil, we load the cache from StoreKey on
+// first use and we write the cache back into it, if it changed, when
+// CloseIdleConnections is called.
 type CacheResolver struct {
-	ReadOnly bool
+	DefaultTTL time.Duration    // default: DefaultCacheTTL
+	Logger     Logger           // default: no logging
+	MaxEntries int              // default: DefaultCacheMaxEntries
+	Now        func() time.Time // default: time.Now
+	Parallel   bool             // default: serial A and AAAA queries
+	ReadOnly   bool
 	Resolver
-	mu    sync.Mutex
-	cache map[string][]string
+	Store    KeyValueStore // default: do not persist the cache
+	StoreKey string        // default: CacheStoreKey
+	mu       sync.Mutex
+	cache    map[string]cacheEntry
+	dirty    bool
+	loaded   bool
+}
+
+// cacheEntry is an entry in the cache. A zero Expires means that
+// the entry does not expire. This is also the persisted format.
+type cacheEntry struct {
+	Addresses []string  `json:"addresses"`
+	Expires   time.Time `json:"expires"`
 }
 
 // LookupHost implements Resolver.LookupHost
 func (r *CacheResolver) LookupHost(
 	ctx context.Context, hostname string) ([]string, error) {
 	if entry := r.Get(hostname); entry != nil {
+		r.debugf("cache: hit for %s: %+v", hostname, entry)
 		return entry, nil
 	}
-	entry, err := r.Resolver.LookupHost(ctx, hostname)
+	if r.ReadOnly {
+		return r.Resolver.LookupHost(ctx, hostname)
+	}
+	entry, ttl, err := r.lookupHost(ctx, hostname)
 	if err != nil {
 		return nil, err
 	}
-	if r.ReadOnly == false {
-		r.Set(hostname, entry)
+	if ttl > 0 {
+		r.SetWithTTL(hostname, entry, ttl)
 	}
 	return entry, nil
 }
 
+// lookupHost performs typed lookups for A and AAAA so to learn the TTL
+// of the answers. Like ParallelResolver, we issue the two queries at the
+// same time when Parallel is true. If the underlying resolver cannot
+// perform typed lookups, or they succeed without yielding any address,
+// we fall back to LookupHost.
+func (r *CacheResolver) lookupHost(
+	ctx context.Context, hostname string) ([]string, time.Duration, error) {
+	replyA, replyAAAA := r.lookupBoth(ctx, hostname)
+	if errors.Is(replyA.err, ErrLookupRecordsNotSupported) {
+		return r.lookupHostWithDefaultTTL(ctx, hostname)
+	}
+	if replyA.err != nil && replyAAAA.err != nil {
+		return nil, 0, replyA.err
+	}
+	var (
+		addrs []string
+		ttl   uint32
+		first = true
+	)
+	for _, answer := range append(replyA.answers, replyAAAA.answers...) {
+		if first || answer.Header().Ttl < ttl {
+			ttl, first = answer.Header().Ttl, false
+		}
+		switch v := answer.(type) {
+		case *dns.A:
+			addrs = append(addrs, v.A.String())
+		case *dns.AAAA:
+			addrs = append(addrs, v.AAAA.String())
+		}
+	}
+	if len(addrs) <= 0 {
+		// One of the two lookups failed and the other one did not give
+		// us any address: querying again would not tell us more.
+		if replyA.err != nil {
+			return nil, 0, replyA.err
+		}
+		if replyAAAA.err != nil {
+			return nil, 0, replyAAAA.err
+		}
+		return r.lookupHostWithDefaultTTL(ctx, hostname)
+	}
+	return addrs, time.Duration(ttl) * time.Second, nil
+}
+
+// lookupBoth performs the A and AAAA typed lookups. When not using
+// Parallel, we skip AAAA if the resolver cannot do typed lookups.
+func (r *CacheResolver) lookupBoth(
+	ctx context.Context, hostname string) (cacheResult, cacheResult) {
+	resA, resAAAA := make(chan cacheResult, 1), make(chan cacheResult, 1)
+	if !r.Parallel {
+		r.lookupRecords(ctx, hostname, dns.TypeA, resA)
+		replyA := <-resA
+		if errors.Is(replyA.err, ErrLookupRecordsNotSupported) {
+			return replyA, cacheResult{err: replyA.err}
+		}
+		r.lookupRecords(ctx, hostname, dns.TypeAAAA, resAAAA)
+		return replyA, <-resAAAA
+	}
+	go r.lookupRecords(ctx, hostname, dns.TypeA, resA)
+	go r.lookupRecords(ctx, hostname, dns.TypeAAAA, resAAAA)
+	return <-resA, <-resAAAA
+}
+
+// cacheResult is the result of a single typed lookup.
+type cacheResult struct {
+	answers []dns.RR
+	err     error
+}
+
+func (r *CacheResolver) lookupRecords(ctx context.Context,
+	hostname string, qtype uint16, out chan<- cacheResult) {
+	answers, err := lookupRecords(ctx, r.Resolver, hostname, qtype)
+	out <- cacheResult{answers: answers, err: err}
+}
+
+func (r *CacheResolver) lookupHostWithDefaultTTL(
+	ctx context.Context, hostname string) ([]string, time.Duration, error) {
+	addrs, err := r.Resolver.LookupHost(ctx, hostname)
+	return addrs, r.defaultTTL(), err
+}
+
+// LookupRecords implements RecordsResolver.LookupRecords. Typed
+// lookups are not cached and always hit the underlying resolver.
+func (r *CacheResolver) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error) {
+	return lookupRecords(ctx, r.Resolver, hostname, qtype)
+}
+
 // Get gets the currently configured entry for domain, or nil
 func (r *CacheResolver) Get(domain string) []string {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	return r.cache[domain]
+	r.load()
+	entry, found := r.cache[domain]
+	if !found {
+		return nil
+	}
+	if r.expired(entry) {
+		r.debugf("cache: entry for %s expired", domain)
+		delete(r.cache, domain)
+		return nil
+	}
+	return entry.Addresses
 }
 
-// Set allows to pre-populate the cache
+// Set allows to pre-populate the cache with entries that never expire
 func (r *CacheResolver) Set(domain string, addresses []string) {
+	r.set(domain, cacheEntry{Addresses: addresses})
+}
+
+// SetWithTTL is like Set but the entry expires after ttl
+func (r *CacheResolver) SetWithTTL(domain string, addresses []string, ttl time.Duration) {
+	r.set(domain, cacheEntry{Addresses: addresses, Expires: r.now().Add(ttl)})
+}
+
+func (r *CacheResolver) set(domain string, entry cacheEntry) {
 	r.mu.Lock()
-	if r.cache == nil {
-		r.cache = make(map[string][]string)
+	defer r.mu.Unlock()
+	r.load()
+	if _, found := r.cache[domain]; !found && len(r.cache) >= r.maxEntries() {
+		r.evict()
 	}
-	r.cache[domain] = addresses
+	r.cache[domain] = entry
+	r.dirty = true
+}
+
+// CloseIdleConnections saves the cache into the store, if it changed
+// since we last saved it, and closes the idle connections of the
+// underlying resolver, if any.
+func (r *CacheResolver) CloseIdleConnections() {
+	r.mu.Lock()
+	r.save()
 	r.mu.Unlock()
+	if closer, ok := r.Resolver.(idleConnectionsCloser); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// idleConnectionsCloser is a resolver with idle connections.
+type idleConnectionsCloser interface {
+	CloseIdleConnections()
+}
+
+// evict makes room for a new entry. It must be called with the mutex held.
+func (r *CacheResolver) evict() {
+	for domain, entry := range r.cache {
+		if r.expired(entry) {
+			r.debugf("cache: entry for %s expired", domain)
+			delete(r.cache, domain)
+		}
+	}
+	for len(r.cache) >= r.maxEntries() {
+		var victim string
+		var expires time.Time
+		for domain, entry := range r.cache {
+			if victim == "" || expiresBefore(entry.Expires, expires) {
+				victim, expires = domain, entry.Expires
+			}
+		}
+		r.debugf("cache: evicting entry for %s", victim)
+		delete(r.cache, victim)
+	}
+}
+
+// expiresBefore returns whether a expires before b, where
+// a zero time means that the entry does not expire.
+func expiresBefore(a, b time.Time) bool {
+	if a.IsZero() {
+		return false
+	}
+	return b.IsZero() || a.Before(b)
+}
+
+// load loads the cache from the store the first time it's called. It
+// must be called with the mutex held. Errors here mean starting cold.
+func (r *CacheResolver) load() {
+	if r.loaded {
+		return
+	}
+	r.loaded = true
+	r.cache = make(map[string]cacheEntry)
+	if r.Store == nil {
+		return
+	}
+	data, err := r.Store.Get(r.storeKey())
+	if err != nil {
+		return
+	}
+	var cache map[string]cacheEntry
+	if err := json.Unmarshal(data, &cache); err != nil {
+		r.debugf("cache: cannot parse persisted cache: %s", err.Error())
+		return
+	}
+	for domain, entry := range cache {
+		if len(r.cache) >= r.maxEntries() {
+			break
+		}
+		if !r.expired(entry) {
+			r.cache[domain] = entry
+		}
+	}
+	r.debugf("cache: loaded %d entries from the store", len(r.cache))
+}
+
+// save writes the cache into the store, if it changed. It must be called
+// with the mutex held. Errors here are not fatal and we just log them.
+func (r *CacheResolver) save() {
+	if r.Store == nil || !r.dirty {
+		return
+	}
+	r.dirty = false
+	data, err := json.Marshal(r.cache)
+	if err != nil {
+		r.debugf("cache: cannot serialize cache: %s", err.Error())
+		return
+	}
+	if err := r.Store.Set(r.storeKey(), data); err != nil {
+		r.debugf("cache: cannot persist cache: %s", err.Error())
+	}
+}
+
+func (r *CacheResolver) expired(entry cacheEntry) bool {
+	return !entry.Expires.IsZero() && !r.now().Before(entry.Expires)
+}
+
+func (r *CacheResolver) defaultTTL() time.Duration {
+	if r.DefaultTTL > 0 {
+		return r.DefaultTTL
+	}
+	return DefaultCacheTTL
+}
+
+func (r *CacheResolver) maxEntries() int {
+	if r.MaxEntries > 0 {
+		return r.MaxEntries
+	}
+	return DefaultCacheMaxEntries
+}
+
+func (r *CacheResolver) storeKey() string {
+	if r.StoreKey != "" {
+		return r.StoreKey
+	}
+	return CacheStoreKey
+}
+
+func (r *CacheResolver) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+func (r *CacheResolver) debugf(format string, v ...interface{}) {
+	if r.Logger != nil {
+		r.Logger.Debugf(format, v...)
+	}
 }
@@ -3,8 +3,14 @@ package resolver_test
 import (
 	"context"
 	"errors"
+	"net"
 	"testing"
+	"time"
 
+	"github.com/apex/log"
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/atomicx"
+	"github.com/ooni/probe-engine/internal/kvstore"
 	"github.com/ooni/probe-engine/netx/resolver"
 )
 
@@ -74,3 +80,295 @@ func TestUnitCacheReadonlySuccess(t *testing.T) {
 		t.Fatal("expected empty cache here")
 	}
 }
+
+func TestUnitCacheHonoursTTL(t *testing.T) {
+	now := time.Now()
+	var r resolver.Resolver = resolver.FakeResolver{
+		Records: []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: "dns.google.com.", Rrtype: dns.TypeA, Ttl: 60},
+			A:   net.IPv4(8, 8, 8, 8),
+		}, &dns.A{
+			Hdr: dns.RR_Header{Name: "dns.google.com.", Rrtype: dns.TypeA, Ttl: 30},
+			A:   net.IPv4(8, 8, 4, 4),
+		}},
+	}
+	cache := &resolver.CacheResolver{
+		Logger:   log.Log,
+		Now:      func() time.Time { return now },
+		Resolver: r,
+	}
+	addrs, err := cache.LookupHost(context.Background(), "dns.google.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fake returns the same records for A and AAAA
+	if len(addrs) != 4 || addrs[0] != "8.8.8.8" || addrs[1] != "8.8.4.4" {
+		t.Fatal("not the result we expected")
+	}
+	now = now.Add(29 * time.Second)
+	if cache.Get("dns.google.com") == nil {
+		t.Fatal("expected full cache here")
+	}
+	now = now.Add(time.Second)
+	if cache.Get("dns.google.com") != nil {
+		t.Fatal("expected the entry to be expired here")
+	}
+}
+
+func TestUnitCacheDefaultTTL(t *testing.T) {
+	now := time.Now()
+	var r resolver.Resolver = resolver.FakeResolver{
+		Result: []string{"8.8.8.8"},
+	}
+	cache := &resolver.CacheResolver{
+		DefaultTTL: time.Minute,
+		Now:        func() time.Time { return now },
+		Resolver:   r,
+	}
+	if _, err := cache.LookupHost(context.Background(), "dns.google.com"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute - time.Second)
+	if cache.Get("dns.google.com") == nil {
+		t.Fatal("expected full cache here")
+	}
+	now = now.Add(time.Second)
+	if cache.Get("dns.google.com") != nil {
+		t.Fatal("expected the entry to be expired here")
+	}
+}
+
+func TestUnitCacheZeroTTLIsNotCached(t *testing.T) {
+	var r resolver.Resolver = resolver.FakeResolver{
+		Records: []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: "dns.google.com.", Rrtype: dns.TypeA},
+			A:   net.IPv4(8, 8, 8, 8),
+		}},
+	}
+	cache := &resolver.CacheResolver{Resolver: r}
+	if _, err := cache.LookupHost(context.Background(), "dns.google.com"); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Get("dns.google.com") != nil {
+		t.Fatal("expected empty cache here")
+	}
+}
+
+func TestUnitCacheEvictsEntries(t *testing.T) {
+	now := time.Now()
+	cache := &resolver.CacheResolver{
+		Logger:     log.Log,
+		MaxEntries: 2,
+		Now:        func() time.Time { return now },
+	}
+	cache.Set("static.example.com", []string{"10.0.0.1"})
+	cache.SetWithTTL("short.example.com", []string{"10.0.0.2"}, time.Minute)
+	cache.SetWithTTL("long.example.com", []string{"10.0.0.3"}, time.Hour)
+	if cache.Get("short.example.com") != nil {
+		t.Fatal("expected the entry expiring sooner to be evicted")
+	}
+	if cache.Get("static.example.com") == nil || cache.Get("long.example.com") == nil {
+		t.Fatal("expected these entries to be in the cache")
+	}
+	now = now.Add(2 * time.Hour)
+	cache.SetWithTTL("short.example.com", []string{"10.0.0.2"}, time.Minute)
+	if cache.Get("long.example.com") != nil {
+		t.Fatal("expected the expired entry to be evicted")
+	}
+	if cache.Get("static.example.com") == nil || cache.Get("short.example.com") == nil {
+		t.Fatal("expected these entries to be in the cache")
+	}
+}
+
+func TestUnitCachePersistence(t *testing.T) {
+	now := time.Now()
+	store := kvstore.NewMemoryKeyValueStore()
+	cache := &resolver.CacheResolver{
+		Now:   func() time.Time { return now },
+		Store: store,
+	}
+	cache.SetWithTTL("dns.google.com", []string{"8.8.8.8"}, time.Minute)
+	cache.SetWithTTL("expired.example.com", []string{"10.0.0.1"}, time.Second)
+	if _, err := store.Get(resolver.CacheStoreKey); err == nil {
+		t.Fatal("expected the cache to not be saved yet")
+	}
+	cache.CloseIdleConnections()
+	now = now.Add(time.Second)
+	warm := &resolver.CacheResolver{
+		Logger: log.Log,
+		Now:    func() time.Time { return now },
+		Resolver: resolver.FakeResolver{
+			Err: errors.New("mocked error"),
+		},
+		Store: store,
+	}
+	addrs, err := warm.LookupHost(context.Background(), "dns.google.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "8.8.8.8" {
+		t.Fatal("not the result we expected")
+	}
+	if warm.Get("expired.example.com") != nil {
+		t.Fatal("expected expired entry to not be loaded")
+	}
+}
+
+func TestUnitCachePersistenceWithInvalidData(t *testing.T) {
+	store := kvstore.NewMemoryKeyValueStore()
+	if err := store.Set(resolver.CacheStoreKey, []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	cache := &resolver.CacheResolver{Logger: log.Log, Store: store}
+	if cache.Get("dns.google.com") != nil {
+		t.Fatal("expected empty cache here")
+	}
+	cache.Set("dns.google.com", []string{"8.8.8.8"})
+	cache.CloseIdleConnections()
+	warm := &resolver.CacheResolver{Store: store}
+	if warm.Get("dns.google.com") == nil {
+		t.Fatal("expected full cache here")
+	}
+}
+
+// cacheCountingTransport counts the queries and then forwards them
+// to the underlying transport. If ErrA is not nil, we fail A queries
+// using ErrA and otherwise we forward them as well.
+type cacheCountingTransport struct {
+	resolver.RoundTripper
+	ErrA    error
+	Queries *atomicx.Int64
+}
+
+func (txp cacheCountingTransport) RoundTrip(
+	ctx context.Context, query []byte) ([]byte, error) {
+	txp.Queries.Add(1)
+	msg := new(dns.Msg)
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+	if txp.ErrA != nil && msg.Question[0].Qtype == dns.TypeA {
+		return nil, txp.ErrA
+	}
+	return txp.RoundTripper.RoundTrip(ctx, query)
+}
+
+func TestUnitCacheOverParallelResolver(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	txp := cacheCountingTransport{
+		RoundTripper: qtypeTransport{
+			t: t, delay: delay, A: "8.8.8.8", AAAA: "2001:4860:4860::8888",
+		},
+		Queries: atomicx.NewInt64(),
+	}
+	cache := &resolver.CacheResolver{
+		DefaultTTL: time.Minute,
+		Parallel:   true,
+		Resolver:   resolver.NewParallelResolver(txp),
+	}
+	start := time.Now()
+	addrs, err := cache.LookupHost(context.Background(), "dns.google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= 2*delay {
+		t.Fatalf("the A and AAAA queries did not run in parallel: %s", elapsed)
+	}
+	if len(addrs) != 2 || addrs[0] != "8.8.8.8" || addrs[1] != "2001:4860:4860::8888" {
+		t.Fatal("not the result we expected")
+	}
+	if txp.Queries.Load() != 2 {
+		t.Fatal("not the number of queries we expected")
+	}
+}
+
+func TestUnitCacheOverSerialResolver(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	txp := cacheCountingTransport{
+		RoundTripper: qtypeTransport{
+			t: t, delay: delay, A: "8.8.8.8", AAAA: "2001:4860:4860::8888",
+		},
+		Queries: atomicx.NewInt64(),
+	}
+	cache := &resolver.CacheResolver{
+		DefaultTTL: time.Minute,
+		Resolver:   resolver.NewSerialResolver(txp),
+	}
+	start := time.Now()
+	addrs, err := cache.LookupHost(context.Background(), "dns.google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*delay {
+		t.Fatalf("the A and AAAA queries did not run serially: %s", elapsed)
+	}
+	if len(addrs) != 2 || addrs[0] != "8.8.8.8" || addrs[1] != "2001:4860:4860::8888" {
+		t.Fatal("not the result we expected")
+	}
+	if txp.Queries.Load() != 2 {
+		t.Fatal("not the number of queries we expected")
+	}
+}
+
+// cacheNoAAAAResolver fails A lookups with ErrA and returns no records
+// for AAAA lookups. It counts the calls to LookupHost.
+type cacheNoAAAAResolver struct {
+	resolver.FakeResolver
+	ErrA        error
+	LookupHosts *atomicx.Int64
+}
+
+func (r cacheNoAAAAResolver) LookupHost(
+	ctx context.Context, hostname string) ([]string, error) {
+	r.LookupHosts.Add(1)
+	return r.FakeResolver.LookupHost(ctx, hostname)
+}
+
+func (r cacheNoAAAAResolver) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error) {
+	if qtype == dns.TypeA {
+		return nil, r.ErrA
+	}
+	return nil, nil
+}
+
+func TestUnitCacheTypedLookupsFailure(t *testing.T) {
+	expected := errors.New("mocked error")
+	r := cacheNoAAAAResolver{
+		FakeResolver: resolver.FakeResolver{Err: expected},
+		ErrA:         expected,
+		LookupHosts:  atomicx.NewInt64(),
+	}
+	cache := &resolver.CacheResolver{Resolver: r}
+	addrs, err := cache.LookupHost(context.Background(), "dns.google")
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if addrs != nil {
+		t.Fatal("expected nil addrs here")
+	}
+	// The AAAA lookup yields no answer, which is not a reason to
+	// query again using the wrapped resolver's LookupHost.
+	if r.LookupHosts.Load() != 0 {
+		t.Fatal("expected no calls to LookupHost")
+	}
+}
+
+func TestUnitCacheReadonlyDoesNotUseTypedLookups(t *testing.T) {
+	r := cacheNoAAAAResolver{
+		FakeResolver: resolver.FakeResolver{Result: []string{"8.8.8.8"}},
+		ErrA:         errors.New("mocked error"),
+		LookupHosts:  atomicx.NewInt64(),
+	}
+	cache := &resolver.CacheResolver{Resolver: r, ReadOnly: true}
+	addrs, err := cache.LookupHost(context.Background(), "dns.google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "8.8.8.8" {
+		t.Fatal("not the result we expected")
+	}
+	if r.LookupHosts.Load() != 1 {
+		t.Fatal("expected a single call to LookupHost")
+	}
+}
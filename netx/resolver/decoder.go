@@ -4,6 +4,7 @@ import (
 	"errors"
 
 	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/errorx"
 )
 
 // The Decoder decodes a DNS reply into A or AAAA entries. It will use the
@@ -13,23 +14,26 @@ type Decoder interface {
 	Decode(qtype uint16, data []byte) ([]string, error)
 }
 
+// The RecordsDecoder is a Decoder that also decodes a DNS reply into all
+// the records contained in its answer section (e.g., the CNAME chain that
+// led to the requested records). It will return error if there are no
+// records for the requested qtype. The records include the RRSIG records,
+// if the query had the DO bit set, and DNSKEY and DS records, when querying
+// for such types.
+type RecordsDecoder interface {
+	Decoder
+	DecodeRecords(qtype uint16, data []byte) ([]dns.RR, error)
+}
+
 // MiekgDecoder uses github.com/miekg/dns to implement the Decoder.
 type MiekgDecoder struct{}
 
 // Decode implements Decoder.Decode.
 func (d MiekgDecoder) Decode(qtype uint16, data []byte) ([]string, error) {
-	reply := new(dns.Msg)
-	if err := reply.Unpack(data); err != nil {
+	reply, err := d.parseReply(data)
+	if err != nil {
 		return nil, err
 	}
-	// TODO(bassosimone): map more errors to net.DNSError names
-	switch reply.Rcode {
-	case dns.RcodeSuccess:
-	case dns.RcodeNameError:
-		return nil, errors.New("ooniresolver: no such host")
-	default:
-		return nil, errors.New("ooniresolver: query failed")
-	}
 	var addrs []string
 	for _, answer := range reply.Answer {
 		switch qtype {
@@ -46,9 +50,53 @@ func (d MiekgDecoder) Decode(qtype uint16, data []byte) ([]string, error) {
 		}
 	}
 	if len(addrs) <= 0 {
-		return nil, errors.New("ooniresolver: no response returned")
+		return nil, errorx.ErrDNSNoAnswer
 	}
 	return addrs, nil
 }
 
-var _ Decoder = MiekgDecoder{}
+// DecodeRecords implements RecordsDecoder.DecodeRecords.
+func (d MiekgDecoder) DecodeRecords(qtype uint16, data []byte) ([]dns.RR, error) {
+	reply, err := d.parseReply(data)
+	if err != nil {
+		return nil, err
+	}
+	// Make sure the reply actually contains the requested qtype, because
+	// a reply only containing a CNAME for an A query is not an answer.
+	var found bool
+	for _, answer := range reply.Answer {
+		if answer.Header().Rrtype == qtype {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errorx.ErrDNSNoAnswer
+	}
+	return reply.Answer, nil
+}
+
+func (d MiekgDecoder) parseReply(data []byte) (*dns.Msg, error) {
+	reply := new(dns.Msg)
+	if err := reply.Unpack(data); err != nil {
+		return nil, err
+	}
+	switch reply.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return nil, errorx.ErrDNSNXDOMAIN
+	case dns.RcodeServerFailure:
+		return nil, errorx.ErrDNSServfail
+	case dns.RcodeRefused:
+		return nil, errorx.ErrDNSRefused
+	case dns.RcodeNotImplemented:
+		return nil, errorx.ErrDNSNotImplemented
+	case dns.RcodeFormatError:
+		return nil, errorx.ErrDNSFormatError
+	default:
+		return nil, errors.New("ooniresolver: query failed")
+	}
+	return reply, nil
+}
+
+var _ RecordsDecoder = MiekgDecoder{}
@@ -1,10 +1,13 @@
 package resolver_test
 
 import (
+	"errors"
 	"strings"
 	"testing"
 
 	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/errorx"
 	"github.com/ooni/probe-engine/netx/resolver"
 )
 
@@ -30,9 +33,27 @@ func TestUnitDecoderNXDOMAIN(t *testing.T) {
 	}
 }
 
+func TestUnitDecoderRcodeErrors(t *testing.T) {
+	d := resolver.MiekgDecoder{}
+	for rcode, expected := range map[int]error{
+		dns.RcodeServerFailure:  errorx.ErrDNSServfail,
+		dns.RcodeRefused:        errorx.ErrDNSRefused,
+		dns.RcodeNotImplemented: errorx.ErrDNSNotImplemented,
+		dns.RcodeFormatError:    errorx.ErrDNSFormatError,
+	} {
+		data, err := d.Decode(dns.TypeA, resolver.GenReplyError(t, rcode))
+		if !errors.Is(err, expected) {
+			t.Fatal("not the error we expected", err)
+		}
+		if data != nil {
+			t.Fatal("expected nil data here")
+		}
+	}
+}
+
 func TestUnitDecoderOtherError(t *testing.T) {
 	d := resolver.MiekgDecoder{}
-	data, err := d.Decode(dns.TypeA, resolver.GenReplyError(t, dns.RcodeRefused))
+	data, err := d.Decode(dns.TypeA, resolver.GenReplyError(t, dns.RcodeYXDomain))
 	if err == nil || !strings.HasSuffix(err.Error(), "query failed") {
 		t.Fatal("not the error we expected")
 	}
@@ -41,6 +62,15 @@ func TestUnitDecoderOtherError(t *testing.T) {
 	}
 }
 
+func TestUnitDecoderNoAnswerIsWrapped(t *testing.T) {
+	d := resolver.MiekgDecoder{}
+	_, err := d.Decode(dns.TypeA, resolver.GenReplySuccess(t, dns.TypeA))
+	failure := archival.NewFailure(err)
+	if failure == nil || *failure != errorx.FailureDNSNoAnswerError {
+		t.Fatal("not the failure we expected")
+	}
+}
+
 func TestUnitDecoderNoAddress(t *testing.T) {
 	d := resolver.MiekgDecoder{}
 	data, err := d.Decode(dns.TypeA, resolver.GenReplySuccess(t, dns.TypeA))
@@ -111,3 +141,83 @@ func TestUnitDecoderUnexpectedAAAAReply(t *testing.T) {
 		t.Fatal("expected nil data here")
 	}
 }
+
+func TestUnitDecoderDecodeRecordsCNAMEChain(t *testing.T) {
+	d := resolver.MiekgDecoder{}
+	data, err := d.DecodeRecords(dns.TypeA, resolver.GenReplyRecords(t,
+		resolver.NewRR(t, "x.org. 300 IN CNAME y.org."),
+		resolver.NewRR(t, "y.org. 60 IN A 1.1.1.1"),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2 {
+		t.Fatal("expected two entries here")
+	}
+	if cname, ok := data[0].(*dns.CNAME); !ok || cname.Target != "y.org." {
+		t.Fatal("invalid first entry")
+	}
+	if a, ok := data[1].(*dns.A); !ok || a.A.String() != "1.1.1.1" {
+		t.Fatal("invalid second entry")
+	}
+}
+
+func TestUnitDecoderDecodeRecordsTXT(t *testing.T) {
+	d := resolver.MiekgDecoder{}
+	data, err := d.DecodeRecords(dns.TypeTXT, resolver.GenReplyRecords(t,
+		resolver.NewRR(t, `x.org. 0 IN TXT "130.192.91.211"`),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txt, ok := data[0].(*dns.TXT); !ok || txt.Txt[0] != "130.192.91.211" {
+		t.Fatal("invalid TXT entry")
+	}
+}
+
+func TestUnitDecoderDecodeRecordsKeepsDNSSECRecords(t *testing.T) {
+	d := resolver.MiekgDecoder{}
+	data, err := d.DecodeRecords(dns.TypeDNSKEY, resolver.GenReplyRecords(t,
+		resolver.NewRR(t, "org. 3600 IN DNSKEY 257 3 13 "+
+			"aGVsbG8gd29ybGQgaGVsbG8gd29ybGQgaGVsbG8gd29ybGQgaGVsbG8gd29ybGQgaGVsbG8gd29ybGQgaGVsbG8="),
+		resolver.NewRR(t, "org. 3600 IN RRSIG DNSKEY 13 1 3600 "+
+			"20300101000000 20200101000000 12345 org. aGVsbG8gd29ybGQ="),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2 {
+		t.Fatal("expected two entries here")
+	}
+	if _, ok := data[0].(*dns.DNSKEY); !ok {
+		t.Fatal("invalid first entry")
+	}
+	if sig, ok := data[1].(*dns.RRSIG); !ok || sig.TypeCovered != dns.TypeDNSKEY {
+		t.Fatal("invalid second entry")
+	}
+}
+
+func TestUnitDecoderDecodeRecordsOnlyCNAME(t *testing.T) {
+	d := resolver.MiekgDecoder{}
+	data, err := d.DecodeRecords(dns.TypeAAAA, resolver.GenReplyRecords(t,
+		resolver.NewRR(t, "x.org. 300 IN CNAME y.org."),
+	))
+	if err == nil || !strings.HasSuffix(err.Error(), "no response returned") {
+		t.Fatal("not the error we expected")
+	}
+	if data != nil {
+		t.Fatal("expected nil data here")
+	}
+}
+
+func TestUnitDecoderDecodeRecordsNXDOMAIN(t *testing.T) {
+	d := resolver.MiekgDecoder{}
+	data, err := d.DecodeRecords(
+		dns.TypeNS, resolver.GenReplyError(t, dns.RcodeNameError))
+	if err == nil || !strings.HasSuffix(err.Error(), "no such host") {
+		t.Fatal("not the error we expected")
+	}
+	if data != nil {
+		t.Fatal("expected nil data here")
+	}
+}
@@ -3,7 +3,10 @@ package resolver
 import (
 	"context"
 	"net"
+	"strings"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
 // Dialer is the network dialer interface assumed by this package.
@@ -13,8 +16,9 @@ type Dialer interface {
 
 // DNSOverUDP is a DNS over UDP RoundTripper.
 type DNSOverUDP struct {
-	dialer  Dialer
-	address string
+	dialer            Dialer
+	address           string
+	lateRepliesWindow time.Duration
 }
 
 // NewDNSOverUDP creates a DNSOverUDP instance.
@@ -22,28 +26,135 @@ func NewDNSOverUDP(dialer Dialer, address string) DNSOverUDP {
 	return DNSOverUDP{dialer: dialer, address: address}
 }
 
-// RoundTrip implements RoundTripper.RoundTrip.
+// NewDNSOverUDPWithLateRepliesWindow creates a DNSOverUDP instance that
+// keeps the socket open for the given window after receiving the first
+// reply, to also collect replies arriving later. This is useful to detect
+// on-path DNS injection, where the injected reply wins the race with
+// the legitimate reply, which would otherwise be discarded.
+func NewDNSOverUDPWithLateRepliesWindow(
+	dialer Dialer, address string, window time.Duration) DNSOverUDP {
+	return DNSOverUDP{dialer: dialer, address: address, lateRepliesWindow: window}
+}
+
+// DNSOverUDPReply is a reply received by DNSOverUDP.
+type DNSOverUDPReply struct {
+	// Data contains the raw reply.
+	Data []byte
+
+	// Time is the time when we received the reply.
+	Time time.Time
+}
+
+// RoundTrip implements RoundTripper.RoundTrip. We return the first reply
+// and we do not wait for late replies, even when configured to do so.
 func (t DNSOverUDP) RoundTrip(ctx context.Context, query []byte) ([]byte, error) {
-	conn, err := t.dialer.DialContext(ctx, "udp", t.address)
+	conn, reply, err := t.roundTrip(ctx, query)
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
+	conn.Close()
+	return reply.Data, nil
+}
+
+// RoundTripWithLateReplies is like RoundTrip but also returns a channel
+// where we post the replies to query received within the late replies window
+// following the first reply. We return as soon as we receive the first reply
+// and we read the late replies in the background. We close the channel when
+// the window expires and the caller must drain it. When we're not configured
+// to wait for late replies, the channel is nil. We drop the datagrams
+// whose ID or question do not match the ones of query, e.g., the replies to
+// a previous query that used the same local port, which would otherwise look
+// like replies injected by a middlebox.
+func (t DNSOverUDP) RoundTripWithLateReplies(ctx context.Context,
+	query []byte) (DNSOverUDPReply, <-chan DNSOverUDPReply, error) {
+	conn, reply, err := t.roundTrip(ctx, query)
+	if err != nil {
+		return DNSOverUDPReply{}, nil, err
+	}
+	if t.lateRepliesWindow <= 0 {
+		conn.Close()
+		return reply, nil, nil
+	}
+	late := make(chan DNSOverUDPReply)
+	go t.readLateReplies(conn, query, late)
+	return reply, late, nil
+}
+
+func (t DNSOverUDP) roundTrip(
+	ctx context.Context, query []byte) (net.Conn, DNSOverUDPReply, error) {
+	conn, err := t.dialer.DialContext(ctx, "udp", t.address)
+	if err != nil {
+		return nil, DNSOverUDPReply{}, err
+	}
 	// Use five seconds timeout like Bionic does. See
 	// https://labs.ripe.net/Members/baptiste_jonglez_1/persistent-dns-connections-for-reliability-and-performance
 	if err = conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
-		return nil, err
+		conn.Close()
+		return nil, DNSOverUDPReply{}, err
 	}
 	if _, err = conn.Write(query); err != nil {
-		return nil, err
+		conn.Close()
+		return nil, DNSOverUDPReply{}, err
 	}
-	reply := make([]byte, 1<<17)
-	var n int
-	n, err = conn.Read(reply)
+	data, err := readReplyTo(conn, query)
 	if err != nil {
-		return nil, err
+		conn.Close()
+		return nil, DNSOverUDPReply{}, err
+	}
+	return conn, DNSOverUDPReply{Data: data, Time: time.Now()}, nil
+}
+
+func (t DNSOverUDP) readLateReplies(
+	conn net.Conn, query []byte, out chan<- DNSOverUDPReply) {
+	defer close(out)
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(t.lateRepliesWindow)); err != nil {
+		return
+	}
+	for {
+		data, err := readReplyTo(conn, query)
+		if err != nil {
+			return // most likely the window has expired
+		}
+		out <- DNSOverUDPReply{Data: data, Time: time.Now()}
+	}
+}
+
+// readReplyTo reads datagrams from conn until it finds a reply to query. If
+// we cannot parse query, we return the first datagram, since we cannot know
+// whether a datagram is a reply to query.
+func readReplyTo(conn net.Conn, query []byte) ([]byte, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(query); err != nil || len(msg.Question) != 1 {
+		msg = nil
+	}
+	for {
+		reply := make([]byte, 1<<17)
+		n, err := conn.Read(reply)
+		if err != nil {
+			return nil, err
+		}
+		if msg == nil || isReplyTo(msg, reply[:n]) {
+			return reply[:n], nil
+		}
+	}
+}
+
+// isReplyTo returns whether data is a reply having the same ID and the
+// same question of query. An injected reply also passes this check, since
+// a middlebox must copy the ID and the question to get the reply accepted.
+func isReplyTo(query *dns.Msg, data []byte) bool {
+	reply := new(dns.Msg)
+	if err := reply.Unpack(data); err != nil {
+		// Let the decoder deal with replies we cannot parse, e.g., truncated
+		// ones, as long as they have the ID of the query.
+		return len(data) >= 2 && int(data[0])<<8|int(data[1]) == int(query.Id)
+	}
+	if !reply.Response || reply.Id != query.Id || len(reply.Question) != 1 {
+		return false
 	}
-	return reply[:n], nil
+	q, r := query.Question[0], reply.Question[0]
+	return q.Qtype == r.Qtype && q.Qclass == r.Qclass && strings.EqualFold(q.Name, r.Name)
 }
 
 // RequiresPadding returns false for UDP according to RFC8467
@@ -1,11 +1,14 @@
 package resolver_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"net"
 	"testing"
+	"time"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/netx/resolver"
 )
 
@@ -105,3 +108,136 @@ func TestUnitDNSOverUDPTransportOK(t *testing.T) {
 		t.Fatal("invalid Address")
 	}
 }
+
+// listenAndReplyMany starts a local UDP server that answers the first
+// query it receives with all the given replies, in order, emulating
+// what happens when there is on-path DNS injection.
+func listenAndReplyMany(t *testing.T, replies ...[]byte) net.PacketConn {
+	pconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buffer := make([]byte, 1<<17)
+		_, addr, err := pconn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		for _, reply := range replies {
+			pconn.WriteTo(reply, addr)
+		}
+	}()
+	return pconn
+}
+
+// readAllReplies returns the first reply followed by the late replies.
+func readAllReplies(first resolver.DNSOverUDPReply,
+	late <-chan resolver.DNSOverUDPReply) []resolver.DNSOverUDPReply {
+	replies := []resolver.DNSOverUDPReply{first}
+	for reply := range late {
+		replies = append(replies, reply)
+	}
+	return replies
+}
+
+func TestUnitDNSOverUDPRoundTripWithLateRepliesLateReplies(t *testing.T) {
+	first := resolver.GenReplySuccess(t, dns.TypeA, "10.10.34.35")
+	second := resolver.GenReplySuccess(t, dns.TypeA, "93.184.216.34")
+	pconn := listenAndReplyMany(t, first, second)
+	defer pconn.Close()
+	const window = 500 * time.Millisecond
+	txp := resolver.NewDNSOverUDPWithLateRepliesWindow(
+		&net.Dialer{}, pconn.LocalAddr().String(), window)
+	start := time.Now()
+	reply, late, err := txp.RoundTripWithLateReplies(context.Background(), []byte("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) >= window {
+		t.Fatal("we waited for the late replies window")
+	}
+	if !reply.Time.After(start) {
+		t.Fatal("the time of the first reply is wrong")
+	}
+	replies := readAllReplies(reply, late)
+	if len(replies) != 2 {
+		t.Fatal("unexpected number of replies", len(replies))
+	}
+	if !bytes.Equal(replies[0].Data, first) || !bytes.Equal(replies[1].Data, second) {
+		t.Fatal("not the replies we expected")
+	}
+	if replies[1].Time.Before(replies[0].Time) {
+		t.Fatal("replies are not sorted by arrival time")
+	}
+}
+
+func TestUnitDNSOverUDPRoundTripWithLateRepliesReturnsFirst(t *testing.T) {
+	first := resolver.GenReplySuccess(t, dns.TypeA, "10.10.34.35")
+	second := resolver.GenReplySuccess(t, dns.TypeA, "93.184.216.34")
+	pconn := listenAndReplyMany(t, first, second)
+	defer pconn.Close()
+	const window = 500 * time.Millisecond
+	txp := resolver.NewDNSOverUDPWithLateRepliesWindow(
+		&net.Dialer{}, pconn.LocalAddr().String(), window)
+	start := time.Now()
+	data, err := txp.RoundTrip(context.Background(), []byte("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) >= window {
+		t.Fatal("we waited for the late replies window")
+	}
+	if !bytes.Equal(data, first) {
+		t.Fatal("not the reply we expected")
+	}
+}
+
+func TestUnitDNSOverUDPRoundTripWithLateRepliesWithoutWindow(t *testing.T) {
+	first := resolver.GenReplySuccess(t, dns.TypeA, "10.10.34.35")
+	second := resolver.GenReplySuccess(t, dns.TypeA, "93.184.216.34")
+	pconn := listenAndReplyMany(t, first, second)
+	defer pconn.Close()
+	txp := resolver.NewDNSOverUDP(&net.Dialer{}, pconn.LocalAddr().String())
+	reply, late, err := txp.RoundTripWithLateReplies(context.Background(), []byte("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply.Data, first) {
+		t.Fatal("not the reply we expected")
+	}
+	if late != nil {
+		t.Fatal("expected nil late replies channel")
+	}
+}
+
+func TestUnitDNSOverUDPRoundTripWithLateRepliesDropsUnrelatedDatagrams(t *testing.T) {
+	query, err := resolver.MiekgEncoder{}.Encode("x.org", dns.TypeA, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := resolver.GenReplyTo(t, query, resolver.NewRR(t, "x.org. 0 IN A 10.10.34.35"))
+	second := resolver.GenReplyTo(t, query, resolver.NewRR(t, "x.org. 0 IN A 93.184.216.34"))
+	otherID := append([]byte{}, first...)
+	otherID[0], otherID[1] = ^query[0], ^query[1]
+	otherQuery, err := resolver.MiekgEncoder{}.Encode("y.org", dns.TypeA, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(otherQuery[:2], query[:2]) // same ID, different question
+	otherQuestion := resolver.GenReplyTo(t, otherQuery)
+	pconn := listenAndReplyMany(t, otherID, first, otherQuestion, second, otherID)
+	defer pconn.Close()
+	txp := resolver.NewDNSOverUDPWithLateRepliesWindow(
+		&net.Dialer{}, pconn.LocalAddr().String(), 500*time.Millisecond)
+	reply, late, err := txp.RoundTripWithLateReplies(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replies := readAllReplies(reply, late)
+	if len(replies) != 2 {
+		t.Fatal("unexpected number of replies", len(replies))
+	}
+	if !bytes.Equal(replies[0].Data, first) || !bytes.Equal(replies[1].Data, second) {
+		t.Fatal("not the replies we expected")
+	}
+}
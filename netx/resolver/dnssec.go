@@ -0,0 +1,365 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+const (
+	// DNSSECStatusSecure means that we validated the chain of trust
+	// from the configured trust anchors down to the answer.
+	DNSSECStatusSecure = "secure"
+
+	// DNSSECStatusUnsigned means that the answer contained no RRSIG
+	// records. Either the zone is not signed or someone stripped the
+	// signatures; comparing with other resolvers helps to tell.
+	DNSSECStatusUnsigned = "unsigned"
+
+	// DNSSECStatusBogus means that the answer contained signatures
+	// but we could not validate them, or the chain of trust.
+	DNSSECStatusBogus = "bogus"
+
+	// DNSSECStatusIndeterminate means that we could not fetch the
+	// DNSKEY or DS records required to validate the answer.
+	DNSSECStatusIndeterminate = "indeterminate"
+)
+
+// rootTrustAnchor is the DS of the root zone KSK-2017.
+const rootTrustAnchor = ". 0 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"
+
+// dnssecMaxDepth is the maximum length of the chain of trust.
+const dnssecMaxDepth = 16
+
+var (
+	errDNSSECBogus         = errors.New("dnssec: bogus")
+	errDNSSECIndeterminate = errors.New("dnssec: indeterminate")
+)
+
+// DNSSECValidator validates the DNSSEC signatures of answers. It uses
+// the resolver passed to Validate to fetch DNSKEY and DS records, hence
+// such resolver must send queries with the DO bit set.
+type DNSSECValidator struct {
+	// Now returns the current time. If nil, we use time.Now.
+	Now func() time.Time
+
+	// TrustAnchors contains the DS records of the root zone. If
+	// empty, we use the root zone KSK-2017.
+	TrustAnchors []*dns.DS
+}
+
+// Validate returns the DNSSEC status of the given answers, which should
+// be the result of a LookupRecords with the DO bit set.
+func (v DNSSECValidator) Validate(
+	ctx context.Context, r RecordsResolver, answers []dns.RR) string {
+	rrsets, sigs := splitRRSets(answers)
+	if len(sigs) <= 0 {
+		return DNSSECStatusUnsigned
+	}
+	for _, rrset := range rrsets {
+		if err := v.verifyRRSet(ctx, r, rrset, sigs, 0); err != nil {
+			if errors.Is(err, errDNSSECIndeterminate) {
+				return DNSSECStatusIndeterminate
+			}
+			return DNSSECStatusBogus
+		}
+	}
+	return DNSSECStatusSecure
+}
+
+// verifyRRSet verifies that at least one signature in sigs covers rrset
+// and that its signer zone keys are trusted.
+func (v DNSSECValidator) verifyRRSet(ctx context.Context, r RecordsResolver,
+	rrset []dns.RR, sigs []*dns.RRSIG, depth int) error {
+	if depth >= dnssecMaxDepth {
+		return errDNSSECBogus
+	}
+	header := rrset[0].Header()
+	err := errDNSSECBogus // in case there are no covering signatures
+	for _, sig := range sigs {
+		if sig.TypeCovered != header.Rrtype ||
+			!strings.EqualFold(sig.Header().Name, header.Name) {
+			continue
+		}
+		var keys []*dns.DNSKEY
+		if keys, err = v.zoneKeys(ctx, r, sig.SignerName, depth); err != nil {
+			continue
+		}
+		if err = v.verifyWithKeys(sig, keys, rrset); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// zoneKeys fetches the DNSKEYs of zone and ensures they are trusted,
+// i.e., that they are signed by a key matching either a trust anchor
+// or a DS record that we can in turn validate.
+func (v DNSSECValidator) zoneKeys(ctx context.Context, r RecordsResolver,
+	zone string, depth int) ([]*dns.DNSKEY, error) {
+	answers, err := r.LookupRecords(ctx, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, errDNSSECIndeterminate
+	}
+	rrsets, sigs := splitRRSets(answers)
+	var (
+		keys   []*dns.DNSKEY
+		keyset []dns.RR
+	)
+	for _, rrset := range rrsets {
+		for _, rr := range rrset {
+			if key, ok := rr.(*dns.DNSKEY); ok {
+				keys = append(keys, key)
+				keyset = append(keyset, rr)
+			}
+		}
+	}
+	if len(keys) <= 0 {
+		return nil, errDNSSECBogus
+	}
+	ds, err := v.delegationSigners(ctx, r, zone, depth)
+	if err != nil {
+		return nil, err
+	}
+	var trusted []*dns.DNSKEY
+	for _, key := range keys {
+		for _, d := range ds {
+			if key.KeyTag() != d.KeyTag || key.Algorithm != d.Algorithm {
+				continue
+			}
+			if computed := key.ToDS(d.DigestType); computed != nil &&
+				strings.EqualFold(computed.Digest, d.Digest) {
+				trusted = append(trusted, key)
+			}
+		}
+	}
+	// The DNSKEY RRset must be signed by one of the trusted keys.
+	for _, sig := range sigs {
+		if sig.TypeCovered == dns.TypeDNSKEY &&
+			v.verifyWithKeys(sig, trusted, keyset) == nil {
+			return keys, nil
+		}
+	}
+	return nil, errDNSSECBogus
+}
+
+// delegationSigners returns the trusted DS records for zone.
+func (v DNSSECValidator) delegationSigners(ctx context.Context, r RecordsResolver,
+	zone string, depth int) ([]*dns.DS, error) {
+	if zone == "." {
+		return v.trustAnchors(), nil
+	}
+	answers, err := r.LookupRecords(ctx, zone, dns.TypeDS)
+	if err != nil {
+		return nil, errDNSSECIndeterminate
+	}
+	rrsets, sigs := splitRRSets(answers)
+	var out []*dns.DS
+	for _, rrset := range rrsets {
+		if rrset[0].Header().Rrtype != dns.TypeDS {
+			continue
+		}
+		if err := v.verifyRRSet(ctx, r, rrset, sigs, depth+1); err != nil {
+			return nil, err
+		}
+		for _, rr := range rrset {
+			out = append(out, rr.(*dns.DS))
+		}
+	}
+	if len(out) <= 0 {
+		return nil, errDNSSECBogus
+	}
+	return out, nil
+}
+
+func (v DNSSECValidator) verifyWithKeys(
+	sig *dns.RRSIG, keys []*dns.DNSKEY, rrset []dns.RR) error {
+	if !sig.ValidityPeriod(v.now()) {
+		return errDNSSECBogus
+	}
+	for _, key := range keys {
+		if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+			continue
+		}
+		if sig.Verify(key, rrset) == nil {
+			return nil
+		}
+	}
+	return errDNSSECBogus
+}
+
+func (v DNSSECValidator) now() time.Time {
+	if v.Now != nil {
+		return v.Now()
+	}
+	return time.Now()
+}
+
+func (v DNSSECValidator) trustAnchors() []*dns.DS {
+	if len(v.TrustAnchors) > 0 {
+		return v.TrustAnchors
+	}
+	rr, err := dns.NewRR(rootTrustAnchor)
+	if err != nil {
+		return nil // should not happen
+	}
+	return []*dns.DS{rr.(*dns.DS)}
+}
+
+// splitRRSets groups the records in answers by owner name and
+// type, and separately returns the RRSIG records.
+func splitRRSets(answers []dns.RR) (rrsets [][]dns.RR, sigs []*dns.RRSIG) {
+	index := make(map[string]int)
+	for _, rr := range answers {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs = append(sigs, sig)
+			continue
+		}
+		header := rr.Header()
+		key := strings.ToLower(header.Name) + " " + dns.TypeToString[header.Rrtype]
+		idx, found := index[key]
+		if !found {
+			idx = len(rrsets)
+			index[key] = idx
+			rrsets = append(rrsets, nil)
+		}
+		rrsets[idx] = append(rrsets[idx], rr)
+	}
+	return
+}
+
+// DNSSECResolver is a resolver that validates the DNSSEC signatures
+// of answers and saves the result as "dnssec_validation_done" events.
+// The underlying resolver must be able to perform typed lookups and
+// should set the DO bit (see MiekgEncoder.DNSSECOK). When the
+// underlying resolver cannot perform typed lookups, this resolver
+// just forwards LookupHost calls without validating.
+type DNSSECResolver struct {
+	Resolver
+	Saver     trace.Sink
+	Validator DNSSECValidator
+}
+
+// LookupHost implements Resolver.LookupHost. We send the A and the
+// AAAA queries in parallel using LookupRecords, so that we can validate
+// them. The two validations share the DNSKEY and DS records.
+func (r DNSSECResolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	rr, ok := r.Resolver.(RecordsResolver)
+	if !ok {
+		return r.Resolver.LookupHost(ctx, hostname)
+	}
+	cache := newDNSSECRecordsCache(rr)
+	resA, resAAAA := make(chan parallelResult), make(chan parallelResult)
+	go r.lookupHost(ctx, cache, hostname, dns.TypeA, resA)
+	go r.lookupHost(ctx, cache, hostname, dns.TypeAAAA, resAAAA)
+	replyA, replyAAAA := <-resA, <-resAAAA
+	if replyA.err != nil && replyAAAA.err != nil {
+		return nil, replyA.err
+	}
+	var addrs []string
+	addrs = append(addrs, replyA.addrs...)
+	addrs = append(addrs, replyAAAA.addrs...)
+	return addrs, nil
+}
+
+func (r DNSSECResolver) lookupHost(ctx context.Context, cache *dnssecRecordsCache,
+	hostname string, qtype uint16, out chan<- parallelResult) {
+	answers, err := r.lookupRecords(ctx, cache, hostname, qtype)
+	var addrs []string
+	for _, answer := range answers {
+		switch v := answer.(type) {
+		case *dns.A:
+			addrs = append(addrs, v.A.String())
+		case *dns.AAAA:
+			addrs = append(addrs, v.AAAA.String())
+		}
+	}
+	out <- parallelResult{addrs: addrs, err: err}
+}
+
+// LookupRecords implements RecordsResolver.LookupRecords. We do not
+// fail when the validation fails, rather we save its status.
+func (r DNSSECResolver) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error) {
+	rr, ok := r.Resolver.(RecordsResolver)
+	if !ok {
+		return nil, ErrLookupRecordsNotSupported
+	}
+	return r.lookupRecords(ctx, newDNSSECRecordsCache(rr), hostname, qtype)
+}
+
+func (r DNSSECResolver) lookupRecords(ctx context.Context,
+	cache *dnssecRecordsCache, hostname string, qtype uint16) ([]dns.RR, error) {
+	answers, err := cache.LookupRecords(ctx, hostname, qtype)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	status := r.Validator.Validate(ctx, cache, answers)
+	stop := time.Now()
+	if r.Saver != nil {
+		r.Saver.Write(trace.Event{
+			DNSQueryType: dns.TypeToString[qtype],
+			DNSSECStatus: status,
+			Duration:     stop.Sub(start),
+			Hostname:     hostname,
+			Name:         "dnssec_validation_done",
+			Time:         stop,
+		})
+	}
+	return answers, nil
+}
+
+// dnssecRecordsCache is a RecordsResolver caching the DNSKEY and DS
+// records, which we would otherwise fetch again for each RRset we
+// validate. We only use it for the duration of a lookup, hence we do
+// not care about the TTL. When several goroutines need the same
+// records, we only send the query once and they share the result.
+type dnssecRecordsCache struct {
+	RecordsResolver
+	entries map[string]*dnssecRecordsCacheEntry
+	mu      sync.Mutex
+}
+
+type dnssecRecordsCacheEntry struct {
+	answers []dns.RR
+	done    chan interface{}
+	err     error
+}
+
+func newDNSSECRecordsCache(r RecordsResolver) *dnssecRecordsCache {
+	return &dnssecRecordsCache{
+		RecordsResolver: r,
+		entries:         make(map[string]*dnssecRecordsCacheEntry),
+	}
+}
+
+// LookupRecords implements RecordsResolver.LookupRecords.
+func (c *dnssecRecordsCache) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error) {
+	if qtype != dns.TypeDNSKEY && qtype != dns.TypeDS {
+		return c.RecordsResolver.LookupRecords(ctx, hostname, qtype)
+	}
+	key := strings.ToLower(dns.Fqdn(hostname)) + " " + dns.TypeToString[qtype]
+	c.mu.Lock()
+	entry, found := c.entries[key]
+	if !found {
+		entry = &dnssecRecordsCacheEntry{done: make(chan interface{})}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+	if !found {
+		entry.answers, entry.err = c.RecordsResolver.LookupRecords(ctx, hostname, qtype)
+		close(entry.done)
+	}
+	<-entry.done
+	return entry.answers, entry.err
+}
+
+var _ RecordsResolver = DNSSECResolver{}
+var _ RecordsResolver = &dnssecRecordsCache{}
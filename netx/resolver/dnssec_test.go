@@ -0,0 +1,325 @@
+package resolver_test
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/resolver"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+// zoneResolver is a RecordsResolver returning the records we have
+// stored for the queried name and qtype, or ErrDNSNoAnswer.
+type zoneResolver struct {
+	resolver.FakeResolver
+	records map[string][]dns.RR
+}
+
+func (r zoneResolver) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error) {
+	rrs, found := r.records[dns.Fqdn(hostname)+" "+dns.TypeToString[qtype]]
+	if !found {
+		return nil, errors.New("dns: no response returned")
+	}
+	return rrs, nil
+}
+
+func (r zoneResolver) add(qtype uint16, rrs ...dns.RR) {
+	key := rrs[0].Header().Name + " " + dns.TypeToString[qtype]
+	r.records[key] = append(r.records[key], rrs...)
+}
+
+// signedZone is a zone with its key.
+type signedZone struct {
+	name string
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newSignedZone(t *testing.T, name string) signedZone {
+	key := &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257, // KSK
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signedZone{name: name, key: key, priv: priv.(crypto.Signer)}
+}
+
+func (z signedZone) sign(t *testing.T, rrset ...dns.RR) *dns.RRSIG {
+	sig := &dns.RRSIG{
+		Hdr: dns.RR_Header{
+			Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG,
+			Class: dns.ClassINET, Ttl: rrset[0].Header().Ttl},
+		Algorithm:  z.key.Algorithm,
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+	}
+	if err := sig.Sign(z.priv, rrset); err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+// newSignedChain creates the chain of trust from the root down to x.org
+// and returns the resolver, the trust anchor, and the x.org zone.
+func newSignedChain(t *testing.T) (zoneResolver, *dns.DS, signedZone) {
+	reso := zoneResolver{records: make(map[string][]dns.RR)}
+	root := newSignedZone(t, ".")
+	org := newSignedZone(t, "org.")
+	xorg := newSignedZone(t, "x.org.")
+	parent := root
+	for _, zone := range []signedZone{root, org, xorg} {
+		reso.add(dns.TypeDNSKEY, zone.key, zone.sign(t, zone.key))
+		if zone.name != "." {
+			ds := zone.key.ToDS(dns.SHA256)
+			reso.add(dns.TypeDS, ds, parent.sign(t, ds))
+		}
+		parent = zone
+	}
+	return reso, root.key.ToDS(dns.SHA256), xorg
+}
+
+func newA(name, addr string) *dns.A {
+	return &dns.A{
+		Hdr: dns.RR_Header{
+			Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A: net.ParseIP(addr),
+	}
+}
+
+func TestUnitDNSSECValidatorSecure(t *testing.T) {
+	reso, anchor, xorg := newSignedChain(t)
+	a := newA("x.org.", "10.0.0.1")
+	answers := []dns.RR{a, xorg.sign(t, a)}
+	v := resolver.DNSSECValidator{TrustAnchors: []*dns.DS{anchor}}
+	if status := v.Validate(context.Background(), reso, answers); status != resolver.DNSSECStatusSecure {
+		t.Fatal("unexpected status", status)
+	}
+}
+
+func TestUnitDNSSECValidatorUnsigned(t *testing.T) {
+	reso, anchor, _ := newSignedChain(t)
+	answers := []dns.RR{newA("x.org.", "10.0.0.1")}
+	v := resolver.DNSSECValidator{TrustAnchors: []*dns.DS{anchor}}
+	if status := v.Validate(context.Background(), reso, answers); status != resolver.DNSSECStatusUnsigned {
+		t.Fatal("unexpected status", status)
+	}
+}
+
+func TestUnitDNSSECValidatorTamperedAnswer(t *testing.T) {
+	reso, anchor, xorg := newSignedChain(t)
+	a := newA("x.org.", "10.0.0.1")
+	sig := xorg.sign(t, a)
+	a.A = net.ParseIP("10.10.34.35") // emulate on path tampering
+	v := resolver.DNSSECValidator{TrustAnchors: []*dns.DS{anchor}}
+	status := v.Validate(context.Background(), reso, []dns.RR{a, sig})
+	if status != resolver.DNSSECStatusBogus {
+		t.Fatal("unexpected status", status)
+	}
+}
+
+func TestUnitDNSSECValidatorWrongTrustAnchor(t *testing.T) {
+	reso, _, xorg := newSignedChain(t)
+	a := newA("x.org.", "10.0.0.1")
+	other := newSignedZone(t, ".")
+	v := resolver.DNSSECValidator{
+		TrustAnchors: []*dns.DS{other.key.ToDS(dns.SHA256)}}
+	status := v.Validate(context.Background(), reso, []dns.RR{a, xorg.sign(t, a)})
+	if status != resolver.DNSSECStatusBogus {
+		t.Fatal("unexpected status", status)
+	}
+}
+
+func TestUnitDNSSECValidatorExpiredSignature(t *testing.T) {
+	reso, anchor, xorg := newSignedChain(t)
+	a := newA("x.org.", "10.0.0.1")
+	v := resolver.DNSSECValidator{
+		Now: func() time.Time {
+			return time.Now().Add(24 * time.Hour)
+		},
+		TrustAnchors: []*dns.DS{anchor},
+	}
+	status := v.Validate(context.Background(), reso, []dns.RR{a, xorg.sign(t, a)})
+	if status != resolver.DNSSECStatusBogus {
+		t.Fatal("unexpected status", status)
+	}
+}
+
+func TestUnitDNSSECValidatorIndeterminate(t *testing.T) {
+	reso, anchor, xorg := newSignedChain(t)
+	delete(reso.records, "org. DNSKEY") // as if the query failed
+	a := newA("x.org.", "10.0.0.1")
+	v := resolver.DNSSECValidator{TrustAnchors: []*dns.DS{anchor}}
+	status := v.Validate(context.Background(), reso, []dns.RR{a, xorg.sign(t, a)})
+	if status != resolver.DNSSECStatusIndeterminate {
+		t.Fatal("unexpected status", status)
+	}
+}
+
+func TestUnitDNSSECResolverLookupRecords(t *testing.T) {
+	reso, anchor, xorg := newSignedChain(t)
+	a := newA("x.org.", "10.0.0.1")
+	reso.add(dns.TypeA, a, xorg.sign(t, a))
+	saver := &trace.Saver{}
+	r := resolver.DNSSECResolver{
+		Resolver:  reso,
+		Saver:     saver,
+		Validator: resolver.DNSSECValidator{TrustAnchors: []*dns.DS{anchor}},
+	}
+	answers, err := r.LookupRecords(context.Background(), "x.org", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answers) != 2 {
+		t.Fatal("unexpected number of answers")
+	}
+	ev := saver.Read()
+	if len(ev) != 1 {
+		t.Fatal("unexpected number of events")
+	}
+	if ev[0].Name != "dnssec_validation_done" || ev[0].Hostname != "x.org" {
+		t.Fatal("unexpected event")
+	}
+	if ev[0].DNSQueryType != "A" || ev[0].DNSSECStatus != resolver.DNSSECStatusSecure {
+		t.Fatal("unexpected validation result")
+	}
+}
+
+func TestUnitDNSSECResolverLookupHost(t *testing.T) {
+	reso, anchor, xorg := newSignedChain(t)
+	a := newA("x.org.", "10.0.0.1")
+	reso.add(dns.TypeA, a, xorg.sign(t, a))
+	saver := &trace.Saver{}
+	r := resolver.DNSSECResolver{
+		Resolver:  reso,
+		Saver:     saver,
+		Validator: resolver.DNSSECValidator{TrustAnchors: []*dns.DS{anchor}},
+	}
+	addrs, err := r.LookupHost(context.Background(), "x.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "10.0.0.1" {
+		t.Fatal("not the addresses we expected")
+	}
+	ev := saver.Read()
+	if len(ev) != 1 || ev[0].DNSQueryType != "A" {
+		// The AAAA query fails, so we don't validate it
+		t.Fatal("unexpected events")
+	}
+}
+
+// countingResolver is a zoneResolver counting the queries.
+type countingResolver struct {
+	zoneResolver
+	counts map[string]int
+	mu     *sync.Mutex
+}
+
+func (r countingResolver) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error) {
+	r.mu.Lock()
+	r.counts[dns.Fqdn(hostname)+" "+dns.TypeToString[qtype]]++
+	r.mu.Unlock()
+	return r.zoneResolver.LookupRecords(ctx, hostname, qtype)
+}
+
+func TestUnitDNSSECResolverLookupHostSharesKeys(t *testing.T) {
+	reso, anchor, xorg := newSignedChain(t)
+	a := newA("x.org.", "10.0.0.1")
+	reso.add(dns.TypeA, a, xorg.sign(t, a))
+	aaaa := &dns.AAAA{
+		Hdr: dns.RR_Header{
+			Name: "x.org.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 300},
+		AAAA: net.ParseIP("::1"),
+	}
+	reso.add(dns.TypeAAAA, aaaa, xorg.sign(t, aaaa))
+	counter := countingResolver{
+		zoneResolver: reso, counts: make(map[string]int), mu: &sync.Mutex{}}
+	saver := &trace.Saver{}
+	r := resolver.DNSSECResolver{
+		Resolver:  counter,
+		Saver:     saver,
+		Validator: resolver.DNSSECValidator{TrustAnchors: []*dns.DS{anchor}},
+	}
+	addrs, err := r.LookupHost(context.Background(), "x.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 {
+		t.Fatal("not the addresses we expected")
+	}
+	ev := saver.Read()
+	if len(ev) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	for _, e := range ev {
+		if e.DNSSECStatus != resolver.DNSSECStatusSecure {
+			t.Fatal("unexpected validation result")
+		}
+	}
+	for key, count := range counter.counts {
+		if count != 1 {
+			t.Fatal("we sent more than one query for", key)
+		}
+	}
+	if counter.counts[". DNSKEY"] != 1 || counter.counts["x.org. DS"] != 1 {
+		t.Fatal("we did not fetch the keys")
+	}
+}
+
+func TestUnitDNSSECResolverLookupHostFailure(t *testing.T) {
+	reso, _, _ := newSignedChain(t)
+	r := resolver.DNSSECResolver{Resolver: reso}
+	addrs, err := r.LookupHost(context.Background(), "www.x.org")
+	if err == nil || !strings.HasSuffix(err.Error(), "no response returned") {
+		t.Fatal("not the error we expected")
+	}
+	if addrs != nil {
+		t.Fatal("expected nil addrs here")
+	}
+}
+
+func TestUnitDNSSECResolverWithoutRecordsResolver(t *testing.T) {
+	r := resolver.DNSSECResolver{Resolver: resolver.SystemResolver{}}
+	addrs, err := r.LookupHost(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+		t.Fatal("not the result we expected")
+	}
+	records, err := r.LookupRecords(context.Background(), "x.org", dns.TypeA)
+	if !errors.Is(err, resolver.ErrLookupRecordsNotSupported) {
+		t.Fatal("not the error we expected")
+	}
+	if records != nil {
+		t.Fatal("expected nil records here")
+	}
+}
+
+func TestUnitDNSSECValidatorDefaultTrustAnchor(t *testing.T) {
+	reso, _, xorg := newSignedChain(t)
+	a := newA("x.org.", "10.0.0.1")
+	// With the real root trust anchor, our fake root is not trusted.
+	v := resolver.DNSSECValidator{}
+	status := v.Validate(context.Background(), reso, []dns.RR{a, xorg.sign(t, a)})
+	if status != resolver.DNSSECStatusBogus {
+		t.Fatal("unexpected status", status)
+	}
+}
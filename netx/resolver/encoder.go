@@ -8,7 +8,16 @@ type Encoder interface {
 }
 
 // MiekgEncoder uses github.com/miekg/dns to implement the Encoder.
-type MiekgEncoder struct{}
+type MiekgEncoder struct {
+	// DNSSECOK causes the encoder to use EDNS0 and to set the DO bit, to
+	// request the server to include DNSSEC records in the reply. We always
+	// set the DO bit when padding, regardless of this setting.
+	DNSSECOK bool
+
+	// CheckingDisabled causes the encoder to set the CD bit, to ask the
+	// server to return records even when they fail DNSSEC validation.
+	CheckingDisabled bool
+}
 
 const (
 	// PaddingDesiredBlockSize is the size that the padded query should be multiple of
@@ -33,6 +42,10 @@ func (e MiekgEncoder) Encode(domain string, qtype uint16, padding bool) ([]byte,
 	query.RecursionDesired = true
 	query.Question = make([]dns.Question, 1)
 	query.Question[0] = question
+	query.CheckingDisabled = e.CheckingDisabled
+	if e.DNSSECOK && !padding {
+		query.SetEdns0(EDNS0MaxResponseSize, true)
+	}
 	if padding {
 		query.SetEdns0(EDNS0MaxResponseSize, DNSSECEnabled)
 		// Clients SHOULD pad queries to the closest multiple of
@@ -97,3 +97,45 @@ func TestUnitEncoderPadding(t *testing.T) {
 		}
 	}
 }
+
+func TestUnitEncoderDNSSECOK(t *testing.T) {
+	for _, padding := range []bool{false, true} {
+		e := resolver.MiekgEncoder{DNSSECOK: true}
+		data, err := e.Encode("x.org", dns.TypeA, padding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := new(dns.Msg)
+		if err := query.Unpack(data); err != nil {
+			t.Fatal(err)
+		}
+		opt := query.IsEdns0()
+		if opt == nil {
+			t.Fatal("expected EDNS0 here")
+		}
+		if !opt.Do() {
+			t.Fatal("expected the DO bit to be set")
+		}
+		if query.CheckingDisabled {
+			t.Fatal("did not expect the CD bit to be set")
+		}
+	}
+}
+
+func TestUnitEncoderCheckingDisabled(t *testing.T) {
+	e := resolver.MiekgEncoder{CheckingDisabled: true}
+	data, err := e.Encode("x.org", dns.TypeA, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := new(dns.Msg)
+	if err := query.Unpack(data); err != nil {
+		t.Fatal(err)
+	}
+	if !query.CheckingDisabled {
+		t.Fatal("expected the CD bit to be set")
+	}
+	if query.IsEdns0() != nil {
+		t.Fatal("did not expect EDNS0 here")
+	}
+}
@@ -3,6 +3,7 @@ package resolver
 import (
 	"context"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/legacy/netx/dialid"
 	"github.com/ooni/probe-engine/legacy/netx/transactionid"
 	"github.com/ooni/probe-engine/netx/errorx"
@@ -27,4 +28,19 @@ func (r ErrorWrapperResolver) LookupHost(ctx context.Context, hostname string) (
 	return addrs, err
 }
 
-var _ Resolver = ErrorWrapperResolver{}
+// LookupRecords implements RecordsResolver.LookupRecords
+func (r ErrorWrapperResolver) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error) {
+	dialID := dialid.ContextDialID(ctx)
+	txID := transactionid.ContextTransactionID(ctx)
+	answers, err := lookupRecords(ctx, r.Resolver, hostname, qtype)
+	err = errorx.SafeErrWrapperBuilder{
+		DialID:        dialID,
+		Error:         err,
+		Operation:     errorx.ResolveOperation,
+		TransactionID: txID,
+	}.MaybeBuild()
+	return answers, err
+}
+
+var _ RecordsResolver = ErrorWrapperResolver{}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"testing"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/legacy/netx/dialid"
 	"github.com/ooni/probe-engine/legacy/netx/transactionid"
 	"github.com/ooni/probe-engine/netx/errorx"
@@ -56,3 +57,42 @@ func TestUnitErrorWrapperFailure(t *testing.T) {
 		t.Fatal("unexpected Operation")
 	}
 }
+
+func TestUnitErrorWrapperLookupRecordsFailure(t *testing.T) {
+	r := resolver.ErrorWrapperResolver{
+		Resolver: resolver.NewFakeResolverThatFails(),
+	}
+	records, err := r.LookupRecords(context.Background(), "dns.google.com", dns.TypeTXT)
+	if records != nil {
+		t.Fatal("expected nil records here")
+	}
+	var errWrapper *errorx.ErrWrapper
+	if !errors.As(err, &errWrapper) {
+		t.Fatal("cannot properly cast the returned error")
+	}
+	if errWrapper.Failure != errorx.FailureDNSNXDOMAINError {
+		t.Fatal("unexpected failure")
+	}
+	if errWrapper.Operation != errorx.ResolveOperation {
+		t.Fatal("unexpected Operation")
+	}
+}
+
+func TestUnitErrorWrapperServfail(t *testing.T) {
+	r := resolver.ErrorWrapperResolver{
+		Resolver: resolver.NewSerialResolver(resolver.FakeTransport{
+			Data: resolver.GenReplyError(t, dns.RcodeServerFailure),
+		}),
+	}
+	addrs, err := r.LookupHost(context.Background(), "dns.google.com")
+	if addrs != nil {
+		t.Fatal("expected nil addr here")
+	}
+	var errWrapper *errorx.ErrWrapper
+	if !errors.As(err, &errWrapper) {
+		t.Fatal("cannot properly cast the returned error")
+	}
+	if errWrapper.Failure != errorx.FailureDNSServfailError {
+		t.Fatal("unexpected failure")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/atomicx"
 )
 
@@ -105,6 +106,7 @@ func (fe FakeEncoder) Encode(domain string, qtype uint16, padding bool) ([]byte,
 type FakeResolver struct {
 	NumFailures *atomicx.Int64
 	Err         error
+	Records     []dns.RR
 	Result      []string
 }
 
@@ -131,6 +133,14 @@ func (c FakeResolver) LookupHost(ctx context.Context, hostname string) ([]string
 	return c.Result, nil
 }
 
+func (c FakeResolver) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error) {
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	return c.Records, nil
+}
+
 func (c FakeResolver) Network() string {
 	return "fake"
 }
@@ -139,4 +149,4 @@ func (c FakeResolver) Address() string {
 	return ""
 }
 
-var _ Resolver = FakeResolver{}
+var _ RecordsResolver = FakeResolver{}
@@ -74,3 +74,45 @@ func GenReplySuccess(t *testing.T, qtype uint16, ips ...string) []byte {
 	}
 	return data
 }
+
+func GenReplyRecords(t *testing.T, records ...dns.RR) []byte {
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn("x.org"), dns.TypeA)
+	reply := new(dns.Msg)
+	reply.Compress = true
+	reply.MsgHdr.RecursionAvailable = true
+	reply.SetReply(query)
+	reply.Answer = append(reply.Answer, records...)
+	data, err := reply.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// GenReplyTo generates a reply to the given query, i.e., a reply
+// having the same ID and question, containing the given records.
+func GenReplyTo(t *testing.T, query []byte, records ...dns.RR) []byte {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(query); err != nil {
+		t.Fatal(err)
+	}
+	reply := new(dns.Msg)
+	reply.Compress = true
+	reply.MsgHdr.RecursionAvailable = true
+	reply.SetReply(msg)
+	reply.Answer = append(reply.Answer, records...)
+	data, err := reply.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func NewRR(t *testing.T, s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
@@ -3,6 +3,8 @@ package resolver
 import (
 	"context"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
 // Logger is the logger assumed by this package
@@ -27,4 +29,17 @@ func (r LoggingResolver) LookupHost(ctx context.Context, hostname string) ([]str
 	return addrs, err
 }
 
-var _ Resolver = LoggingResolver{}
+// LookupRecords implements RecordsResolver.LookupRecords
+func (r LoggingResolver) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error) {
+	qname := dns.TypeToString[qtype]
+	r.Logger.Debugf("resolve %s %s...", hostname, qname)
+	start := time.Now()
+	answers, err := lookupRecords(ctx, r.Resolver, hostname, qtype)
+	stop := time.Now()
+	r.Logger.Debugf("resolve %s %s... (%+v, %+v) in %s",
+		hostname, qname, answers, err, stop.Sub(start))
+	return answers, err
+}
+
+var _ RecordsResolver = LoggingResolver{}
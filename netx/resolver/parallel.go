@@ -0,0 +1,91 @@
+package resolver
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/atomicx"
+)
+
+// ParallelResolver is a resolver that issues the A and the AAAA query
+// for the requested domain in parallel. Each query is retried using the
+// same policy of SerialResolver. Because the two queries run at the same
+// time, the DNS events emitted by the transport may interleave; yet, each
+// event carries its own query, so they remain unambiguous.
+type ParallelResolver struct {
+	Encoder     Encoder
+	Decoder     Decoder
+	NumTimeouts *atomicx.Int64
+	Txp         RoundTripper
+}
+
+// NewParallelResolver creates a new ParallelResolver instance.
+func NewParallelResolver(t RoundTripper) ParallelResolver {
+	return ParallelResolver{
+		Encoder:     MiekgEncoder{},
+		Decoder:     MiekgDecoder{},
+		NumTimeouts: atomicx.NewInt64(),
+		Txp:         t,
+	}
+}
+
+// Transport returns the transport being used.
+func (r ParallelResolver) Transport() RoundTripper {
+	return r.Txp
+}
+
+// Network implements Resolver.Network
+func (r ParallelResolver) Network() string {
+	return r.Txp.Network()
+}
+
+// Address implements Resolver.Address
+func (r ParallelResolver) Address() string {
+	return r.Txp.Address()
+}
+
+// parallelResult is the result of a single query.
+type parallelResult struct {
+	addrs []string
+	err   error
+}
+
+// LookupHost implements Resolver.LookupHost.
+func (r ParallelResolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	resA, resAAAA := make(chan parallelResult), make(chan parallelResult)
+	go r.lookupHost(ctx, hostname, dns.TypeA, resA)
+	go r.lookupHost(ctx, hostname, dns.TypeAAAA, resAAAA)
+	replyA, replyAAAA := <-resA, <-resAAAA
+	if replyA.err != nil && replyAAAA.err != nil {
+		return nil, replyA.err
+	}
+	var addrs []string
+	addrs = append(addrs, replyA.addrs...)
+	addrs = append(addrs, replyAAAA.addrs...)
+	return addrs, nil
+}
+
+// LookupRecords implements RecordsResolver.LookupRecords.
+func (r ParallelResolver) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error) {
+	return r.serial().LookupRecords(ctx, hostname, qtype)
+}
+
+func (r ParallelResolver) lookupHost(ctx context.Context,
+	hostname string, qtype uint16, out chan<- parallelResult) {
+	addrs, err := r.serial().lookupHost(ctx, hostname, qtype)
+	out <- parallelResult{addrs: addrs, err: err}
+}
+
+// serial returns a SerialResolver sharing our settings, which we
+// use to perform each single query with retries.
+func (r ParallelResolver) serial() SerialResolver {
+	return SerialResolver{
+		Encoder:     r.Encoder,
+		Decoder:     r.Decoder,
+		NumTimeouts: r.NumTimeouts,
+		Txp:         r.Txp,
+	}
+}
+
+var _ RecordsResolver = ParallelResolver{}
@@ -0,0 +1,148 @@
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/resolver"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+// qtypeTransport is a transport that replies to A and AAAA queries
+// using the configured address after the configured delay.
+type qtypeTransport struct {
+	resolver.FakeTransport
+	t     *testing.T
+	delay time.Duration
+	A     string
+	AAAA  string
+}
+
+func (txp qtypeTransport) RoundTrip(ctx context.Context, query []byte) ([]byte, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+	time.Sleep(txp.delay)
+	qtype := msg.Question[0].Qtype
+	if qtype == dns.TypeA {
+		return resolver.GenReplySuccess(txp.t, qtype, txp.A), nil
+	}
+	return resolver.GenReplySuccess(txp.t, qtype, txp.AAAA), nil
+}
+
+func TestUnitParallelGettingTransport(t *testing.T) {
+	txp := resolver.NewDNSOverTLS(resolver.DialTLSContext, "8.8.8.8:853")
+	r := resolver.NewParallelResolver(txp)
+	rtx := r.Transport()
+	if rtx.Network() != "dot" || rtx.Address() != "8.8.8.8:853" {
+		t.Fatal("not the transport we expected")
+	}
+	if r.Network() != rtx.Network() {
+		t.Fatal("invalid network seen from the resolver")
+	}
+	if r.Address() != rtx.Address() {
+		t.Fatal("invalid address seen from the resolver")
+	}
+}
+
+func TestUnitParallelRoundTripError(t *testing.T) {
+	mocked := errors.New("mocked error")
+	txp := resolver.FakeTransport{Err: mocked}
+	r := resolver.NewParallelResolver(txp)
+	addrs, err := r.LookupHost(context.Background(), "www.gogle.com")
+	if !errors.Is(err, mocked) {
+		t.Fatal("not the error we expected")
+	}
+	if addrs != nil {
+		t.Fatal("expected nil address here")
+	}
+}
+
+func TestUnitParallelWithAReply(t *testing.T) {
+	txp := resolver.FakeTransport{
+		Data: resolver.GenReplySuccess(t, dns.TypeA, "8.8.8.8"),
+	}
+	r := resolver.NewParallelResolver(txp)
+	addrs, err := r.LookupHost(context.Background(), "www.gogle.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "8.8.8.8" {
+		t.Fatal("not the result we expected")
+	}
+}
+
+func TestUnitParallelWithTimeout(t *testing.T) {
+	txp := resolver.FakeTransport{
+		Err: &net.OpError{Err: syscall.ETIMEDOUT, Op: "dial"},
+	}
+	r := resolver.NewParallelResolver(txp)
+	addrs, err := r.LookupHost(context.Background(), "www.gogle.com")
+	if !errors.Is(err, syscall.ETIMEDOUT) {
+		t.Fatal("not the error we expected")
+	}
+	if addrs != nil {
+		t.Fatal("expected nil address here")
+	}
+	if r.NumTimeouts.Load() <= 0 {
+		t.Fatal("we didn't actually take the timeouts")
+	}
+}
+
+func TestUnitParallelQueriesAreConcurrent(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	saver := &trace.Saver{}
+	txp := resolver.SaverDNSTransport{
+		RoundTripper: qtypeTransport{t: t, delay: delay, A: "8.8.8.8", AAAA: "::1"},
+		Saver:        saver,
+	}
+	r := resolver.NewParallelResolver(txp)
+	start := time.Now()
+	addrs, err := r.LookupHost(context.Background(), "www.gogle.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= 2*delay {
+		t.Fatal("queries do not seem to run in parallel", elapsed)
+	}
+	if len(addrs) != 2 || addrs[0] != "8.8.8.8" || addrs[1] != "::1" {
+		t.Fatal("not the result we expected", addrs)
+	}
+	var starts, dones int
+	for _, ev := range saver.Read() {
+		switch ev.Name {
+		case "dns_round_trip_start":
+			starts++
+		case "dns_round_trip_done":
+			dones++
+			if ev.Err != nil || len(ev.DNSQuery) <= 0 || len(ev.DNSReply) <= 0 {
+				t.Fatal("unexpected dns_round_trip_done event")
+			}
+		}
+	}
+	if starts != 2 || dones != 2 {
+		t.Fatal("unexpected number of events")
+	}
+}
+
+func TestUnitParallelLookupRecordsSuccess(t *testing.T) {
+	txp := resolver.FakeTransport{
+		Data: resolver.GenReplyRecords(t,
+			resolver.NewRR(t, "x.org. 300 IN HTTPS 1 . alpn=h2,h3"),
+		),
+	}
+	r := resolver.NewParallelResolver(txp)
+	records, err := r.LookupRecords(context.Background(), "x.org", dns.TypeHTTPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatal("not the result we expected")
+	}
+}
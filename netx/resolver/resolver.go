@@ -2,6 +2,9 @@ package resolver
 
 import (
 	"context"
+	"errors"
+
+	"github.com/miekg/dns"
 )
 
 // Resolver is a DNS resolver. The *net.Resolver used by Go implements
@@ -16,3 +19,30 @@ type Resolver interface {
 	// Address returns the address being used by the resolver
 	Address() string
 }
+
+// RecordsResolver is a Resolver that is also able to perform typed
+// lookups, i.e., to send a query for an arbitrary qtype and return the
+// full answer set contained in the reply (e.g. the CNAME chain).
+type RecordsResolver interface {
+	Resolver
+
+	// LookupRecords sends a qtype query for hostname and returns
+	// all the resource records in the answer section.
+	LookupRecords(ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error)
+}
+
+// ErrLookupRecordsNotSupported indicates that the underlying resolver
+// is not able to perform typed lookups. This happens, for example, when
+// we are using the system resolver.
+var ErrLookupRecordsNotSupported = errors.New("resolver: LookupRecords not supported")
+
+// lookupRecords calls r.LookupRecords if r is a RecordsResolver and
+// otherwise returns ErrLookupRecordsNotSupported.
+func lookupRecords(
+	ctx context.Context, r Resolver, hostname string, qtype uint16) ([]dns.RR, error) {
+	rr, ok := r.(RecordsResolver)
+	if !ok {
+		return nil, ErrLookupRecordsNotSupported
+	}
+	return rr.LookupRecords(ctx, hostname, qtype)
+}
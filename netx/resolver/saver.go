@@ -2,15 +2,17 @@ package resolver
 
 import (
 	"context"
+	"strings"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/netx/trace"
 )
 
 // SaverResolver is a resolver that saves events
 type SaverResolver struct {
 	Resolver
-	Saver *trace.Saver
+	Saver trace.Sink
 }
 
 // LookupHost implements Resolver.LookupHost
@@ -38,10 +40,38 @@ func (r SaverResolver) LookupHost(ctx context.Context, hostname string) ([]strin
 	return addrs, err
 }
 
+// LookupRecords implements RecordsResolver.LookupRecords
+func (r SaverResolver) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error) {
+	start := time.Now()
+	r.Saver.Write(trace.Event{
+		Address:      r.Resolver.Address(),
+		DNSQueryType: dns.TypeToString[qtype],
+		Hostname:     hostname,
+		Name:         "resolve_start",
+		Proto:        r.Resolver.Network(),
+		Time:         start,
+	})
+	answers, err := lookupRecords(ctx, r.Resolver, hostname, qtype)
+	stop := time.Now()
+	r.Saver.Write(trace.Event{
+		Address:      r.Resolver.Address(),
+		DNSAnswers:   answers,
+		DNSQueryType: dns.TypeToString[qtype],
+		Duration:     stop.Sub(start),
+		Err:          err,
+		Hostname:     hostname,
+		Name:         "resolve_done",
+		Proto:        r.Resolver.Network(),
+		Time:         stop,
+	})
+	return answers, err
+}
+
 // SaverDNSTransport is a DNS transport that saves events
 type SaverDNSTransport struct {
 	RoundTripper
-	Saver *trace.Saver
+	Saver trace.Sink
 }
 
 // RoundTrip implements RoundTripper.RoundTrip
@@ -54,8 +84,22 @@ func (txp SaverDNSTransport) RoundTrip(ctx context.Context, query []byte) ([]byt
 		Proto:    txp.Network(),
 		Time:     start,
 	})
-	reply, err := txp.RoundTripper.RoundTrip(ctx, query)
-	stop := time.Now()
+	var (
+		reply       []byte
+		err         error
+		lateReplies <-chan DNSOverUDPReply
+		stop        time.Time
+	)
+	if lrt, ok := txp.RoundTripper.(lateRepliesRoundTripper); ok {
+		var first DNSOverUDPReply
+		first, lateReplies, err = lrt.RoundTripWithLateReplies(ctx, query)
+		reply, stop = first.Data, first.Time
+	} else {
+		reply, err = txp.RoundTripper.RoundTrip(ctx, query)
+	}
+	if stop.IsZero() {
+		stop = time.Now()
+	}
 	txp.Saver.Write(trace.Event{
 		Address:  txp.Address(),
 		DNSQuery: query,
@@ -66,8 +110,54 @@ func (txp SaverDNSTransport) RoundTrip(ctx context.Context, query []byte) ([]byt
 		Proto:    txp.Network(),
 		Time:     stop,
 	})
+	if lateReplies != nil {
+		// Save the first reply like the late replies, so that we can
+		// compare all the replies to the same query.
+		txp.Saver.Write(txp.newReplyEvent("dns_first_reply", query,
+			DNSOverUDPReply{Data: reply, Time: stop}))
+		go txp.saveLateReplies(query, lateReplies)
+	}
 	return reply, err
 }
 
-var _ Resolver = SaverResolver{}
+// lateRepliesRoundTripper is a RoundTripper that may receive more than
+// one reply for a single query (e.g. DNSOverUDP).
+type lateRepliesRoundTripper interface {
+	RoundTripWithLateReplies(ctx context.Context,
+		query []byte) (DNSOverUDPReply, <-chan DNSOverUDPReply, error)
+}
+
+// saveLateReplies saves the late replies in the background, such that
+// we do not block the caller for the whole late replies window.
+func (txp SaverDNSTransport) saveLateReplies(
+	query []byte, lateReplies <-chan DNSOverUDPReply) {
+	for late := range lateReplies {
+		txp.Saver.Write(txp.newReplyEvent("dns_late_reply", query, late))
+	}
+}
+
+// newReplyEvent creates the "dns_first_reply" or "dns_late_reply" event.
+// We decode the reply here, so that the event looks like a typed
+// "resolve_done" and each reply can become a distinct archival entry.
+func (txp SaverDNSTransport) newReplyEvent(
+	name string, query []byte, reply DNSOverUDPReply) trace.Event {
+	ev := trace.Event{
+		Address:  txp.Address(),
+		DNSQuery: query,
+		DNSReply: reply.Data,
+		Name:     name,
+		Proto:    txp.Network(),
+		Time:     reply.Time,
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(query); err == nil && len(msg.Question) == 1 {
+		question := msg.Question[0]
+		ev.Hostname = strings.TrimSuffix(question.Name, ".")
+		ev.DNSQueryType = dns.TypeToString[question.Qtype]
+		ev.DNSAnswers, ev.Err = MiekgDecoder{}.DecodeRecords(question.Qtype, reply.Data)
+	}
+	return ev
+}
+
+var _ RecordsResolver = SaverResolver{}
 var _ RoundTripper = SaverDNSTransport{}
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"net"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/netx/resolver"
 	"github.com/ooni/probe-engine/netx/trace"
 )
@@ -209,3 +211,118 @@ func TestUnitSaverDNSTransportSuccess(t *testing.T) {
 		t.Fatal("the saved time is wrong")
 	}
 }
+
+func TestUnitSaverResolverLookupRecords(t *testing.T) {
+	expected := []dns.RR{&dns.CNAME{
+		Hdr:    dns.RR_Header{Name: "www.x.org.", Rrtype: dns.TypeCNAME, Ttl: 300},
+		Target: "x.org.",
+	}}
+	saver := &trace.Saver{}
+	reso := resolver.SaverResolver{
+		Resolver: resolver.FakeResolver{
+			Records: expected,
+		},
+		Saver: saver,
+	}
+	records, err := reso.LookupRecords(context.Background(), "www.x.org", dns.TypeCNAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Fatal("not the result we expected")
+	}
+	ev := saver.Read()
+	if len(ev) != 2 {
+		t.Fatal("expected number of events")
+	}
+	if ev[0].Name != "resolve_start" || ev[0].DNSQueryType != "CNAME" {
+		t.Fatal("unexpected first event")
+	}
+	if ev[1].Name != "resolve_done" || ev[1].DNSQueryType != "CNAME" {
+		t.Fatal("unexpected second event")
+	}
+	if !reflect.DeepEqual(ev[1].DNSAnswers, expected) {
+		t.Fatal("unexpected DNSAnswers")
+	}
+}
+
+func TestUnitSaverResolverLookupRecordsNotSupported(t *testing.T) {
+	saver := &trace.Saver{}
+	reso := resolver.SaverResolver{
+		Resolver: resolver.SystemResolver{},
+		Saver:    saver,
+	}
+	records, err := reso.LookupRecords(context.Background(), "x.org", dns.TypeTXT)
+	if !errors.Is(err, resolver.ErrLookupRecordsNotSupported) {
+		t.Fatal("not the error we expected")
+	}
+	if records != nil {
+		t.Fatal("expected nil records here")
+	}
+	ev := saver.Read()
+	if len(ev) != 2 || !errors.Is(ev[1].Err, resolver.ErrLookupRecordsNotSupported) {
+		t.Fatal("unexpected events")
+	}
+}
+
+func TestUnitSaverDNSTransportLateReplies(t *testing.T) {
+	query, err := resolver.MiekgEncoder{}.Encode("x.org", dns.TypeA, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := resolver.GenReplyTo(t, query, resolver.NewRR(t, "x.org. 0 IN A 10.10.34.35"))
+	second := resolver.GenReplyTo(t, query, resolver.NewRR(t, "x.org. 0 IN A 93.184.216.34"))
+	pconn := listenAndReplyMany(t, first, second)
+	defer pconn.Close()
+	saver := &trace.Saver{}
+	const window = 500 * time.Millisecond
+	txp := resolver.SaverDNSTransport{
+		RoundTripper: resolver.NewDNSOverUDPWithLateRepliesWindow(
+			&net.Dialer{}, pconn.LocalAddr().String(), window),
+		Saver: saver,
+	}
+	reply, err := txp.RoundTrip(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	returned := time.Now()
+	if !bytes.Equal(reply, first) {
+		t.Fatal("expected another reply here")
+	}
+	ev := saver.Read()
+	if len(ev) != 3 {
+		t.Fatal("expected number of events")
+	}
+	if ev[1].Name != "dns_round_trip_done" || !bytes.Equal(ev[1].DNSReply, first) {
+		t.Fatal("unexpected second event")
+	}
+	if returned.Sub(ev[0].Time) >= window || ev[1].Time.After(returned) {
+		t.Fatal("we waited for the late replies window")
+	}
+	if ev[2].Name != "dns_first_reply" || !bytes.Equal(ev[2].DNSReply, first) {
+		t.Fatal("unexpected third event")
+	}
+	if !ev[2].Time.Equal(ev[1].Time) {
+		t.Fatal("the first reply time is wrong")
+	}
+	// We save the late replies in the background.
+	time.Sleep(2 * window)
+	ev = append(ev, saver.Read()...)
+	if len(ev) != 4 {
+		t.Fatal("expected number of events")
+	}
+	for _, ev := range ev[2:] {
+		if ev.Hostname != "x.org" || ev.DNSQueryType != "A" {
+			t.Fatal("unexpected query in reply event")
+		}
+		if ev.Err != nil || len(ev.DNSAnswers) != 1 {
+			t.Fatal("unexpected answers in reply event")
+		}
+	}
+	if ev[3].Name != "dns_late_reply" || !bytes.Equal(ev[3].DNSReply, second) {
+		t.Fatal("unexpected fourth event")
+	}
+	if !ev[3].Time.After(ev[2].Time) {
+		t.Fatal("the saved time is wrong")
+	}
+}
@@ -61,8 +61,8 @@ func (r SerialResolver) Address() string {
 // LookupHost implements Resolver.LookupHost.
 func (r SerialResolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
 	var addrs []string
-	addrsA, errA := r.roundTripWithRetry(ctx, hostname, dns.TypeA)
-	addrsAAAA, errAAAA := r.roundTripWithRetry(ctx, hostname, dns.TypeAAAA)
+	addrsA, errA := r.lookupHost(ctx, hostname, dns.TypeA)
+	addrsAAAA, errAAAA := r.lookupHost(ctx, hostname, dns.TypeAAAA)
 	if errA != nil && errAAAA != nil {
 		return nil, errA
 	}
@@ -71,13 +71,36 @@ func (r SerialResolver) LookupHost(ctx context.Context, hostname string) ([]stri
 	return addrs, nil
 }
 
-func (r SerialResolver) roundTripWithRetry(
+// LookupRecords implements RecordsResolver.LookupRecords.
+func (r SerialResolver) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) ([]dns.RR, error) {
+	rd, ok := r.Decoder.(RecordsDecoder)
+	if !ok {
+		return nil, ErrLookupRecordsNotSupported
+	}
+	replydata, err := r.roundTripWithRetry(ctx, hostname, qtype)
+	if err != nil {
+		return nil, err
+	}
+	return rd.DecodeRecords(qtype, replydata)
+}
+
+func (r SerialResolver) lookupHost(
 	ctx context.Context, hostname string, qtype uint16) ([]string, error) {
+	replydata, err := r.roundTripWithRetry(ctx, hostname, qtype)
+	if err != nil {
+		return nil, err
+	}
+	return r.Decoder.Decode(qtype, replydata)
+}
+
+func (r SerialResolver) roundTripWithRetry(
+	ctx context.Context, hostname string, qtype uint16) ([]byte, error) {
 	var errorslist []error
 	for i := 0; i < 3; i++ {
-		replies, err := r.roundTrip(ctx, hostname, qtype)
+		replydata, err := r.roundTrip(ctx, hostname, qtype)
 		if err == nil {
-			return replies, nil
+			return replydata, nil
 		}
 		errorslist = append(errorslist, err)
 		var operr *net.OpError
@@ -98,16 +121,12 @@ func (r SerialResolver) roundTripWithRetry(
 }
 
 func (r SerialResolver) roundTrip(
-	ctx context.Context, hostname string, qtype uint16) ([]string, error) {
+	ctx context.Context, hostname string, qtype uint16) ([]byte, error) {
 	querydata, err := r.Encoder.Encode(hostname, qtype, r.Txp.RequiresPadding())
 	if err != nil {
 		return nil, err
 	}
-	replydata, err := r.Txp.RoundTrip(ctx, querydata)
-	if err != nil {
-		return nil, err
-	}
-	return r.Decoder.Decode(qtype, replydata)
+	return r.Txp.RoundTrip(ctx, querydata)
 }
 
-var _ Resolver = SerialResolver{}
+var _ RecordsResolver = SerialResolver{}
@@ -109,3 +109,58 @@ func TestUnitOONIWithTimeout(t *testing.T) {
 		t.Fatal("we didn't actually take the timeouts")
 	}
 }
+
+func TestUnitOONILookupRecordsSuccess(t *testing.T) {
+	txp := resolver.FakeTransport{
+		Data: resolver.GenReplyRecords(t,
+			resolver.NewRR(t, "x.org. 300 IN HTTPS 1 . alpn=h2,h3"),
+		),
+	}
+	r := resolver.NewSerialResolver(txp)
+	records, err := r.LookupRecords(context.Background(), "x.org", dns.TypeHTTPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatal("not the result we expected")
+	}
+	if _, ok := records[0].(*dns.HTTPS); !ok {
+		t.Fatal("not the record type we expected")
+	}
+}
+
+type decodeOnlyDecoder struct{}
+
+func (decodeOnlyDecoder) Decode(qtype uint16, data []byte) ([]string, error) {
+	return resolver.MiekgDecoder{}.Decode(qtype, data)
+}
+
+func TestUnitOONILookupRecordsWithoutRecordsDecoder(t *testing.T) {
+	txp := resolver.FakeTransport{
+		Data: resolver.GenReplyRecords(t,
+			resolver.NewRR(t, "x.org. 300 IN HTTPS 1 . alpn=h2,h3"),
+		),
+	}
+	r := resolver.NewSerialResolver(txp)
+	r.Decoder = decodeOnlyDecoder{}
+	records, err := r.LookupRecords(context.Background(), "x.org", dns.TypeHTTPS)
+	if !errors.Is(err, resolver.ErrLookupRecordsNotSupported) {
+		t.Fatal("not the error we expected", err)
+	}
+	if records != nil {
+		t.Fatal("expected nil records here")
+	}
+}
+
+func TestUnitOONILookupRecordsRoundTripError(t *testing.T) {
+	mocked := errors.New("mocked error")
+	txp := resolver.FakeTransport{Err: mocked}
+	r := resolver.NewSerialResolver(txp)
+	records, err := r.LookupRecords(context.Background(), "x.org", dns.TypeTXT)
+	if !errors.Is(err, mocked) {
+		t.Fatal("not the error we expected")
+	}
+	if records != nil {
+		t.Fatal("expected nil records here")
+	}
+}
@@ -16,22 +16,40 @@
 //
 //     selfcensor.Enable(`{"BlockedFingerprints":{"dns.google":"RST"}}`)
 //
+// The following example blocks TLS handshakes using `dns.google` as SNI:
+//
+//     selfcensor.Enable(`{"BlockedSNIs":{"dns.google":"RST"}}`)
+//
+// The following example injects a blockpage for HTTP requests whose Host
+// header is `example.com`:
+//
+//     selfcensor.Enable(`{"BlockedHosts":{"example.com":"<html>blocked</html>"}}`)
+//
+// The following example throttles `8.8.8.8:443` to 10 kB/s:
+//
+//     selfcensor.Enable(`{"ThrottledEndpoints":{"8.8.8.8:443":10000}}`)
+//
 // The documentation of the Spec structure contains further information on
 // how to populate the JSON. Miniooni uses the `--self-censor-spec flag` to
 // which you are supposed to pass a serialized JSON.
 package selfcensor
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ooni/probe-engine/atomicx"
+	"github.com/ooni/probe-engine/netx/dialer"
 )
 
 // Spec indicates what self censorship techniques to implement.
@@ -58,6 +76,29 @@ type Spec struct {
 	// is "TIMEOUT", then the code will return claiming "i/o timeout". If
 	// the value is anything else, we will perform a "RST".
 	BlockedFingerprints map[string]string
+
+	// BlockedSNIs allows you to block TLS handshakes depending on the SNI
+	// inside the ClientHello. The key is the SNI. We reassemble the ClientHello
+	// when it spans several writes or several TLS records. If the value is
+	// "RST", then the connection will be reset. If the value is "TIMEOUT",
+	// then the code will return claiming "i/o timeout". If the value is
+	// "ALERT", then the client will receive a handshake_failure TLS alert. If
+	// the value is anything else, we will perform a "RST".
+	BlockedSNIs map[string]string
+
+	// BlockedHosts allows you to block HTTP requests depending on their Host
+	// header. The key is the Host header without the port. If the value is
+	// "RST", then the connection will be reset. If the value is "TIMEOUT",
+	// then the code will return claiming "i/o timeout". Otherwise, we do not
+	// send the request to the server and the client receives a 451 response
+	// whose body is the value, i.e., an injected blockpage.
+	BlockedHosts map[string]string
+
+	// ThrottledEndpoints allows you to throttle specific IP endpoints. The
+	// key is `IP:port`, as in BlockedEndpoints. The value is the maximum
+	// number of bytes per second we read from and write to the endpoint,
+	// which we enforce using a dialer.Shaper. We ignore zero and negative values.
+	ThrottledEndpoints map[string]int64
 }
 
 var (
@@ -180,35 +221,266 @@ func (d SystemDialer) DialContext(
 				}
 			}
 		}
-		if spec.BlockedFingerprints != nil {
-			conn, err := defaultNetDialer.DialContext(ctx, network, address)
+		rate := spec.ThrottledEndpoints[address]
+		if spec.BlockedFingerprints != nil || spec.BlockedSNIs != nil ||
+			spec.BlockedHosts != nil || rate > 0 {
+			var d dialer.Dialer = defaultNetDialer
+			if rate > 0 {
+				d = dialer.ShapingDialer{Dialer: d, Shaper: dialer.NewShaper(
+					dialer.ShapingConfig{DownloadRate: rate, UploadRate: rate})}
+			}
+			conn, err := d.DialContext(ctx, network, address)
 			if err != nil {
 				return nil, err
 			}
-			return connWrapper{Conn: conn, closed: make(chan interface{}, 128),
-				fingerprints: spec.BlockedFingerprints}, nil
+			return newConnWrapper(conn, network, spec), nil
 		}
 		// FALLTHROUGH
 	}
 	return defaultNetDialer.DialContext(ctx, network, address)
 }
 
+// maxInspectedBytes is the maximum number of bytes we buffer while waiting
+// for the whole ClientHello or for the whole HTTP request headers.
+const maxInspectedBytes = 1 << 16
+
 type connWrapper struct {
 	net.Conn
 	closed       chan interface{}
+	decided      chan interface{}
 	fingerprints map[string]string
+	hosts        map[string]string
+	snis         map[string]string
+
+	// The following fields are protected by mu. The pending field contains
+	// the data we are still inspecting and therefore have not written yet.
+	// Once we have decided, failure is the error we return, if any, and
+	// injected is the response we return instead of the server's one.
+	mu       sync.Mutex
+	failure  error
+	injected *bytes.Reader
+	pending  []byte
 }
 
-func (c connWrapper) Write(p []byte) (int, error) {
-	// TODO(bassosimone): implement reassembly to workaround the
-	// splitting of the ClientHello message.
+func newConnWrapper(conn net.Conn, network string, spec *Spec) *connWrapper {
+	c := &connWrapper{
+		Conn:         conn,
+		closed:       make(chan interface{}, 128),
+		decided:      make(chan interface{}),
+		fingerprints: spec.BlockedFingerprints,
+		hosts:        spec.BlockedHosts,
+		snis:         spec.BlockedSNIs,
+	}
+	if !strings.HasPrefix(network, "tcp") ||
+		(spec.BlockedHosts == nil && spec.BlockedSNIs == nil) {
+		close(c.decided) // nothing to inspect
+	}
+	return c
+}
+
+// Read reads from the connection. When we are inspecting the data written
+// by the client, Read blocks until we have decided whether to censor. This
+// is fine because both TLS and HTTP are protocols where the client speaks
+// first, while we cannot use BlockedSNIs and BlockedHosts otherwise.
+func (c *connWrapper) Read(p []byte) (int, error) {
+	<-c.decided
+	c.mu.Lock()
+	failure, injected := c.failure, c.injected
+	c.mu.Unlock()
+	if failure != nil {
+		return 0, failure
+	}
+	if injected != nil {
+		return injected.Read(p)
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *connWrapper) Write(p []byte) (int, error) {
 	if _, err := c.match(p, len(p)); err != nil {
 		return 0, err
 	}
-	return c.Conn.Write(p)
+	c.mu.Lock()
+	select {
+	case <-c.decided:
+		failure, injected := c.failure, c.injected
+		c.mu.Unlock()
+		switch {
+		case failure != nil:
+			return 0, failure
+		case injected != nil:
+			return len(p), nil // as if the censor swallowed the data
+		default:
+			return c.write(p, len(p))
+		}
+	default:
+	}
+	c.pending = append(c.pending, p...)
+	action, done := c.inspect(c.pending)
+	if !done {
+		c.mu.Unlock()
+		return len(p), nil
+	}
+	data := c.pending
+	c.pending = nil
+	switch action.kind {
+	case "":
+	case "INJECT":
+		c.injected = bytes.NewReader(action.response)
+	case "TIMEOUT":
+		c.failure = errTimeout
+	default:
+		c.failure = errors.New("connection reset by peer")
+	}
+	failure, injected := c.failure, c.injected
+	close(c.decided)
+	c.mu.Unlock()
+	switch {
+	case failure != nil:
+		return 0, failure
+	case injected != nil:
+		return len(p), nil
+	default:
+		return c.write(data, len(p))
+	}
+}
+
+// write writes data and returns n on success. We need this function
+// because, after inspecting data, we may need to write more bytes than
+// the ones passed to Write, yet we must return the number of the latter.
+func (c *connWrapper) write(data []byte, n int) (int, error) {
+	if _, err := c.Conn.Write(data); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// censorAction is the action to perform after inspecting the data that
+// the client writes. The kind is empty if we should not censor, "RST",
+// "TIMEOUT", or "INJECT", in which case response is what we inject.
+type censorAction struct {
+	kind     string
+	response []byte
+}
+
+// tlsAlertHandshakeFailure is a fatal handshake_failure TLS alert.
+var tlsAlertHandshakeFailure = []byte{21, 3, 1, 0, 2, 2, 40}
+
+// inspect inspects the data written so far. It returns done == false
+// when data does not contain enough bytes to decide yet.
+func (c *connWrapper) inspect(data []byte) (action censorAction, done bool) {
+	tooLarge := len(data) >= maxInspectedBytes
+	if len(data) > 0 && data[0] == tlsRecordTypeHandshake {
+		sni, err := parseClientHelloSNI(data)
+		if err == errIncompleteClientHello && !tooLarge {
+			return censorAction{}, false
+		}
+		switch value, found := c.snis[sni]; {
+		case err != nil || !found:
+			return censorAction{}, true
+		case value == "TIMEOUT":
+			return censorAction{kind: "TIMEOUT"}, true
+		case value == "ALERT":
+			return censorAction{kind: "INJECT", response: tlsAlertHandshakeFailure}, true
+		default:
+			return censorAction{kind: "RST"}, true
+		}
+	}
+	if c.hosts == nil || !mayBeHTTPRequest(data) {
+		return censorAction{}, true
+	}
+	if !bytes.Contains(data, []byte("\r\n\r\n")) && !tooLarge {
+		return censorAction{}, false
+	}
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return censorAction{}, true
+	}
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	switch value, found := c.hosts[host]; {
+	case !found:
+		return censorAction{}, true
+	case value == "TIMEOUT" || value == "RST":
+		return censorAction{kind: value}, true
+	default:
+		return censorAction{kind: "INJECT", response: newBlockpage(value)}, true
+	}
 }
 
-func (c connWrapper) match(p []byte, n int) (int, error) {
+// tlsRecordTypeHandshake is the type of TLS records containing handshake messages.
+const tlsRecordTypeHandshake = 22
+
+var (
+	errIncompleteClientHello = errors.New("selfcensor: incomplete ClientHello")
+	errInvalidClientHello    = errors.New("selfcensor: invalid ClientHello")
+)
+
+// parseClientHelloSNI returns the SNI inside the ClientHello contained in
+// the TLS records inside data. It returns an empty string if the ClientHello
+// has no SNI, and errIncompleteClientHello if data does not contain the whole
+// ClientHello yet, e.g., because the client split it into several writes. We
+// reassemble the ClientHello into a single record for dialer.ClientHelloSNI.
+func parseClientHelloSNI(data []byte) (string, error) {
+	var message []byte
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return "", errIncompleteClientHello
+		}
+		if data[0] != tlsRecordTypeHandshake {
+			return "", errInvalidClientHello
+		}
+		length := int(data[3])<<8 | int(data[4])
+		if len(data) < 5+length {
+			return "", errIncompleteClientHello
+		}
+		message = append(message, data[5:5+length]...)
+		data = data[5+length:]
+	}
+	if len(message) < 4 {
+		return "", errIncompleteClientHello
+	}
+	if message[0] != 1 { // client_hello
+		return "", errInvalidClientHello
+	}
+	length := int(message[1])<<16 | int(message[2])<<8 | int(message[3])
+	if len(message) < 4+length {
+		return "", errIncompleteClientHello
+	}
+	message = message[:4+length]
+	if len(message) > 0xffff {
+		return "", errInvalidClientHello
+	}
+	record := append([]byte{tlsRecordTypeHandshake, 3, 1,
+		byte(len(message) >> 8), byte(len(message))}, message...)
+	sni, _ := dialer.ClientHelloSNI(record)
+	return sni, nil
+}
+
+// mayBeHTTPRequest returns whether data may be the beginning of an HTTP
+// request, i.e., whether it starts with something looking like a method.
+func mayBeHTTPRequest(data []byte) bool {
+	for i, b := range data {
+		switch {
+		case b == ' ' && i > 0:
+			return true
+		case b < 'A' || b > 'Z' || i >= 16:
+			return false
+		}
+	}
+	return true
+}
+
+// newBlockpage returns an HTTP response containing the given blockpage.
+func newBlockpage(body string) []byte {
+	return []byte(fmt.Sprintf("HTTP/1.1 451 Unavailable For Legal Reasons\r\n"+
+		"Content-Type: text/html\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		len(body), body))
+}
+
+func (c *connWrapper) match(p []byte, n int) (int, error) {
 	p = p[:n] // trim
 	for key, value := range c.fingerprints {
 		if bytes.Index(p, []byte(key)) != -1 {
@@ -221,10 +493,19 @@ func (c connWrapper) match(p []byte, n int) (int, error) {
 	return n, nil
 }
 
-func (c connWrapper) Close() error {
+func (c *connWrapper) Close() error {
 	// Implementation note: we will block here if we attempt to close
 	// too many times and noone's reading. Because we have a large buffer,
 	// and because this is integration testing code, that's fine.
 	c.closed <- true
+	c.mu.Lock()
+	select {
+	case <-c.decided:
+	default:
+		// unblock pending reads when closing before deciding
+		c.failure = errors.New("use of closed network connection")
+		close(c.decided)
+	}
+	c.mu.Unlock()
 	return c.Conn.Close()
 }
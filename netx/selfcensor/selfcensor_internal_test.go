@@ -0,0 +1,78 @@
+package selfcensor
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+func captureClientHello(t *testing.T, config *tls.Config) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, config).Handshake()
+		client.Close()
+	}()
+	buffer := make([]byte, 1<<16)
+	count, err := server.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer[:count]
+}
+
+func TestParseClientHelloSNI(t *testing.T) {
+	hello := captureClientHello(t, &tls.Config{ServerName: "dns.google"})
+	t.Run("with a whole ClientHello", func(t *testing.T) {
+		sni, err := parseClientHelloSNI(hello)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sni != "dns.google" {
+			t.Fatal("unexpected SNI", sni)
+		}
+	})
+	t.Run("with a truncated ClientHello", func(t *testing.T) {
+		for _, size := range []int{3, 5, 8, len(hello) - 1} {
+			if _, err := parseClientHelloSNI(hello[:size]); err != errIncompleteClientHello {
+				t.Fatal("not the error we expected", size, err)
+			}
+		}
+	})
+	t.Run("with a ClientHello spanning two records", func(t *testing.T) {
+		message := hello[5:]
+		var data []byte
+		for _, chunk := range [][]byte{message[:100], message[100:]} {
+			data = append(data, tlsRecordTypeHandshake, 3, 1, byte(len(chunk)>>8), byte(len(chunk)))
+			data = append(data, chunk...)
+		}
+		sni, err := parseClientHelloSNI(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sni != "dns.google" {
+			t.Fatal("unexpected SNI", sni)
+		}
+	})
+	t.Run("with a ClientHello without SNI", func(t *testing.T) {
+		data := captureClientHello(t, &tls.Config{InsecureSkipVerify: true})
+		sni, err := parseClientHelloSNI(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sni != "" {
+			t.Fatal("unexpected SNI", sni)
+		}
+	})
+	t.Run("with a record that is not a handshake", func(t *testing.T) {
+		if _, err := parseClientHelloSNI([]byte{23, 3, 3, 0, 0}); err != errInvalidClientHello {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+	t.Run("with a handshake message that is not a ClientHello", func(t *testing.T) {
+		data := []byte{tlsRecordTypeHandshake, 3, 3, 0, 4, 2, 0, 0, 0}
+		if _, err := parseClientHelloSNI(data); err != errInvalidClientHello {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+}
@@ -2,6 +2,12 @@ package selfcensor_test
 
 import (
 	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -269,3 +275,198 @@ func TestBlockedFingerprintsConnectionReset(t *testing.T) {
 		t.Fatal("expected nil conn here")
 	}
 }
+
+func newTLSClientConn(t *testing.T, address, sni string) (*tls.Conn, error) {
+	conn, err := selfcensor.SystemDialer{}.DialContext(
+		context.Background(), "tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsconn := tls.Client(conn, &tls.Config{ServerName: sni, InsecureSkipVerify: true})
+	if err := tlsconn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsconn, nil
+}
+
+func TestBlockedSNIs(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	address := server.Listener.Addr().String()
+	err := selfcensor.MaybeEnable(`{"BlockedSNIs":{
+		"example.com":"RST",
+		"example.org":"TIMEOUT",
+		"example.net":"ALERT"
+	}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selfcensor.Enabled() != true {
+		t.Fatal("we expected self censorship to be enabled now")
+	}
+	for sni, expected := range map[string]string{
+		"example.com": "connection reset by peer",
+		"example.org": "i/o timeout",
+		"example.net": "remote error: tls: handshake failure",
+	} {
+		conn, err := newTLSClientConn(t, address, sni)
+		if err == nil || !strings.HasSuffix(err.Error(), expected) {
+			t.Fatal("not the error we expected", sni, err)
+		}
+		if conn != nil {
+			t.Fatal("expected nil conn here")
+		}
+	}
+	conn, err := newTLSClientConn(t, address, "ooni.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestBlockedSNIsWithSplitClientHello(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := ioutil.ReadAll(conn)
+		received <- data
+	}()
+	client, server := net.Pipe()
+	go tls.Client(client, &tls.Config{ServerName: "example.com"}).Handshake()
+	hello := make([]byte, 1<<16)
+	count, err := server.Read(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello = hello[:count]
+	client.Close()
+	server.Close()
+	err = selfcensor.MaybeEnable(`{"BlockedSNIs":{"example.com":"RST"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := selfcensor.SystemDialer{}.DialContext(
+		context.Background(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(hello[:20]); err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Write(hello[20:])
+	if err == nil || err.Error() != "connection reset by peer" {
+		t.Fatal("not the error we expected")
+	}
+	conn.Close()
+	if data := <-received; len(data) != 0 {
+		t.Fatal("the ClientHello should not have reached the server")
+	}
+}
+
+func TestBlockedHosts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("<html>not blocked</html>"))
+		}))
+	defer server.Close()
+	const blockpage = "<html>blocked</html>"
+	err := selfcensor.MaybeEnable(`{"BlockedHosts":{
+		"example.com":"` + blockpage + `",
+		"example.org":"RST"
+	}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selfcensor.Enabled() != true {
+		t.Fatal("we expected self censorship to be enabled now")
+	}
+	get := func(host string) (*http.Response, []byte, error) {
+		clnt := &http.Client{Transport: &http.Transport{
+			DialContext: selfcensor.SystemDialer{}.DialContext,
+		}}
+		defer clnt.CloseIdleConnections()
+		req, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = host
+		resp, err := clnt.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		return resp, data, err
+	}
+	t.Run("with a blockpage", func(t *testing.T) {
+		resp, data, err := get("example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 451 || string(data) != blockpage {
+			t.Fatal("expected the blockpage here", resp.StatusCode, string(data))
+		}
+	})
+	t.Run("with RST", func(t *testing.T) {
+		_, _, err := get("example.org")
+		if err == nil || !strings.HasSuffix(err.Error(), "connection reset by peer") {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+	t.Run("with a host that is not blocked", func(t *testing.T) {
+		resp, data, err := get("ooni.org")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 200 || string(data) != "<html>not blocked</html>" {
+			t.Fatal("unexpected response", resp.StatusCode, string(data))
+		}
+	})
+}
+
+func TestThrottledEndpoints(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	const size = 30000
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write(make([]byte, size))
+	}()
+	err = selfcensor.MaybeEnable(`{"ThrottledEndpoints":{"` +
+		listener.Addr().String() + `":100000}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := selfcensor.SystemDialer{}.DialContext(
+		context.Background(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	begin := time.Now()
+	if _, err := io.ReadFull(conn, make([]byte, size)); err != nil {
+		t.Fatal(err)
+	}
+	// At 100 kB/s, with a 10 kB bucket, we expect reading 30 kB
+	// to take around 200 ms.
+	if elapsed := time.Since(begin); elapsed < 150*time.Millisecond {
+		t.Fatal("reading was too fast", elapsed)
+	}
+}
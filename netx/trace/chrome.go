@@ -0,0 +1,204 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ChromeTrace converts events into the Chrome trace event format, which
+// you can open with chrome://tracing or https://ui.perfetto.dev. Each call
+// to AddProcess adds a process, typically a measurement, to the trace. Inside
+// a process, there is one track for DNS resolutions, one track for each
+// connection, and one track for the HTTP round trips we cannot associate
+// with any connection. The zero value is ready to use.
+type ChromeTrace struct {
+	events []chromeEvent
+	mu     sync.Mutex
+	pid    int
+}
+
+// chromeEvent is an event in the Chrome trace event format.
+type chromeEvent struct {
+	Args map[string]interface{} `json:"args,omitempty"`
+	Cat  string                 `json:"cat,omitempty"`
+	Dur  float64                `json:"dur,omitempty"`
+	Name string                 `json:"name"`
+	Ph   string                 `json:"ph"`
+	PID  int                    `json:"pid"`
+	TID  int64                  `json:"tid"`
+	TS   float64                `json:"ts"`
+}
+
+// The tracks of each process that do not represent a connection. The
+// track of a connection is the ID of the connection, which is the local
+// port, negative for UDP, and is therefore never equal to these values.
+const (
+	chromeTrackDNS         = 1 << 20
+	chromeTrackHTTP        = chromeTrackDNS + 1
+	chromeTrackFailedConns = chromeTrackDNS + 2
+)
+
+// AddProcess adds to the trace a process with the given name containing
+// the given events, which should be sorted by time.
+func (ct *ChromeTrace) AddProcess(name string, events []Event) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	ct.pid++
+	p := chromeProcess{
+		names: map[int64]string{
+			chromeTrackDNS:         "dns",
+			chromeTrackFailedConns: "failed connects",
+			chromeTrackHTTP:        "http",
+		},
+		pid: ct.pid,
+	}
+	p.add(events)
+	ct.events = append(ct.events, chromeEvent{
+		Args: map[string]interface{}{"name": name},
+		Name: "process_name",
+		Ph:   "M",
+		PID:  ct.pid,
+	})
+	var tids []int64
+	for tid := range p.used {
+		tids = append(tids, tid)
+	}
+	sort.Slice(tids, func(i, j int) bool { return tids[i] < tids[j] })
+	for _, tid := range tids {
+		ct.events = append(ct.events, chromeEvent{
+			Args: map[string]interface{}{"name": p.names[tid]},
+			Name: "thread_name",
+			Ph:   "M",
+			PID:  ct.pid,
+			TID:  tid,
+		})
+	}
+	ct.events = append(ct.events, p.events...)
+}
+
+// WriteJSON writes the trace in JSON format into w.
+func (ct *ChromeTrace) WriteJSON(w io.Writer) error {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	events := ct.events
+	if events == nil {
+		events = []chromeEvent{}
+	}
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"displayTimeUnit": "ms",
+		"traceEvents":     events,
+	})
+}
+
+// chromeProcess converts the events of a process.
+type chromeProcess struct {
+	events []chromeEvent
+	names  map[int64]string
+	pid    int
+	used   map[int64]bool
+}
+
+func (p *chromeProcess) add(events []Event) {
+	p.used = make(map[int64]bool)
+	var (
+		begin    *Event
+		httpArgs map[string]interface{}
+		httpTID  int64
+	)
+	for _, ev := range events {
+		ev := ev
+		switch ev.Name {
+		case "resolve_done":
+			args := map[string]interface{}{"hostname": ev.Hostname}
+			if len(ev.Addresses) > 0 {
+				args["addresses"] = ev.Addresses
+			}
+			if ev.DNSQueryType != "" {
+				args["query_type"] = ev.DNSQueryType
+			}
+			p.span(chromeTrackDNS, "resolve "+ev.Hostname, "dns", ev, args)
+		case "connect":
+			tid := int64(chromeTrackFailedConns)
+			if ev.ConnID != 0 {
+				tid = ev.ConnID
+				p.names[tid] = fmt.Sprintf("%s %s (conn %d)", ev.Proto, ev.Address, ev.ConnID)
+			}
+			p.span(tid, "connect", "net", ev, map[string]interface{}{
+				"address": ev.Address,
+			})
+		case "tls_handshake_done":
+			p.span(p.track(ev.ConnID), "tls_handshake", "tls", ev, map[string]interface{}{
+				"alpn":        ev.TLSNegotiatedProto,
+				"server_name": ev.TLSServerName,
+				"tls_version": ev.TLSVersion,
+			})
+		case "read", "write":
+			p.span(p.track(ev.ConnID), ev.Name, "net", ev, map[string]interface{}{
+				"num_bytes": ev.NumBytes,
+			})
+		case "http_transaction_start":
+			begin, httpArgs, httpTID = &ev, make(map[string]interface{}), chromeTrackHTTP
+		case "http_got_conn":
+			if begin != nil && ev.ConnID != 0 {
+				httpTID = ev.ConnID
+			}
+		case "http_request_metadata":
+			if begin != nil {
+				httpArgs["method"], httpArgs["url"] = ev.HTTPMethod, ev.HTTPURL
+			}
+		case "http_response_metadata":
+			if begin != nil {
+				httpArgs["status_code"] = ev.HTTPStatusCode
+			}
+		case "http_transaction_done":
+			if begin == nil {
+				continue
+			}
+			ev.Duration = ev.Time.Sub(begin.Time)
+			p.span(p.track(httpTID), "http_round_trip", "http", ev, httpArgs)
+			begin = nil
+		}
+	}
+}
+
+// track returns the track of the connection with the given ID, or the
+// HTTP track if we don't know the connection ID.
+func (p *chromeProcess) track(connID int64) int64 {
+	if connID == 0 {
+		return chromeTrackHTTP
+	}
+	if _, found := p.names[connID]; !found {
+		p.names[connID] = fmt.Sprintf("conn %d", connID)
+	}
+	return connID
+}
+
+// span adds a span ending at ev.Time and lasting ev.Duration.
+func (p *chromeProcess) span(
+	tid int64, name, cat string, ev Event, args map[string]interface{}) {
+	if ev.Err != nil {
+		args["failure"] = ev.Err.Error()
+	}
+	p.used[tid] = true
+	p.events = append(p.events, chromeEvent{
+		Args: args,
+		Cat:  cat,
+		Dur:  chromeMicroseconds(ev.Duration),
+		Name: name,
+		Ph:   "X",
+		PID:  p.pid,
+		TID:  tid,
+		TS:   chromeMicroseconds(time.Duration(ev.Time.Add(-ev.Duration).UnixNano())),
+	})
+}
+
+// chromeMicroseconds converts d to microseconds. We convert the integer
+// and the fractional parts separately to avoid rounding errors in the
+// integer part, which is large when d is a time since the epoch.
+func chromeMicroseconds(d time.Duration) float64 {
+	return float64(d/time.Microsecond) + float64(d%time.Microsecond)/1e3
+}
@@ -0,0 +1,154 @@
+package trace_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+type chromeTraceFile struct {
+	TraceEvents []struct {
+		Args map[string]interface{} `json:"args"`
+		Dur  float64                `json:"dur"`
+		Name string                 `json:"name"`
+		Ph   string                 `json:"ph"`
+		PID  int                    `json:"pid"`
+		TID  int64                  `json:"tid"`
+		TS   float64                `json:"ts"`
+	} `json:"traceEvents"`
+}
+
+func TestChromeTrace(t *testing.T) {
+	begin := time.Unix(1600000000, 0)
+	events := []trace.Event{{
+		Addresses: []string{"93.184.216.34"},
+		Duration:  10 * time.Millisecond,
+		Hostname:  "example.com",
+		Name:      "resolve_done",
+		Time:      begin.Add(10 * time.Millisecond),
+	}, {
+		Name: "http_transaction_start",
+		Time: begin.Add(10 * time.Millisecond),
+	}, {
+		HTTPMethod: "GET",
+		HTTPURL:    "https://example.com/",
+		Name:       "http_request_metadata",
+		Time:       begin.Add(10 * time.Millisecond),
+	}, {
+		Address:  "93.184.216.34:443",
+		ConnID:   54321,
+		Duration: 20 * time.Millisecond,
+		Name:     "connect",
+		Proto:    "tcp",
+		Time:     begin.Add(30 * time.Millisecond),
+	}, {
+		Address:  "[2606:2800:220:1:248:1893:25c8:1946]:443",
+		Duration: time.Millisecond,
+		Err:      errors.New("network_unreachable"),
+		Name:     "connect",
+		Proto:    "tcp",
+		Time:     begin.Add(11 * time.Millisecond),
+	}, {
+		ConnID:   54321,
+		Duration: 40 * time.Millisecond,
+		Name:     "tls_handshake_done",
+		Time:     begin.Add(70 * time.Millisecond),
+	}, {
+		ConnID: 54321,
+		Name:   "http_got_conn",
+		Time:   begin.Add(70 * time.Millisecond),
+	}, {
+		ConnID:   54321,
+		Duration: time.Millisecond,
+		Name:     "write",
+		NumBytes: 128,
+		Time:     begin.Add(71 * time.Millisecond),
+	}, {
+		HTTPStatusCode: 200,
+		Name:           "http_response_metadata",
+		Time:           begin.Add(90 * time.Millisecond),
+	}, {
+		Name: "http_transaction_done",
+		Time: begin.Add(90 * time.Millisecond),
+	}, {
+		Name: "http_transaction_start",
+		Time: begin.Add(100 * time.Millisecond),
+	}, {
+		Err:  errors.New("generic_timeout_error"),
+		Name: "http_transaction_done",
+		Time: begin.Add(110 * time.Millisecond),
+	}}
+	var ct trace.ChromeTrace
+	ct.AddProcess("urlgetter https://example.com/", events)
+	ct.AddProcess("urlgetter", nil)
+	w := new(bytes.Buffer)
+	if err := ct.WriteJSON(w); err != nil {
+		t.Fatal(err)
+	}
+	var out chromeTraceFile
+	if err := json.Unmarshal(w.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	type summary struct {
+		Name string
+		PID  int
+		TID  int64
+		TS   float64
+		Dur  float64
+	}
+	var got []summary
+	for _, ev := range out.TraceEvents {
+		name := ev.Name
+		if ev.Ph == "M" {
+			name += ": " + ev.Args["name"].(string)
+		}
+		got = append(got, summary{Name: name, PID: ev.PID, TID: ev.TID, TS: ev.TS, Dur: ev.Dur})
+	}
+	const (
+		ts          = 1600000000 * 1e6
+		trackDNS    = 1 << 20
+		trackHTTP   = trackDNS + 1
+		trackFailed = trackDNS + 2
+	)
+	expected := []summary{
+		{Name: "process_name: urlgetter https://example.com/", PID: 1},
+		{Name: "thread_name: tcp 93.184.216.34:443 (conn 54321)", PID: 1, TID: 54321},
+		{Name: "thread_name: dns", PID: 1, TID: trackDNS},
+		{Name: "thread_name: http", PID: 1, TID: trackHTTP},
+		{Name: "thread_name: failed connects", PID: 1, TID: trackFailed},
+		{Name: "resolve example.com", PID: 1, TID: trackDNS, TS: ts, Dur: 10e3},
+		{Name: "connect", PID: 1, TID: 54321, TS: ts + 10e3, Dur: 20e3},
+		{Name: "connect", PID: 1, TID: trackFailed, TS: ts + 10e3, Dur: 1e3},
+		{Name: "tls_handshake", PID: 1, TID: 54321, TS: ts + 30e3, Dur: 40e3},
+		{Name: "write", PID: 1, TID: 54321, TS: ts + 70e3, Dur: 1e3},
+		{Name: "http_round_trip", PID: 1, TID: 54321, TS: ts + 10e3, Dur: 80e3},
+		{Name: "http_round_trip", PID: 1, TID: trackHTTP, TS: ts + 100e3, Dur: 10e3},
+		{Name: "process_name: urlgetter", PID: 2},
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Fatal(diff)
+	}
+	roundTrip := out.TraceEvents[10].Args
+	if roundTrip["method"] != "GET" || roundTrip["status_code"] != float64(200) {
+		t.Fatal("unexpected round trip args", roundTrip)
+	}
+	if out.TraceEvents[11].Args["failure"] != "generic_timeout_error" {
+		t.Fatal("unexpected failure")
+	}
+}
+
+func TestChromeTraceEmpty(t *testing.T) {
+	var ct trace.ChromeTrace
+	w := new(bytes.Buffer)
+	if err := ct.WriteJSON(w); err != nil {
+		t.Fatal(err)
+	}
+	if w.String() != `{"displayTimeUnit":"ms","traceEvents":[]}`+"\n" {
+		t.Fatal("unexpected output", w.String())
+	}
+}
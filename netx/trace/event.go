@@ -4,18 +4,25 @@ import (
 	"crypto/x509"
 	"net/http"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
 // Event is one of the events within a trace
 type Event struct {
 	Addresses          []string            `json:",omitempty"`
 	Address            string              `json:",omitempty"`
+	ConnID             int64               `json:",omitempty"`
+	DNSAnswers         []dns.RR            `json:",omitempty"`
+	DNSQueryType       string              `json:",omitempty"`
+	DNSSECStatus       string              `json:",omitempty"`
 	DNSQuery           []byte              `json:",omitempty"`
 	DNSReply           []byte              `json:",omitempty"`
 	DataIsTruncated    bool                `json:",omitempty"`
 	Data               []byte              `json:",omitempty"`
 	Duration           time.Duration       `json:",omitempty"`
 	Err                error               `json:",omitempty"`
+	HTTPBodySHA256     string              `json:",omitempty"`
 	HTTPHeaders        http.Header         `json:",omitempty"`
 	HTTPMethod         string              `json:",omitempty"`
 	HTTPStatusCode     int                 `json:",omitempty"`
@@ -27,9 +34,14 @@ type Event struct {
 	Proto              string              `json:",omitempty"`
 	TLSServerName      string              `json:",omitempty"`
 	TLSCipherSuite     string              `json:",omitempty"`
+	TLSFingerprint     string              `json:",omitempty"`
+	TLSFingerprintALPN []string            `json:",omitempty"`
 	TLSNegotiatedProto string              `json:",omitempty"`
 	TLSNextProtos      []string            `json:",omitempty"`
 	TLSPeerCerts       []*x509.Certificate `json:",omitempty"`
+	TLSSplitStrategy   string              `json:",omitempty"`
+	TLSVerifiedChain   []*x509.Certificate `json:",omitempty"`
+	TLSVerifyErr       error               `json:",omitempty"`
 	TLSVersion         string              `json:",omitempty"`
 	Time               time.Time           `json:",omitempty"`
 }
@@ -2,7 +2,9 @@ package trace
 
 import "sync"
 
-// The Saver saves a trace
+// The Saver saves a trace. A nil *Saver is a valid Saver that discards
+// all the events, because a nil *Saver assigned to a Sink yields a Sink
+// that is not nil, which code checking for a nil Sink would use.
 type Saver struct {
 	ops []Event
 	mu  sync.Mutex
@@ -11,6 +13,9 @@ type Saver struct {
 // Read reads and returns events inside the trace. It advances
 // the read pointer so you won't see such events again.
 func (s *Saver) Read() []Event {
+	if s == nil {
+		return nil
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	v := s.ops
@@ -21,7 +26,21 @@ func (s *Saver) Read() []Event {
 // Write adds the given event to the trace. A subsequent call
 // to Read will read this event.
 func (s *Saver) Write(ev Event) {
+	if s == nil {
+		return
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.ops = append(s.ops, ev)
 }
+
+// WriteBatch adds the given events to the trace, such that they
+// do not interleave with events written by other goroutines.
+func (s *Saver) WriteBatch(events []Event) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops = append(s.ops, events...)
+}
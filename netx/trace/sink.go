@@ -0,0 +1,199 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// Sink receives the events of a trace. The Saver is the simplest Sink,
+// which keeps all the events in memory until you Read them. Because the
+// events may contain the data read and written, and the DNS replies, the
+// memory used by a Saver may grow large during long measurements, in which
+// case you should consider using one of the other sinks in this package.
+//
+// A Sink must be safe to use from multiple goroutines.
+type Sink interface {
+	Write(ev Event)
+}
+
+var _ Sink = &Saver{}
+
+// BatchSink is a Sink that can write several events at once, such
+// that they do not interleave with events written by other goroutines.
+type BatchSink interface {
+	Sink
+	WriteBatch(events []Event)
+}
+
+var _ BatchSink = &Saver{}
+
+// WriteBatch writes events into sink. If sink is a BatchSink, the events
+// do not interleave with events written by other goroutines.
+func WriteBatch(sink Sink, events []Event) {
+	if bs, ok := sink.(BatchSink); ok {
+		bs.WriteBatch(events)
+		return
+	}
+	for _, ev := range events {
+		sink.Write(ev)
+	}
+}
+
+type sinkKey struct{}
+
+// ContextSink retrieves the Sink from the context. Experiments that
+// support this functionality write the events of each measurement into
+// such Sink, e.g., to export a measurement as a Chrome trace.
+func ContextSink(ctx context.Context) Sink {
+	sink, _ := ctx.Value(sinkKey{}).(Sink)
+	return sink
+}
+
+// WithSink assigns the Sink to the context
+func WithSink(ctx context.Context, sink Sink) context.Context {
+	return context.WithValue(ctx, sinkKey{}, sink)
+}
+
+// RingSaver is like Saver except that it only keeps the latest events
+// up to a maximum number. When it is full, writing a new event discards
+// the oldest event. Use NewRingSaver to create a new RingSaver. Like
+// for Saver, a nil *RingSaver is valid and discards all the events.
+type RingSaver struct {
+	dropped int64
+	events  []Event
+	mu      sync.Mutex
+	next    int
+	size    int
+}
+
+// NewRingSaver creates a new RingSaver keeping at most size events. If
+// size is zero or negative, we keep a single event.
+func NewRingSaver(size int) *RingSaver {
+	if size <= 0 {
+		size = 1
+	}
+	return &RingSaver{size: size}
+}
+
+// Read reads and returns the events inside the ring, from the oldest
+// to the newest, and clears the ring.
+func (s *RingSaver) Read() []Event {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Event
+	if len(s.events) >= s.size {
+		out = append(out, s.events[s.next:]...)
+	}
+	out = append(out, s.events[:s.next]...)
+	s.events, s.next = nil, 0
+	return out
+}
+
+// Write adds the given event to the ring, possibly discarding the
+// oldest event in the ring if the ring is full.
+func (s *RingSaver) Write(ev Event) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.events) < s.size {
+		s.events = append(s.events, ev)
+	} else {
+		s.events[s.next] = ev
+		s.dropped++
+	}
+	s.next = (s.next + 1) % s.size
+}
+
+// Dropped returns the number of events we discarded so far.
+func (s *RingSaver) Dropped() int64 {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// FilterSink is a Sink that only forwards to the underlying Sink the
+// events whose Name is one of Names. The use case is saving just the
+// events you need, e.g., the connect events, during long measurements.
+type FilterSink struct {
+	Sink
+	Names []string
+}
+
+// Write implements Sink.Write
+func (s FilterSink) Write(ev Event) {
+	for _, name := range s.Names {
+		if ev.Name == name {
+			s.Sink.Write(ev)
+			return
+		}
+	}
+}
+
+// ChannelSink is a Sink that sends events to a channel. Writing blocks
+// until the event is received, so that we don't lose events. Therefore,
+// there must be a goroutine reading from the channel while measuring.
+type ChannelSink struct {
+	C chan<- Event
+}
+
+// Write implements Sink.Write
+func (s ChannelSink) Write(ev Event) {
+	s.C <- ev
+}
+
+// StreamSink is a Sink that writes each event as a line of JSON to an
+// io.Writer, e.g., a file. We serialise the Err field as a string. After
+// the first write error, we stop writing and Err returns such error. Use
+// NewStreamSink to create a new StreamSink.
+type StreamSink struct {
+	err error
+	mu  sync.Mutex
+	w   io.Writer
+}
+
+// NewStreamSink creates a new StreamSink writing into w.
+func NewStreamSink(w io.Writer) *StreamSink {
+	return &StreamSink{w: w}
+}
+
+// streamEvent is the serialisation of an Event by StreamSink.
+type streamEvent struct {
+	Event
+	Err string `json:",omitempty"`
+}
+
+// Write implements Sink.Write
+func (s *StreamSink) Write(ev Event) {
+	sev := streamEvent{Event: ev}
+	if ev.Err != nil {
+		sev.Err = ev.Err.Error()
+	}
+	data, err := json.Marshal(sev)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	if err != nil {
+		s.err = err
+		return
+	}
+	_, s.err = s.w.Write(append(data, '\n'))
+}
+
+// Err returns the first error that occurred when writing.
+func (s *StreamSink) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
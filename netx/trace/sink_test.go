@@ -0,0 +1,123 @@
+package trace_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+func TestRingSaver(t *testing.T) {
+	saver := trace.NewRingSaver(3)
+	if ev := saver.Read(); len(ev) != 0 {
+		t.Fatal("expected no events")
+	}
+	for _, name := range []string{"a", "b"} {
+		saver.Write(trace.Event{Name: name})
+	}
+	if diff := cmp.Diff([]trace.Event{{Name: "a"}, {Name: "b"}}, saver.Read()); diff != "" {
+		t.Fatal(diff)
+	}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		saver.Write(trace.Event{Name: name})
+	}
+	expected := []trace.Event{{Name: "c"}, {Name: "d"}, {Name: "e"}}
+	if diff := cmp.Diff(expected, saver.Read()); diff != "" {
+		t.Fatal(diff)
+	}
+	if saver.Dropped() != 2 {
+		t.Fatal("unexpected number of dropped events")
+	}
+	if ev := saver.Read(); len(ev) != 0 {
+		t.Fatal("expected no events")
+	}
+}
+
+func TestRingSaverWithInvalidSize(t *testing.T) {
+	saver := trace.NewRingSaver(0)
+	saver.Write(trace.Event{Name: "a"})
+	saver.Write(trace.Event{Name: "b"})
+	if diff := cmp.Diff([]trace.Event{{Name: "b"}}, saver.Read()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestNilSavers(t *testing.T) {
+	var saver *trace.Saver
+	saver.Write(trace.Event{Name: "a"})
+	trace.WriteBatch(saver, []trace.Event{{Name: "b"}})
+	if ev := saver.Read(); len(ev) != 0 {
+		t.Fatal("expected no events")
+	}
+	var ring *trace.RingSaver
+	ring.Write(trace.Event{Name: "a"})
+	if ev := ring.Read(); len(ev) != 0 {
+		t.Fatal("expected no events")
+	}
+	if ring.Dropped() != 0 {
+		t.Fatal("unexpected number of dropped events")
+	}
+}
+
+func TestFilterSink(t *testing.T) {
+	saver := new(trace.Saver)
+	sink := trace.FilterSink{Names: []string{"connect", "resolve_done"}, Sink: saver}
+	for _, name := range []string{"connect", "read", "resolve_start", "resolve_done"} {
+		sink.Write(trace.Event{Name: name})
+	}
+	expected := []trace.Event{{Name: "connect"}, {Name: "resolve_done"}}
+	if diff := cmp.Diff(expected, saver.Read()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestChannelSink(t *testing.T) {
+	ch := make(chan trace.Event, 1)
+	sink := trace.ChannelSink{C: ch}
+	sink.Write(trace.Event{Name: "connect"})
+	if ev := <-ch; ev.Name != "connect" {
+		t.Fatal("unexpected event")
+	}
+}
+
+func TestStreamSink(t *testing.T) {
+	w := new(bytes.Buffer)
+	sink := trace.NewStreamSink(w)
+	sink.Write(trace.Event{Name: "connect", Err: errors.New("connection_refused")})
+	sink.Write(trace.Event{Name: "read", NumBytes: 4})
+	if sink.Err() != nil {
+		t.Fatal(sink.Err())
+	}
+	expected := `{"Name":"connect","Time":"0001-01-01T00:00:00Z","Err":"connection_refused"}
+{"Name":"read","NumBytes":4,"Time":"0001-01-01T00:00:00Z"}
+`
+	if diff := cmp.Diff(expected, w.String()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestStreamSinkWriteError(t *testing.T) {
+	w := &failingWriter{err: io.ErrShortWrite}
+	sink := trace.NewStreamSink(w)
+	sink.Write(trace.Event{Name: "connect"})
+	sink.Write(trace.Event{Name: "read"})
+	if !errors.Is(sink.Err(), io.ErrShortWrite) {
+		t.Fatal("not the error we expected")
+	}
+	if w.count != 1 {
+		t.Fatal("we continued writing after the first error")
+	}
+}
+
+type failingWriter struct {
+	count int
+	err   error
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.count++
+	return 0, w.err
+}
@@ -9,6 +9,7 @@ import (
 	engine "github.com/ooni/probe-engine"
 	"github.com/ooni/probe-engine/internal/runtimex"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
 )
 
 const (
@@ -74,9 +75,6 @@ func (r *Runner) hasUnsupportedSettings(logger *ChanLogger) (unsupported bool) {
 	if r.settings.Options.BouncerBaseURL != "" {
 		sadly("Options.BouncerBaseURL: not supported")
 	}
-	if r.settings.Options.CABundlePath != "" {
-		logger.Warn("Options.CABundlePath: not supported")
-	}
 	if r.settings.Options.CollectorBaseURL != "" {
 		sadly("Options.CollectorBaseURL: not supported")
 	}
@@ -190,6 +188,13 @@ func (r *Runner) newsession(logger *ChanLogger) (*engine.Session, error) {
 			Address: r.settings.Options.ProbeServicesBaseURL,
 		}}
 	}
+	if r.settings.Options.CABundlePath != "" {
+		// Like Measurement Kit, we replace our own CA bundle.
+		config.CertPool, err = netx.LoadCABundle(r.settings.Options.CABundlePath, true)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return engine.NewSession(config)
 }
 
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"strings"
 	"testing"
 
@@ -102,7 +103,6 @@ func TestUnitRunnerHasUnsupportedSettings(t *testing.T) {
 	}
 	expectedWarn := []string{
 		"Options.AllEndpoints: not supported",
-		"Options.CABundlePath: not supported",
 		"Options.ConstantBitrate: not supported",
 		"Options.DNSNameserver: not supported",
 		"Options.DNSEngine: not supported",
@@ -128,6 +128,29 @@ func TestUnitRunnerHasUnsupportedSettings(t *testing.T) {
 	}
 }
 
+func TestUnitRunnerNewSessionCABundleFailure(t *testing.T) {
+	out := make(chan *Event)
+	settings := &Settings{
+		AssetsDir: "../../testdata/oonimkall/assets",
+		Name:      "Example",
+		Options: SettingsOptions{
+			CABundlePath:    "../../testdata/oonimkall/nonexistent.pem",
+			SoftwareName:    "oonimkall-test",
+			SoftwareVersion: "0.1.0",
+		},
+		StateDir: "../../testdata/oonimkall/state",
+	}
+	r := NewRunner(settings, out)
+	logger := NewChanLogger(r.emitter, "WARNING", r.out)
+	sess, err := r.newsession(logger)
+	if !os.IsNotExist(err) {
+		t.Fatal("not the error we expected")
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+}
+
 func TestUnitMeasurementSubmissionEventName(t *testing.T) {
 	if measurementSubmissionEventName(nil) != statusMeasurementSubmission {
 		t.Fatal("unexpected submission event name")
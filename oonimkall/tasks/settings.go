@@ -73,10 +73,9 @@ type SettingsOptions struct {
 	// a startup failure.
 	BouncerBaseURL string `json:"bouncer_base_url,omitempty"`
 
-	// CABundlePath contains the CA bundle path. This
-	// option is not implemented by this library. Attempting
-	// to set it will cause a startup warning, and the
-	// library will otherwise ignore this setting.
+	// CABundlePath contains the CA bundle path. When set, we
+	// use the CAs in this bundle instead of our own CAs to verify
+	// the certificates during the measurements.
 	CABundlePath string `json:"net/ca_bundle_path,omitempty"`
 
 	// CollectorBaseURL contains the collector base URL. This option is not
@@ -2,8 +2,10 @@ package engine
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -23,6 +25,7 @@ import (
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx"
 	"github.com/ooni/probe-engine/netx/bytecounter"
+	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/probeservices"
 	"github.com/ooni/probe-engine/resources"
 )
@@ -31,10 +34,15 @@ import (
 type SessionConfig struct {
 	AssetsDir              string
 	AvailableProbeServices []model.Service
+	CertPool               *x509.CertPool
 	KVStore                KVStore
+	KeyLogWriter           io.Writer
 	Logger                 model.Logger
+	PcapDir                string
 	PrivacySettings        model.PrivacySettings
 	ProxyURL               *url.URL
+	ResolverURLs           []string
+	Shaper                 *dialer.Shaper
 	SoftwareName           string
 	SoftwareVersion        string
 	TempDir                string
@@ -48,16 +56,20 @@ type Session struct {
 	availableProbeServices   []model.Service
 	availableTestHelpers     map[string][]model.Service
 	byteCounter              *bytecounter.Counter
+	certPool                 *x509.CertPool
 	httpDefaultTransport     netx.HTTPRoundTripper
+	keyLogWriter             io.Writer
 	kvStore                  model.KeyValueStore
 	privacySettings          model.PrivacySettings
 	location                 *model.LocationInfo
 	logger                   model.Logger
+	pcapDir                  string
 	proxyURL                 *url.URL
 	queryProbeServicesCount  *atomicx.Int64
 	resolver                 *sessionresolver.Resolver
 	selectedProbeServiceHook func(*model.Service)
 	selectedProbeService     *model.Service
+	shaper                   *dialer.Shaper
 	softwareName             string
 	softwareVersion          string
 	tempDir                  string
@@ -97,11 +109,15 @@ func NewSession(config SessionConfig) (*Session, error) {
 		assetsDir:               config.AssetsDir,
 		availableProbeServices:  config.AvailableProbeServices,
 		byteCounter:             bytecounter.New(),
+		certPool:                config.CertPool,
+		keyLogWriter:            config.KeyLogWriter,
 		kvStore:                 config.KVStore,
 		privacySettings:         config.PrivacySettings,
 		logger:                  config.Logger,
+		pcapDir:                 config.PcapDir,
 		proxyURL:                config.ProxyURL,
 		queryProbeServicesCount: atomicx.NewInt64(),
+		shaper:                  config.Shaper,
 		softwareName:            config.SoftwareName,
 		softwareVersion:         config.SoftwareVersion,
 		tempDir:                 tempDir,
@@ -109,11 +125,19 @@ func NewSession(config SessionConfig) (*Session, error) {
 		torBinary:               config.TorBinary,
 	}
 	httpConfig := netx.Config{
-		ByteCounter:  sess.byteCounter,
-		BogonIsError: true,
-		Logger:       sess.logger,
+		ByteCounter:   sess.byteCounter,
+		BogonIsError:  true,
+		DNSCacheStore: sess.kvStore,
+		Logger:        sess.logger,
+	}
+	if len(config.ResolverURLs) <= 0 {
+		config.ResolverURLs = sessionresolver.DefaultURLs
+	}
+	sess.resolver, err = sessionresolver.NewWithURLs(httpConfig, config.ResolverURLs)
+	if err != nil {
+		os.RemoveAll(tempDir)
+		return nil, err
 	}
-	sess.resolver = sessionresolver.New(httpConfig)
 	httpConfig.FullResolver = sess.resolver
 	httpConfig.ProxyURL = config.ProxyURL // no need to proxy the resolver
 	sess.httpDefaultTransport = netx.NewHTTPTransport(httpConfig)